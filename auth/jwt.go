@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,6 +10,9 @@ import (
 
 const (
 	SecretKey = "my-super-secret-key-change-in-production"
+	// FeedSecretKey підписує токени календарних стрічок, щоб їх не можна було
+	// використати як Bearer токен
+	FeedSecretKey = "my-feed-secret-key-change-in-production"
 	
 	RoleAdmin  = "admin"
 	RoleReader = "reader"
@@ -80,7 +84,7 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(SecretKey), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	
 	if err != nil {
 		return nil, err
@@ -90,6 +94,11 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 	
+	// токен без користувача чи ролі не виданий через /login
+	if claims.Username == "" || claims.Role == "" {
+		return nil, errors.New("invalid token")
+	}
+	
 	return claims, nil
 }
 
@@ -112,7 +121,63 @@ func IsAdmin(role string) bool {
 	return role == RoleAdmin
 }
 
+// IsClinical перевіряє чи роль належить клінічному персоналу, якому доступні
+// медичні записи; лікарі (doctor) мають роль адміна
+func IsClinical(role string) bool {
+	return role == RoleAdmin
+}
+
+// IsKnownRole перевіряє чи роль існує
+func IsKnownRole(role string) bool {
+	return role == RoleAdmin || role == RoleReader
+}
+
 // IsReader перевіряє чи користувач має роль читача
 func IsReader(role string) bool {
 	return role == RoleReader
-}
\ No newline at end of file
+}
+
+// FeedClaims структура для токена календарної підписки
+type FeedClaims struct {
+	Feed string `json:"feed"`
+	jwt.RegisteredClaims
+}
+
+// FeedSubject формує ідентифікатор стрічки, наприклад "patient:5"
+func FeedSubject(kind string, id int) string {
+	return fmt.Sprintf("%s:%d", kind, id)
+}
+
+// GenerateFeedToken генерує довготривалий токен для .ics стрічки.
+// Календарні клієнти не вміють передавати Bearer заголовок, тому токен іде в URL
+// і дає доступ лише до однієї стрічки.
+func GenerateFeedToken(kind string, id int) (string, error) {
+	claims := &FeedClaims{
+		Feed: FeedSubject(kind, id),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(365 * 24 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(FeedSecretKey))
+}
+
+// ValidateFeedToken перевіряє, що токен виданий саме для цієї стрічки
+func ValidateFeedToken(tokenString, kind string, id int) error {
+	claims := &FeedClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(FeedSecretKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid || claims.Feed != FeedSubject(kind, id) {
+		return errors.New("invalid feed token")
+	}
+
+	return nil
+}
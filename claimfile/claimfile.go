@@ -0,0 +1,221 @@
+// Package claimfile writes insurance claims as an ASC X12 837P (005010X222A1)
+// professional claim interchange for submission to a payer. A batch is one
+// interchange with one functional group and one transaction set:
+//
+//	ISA, GS, ST, BHT                      envelope and batch reference
+//	NM1*41, NM1*40                        the hospital as submitter, the payer as receiver
+//	HL*1**20, CUR, NM1*85                 the hospital as billing provider and the currency
+//	HL*n*1*22 per claim                   the patient as subscriber:
+//	  SBR, NM1*IL, DMG, NM1*PR            policy, member, demographics and payer
+//	  CLM, AMT*F5, HI, NM1*82             claim, copay, ICD-10 diagnoses and doctor
+//	  LX, SV1, DTP*472 per service line   service code, charge, quantity and date
+//	SE, GE, IEA                           control counts
+//
+// The hospital has no NPI, so providers are named without one; the sender
+// and payer codes identify the trading partners. Elements are separated by
+// "*", components by ":" and segments end with "~" and a line break; those
+// characters in values are replaced by spaces. Amounts are in major units
+// with the decimals of the currency, e.g. none for JPY.
+package claimfile
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType of a claim file
+const ContentType = "application/edi-x12"
+
+// maxPointers is how many diagnoses a service line may point to
+const maxPointers = 4
+
+type Line struct {
+	Code        string
+	Description string
+	Quantity    int
+	Amount      int64 // minor units
+}
+
+type Claim struct {
+	Number      string
+	MemberID    string
+	GroupNumber string
+	LastName    string
+	FirstName   string
+	BirthDate   string // YYYY-MM-DD
+	Sex         string // male, female, other or unknown
+	ServiceDate string // YYYY-MM-DD
+	Doctor      string
+	Diagnoses   []string // ICD-10, the principal one first
+	TotalCharge int64
+	Copay       int64
+	Lines       []Line
+}
+
+type Batch struct {
+	Sender    string
+	Payer     string // payer code
+	PayerName string
+	Control   int    // interchange control number, 1 to 999999999
+	ID        string // reference of the batch
+	Created   time.Time
+	Currency  string // ISO 4217
+	Claims    []Claim
+}
+
+// WriteTo serializes the batch
+func (b *Batch) WriteTo(w io.Writer) (int64, error) {
+	created := b.Created.UTC()
+	control := fmt.Sprintf("%09d", b.Control%1000000000)
+	var set [][]string // ST to SE
+	add := func(values ...string) {
+		set = append(set, clean(values...))
+	}
+
+	add("ST", "837", "0001", "005010X222A1")
+	add("BHT", "0019", "00", b.ID, created.Format("20060102"), created.Format("1504"), "CH")
+	add("NM1", "41", "2", b.Sender, "", "", "", "", "46", b.Sender)
+	add("NM1", "40", "2", b.PayerName, "", "", "", "", "46", b.Payer)
+	add("HL", "1", "", "20", "1")
+	add("CUR", "85", b.Currency)
+	add("NM1", "85", "2", b.Sender)
+	for i, c := range b.Claims {
+		add("HL", strconv.Itoa(i+2), "1", "22", "0")
+		add("SBR", "P", "18", c.GroupNumber, "", "", "", "", "", "CI")
+		add("NM1", "IL", "1", c.LastName, c.FirstName, "", "", "", "MI", c.MemberID)
+		add("DMG", "D8", date(c.BirthDate), sex(c.Sex))
+		add("NM1", "PR", "2", b.PayerName, "", "", "", "", "PI", b.Payer)
+		// office visit, professional claim, original
+		add("CLM", c.Number, b.amount(c.TotalCharge), "", "", "", "Y", "A", "Y", "Y")
+		set[len(set)-1][5] = "11:B:1"
+		if c.Copay > 0 {
+			add("AMT", "F5", b.amount(c.Copay))
+		}
+		var pointers []string
+		if len(c.Diagnoses) > 0 {
+			hi := []string{"HI"}
+			for j, code := range c.Diagnoses {
+				qualifier := "ABF"
+				if j == 0 {
+					qualifier = "ABK"
+				}
+				hi = append(hi, qualifier+":"+strings.ReplaceAll(clean(code)[0], ".", ""))
+				if j < maxPointers {
+					pointers = append(pointers, strconv.Itoa(j+1))
+				}
+			}
+			set = append(set, hi)
+		}
+		if c.Doctor != "" {
+			add("NM1", "82", "1", c.Doctor)
+		}
+		for j, l := range c.Lines {
+			add("LX", strconv.Itoa(j+1))
+			sv1 := clean("SV1", "", b.amount(l.Amount), "UN", strconv.Itoa(l.Quantity), "", "", "")
+			sv1[1] = strings.TrimRight("HC:"+strings.Join(clean(l.Code, "", "", "", "", l.Description), ":"), ":")
+			sv1[7] = strings.Join(pointers, ":")
+			set = append(set, trim(sv1))
+			add("DTP", "472", "D8", date(c.ServiceDate))
+		}
+	}
+	add("SE", strconv.Itoa(len(set)+1), "0001")
+
+	segments := append([][]string{
+		{"ISA", "00", pad("", 10), "00", pad("", 10), "ZZ", pad(clean(b.Sender)[0], 15), "ZZ", pad(clean(b.Payer)[0], 15),
+			created.Format("060102"), created.Format("1504"), "^", "00501", control, "0", "P", ":"},
+		clean("GS", "HC", b.Sender, b.Payer, created.Format("20060102"), created.Format("1504"), strconv.Itoa(b.Control%1000000000), "X", "005010X222A1"),
+	}, set...)
+	segments = append(segments,
+		clean("GE", "1", strconv.Itoa(b.Control%1000000000)),
+		clean("IEA", "1", control))
+
+	var sb strings.Builder
+	for _, s := range segments {
+		sb.WriteString(strings.Join(trim(s), "*"))
+		sb.WriteString("~\n")
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+var separators = strings.NewReplacer("*", " ", ":", " ", "~", " ", "^", " ", "\r\n", " ", "\r", " ", "\n", " ")
+
+// clean makes values safe to put in a segment
+func clean(values ...string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.TrimSpace(separators.Replace(v))
+	}
+	return out
+}
+
+// trim drops the empty elements at the end of a segment, as X12 requires
+func trim(segment []string) []string {
+	for len(segment) > 1 && segment[len(segment)-1] == "" {
+		segment = segment[:len(segment)-1]
+	}
+	return segment
+}
+
+// pad fills the fixed-width elements of the ISA segment
+func pad(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// date turns YYYY-MM-DD into YYYYMMDD
+func date(s string) string {
+	return strings.ReplaceAll(s, "-", "")
+}
+
+// sex is the X12 gender code
+func sex(s string) string {
+	switch s {
+	case "male":
+		return "M"
+	case "female":
+		return "F"
+	}
+	return "U"
+}
+
+// zeroDecimal and threeDecimal list the ISO 4217 currencies whose minor unit
+// is not a hundredth
+var (
+	zeroDecimal  = []string{"BIF", "CLP", "DJF", "GNF", "ISK", "JPY", "KMF", "KRW", "PYG", "RWF", "UGX", "UYI", "VND", "VUV", "XAF", "XOF", "XPF"}
+	threeDecimal = []string{"BHD", "IQD", "JOD", "KWD", "LYD", "OMR", "TND"}
+)
+
+// Decimals is the number of digits of the minor unit of an ISO 4217 currency
+func Decimals(currency string) int {
+	switch currency = strings.ToUpper(currency); {
+	case slices.Contains(zeroDecimal, currency):
+		return 0
+	case slices.Contains(threeDecimal, currency):
+		return 3
+	}
+	return 2
+}
+
+// amount formats minor units of the batch currency in major units
+func (b *Batch) amount(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	decimals := Decimals(b.Currency)
+	if decimals == 0 {
+		return sign + strconv.FormatInt(v, 10)
+	}
+	scale := int64(1)
+	for range decimals {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, v/scale, decimals, v%scale)
+}
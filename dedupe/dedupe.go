@@ -0,0 +1,265 @@
+// Package dedupe finds patient records that probably describe the same person.
+// Names are compared with trigram similarity (as in PostgreSQL pg_trgm) and
+// candidate pairs are found by blocking on Soundex codes and identifiers, so
+// not every pair of patients is compared. Names are folded with translit.Fold
+// first, so Cyrillic names and their transliterations are compared as Latin.
+package dedupe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/translit"
+)
+
+// DefaultMinScore is the score from which a pair is reported
+const DefaultMinScore = 0.65
+
+// Trigrams returns the set of trigrams of s. Each word is folded and
+// padded with two spaces in front and one behind, like pg_trgm does.
+func Trigrams(s string) map[string]bool {
+	out := map[string]bool{}
+	for _, word := range translit.Words(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			out[string(padded[i:i+3])] = true
+		}
+	}
+	return out
+}
+
+// Similarity is the number of shared trigrams divided by the number of distinct trigrams of both strings
+func Similarity(a, b string) float64 {
+	ta, tb := Trigrams(a), Trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// Soundex returns the American Soundex code of the first word of s after
+// folding, or "" when it has no letters
+func Soundex(s string) string {
+	codes := map[rune]byte{
+		'b': '1', 'f': '1', 'p': '1', 'v': '1',
+		'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+		'd': '3', 't': '3',
+		'l': '4',
+		'm': '5', 'n': '5',
+		'r': '6',
+	}
+	var out []byte
+	var last byte
+	for _, r := range translit.Fold(s) {
+		if r < 'a' || r > 'z' {
+			if len(out) > 0 && !unicode.IsLetter(r) {
+				break
+			}
+			continue
+		}
+		code := codes[r]
+		if len(out) == 0 {
+			out = append(out, byte(unicode.ToUpper(r)))
+			last = code
+			continue
+		}
+		switch {
+		case r == 'h' || r == 'w':
+			// do not separate equal codes
+		case code == 0:
+			last = 0
+		case code != last:
+			out = append(out, code)
+			last = code
+		}
+		if len(out) == 4 {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	for len(out) < 4 {
+		out = append(out, '0')
+	}
+	return string(out)
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Compare scores how likely a and b are the same person, from 0 to 1, and
+// explains the score. Different national IDs or dates of birth rule a pair out.
+func Compare(a, b *models.Patient) (float64, []string) {
+	if a.NationalID != "" && b.NationalID != "" && !strings.EqualFold(a.NationalID, b.NationalID) {
+		return 0, nil
+	}
+	if a.DateOfBirth != "" && b.DateOfBirth != "" && a.DateOfBirth != b.DateOfBirth && !transposed(a.DateOfBirth, b.DateOfBirth) {
+		return 0, nil
+	}
+
+	var reasons []string
+	last := Similarity(a.LastName, b.LastName)
+	first := Similarity(a.FirstName, b.FirstName)
+	// swapped first and last name
+	if swapped := (Similarity(a.FirstName, b.LastName) + Similarity(a.LastName, b.FirstName)) / 2; swapped > (last+first)/2 {
+		last, first = swapped, swapped
+		reasons = append(reasons, "first and last name swapped")
+	}
+	if Soundex(a.LastName) != "" && Soundex(a.LastName) == Soundex(b.LastName) && last < 1 {
+		last = max(last, 0.7)
+		reasons = append(reasons, "last names sound alike")
+	}
+	reasons = append(reasons, fmt.Sprintf("last name similarity %.2f", last), fmt.Sprintf("first name similarity %.2f", first))
+	score := 0.4*last + 0.3*first
+
+	switch {
+	case a.DateOfBirth != "" && a.DateOfBirth == b.DateOfBirth:
+		score += 0.3
+		reasons = append(reasons, "same date of birth")
+	case a.DateOfBirth != "" && b.DateOfBirth != "":
+		score += 0.15
+		reasons = append(reasons, "date of birth differs by swapped day and month")
+	case a.Age == b.Age:
+		score += 0.1
+		reasons = append(reasons, "same age")
+	}
+
+	identifiers := 0
+	if a.NationalID != "" && strings.EqualFold(a.NationalID, b.NationalID) {
+		identifiers += 2
+		reasons = append(reasons, "same national ID")
+	}
+	if p := digits(a.Phone); len(p) >= 5 && p == digits(b.Phone) {
+		identifiers++
+		reasons = append(reasons, "same phone")
+	}
+	if a.Email != "" && strings.EqualFold(a.Email, b.Email) {
+		identifiers++
+		reasons = append(reasons, "same email")
+	}
+	score += 0.05 * float64(identifiers)
+	return min(score, 1), reasons
+}
+
+// transposed reports whether two YYYY-MM-DD dates differ only by swapped day and month
+func transposed(a, b string) bool {
+	if len(a) != 10 || len(b) != 10 {
+		return false
+	}
+	return a[:4] == b[:4] && a[5:7] == b[8:10] && a[8:10] == b[5:7]
+}
+
+// blockingKeys puts a patient into the groups it is compared within
+func blockingKeys(p *models.Patient) []string {
+	var keys []string
+	if s := Soundex(p.LastName); s != "" {
+		keys = append(keys, "last:"+s)
+	}
+	// both names in either order catch swapped first and last names
+	first, last := Soundex(p.FirstName), Soundex(p.LastName)
+	if first != "" && last != "" {
+		keys = append(keys, "names:"+min(first, last)+max(first, last))
+	}
+	if p.DateOfBirth != "" {
+		keys = append(keys, "dob:"+p.DateOfBirth)
+	}
+	if p.NationalID != "" {
+		keys = append(keys, "national_id:"+strings.ToUpper(p.NationalID))
+	}
+	if d := digits(p.Phone); len(d) >= 5 {
+		keys = append(keys, "phone:"+d)
+	}
+	if p.Email != "" {
+		keys = append(keys, "email:"+strings.ToLower(p.Email))
+	}
+	return keys
+}
+
+// Find returns the pairs of patients scoring at least minScore, best first.
+// The pair {a, b} is reported once with the lower ID as Patient; skip
+// reports pairs that must not be reported.
+func Find(patients []models.Patient, minScore float64, skip func(a, b int) bool) []models.DuplicateCandidate {
+	groups := map[string][]int{}
+	for i := range patients {
+		for _, key := range blockingKeys(&patients[i]) {
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	type pair struct{ a, b int }
+	seen := map[pair]bool{}
+	out := []models.DuplicateCandidate{}
+	for _, members := range groups {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				a, b := &patients[members[x]], &patients[members[y]]
+				if a.ID > b.ID {
+					a, b = b, a
+				}
+				key := pair{a.ID, b.ID}
+				if a.ID == b.ID || seen[key] {
+					continue
+				}
+				seen[key] = true
+				if skip != nil && skip(a.ID, b.ID) {
+					continue
+				}
+				if score, reasons := Compare(a, b); score >= minScore {
+					out = append(out, models.DuplicateCandidate{Patient: *a, Duplicate: *b, Score: round(score), Reasons: reasons})
+				}
+			}
+		}
+	}
+
+	sortCandidates(out)
+	return out
+}
+
+// Matches compares one patient with all others, e.g. right after registration
+func Matches(p *models.Patient, others []models.Patient, minScore float64, skip func(a, b int) bool) []models.DuplicateCandidate {
+	out := []models.DuplicateCandidate{}
+	for i := range others {
+		other := &others[i]
+		if other.ID == p.ID || (skip != nil && skip(p.ID, other.ID)) {
+			continue
+		}
+		if score, reasons := Compare(p, other); score >= minScore {
+			out = append(out, models.DuplicateCandidate{Patient: *p, Duplicate: *other, Score: round(score), Reasons: reasons})
+		}
+	}
+	sortCandidates(out)
+	return out
+}
+
+// sortCandidates orders by score, best first, then by IDs
+func sortCandidates(out []models.DuplicateCandidate) {
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		if out[i].Patient.ID != out[j].Patient.ID {
+			return out[i].Patient.ID < out[j].Patient.ID
+		}
+		return out[i].Duplicate.ID < out[j].Duplicate.ID
+	})
+}
+
+func round(f float64) float64 {
+	return float64(int(f*100+0.5)) / 100
+}
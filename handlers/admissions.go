@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+func respondAdmissions(w http.ResponseWriter, admissions []models.Admission, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch admissions: "+err.Error())
+		return
+	}
+	if admissions == nil {
+		admissions = []models.Admission{}
+	}
+	utils.RespondJSON(w, http.StatusOK, admissions)
+}
+
+// checkBedAndDoctor answers 400 when the bed or the doctor in the body does not exist; doctorID 0 is skipped
+func checkBedAndDoctor(w http.ResponseWriter, r *http.Request, bedID, doctorID int) bool {
+	if _, err := storage.Store.GetBed(r.Context(), bedID); err != nil {
+		respondReferenceError(w, err, "bed_id does not exist", "failed to fetch bed: ")
+		return false
+	}
+	if doctorID != 0 {
+		if _, err := storage.Store.GetDoctorByID(r.Context(), doctorID); err != nil {
+			respondReferenceError(w, err, "attending_doctor_id does not exist", "failed to fetch doctor: ")
+			return false
+		}
+	}
+	return true
+}
+
+// CreatePatientAdmissionHandler admits a patient into a free bed under an
+// attending doctor; 409 when the bed is taken or the patient is already admitted
+func CreatePatientAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var admission models.Admission
+	if err := json.NewDecoder(r.Body).Decode(&admission); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	admission.Reason = strings.TrimSpace(admission.Reason)
+	if admission.AdmittedAt.IsZero() {
+		admission.AdmittedAt = time.Now()
+	}
+	if err := admission.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	admission.PatientID = id
+	admission.AdmittedBy = currentUser(r)
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	if !checkBedAndDoctor(w, r, admission.BedID, admission.AttendingDoctorID) {
+		return
+	}
+
+	created, err := storage.Store.CreateAdmission(ctx, &admission)
+	if err != nil {
+		respondWriteError(w, err, "Patient not found", "failed to admit: ")
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+// GetPatientAdmissionsHandler lists the stays of a patient, the current one first
+func GetPatientAdmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	admissions, err := storage.Store.GetPatientAdmissions(ctx, id, include)
+	respondAdmissions(w, admissions, err)
+}
+
+// GetAdmissionsHandler is the inpatient census: admissions by ?status=
+// (default admitted), optionally in one ?ward_id= or ?department_id=
+func GetAdmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.AdmissionAdmitted
+	}
+	if !slices.Contains(models.AdmissionStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.AdmissionStatuses, ", "))
+		return
+	}
+	wardID, ok := idParam(w, r, "ward_id")
+	if !ok {
+		return
+	}
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+
+	admissions, err := storage.Store.GetAdmissions(r.Context(), status, wardID, departmentID)
+	respondAdmissions(w, admissions, err)
+}
+
+func GetAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	admission, err := storage.Store.GetAdmission(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Admission not found", "failed to fetch admission: ")
+		return
+	}
+	if utils.NotModified(w, r, admission.Version) {
+		return
+	}
+	utils.SetETag(w, admission.Version)
+	utils.RespondJSON(w, http.StatusOK, admission)
+}
+
+// TransferAdmissionHandler moves an admitted patient to another free bed,
+// optionally handing over to another attending doctor
+func TransferAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var transfer models.Transfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	transfer.Reason = strings.TrimSpace(transfer.Reason)
+	if err := transfer.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkBedAndDoctor(w, r, transfer.BedID, transfer.AttendingDoctorID) {
+		return
+	}
+
+	admission, err := storage.Store.TransferAdmission(r.Context(), id, version, &transfer, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Admission not found", "transfer failed: ")
+		return
+	}
+	utils.SetETag(w, admission.Version)
+	utils.RespondJSON(w, http.StatusOK, admission)
+}
+
+// DischargeAdmissionHandler ends an admission and frees the bed
+func DischargeAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var discharge models.Discharge
+	if err := json.NewDecoder(r.Body).Decode(&discharge); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	discharge.Disposition = strings.ToLower(strings.TrimSpace(discharge.Disposition))
+	discharge.Summary = strings.TrimSpace(discharge.Summary)
+	if err := discharge.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	admission, err := storage.Store.DischargeAdmission(r.Context(), id, version, &discharge, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Admission not found", "discharge failed: ")
+		return
+	}
+	utils.SetETag(w, admission.Version)
+	utils.RespondJSON(w, http.StatusOK, admission)
+}
+
+func DeleteAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteAdmission(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Admission not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Admission deleted"})
+}
+
+// RestoreAdmissionHandler brings back a soft-deleted admission; 409 when its patient is deleted or its bed was taken
+func RestoreAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	admission, err := storage.Store.RestoreAdmission(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Admission not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, admission.Version)
+	utils.RespondJSON(w, http.StatusOK, admission)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// prepareAllergy trims the text fields, upper-cases the ATC code and defaults the status
+func prepareAllergy(a *models.Allergy) error {
+	a.Substance = strings.TrimSpace(a.Substance)
+	a.ATCCode = strings.ToUpper(strings.TrimSpace(a.ATCCode))
+	a.Reaction = strings.TrimSpace(a.Reaction)
+	a.Notes = strings.TrimSpace(a.Notes)
+	if a.Status == "" {
+		a.Status = "active"
+	}
+	return a.Validate()
+}
+
+// allergyIDs parses {id} and {allergy_id}
+func allergyIDs(w http.ResponseWriter, r *http.Request) (patientID, id int, ok bool) {
+	patientID, err := utils.PathID(r, "id")
+	if err == nil {
+		id, err = utils.PathID(r, "allergy_id")
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return 0, 0, false
+	}
+	return patientID, id, true
+}
+
+// GetPatientAllergiesHandler lists the allergies of a patient, optionally by ?status=
+func GetPatientAllergiesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.AllergyStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.AllergyStatuses, ", "))
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	allergies, err := storage.Store.GetPatientAllergies(ctx, id, status, include)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch allergies: "+err.Error())
+		return
+	}
+	if allergies == nil {
+		allergies = []models.Allergy{}
+	}
+	utils.RespondJSON(w, http.StatusOK, allergies)
+}
+
+func GetPatientAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := allergyIDs(w, r)
+	if !ok {
+		return
+	}
+
+	allergy, err := storage.Store.GetAllergy(r.Context(), patientID, id)
+	if err != nil {
+		respondLookupError(w, err, "Allergy not found", "failed to fetch allergy: ")
+		return
+	}
+	if utils.NotModified(w, r, allergy.Version) {
+		return
+	}
+	utils.SetETag(w, allergy.Version)
+	utils.RespondJSON(w, http.StatusOK, allergy)
+}
+
+func CreatePatientAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var allergy models.Allergy
+	if err := json.NewDecoder(r.Body).Decode(&allergy); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareAllergy(&allergy); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	allergy.PatientID = id
+	allergy.RecordedBy = currentUser(r)
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	created, err := storage.Store.CreateAllergy(ctx, &allergy)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create allergy: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func UpdatePatientAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := allergyIDs(w, r)
+	if !ok {
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Allergy
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareAllergy(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.PatientID, updated.Version = id, patientID, version
+
+	if err := storage.Store.UpdateAllergy(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Allergy not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+func DeletePatientAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := allergyIDs(w, r)
+	if !ok {
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteAllergy(r.Context(), patientID, id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Allergy not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Allergy deleted"})
+}
+
+// RestorePatientAllergyHandler brings back a soft-deleted allergy of a patient that is not deleted
+func RestorePatientAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := allergyIDs(w, r)
+	if !ok {
+		return
+	}
+
+	allergy, err := storage.Store.RestoreAllergy(r.Context(), patientID, id)
+	if err != nil {
+		respondWriteError(w, err, "Allergy not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, allergy.Version)
+	utils.RespondJSON(w, http.StatusOK, allergy)
+}
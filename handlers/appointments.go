@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -13,14 +17,27 @@ import (
 
 func GetAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	staff, ok := departmentStaff(w, r)
+	if !ok {
+		return
+	}
 
-	appointments, err := storage.Store.GetAllAppointments(ctx)
+	appointments, err := storage.Store.GetAllAppointments(ctx, include)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch appointments: "+err.Error())
 		return
 	}
+	appointments = onStaff(appointments, staff, func(a *models.Appointment) int { return a.DoctorID })
+
+	utils.RespondJSON(w, http.StatusOK, filterAppointments(appointments, r.URL.Query()))
+}
 
-	query := r.URL.Query()
+// filterAppointments applies the list query parameters; it never returns nil
+func filterAppointments(appointments []models.Appointment, query url.Values) []models.Appointment {
 	patientIDStr := query.Get("patient_id")
 	doctorIDStr := query.Get("doctor_id")
 	date := query.Get("date")
@@ -28,10 +45,9 @@ func GetAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	if patientIDStr == "" && doctorIDStr == "" && date == "" && status == "" {
 		if appointments == nil {
-			appointments = []models.Appointment{}
+			return []models.Appointment{}
 		}
-		utils.RespondJSON(w, http.StatusOK, appointments)
-		return
+		return appointments
 	}
 
 	var filtered []models.Appointment
@@ -65,12 +81,16 @@ func GetAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
 	if filtered == nil {
 		filtered = []models.Appointment{}
 	}
-	utils.RespondJSON(w, http.StatusOK, filtered)
+	return filtered
 }
 
 func GetAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/appointments/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	appointment, err := storage.Store.GetAppointmentByID(ctx, id)
 	if err != nil {
@@ -81,6 +101,10 @@ func GetAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if utils.NotModified(w, r, appointment.Version) {
+		return
+	}
+	utils.SetETag(w, appointment.Version)
 	utils.RespondJSON(w, http.StatusOK, appointment)
 }
 
@@ -92,67 +116,204 @@ func CreateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := ap.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	created, err := storage.Store.CreateAppointment(ctx, &ap)
+	if errors.Is(err, storage.ErrOnLeave) || errors.Is(err, storage.ErrOffShift) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to create appointment: "+err.Error())
 		return
 	}
+	utils.SetETag(w, created.Version)
 	utils.RespondJSON(w, http.StatusCreated, created)
 }
 
 func UpdateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/appointments/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var updated models.Appointment
 	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := updated.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	updated.ID = id
+	updated.Version = version
 
 	if err := storage.Store.UpdateAppointment(ctx, &updated); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Appointment not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "update failed: "+err.Error())
-		}
+		respondWriteError(w, err, "Appointment not found", "update failed: ")
 		return
 	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// PatchAppointmentHandler accepts a JSON Merge Patch or a JSON Patch for the current row
+func PatchAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, patch, perr := readPatch(r)
+	if perr != nil {
+		respondPatchError(w, perr, "Appointment not found")
+		return
+	}
+
+	updated, err := storage.Store.PatchAppointment(ctx, id, version, func(a *models.Appointment) error {
+		return patchInto(a, mediaType, patch)
+	})
+	if err != nil {
+		respondPatchError(w, err, "Appointment not found")
+		return
+	}
+	utils.SetETag(w, updated.Version)
 	utils.RespondJSON(w, http.StatusOK, updated)
 }
 
 func DeleteAppointmentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/appointments/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
-	if err := storage.Store.DeleteAppointment(ctx, id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Appointment not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "delete failed: "+err.Error())
-		}
+	if err := storage.Store.DeleteAppointment(ctx, id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Appointment not found", "delete failed: ")
 		return
 	}
 	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Appointment deleted"})
 }
 
-func AppointmentsRouter(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		if strings.HasPrefix(r.URL.Path, "/appointments/") && len(r.URL.Path) > len("/appointments/") {
-			GetAppointmentHandler(w, r)
-		} else {
-			GetAppointmentsHandler(w, r)
-		}
-	case http.MethodPost:
-		CreateAppointmentHandler(w, r)
-	case http.MethodPut:
-		UpdateAppointmentHandler(w, r)
-	case http.MethodDelete:
-		DeleteAppointmentHandler(w, r)
-	default:
-		utils.RespondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+// RestoreAppointmentHandler brings back a soft-deleted appointment; its patient must not be deleted
+func RestoreAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	appointment, err := storage.Store.RestoreAppointment(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Appointment not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, appointment.Version)
+	utils.RespondJSON(w, http.StatusOK, appointment)
+}
+
+// CreateAppointmentsBatchHandler inserts an array of appointments; large batches are sent with COPY
+func CreateAppointmentsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Appointment]{
+		validate: (*models.Appointment).Validate,
+		key:      appointmentKey,
+		status:   http.StatusCreated,
+		write:    storage.Store.CreateAppointments,
+		notFound: "Appointment not found",
+	})
+}
+
+// UpdateAppointmentsBatchHandler overwrites an array of appointments; each item carries its id and version
+func UpdateAppointmentsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Appointment]{
+		validate: (*models.Appointment).Validate,
+		key:      appointmentKey,
+		needKey:  true,
+		status:   http.StatusOK,
+		write:    storage.Store.UpdateAppointments,
+		notFound: "Appointment not found",
+	})
+}
+
+// DeleteAppointmentsBatchHandler soft-deletes an array of {"id", "version"} references
+func DeleteAppointmentsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	runBatchOp(w, r, batchOp[models.BatchRef]{
+		key:     batchRefKey,
+		needKey: true,
+		status:  http.StatusOK,
+		write: func(ctx context.Context, refs []*models.BatchRef, atomic bool) ([]error, error) {
+			return storage.Store.DeleteAppointments(ctx, refs, atomic, user)
+		},
+		notFound: "Appointment not found",
+	})
+}
+
+func appointmentKey(a *models.Appointment) (int, int) {
+	return a.ID, a.Version
+}
+
+// appointmentSheet describes appointments for spreadsheet export and import; duplicates
+// share the same patient, doctor, date and time. A non-nil staff keeps only the appointments with its doctors.
+func appointmentSheet(includeDeleted bool, staff map[int]bool) sheetSpec[models.Appointment] {
+	return sheetSpec[models.Appointment]{
+		name: "appointments",
+		load: func(ctx context.Context) ([]models.Appointment, error) {
+			appointments, err := storage.Store.GetAllAppointments(ctx, includeDeleted)
+			return onStaff(appointments, staff, func(a *models.Appointment) int { return a.DoctorID }), err
+		},
+		filter: filterAppointments,
+		create: storage.Store.CreateAppointments,
+		key: func(a *models.Appointment) string {
+			return fmt.Sprintf("%d|%d|%s|%s", a.PatientID, a.DoctorID, a.Date, clockKey(a.Time))
+		},
+		id:        func(a *models.Appointment) int { return a.ID },
+		normalize: spreadsheetDate,
+	}
+}
+
+// ExportAppointmentsHandler streams the filtered list as CSV or XLSX
+func ExportAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	staff, ok := departmentStaff(w, r)
+	if !ok {
+		return
+	}
+	exportSheet(w, r, appointmentSheet(include, staff))
+}
+
+// ImportAppointmentsHandler creates appointments from an uploaded CSV or XLSX file
+func ImportAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	importSheet[models.Appointment](w, r, appointmentSheet(false, nil))
+}
+
+// clockKey makes 10:00 and 10:00:00 compare equal
+func clockKey(clock string) string {
+	if len(clock) == len("15:04") {
+		return clock + ":00"
 	}
+	return clock
 }
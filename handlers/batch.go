@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+const (
+	batchAtomic  = "atomic"
+	batchPartial = "partial"
+
+	maxBatchItems = 50000
+	maxBatchSize  = 64 << 20
+)
+
+// batchOp describes one batch endpoint: how items are checked, written and reported
+type batchOp[T any] struct {
+	validate func(*T) error
+	key      func(*T) (id, version int)
+	needKey  bool // updates and deletes name an existing row and its version
+	status   int  // per-item status on success
+	write    func(ctx context.Context, items []*T, atomic bool) ([]error, error)
+	notFound string
+}
+
+// runBatchOp handles a JSON array body. With ?mode=atomic (default) either
+// every item is applied or none; with ?mode=partial failed items are skipped.
+// The response lists the outcome of every item in request order.
+func runBatchOp[T any](w http.ResponseWriter, r *http.Request, op batchOp[T]) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = batchAtomic
+	}
+	if mode != batchAtomic && mode != batchPartial {
+		utils.RespondError(w, http.StatusBadRequest, "mode must be atomic or partial")
+		return
+	}
+	atomic := mode == batchAtomic
+
+	var items []T
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&items); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body: expected a JSON array")
+		return
+	}
+	if len(items) == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "batch is empty")
+		return
+	}
+	if len(items) > maxBatchItems {
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch is limited to %d items", maxBatchItems))
+		return
+	}
+
+	results := make([]models.BatchItemResult, len(items))
+	var valid []*T
+	var index []int
+	invalid := false
+	for i := range items {
+		results[i].Index = i
+		if err := op.check(&items[i]); err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			invalid = true
+			continue
+		}
+		valid = append(valid, &items[i])
+		index = append(index, i)
+	}
+
+	if invalid && atomic {
+		for _, i := range index {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = storage.ErrNotApplied.Error()
+		}
+		respondBatch(w, mode, results)
+		return
+	}
+
+	if len(valid) > 0 {
+		errs, err := op.write(r.Context(), valid, atomic)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "batch failed: "+err.Error())
+			return
+		}
+		for j, item := range valid {
+			res := &results[index[j]]
+			if errs[j] != nil {
+				res.Status, res.Error = batchItemStatus(errs[j], op.notFound)
+				continue
+			}
+			res.Status = op.status
+			res.ID, res.Version = op.key(item)
+		}
+	}
+	respondBatch(w, mode, results)
+}
+
+func (op batchOp[T]) check(item *T) error {
+	if op.validate != nil {
+		if err := op.validate(item); err != nil {
+			return err
+		}
+	}
+	if op.needKey {
+		id, version := op.key(item)
+		if id <= 0 {
+			return errors.New("id is required")
+		}
+		if version <= 0 {
+			return errors.New("version is required (the ETag of the row)")
+		}
+	}
+	return nil
+}
+
+func batchItemStatus(err error, notFound string) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotApplied):
+		return http.StatusFailedDependency, err.Error()
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return http.StatusPreconditionFailed, "version does not match the current version"
+	case errors.Is(err, storage.ErrDuplicate), errors.Is(err, storage.ErrOnLeave), errors.Is(err, storage.ErrOffShift):
+		return http.StatusConflict, err.Error()
+	case strings.Contains(err.Error(), "not found"):
+		return http.StatusNotFound, notFound
+	case storage.IsConstraintViolation(err):
+		return http.StatusConflict, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
+
+// respondBatch answers 200 when every item was applied, 422 when an atomic
+// batch was rolled back and 207 when a partial batch skipped some items
+func respondBatch(w http.ResponseWriter, mode string, results []models.BatchItemResult) {
+	resp := models.BatchResponse{Mode: mode, Results: results}
+	for _, res := range results {
+		if res.Status < 300 {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case resp.Failed > 0 && mode == batchAtomic:
+		status = http.StatusUnprocessableEntity
+	case resp.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+	utils.RespondJSON(w, status, resp)
+}
+
+func batchRefKey(ref *models.BatchRef) (int, int) {
+	return ref.ID, ref.Version
+}
@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/pdf"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// prepareService trims the name and upper-cases the code
+func prepareService(sv *models.Service) error {
+	sv.Code = strings.ToUpper(strings.TrimSpace(sv.Code))
+	sv.Name = strings.TrimSpace(sv.Name)
+	sv.Specialty = strings.ToLower(strings.TrimSpace(sv.Specialty))
+	return sv.Validate()
+}
+
+// GetServicesHandler lists the billing catalog; inactive services with ?inactive=true
+func GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	inactive := false
+	if s := r.URL.Query().Get("inactive"); s != "" {
+		var err error
+		if inactive, err = strconv.ParseBool(s); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "inactive must be true or false")
+			return
+		}
+	}
+
+	services, err := storage.Store.GetServices(r.Context(), inactive)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch services: "+err.Error())
+		return
+	}
+	if services == nil {
+		services = []models.Service{}
+	}
+	utils.RespondJSON(w, http.StatusOK, services)
+}
+
+func GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	service, err := storage.Store.GetService(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Service not found", "failed to fetch service: ")
+		return
+	}
+	if utils.NotModified(w, r, service.Version) {
+		return
+	}
+	utils.SetETag(w, service.Version)
+	utils.RespondJSON(w, http.StatusOK, service)
+}
+
+// CreateServiceHandler adds a service to the catalog; it is active unless the body says otherwise
+func CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	service := models.Service{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareService(&service); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := storage.Store.CreateService(r.Context(), &service)
+	respondCreated(w, err, service.Version, created, "failed to create service: ")
+}
+
+func UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Service
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareService(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.Version = id, version
+
+	if err := storage.Store.UpdateService(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Service not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// checkServices answers 400 when a line names a service that does not exist or is withdrawn
+func checkServices(w http.ResponseWriter, r *http.Request, lines []models.InvoiceLine) bool {
+	for i, l := range lines {
+		if l.ServiceID == 0 {
+			continue
+		}
+		service, err := storage.Store.GetService(r.Context(), l.ServiceID)
+		if err != nil {
+			respondReferenceError(w, err, fmt.Sprintf("lines[%d].service_id does not exist", i), "failed to fetch service: ")
+			return false
+		}
+		if !service.Active {
+			utils.RespondError(w, http.StatusBadRequest, fmt.Sprintf("lines[%d]: service %s is not active", i, service.Code))
+			return false
+		}
+	}
+	return true
+}
+
+// prepareLines trims the free text of submitted lines
+func prepareLines(lines []models.InvoiceLine) {
+	for i := range lines {
+		lines[i].Description = strings.TrimSpace(lines[i].Description)
+		lines[i].Code = strings.ToUpper(strings.TrimSpace(lines[i].Code))
+	}
+}
+
+// GetInvoicesHandler lists invoices, the latest first, optionally of one ?patient_id=, ?appointment_id= and ?status=
+func GetInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, ok := idParam(w, r, "patient_id")
+	if !ok {
+		return
+	}
+	appointmentID, ok := idParam(w, r, "appointment_id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.InvoiceStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.InvoiceStatuses, ", "))
+		return
+	}
+
+	invoices, err := storage.Store.GetInvoices(r.Context(), patientID, appointmentID, status)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch invoices: "+err.Error())
+		return
+	}
+	if invoices == nil {
+		invoices = []models.Invoice{}
+	}
+	utils.RespondJSON(w, http.StatusOK, invoices)
+}
+
+// CreateInvoiceHandler drafts an invoice by hand, e.g. for services outside an
+// appointment; completed appointments get theirs automatically
+func CreateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var invoice models.Invoice
+	if err := json.NewDecoder(r.Body).Decode(&invoice); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if invoice.PatientID <= 0 {
+		utils.RespondError(w, http.StatusBadRequest, "patient_id is required")
+		return
+	}
+	invoice.Notes = strings.TrimSpace(invoice.Notes)
+	prepareLines(invoice.Lines)
+	if err := invoice.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	invoice.CreatedBy = currentUser(r)
+
+	if _, err := storage.Store.GetPatientByID(ctx, invoice.PatientID); err != nil {
+		respondReferenceError(w, err, "patient_id does not exist", "failed to fetch patient: ")
+		return
+	}
+	if invoice.AppointmentID != 0 {
+		appointment, err := storage.Store.GetAppointmentByID(ctx, invoice.AppointmentID)
+		if err != nil {
+			respondReferenceError(w, err, "appointment_id does not exist", "failed to fetch appointment: ")
+			return
+		}
+		if appointment.PatientID != invoice.PatientID {
+			utils.RespondError(w, http.StatusBadRequest, "appointment_id is not an appointment of the patient")
+			return
+		}
+	}
+	if !checkServices(w, r, invoice.Lines) {
+		return
+	}
+
+	created, err := storage.Store.CreateInvoice(ctx, &invoice)
+	if errors.Is(err, storage.ErrDuplicate) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create invoice: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoice, err := storage.Store.GetInvoice(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Invoice not found", "failed to fetch invoice: ")
+		return
+	}
+	if utils.NotModified(w, r, invoice.Version) {
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+// InvoicePDFHandler renders an invoice as a printable PDF
+func InvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoice, err := storage.Store.GetInvoice(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Invoice not found", "failed to fetch invoice: ")
+		return
+	}
+	name := invoice.Number
+	if name == "" {
+		name = fmt.Sprintf("draft-%d", invoice.ID)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="invoice-%s.pdf"`, name))
+	utils.SetETag(w, invoice.Version)
+	w.WriteHeader(http.StatusOK)
+	invoicePDF(invoice).WriteTo(w)
+}
+
+// money formats minor units with two decimals
+func money(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// clip cuts s to at most n characters as printed
+func clip(s string, n int) string {
+	s = pdf.Transliterate(s)
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n-1]) + "~"
+	}
+	return s
+}
+
+func invoicePDF(inv *models.Invoice) *pdf.Document {
+	title := "INVOICE " + inv.Number
+	if inv.Number == "" {
+		title = fmt.Sprintf("DRAFT INVOICE #%d", inv.ID)
+	}
+	doc := &pdf.Document{Title: title}
+	rule := strings.Repeat("-", 78)
+
+	doc.AddBold("%s", title)
+	doc.Add("")
+	doc.Add("Patient:  %s (#%d)", inv.PatientName, inv.PatientID)
+	if inv.AppointmentID != 0 {
+		doc.Add("Visit:    appointment #%d", inv.AppointmentID)
+	}
+	if inv.IssuedAt != nil {
+		doc.Add("Issued:   %s", inv.IssuedAt.In(CalendarLocation).Format("2006-01-02"))
+	}
+	if inv.DueDate != "" {
+		doc.Add("Due:      %s", inv.DueDate)
+	}
+	doc.Add("Status:   %s", inv.Status)
+	doc.Add("")
+	doc.AddBold("%-10s %-30s %5s %11s %5s %12s", "Code", "Description", "Qty", "Price", "Disc", "Amount")
+	doc.Add("%s", rule)
+	for _, l := range inv.Lines {
+		discount := ""
+		if l.DiscountPercent != 0 {
+			discount = fmt.Sprintf("%d%%", l.DiscountPercent)
+		}
+		doc.Add("%-10s %-30s %5d %11s %5s %12s", clip(l.Code, 10), clip(l.Description, 30), l.Quantity, money(l.UnitPrice), discount, money(l.Amount))
+	}
+	doc.Add("%s", rule)
+	doc.Add("%65s %12s", "Subtotal", money(inv.Subtotal))
+	if inv.Discount != 0 {
+		doc.Add("%65s %12s", "Discount", "-"+money(inv.Discount))
+	}
+	doc.AddBold("%65s %12s", "Total "+inv.Currency, money(inv.Total))
+	doc.Add("%65s %12s", "Paid", money(inv.Paid))
+	doc.AddBold("%65s %12s", "Balance due", money(inv.Balance))
+
+	if len(inv.Payments) > 0 {
+		doc.Add("")
+		doc.AddBold("Payments")
+		for _, p := range inv.Payments {
+			amount := money(p.Amount)
+			if p.Kind == models.PaymentRefund {
+				amount = "-" + amount
+			}
+			doc.Add("%-10s %-8s %-10s %-35s %12s", p.ReceivedAt.In(CalendarLocation).Format("2006-01-02"), p.Kind, p.Method, clip(p.Reference, 35), amount)
+		}
+	}
+	if inv.Notes != "" {
+		doc.Add("")
+		doc.Add("%s", clip(inv.Notes, pdf.Columns))
+	}
+	doc.Add("")
+	doc.Add("All amounts in %s.", inv.Currency)
+	return doc
+}
+
+// UpdateInvoiceHandler sets the discount, due date and notes of a draft
+func UpdateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var terms models.Invoice
+	if err := json.NewDecoder(r.Body).Decode(&terms); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	terms.Lines = nil
+	if err := terms.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoice, err := storage.Store.UpdateInvoice(r.Context(), id, version, terms.Discount, terms.DueDate, strings.TrimSpace(terms.Notes))
+	if err != nil {
+		respondWriteError(w, err, "Invoice not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+// AddInvoiceLineHandler adds a line to a draft; a service from the catalog sets the code and price
+func AddInvoiceLineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	line := models.InvoiceLine{Quantity: 1}
+	if err := json.NewDecoder(r.Body).Decode(&line); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	lines := []models.InvoiceLine{line}
+	prepareLines(lines)
+	if err := lines[0].Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkServices(w, r, lines) {
+		return
+	}
+
+	invoice, err := storage.Store.AddInvoiceLine(r.Context(), id, version, &lines[0])
+	if err != nil {
+		respondWriteError(w, err, "Invoice not found", "failed to add invoice line: ")
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+func DeleteInvoiceLineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lineID, err := utils.PathID(r, "line_id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	invoice, err := storage.Store.DeleteInvoiceLine(r.Context(), id, lineID, version)
+	if err != nil {
+		respondWriteError(w, err, "Invoice or line not found", "failed to delete invoice line: ")
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+// changeInvoice answers a status change of an invoice made by change
+func changeInvoice(w http.ResponseWriter, r *http.Request, change func(r *http.Request, id, version int) (*models.Invoice, error)) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	invoice, err := change(r, id, version)
+	if err != nil {
+		respondWriteError(w, err, "Invoice not found", "failed to update invoice: ")
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+// IssueInvoiceHandler numbers a draft and opens it for payment
+func IssueInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	changeInvoice(w, r, func(r *http.Request, id, version int) (*models.Invoice, error) {
+		return storage.Store.IssueInvoice(r.Context(), id, version)
+	})
+}
+
+// VoidInvoiceHandler cancels an invoice nothing is paid on
+func VoidInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	changeInvoice(w, r, func(r *http.Request, id, version int) (*models.Invoice, error) {
+		return storage.Store.VoidInvoice(r.Context(), id, version)
+	})
+}
+
+// recordPayment reads a payment of kind from the body and records it
+func recordPayment(w http.ResponseWriter, r *http.Request, kind string) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var payment models.Payment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	payment.Method = strings.ToLower(strings.TrimSpace(payment.Method))
+	payment.Reference = strings.TrimSpace(payment.Reference)
+	if err := payment.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payment.Kind, payment.ReceivedBy = kind, currentUser(r)
+
+	invoice, err := storage.Store.RecordPayment(r.Context(), id, version, &payment)
+	if err != nil {
+		respondWriteError(w, err, "Invoice not found", "failed to record payment: ")
+		return
+	}
+	utils.SetETag(w, invoice.Version)
+	utils.RespondJSON(w, http.StatusOK, invoice)
+}
+
+// CreatePaymentHandler records money received; 409 over the balance
+func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	recordPayment(w, r, models.PaymentReceived)
+}
+
+// CreateRefundHandler pays money back; 409 over what was paid
+func CreateRefundHandler(w http.ResponseWriter, r *http.Request) {
+	recordPayment(w, r, models.PaymentRefund)
+}
+
+// GetPatientBalanceHandler sums what a patient was invoiced and paid and lists the unpaid invoices
+func GetPatientBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	balance, err := storage.Store.GetPatientBalance(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch balance: ")
+		return
+	}
+	if balance.Invoices == nil {
+		balance.Invoices = []models.Invoice{}
+	}
+	utils.RespondJSON(w, http.StatusOK, balance)
+}
+
+// GetOutstandingBalancesHandler lists the patients who owe money, the largest balance first
+func GetOutstandingBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	balances, err := storage.Store.GetOutstandingBalances(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch balances: "+err.Error())
+		return
+	}
+	if balances == nil {
+		balances = []models.PatientBalance{}
+	}
+	utils.RespondJSON(w, http.StatusOK, balances)
+}
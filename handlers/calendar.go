@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/auth"
+	"github.com/TeseySTD/GoHospitalApi/ical"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// Appointment date and time are stored without a zone; they are local to the hospital
+var CalendarLocation = time.UTC
+
+const (
+	feedPatient = "patient"
+	feedDoctor  = "doctor"
+)
+
+func PatientCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patient, err := storage.Store.GetPatientByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	appointments, err := storage.Store.GetAppointmentDetails(ctx, id, 0)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch appointments: "+err.Error())
+		return
+	}
+
+	cal := newCalendar(fmt.Sprintf("Appointments - %s %s", patient.FirstName, patient.LastName))
+	for _, a := range appointments {
+		summary := "Doctor appointment"
+		if a.DoctorName != "" {
+			summary = "Appointment with Dr. " + a.DoctorName
+		}
+		if ev, ok := calendarEvent(a, summary, a.Specialization); ok {
+			cal.Events = append(cal.Events, ev)
+		}
+	}
+	writeCalendar(w, cal)
+}
+
+func DoctorCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	doctor, err := storage.Store.GetDoctorByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+
+	appointments, err := storage.Store.GetAppointmentDetails(ctx, 0, id)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch appointments: "+err.Error())
+		return
+	}
+
+	cal := newCalendar(fmt.Sprintf("Schedule - Dr. %s %s", doctor.FirstName, doctor.LastName))
+	for _, a := range appointments {
+		if ev, ok := calendarEvent(a, "Patient: "+a.PatientName, ""); ok {
+			cal.Events = append(cal.Events, ev)
+		}
+	}
+	writeCalendar(w, cal)
+}
+
+// PatientCalendarTokenHandler returns a subscription URL for calendar apps
+func PatientCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	calendarToken(w, r, feedPatient, "/patients/")
+}
+
+func DoctorCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	calendarToken(w, r, feedDoctor, "/doctors/")
+}
+
+func calendarToken(w http.ResponseWriter, r *http.Request, kind, prefix string) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := auth.GenerateFeedToken(kind, id)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusOK, map[string]string{
+		"token": token,
+		"url":   fmt.Sprintf("%s%d/calendar.ics?token=%s", prefix, id, token),
+	})
+}
+
+func newCalendar(name string) *ical.Calendar {
+	return &ical.Calendar{ProdID: "-//GoHospitalApi//Appointments//EN", Name: name}
+}
+
+// calendarEvent converts an appointment; rows without a date cannot be placed on a calendar
+func calendarEvent(a models.AppointmentDetails, summary, description string) (ical.Event, bool) {
+	clock := a.Time
+	if clock == "" {
+		clock = "00:00:00"
+	}
+	start, err := time.ParseInLocation("2006-01-02 15:04:05", a.Date+" "+clock, CalendarLocation)
+	if err != nil {
+		return ical.Event{}, false
+	}
+
+	return ical.Event{
+		UID:         fmt.Sprintf("appointment-%d@gohospitalapi", a.ID),
+		Sequence:    a.Version,
+		Modified:    a.UpdatedAt,
+		Start:       start,
+		End:         start.Add(storage.AppointmentDuration),
+		Summary:     summary,
+		Description: description,
+		Status:      calendarStatus(a.Status),
+	}, true
+}
+
+func calendarStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "cancelled", "canceled":
+		return ical.StatusCancelled
+	case "pending", "tentative":
+		return ical.StatusTentative
+	default:
+		return ical.StatusConfirmed
+	}
+}
+
+func writeCalendar(w http.ResponseWriter, cal *ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="calendar.ics"`)
+	w.WriteHeader(http.StatusOK)
+	cal.WriteTo(w)
+}
+
+func respondLookupError(w http.ResponseWriter, err error, notFound, prefix string) {
+	if strings.Contains(err.Error(), "no rows") || strings.Contains(err.Error(), "not found") {
+		utils.RespondError(w, http.StatusNotFound, notFound)
+	} else {
+		utils.RespondError(w, http.StatusInternalServerError, prefix+err.Error())
+	}
+}
+
+// respondReferenceError answers 400 when an id in the request body names a missing row
+func respondReferenceError(w http.ResponseWriter, err error, missing, prefix string) {
+	if strings.Contains(err.Error(), "no rows") || strings.Contains(err.Error(), "not found") {
+		utils.RespondError(w, http.StatusBadRequest, missing)
+		return
+	}
+	utils.RespondError(w, http.StatusInternalServerError, prefix+err.Error())
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/claimfile"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// ClaimSender identifies the hospital in the header of claim files
+var ClaimSender = "HOSPITAL"
+
+// maxClaimRange caps how many days one claim run may cover
+const maxClaimRange = 92
+
+// GetClaimsHandler lists claims, the latest first, optionally of one ?patient_id=, ?payer_id= and ?status=
+func GetClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, ok := idParam(w, r, "patient_id")
+	if !ok {
+		return
+	}
+	payerID, ok := idParam(w, r, "payer_id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.ClaimStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.ClaimStatuses, ", "))
+		return
+	}
+
+	claims, err := storage.Store.GetClaims(r.Context(), patientID, payerID, status)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch claims: "+err.Error())
+		return
+	}
+	if claims == nil {
+		claims = []models.Claim{}
+	}
+	utils.RespondJSON(w, http.StatusOK, claims)
+}
+
+func GetClaimHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claim, err := storage.Store.GetClaim(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Claim not found", "failed to fetch claim: ")
+		return
+	}
+	if utils.NotModified(w, r, claim.Version) {
+		return
+	}
+	utils.SetETag(w, claim.Version)
+	utils.RespondJSON(w, http.StatusOK, claim)
+}
+
+// CreateClaimHandler claims the payer's share of an issued invoice
+func CreateClaimHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claim, err := storage.Store.CreateClaim(r.Context(), id, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Invoice not found", "failed to create claim: ")
+		return
+	}
+	utils.SetETag(w, claim.Version)
+	utils.RespondJSON(w, http.StatusCreated, claim)
+}
+
+// claimRange reads ?from= and ?to= as dates; the default is the last week up to today
+func claimRange(w http.ResponseWriter, r *http.Request) (from, to string, ok bool) {
+	today := time.Now().In(CalendarLocation)
+	from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if to == "" {
+		to = today.Format("2006-01-02")
+	}
+	end, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "to must be in YYYY-MM-DD format")
+		return "", "", false
+	}
+	if from == "" {
+		from = end.AddDate(0, 0, -6).Format("2006-01-02")
+	}
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "from must be in YYYY-MM-DD format")
+		return "", "", false
+	}
+	if end.Before(start) || end.Sub(start) >= maxClaimRange*24*time.Hour {
+		utils.RespondError(w, http.StatusBadRequest, fmt.Sprintf("to must not be before from and the range must not exceed %d days", maxClaimRange))
+		return "", "", false
+	}
+	return from, to, true
+}
+
+// GenerateClaimsHandler claims the invoices of the visits completed between
+// ?from= and ?to=; invoices that are drafts or not covered are reported as skipped
+func GenerateClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := claimRange(w, r)
+	if !ok {
+		return
+	}
+
+	run, err := storage.Store.GenerateClaims(r.Context(), from, to, currentUser(r))
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to generate claims: "+err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, run)
+}
+
+// ChangeClaimStatusHandler records what the payer did with a claim; a paid
+// claim posts the remittance to its invoice, refunding a patient who paid up front
+func ChangeClaimStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var change models.ClaimStatusChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	change.Note = strings.TrimSpace(change.Note)
+	if err := change.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	change.ChangedBy = currentUser(r)
+
+	claim, err := storage.Store.ChangeClaimStatus(r.Context(), id, version, &change)
+	if err != nil {
+		respondWriteError(w, err, "Claim not found", "failed to update claim: ")
+		return
+	}
+	utils.SetETag(w, claim.Version)
+	utils.RespondJSON(w, http.StatusOK, claim)
+}
+
+// payerParam reads the required ?payer_id= and loads the payer
+func payerParam(w http.ResponseWriter, r *http.Request) (*models.Payer, bool) {
+	id, ok := idParam(w, r, "payer_id")
+	if !ok {
+		return nil, false
+	}
+	if id == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "payer_id is required")
+		return nil, false
+	}
+	payer, err := storage.Store.GetPayer(r.Context(), id)
+	if err != nil {
+		respondReferenceError(w, err, "payer_id does not exist", "failed to fetch payer: ")
+		return nil, false
+	}
+	return payer, true
+}
+
+// ExportClaimsHandler downloads the claims of ?payer_id= in ?status= (default
+// ready) as a claim file without changing them
+func ExportClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	payer, ok := payerParam(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ClaimReady
+	}
+	if !slices.Contains(models.ClaimStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.ClaimStatuses, ", "))
+		return
+	}
+
+	claims, err := storage.Store.GetClaimsForExport(r.Context(), payer.ID, status)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch claims: "+err.Error())
+		return
+	}
+	respondClaimFile(w, r, payer, claims)
+}
+
+// SubmitClaimsHandler marks the ready claims of ?payer_id= submitted and
+// answers the claim file to send to the payer
+func SubmitClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	payer, ok := payerParam(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := storage.Store.SubmitClaims(r.Context(), payer.ID, currentUser(r))
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to submit claims: "+err.Error())
+		return
+	}
+	respondClaimFile(w, r, payer, claims)
+}
+
+// respondClaimFile writes claims as an X12 837P batch for payer
+func respondClaimFile(w http.ResponseWriter, r *http.Request, payer *models.Payer, claims []models.Claim) {
+	now := time.Now()
+	batch := claimfile.Batch{
+		Sender:    ClaimSender,
+		Payer:     payer.Code,
+		PayerName: payer.Name,
+		Control:   int(now.Unix() % 1000000000),
+		ID:        fmt.Sprintf("%s-%s", payer.Code, now.In(CalendarLocation).Format("20060102150405")),
+		Created:   now,
+		Currency:  storage.BillingCurrency,
+	}
+	patients := map[int]*models.Patient{}
+	for _, c := range claims {
+		patient, cached := patients[c.PatientID]
+		if !cached {
+			var err error
+			if patient, err = storage.Store.GetPatientByID(r.Context(), c.PatientID); err != nil {
+				utils.RespondError(w, http.StatusInternalServerError, "failed to fetch patient: "+err.Error())
+				return
+			}
+			patients[c.PatientID] = patient
+		}
+		claim := claimfile.Claim{
+			Number:      c.Number,
+			MemberID:    c.MemberID,
+			GroupNumber: c.GroupNumber,
+			LastName:    patient.LastName,
+			FirstName:   patient.FirstName,
+			BirthDate:   patient.DateOfBirth,
+			Sex:         patient.Sex,
+			ServiceDate: c.ServiceDate,
+			Doctor:      c.DoctorName,
+			Diagnoses:   c.Diagnoses,
+			TotalCharge: c.TotalCharge,
+			Copay:       c.Copay,
+		}
+		for _, l := range c.Lines {
+			claim.Lines = append(claim.Lines, claimfile.Line{Code: l.Code, Description: l.Description, Quantity: l.Quantity, Amount: l.Amount})
+		}
+		batch.Claims = append(batch.Claims, claim)
+	}
+
+	filename := "claims-" + batch.ID + ".x12"
+	w.Header().Set("Content-Type", claimfile.ContentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
+	batch.WriteTo(w)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/TeseySTD/GoHospitalApi/auth"
+	"github.com/TeseySTD/GoHospitalApi/middleware"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// includeDeleted parses ?include_deleted=, which lists soft-deleted rows too.
+// Only admins may see deleted rows.
+func includeDeleted(w http.ResponseWriter, r *http.Request) (include bool, ok bool) {
+	s := r.URL.Query().Get("include_deleted")
+	if s == "" {
+		return false, true
+	}
+	include, err := strconv.ParseBool(s)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "include_deleted must be true or false")
+		return false, false
+	}
+	if role, _ := r.Context().Value(middleware.RoleContextKey).(string); include && !auth.IsAdmin(role) {
+		utils.RespondError(w, http.StatusForbidden, "include_deleted requires admin access")
+		return false, false
+	}
+	return include, true
+}
+
+// currentUser is the authenticated user recorded with deletions and merges
+func currentUser(r *http.Request) string {
+	user, _ := r.Context().Value(middleware.UserContextKey).(string)
+	return user
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/specialties"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// GetSpecialtiesHandler lists the specialty vocabulary, narrowed by ?q= to the
+// specialties whose code, name or a synonym contains the text
+func GetSpecialtiesHandler(w http.ResponseWriter, r *http.Request) {
+	all := specialties.All()
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if q == "" {
+		utils.RespondJSON(w, http.StatusOK, all)
+		return
+	}
+	matches := []specialties.Specialty{}
+	for _, s := range all {
+		names := append([]string{s.Code, s.Name}, s.Synonyms...)
+		if slices.ContainsFunc(names, func(name string) bool { return strings.Contains(strings.ToLower(name), q) }) {
+			matches = append(matches, s)
+		}
+	}
+	utils.RespondJSON(w, http.StatusOK, matches)
+}
+
+// checkParentDepartment answers 400 when the parent_id in the body does not exist; 0 is skipped
+func checkParentDepartment(w http.ResponseWriter, r *http.Request, parentID int) bool {
+	if parentID == 0 {
+		return true
+	}
+	if _, err := storage.Store.GetDepartment(r.Context(), parentID); err != nil {
+		respondReferenceError(w, err, "parent_id does not exist", "failed to fetch department: ")
+		return false
+	}
+	return true
+}
+
+// GetDepartmentTreeHandler returns the departments nested under their parents
+func GetDepartmentTreeHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := storage.Store.GetDepartmentTree(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch departments: "+err.Error())
+		return
+	}
+	if tree == nil {
+		tree = []models.Department{}
+	}
+	utils.RespondJSON(w, http.StatusOK, tree)
+}
+
+func respondMembers(w http.ResponseWriter, members []models.DepartmentMember, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch department members: "+err.Error())
+		return
+	}
+	if members == nil {
+		members = []models.DepartmentMember{}
+	}
+	utils.RespondJSON(w, http.StatusOK, members)
+}
+
+// GetDepartmentMembersHandler lists the staff of a department, the most senior
+// first; ?role= narrows to one role and ?subdepartments=true adds the staff of
+// the departments nested under it
+func GetDepartmentMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := r.URL.Query()
+	role := query.Get("role")
+	if role != "" && !slices.Contains(models.DepartmentRoles, role) {
+		utils.RespondError(w, http.StatusBadRequest, "role must be one of "+strings.Join(models.DepartmentRoles, ", "))
+		return
+	}
+	subdepartments := false
+	switch query.Get("subdepartments") {
+	case "", "false":
+	case "true":
+		subdepartments = true
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "subdepartments must be true or false")
+		return
+	}
+
+	if _, err := storage.Store.GetDepartment(ctx, id); err != nil {
+		respondLookupError(w, err, "Department not found", "failed to fetch department: ")
+		return
+	}
+	members, err := storage.Store.GetDepartmentMembers(ctx, id, role, subdepartments)
+	respondMembers(w, members, err)
+}
+
+// GetDepartmentHeadHandler returns the head of a department; 404 when the post is vacant
+func GetDepartmentHeadHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetDepartment(ctx, id); err != nil {
+		respondLookupError(w, err, "Department not found", "failed to fetch department: ")
+		return
+	}
+	head, err := storage.Store.GetDepartmentHead(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "The department has no head", "failed to fetch department head: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, head)
+}
+
+// SetDepartmentMemberHandler puts a doctor on the staff of a department or
+// changes the role; 201 when the doctor joins, 409 for a second head
+func SetDepartmentMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	doctorID, err := utils.PathID(r, "doctor_id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var member models.DepartmentMember
+	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	member.Role = strings.ToLower(strings.TrimSpace(member.Role))
+	if member.JoinedAt.IsZero() {
+		member.JoinedAt = time.Now()
+	}
+	if err := member.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	member.DepartmentID, member.DoctorID = id, doctorID
+
+	if _, err := storage.Store.GetDepartment(ctx, id); err != nil {
+		respondLookupError(w, err, "Department not found", "failed to fetch department: ")
+		return
+	}
+	if _, err := storage.Store.GetDoctorByID(ctx, doctorID); err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+
+	saved, created, err := storage.Store.SetDepartmentMember(ctx, &member)
+	if err != nil {
+		respondWriteError(w, err, "Doctor not found", "failed to save department member: ")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	utils.RespondJSON(w, status, saved)
+}
+
+// RemoveDepartmentMemberHandler takes a doctor off the staff of a department
+func RemoveDepartmentMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	doctorID, err := utils.PathID(r, "doctor_id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := storage.Store.RemoveDepartmentMember(r.Context(), id, doctorID); err != nil {
+		respondLookupError(w, err, "The doctor is not on the staff of this department", "failed to remove department member: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Department member removed"})
+}
+
+// GetDoctorDepartmentsHandler lists the departments of a doctor with the role in each
+func GetDoctorDepartmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetDoctorByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+	members, err := storage.Store.GetDoctorDepartments(ctx, id)
+	respondMembers(w, members, err)
+}
+
+// departmentStaff reads ?department_id= and returns the doctors on the staff of
+// the department and its sub-departments; nil means the parameter is absent
+func departmentStaff(w http.ResponseWriter, r *http.Request) (map[int]bool, bool) {
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok || departmentID == 0 {
+		return nil, ok
+	}
+	ids, err := storage.Store.GetDepartmentDoctorIDs(r.Context(), departmentID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch department members: "+err.Error())
+		return nil, false
+	}
+	staff := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		staff[id] = true
+	}
+	return staff, true
+}
+
+// onStaff keeps the items whose doctor is in staff; a nil staff keeps everything
+func onStaff[T any](items []T, staff map[int]bool, doctorID func(*T) int) []T {
+	if staff == nil {
+		return items
+	}
+	return slices.DeleteFunc(items, func(item T) bool { return !staff[doctorID(&item)] })
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -13,26 +15,39 @@ import (
 
 func GetDoctorsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	staff, ok := departmentStaff(w, r)
+	if !ok {
+		return
+	}
 
-	doctors, err := storage.Store.GetAllDoctors(ctx)
+	doctors, err := storage.Store.GetAllDoctors(ctx, include)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch doctors: "+err.Error())
 		return
 	}
+	doctors = onStaff(doctors, staff, func(d *models.Doctor) int { return d.ID })
 
-	query := r.URL.Query()
+	utils.RespondJSON(w, http.StatusOK, filterDoctors(doctors, r.URL.Query()))
+}
+
+// filterDoctors applies the list query parameters; it never returns nil
+func filterDoctors(doctors []models.Doctor, query url.Values) []models.Doctor {
 	firstName := strings.ToLower(query.Get("first_name"))
 	lastName := strings.ToLower(query.Get("last_name"))
 	specialization := strings.ToLower(query.Get("specialization"))
+	specialty := query.Get("specialty")
 	experienceStr := query.Get("experience")
 	minExpStr := query.Get("min_experience")
 
-	if firstName == "" && lastName == "" && specialization == "" && experienceStr == "" && minExpStr == "" {
+	if firstName == "" && lastName == "" && specialization == "" && specialty == "" && experienceStr == "" && minExpStr == "" {
 		if doctors == nil {
-			doctors = []models.Doctor{}
+			return []models.Doctor{}
 		}
-		utils.RespondJSON(w, http.StatusOK, doctors)
-		return
+		return doctors
 	}
 
 	var filtered []models.Doctor
@@ -48,6 +63,9 @@ func GetDoctorsHandler(w http.ResponseWriter, r *http.Request) {
 		if specialization != "" && !strings.Contains(strings.ToLower(doctor.Specialization), specialization) {
 			match = false
 		}
+		if specialty != "" && !strings.EqualFold(doctor.SpecialtyCode, specialty) {
+			match = false
+		}
 		if experienceStr != "" {
 			experience, err := strconv.Atoi(experienceStr)
 			if err != nil || doctor.Experience != experience {
@@ -69,12 +87,16 @@ func GetDoctorsHandler(w http.ResponseWriter, r *http.Request) {
 	if filtered == nil {
 		filtered = []models.Doctor{}
 	}
-	utils.RespondJSON(w, http.StatusOK, filtered)
+	return filtered
 }
 
 func GetDoctorHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/doctors/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	doctor, err := storage.Store.GetDoctorByID(ctx, id)
 	if err != nil {
@@ -85,6 +107,10 @@ func GetDoctorHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if utils.NotModified(w, r, doctor.Version) {
+		return
+	}
+	utils.SetETag(w, doctor.Version)
 	utils.RespondJSON(w, http.StatusOK, doctor)
 }
 
@@ -96,67 +122,239 @@ func CreateDoctorHandler(w http.ResponseWriter, r *http.Request) {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := doctor.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	created, err := storage.Store.CreateDoctor(ctx, &doctor)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to create doctor: "+err.Error())
 		return
 	}
+	utils.SetETag(w, created.Version)
 	utils.RespondJSON(w, http.StatusCreated, created)
 }
 
 func UpdateDoctorHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/doctors/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var updatedDoctor models.Doctor
 	if err := json.NewDecoder(r.Body).Decode(&updatedDoctor); err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := updatedDoctor.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	updatedDoctor.ID = id
+	updatedDoctor.Version = version
 
 	if err := storage.Store.UpdateDoctor(ctx, &updatedDoctor); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Doctor not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "update failed: "+err.Error())
-		}
+		respondWriteError(w, err, "Doctor not found", "update failed: ")
 		return
 	}
+	utils.SetETag(w, updatedDoctor.Version)
 	utils.RespondJSON(w, http.StatusOK, updatedDoctor)
 }
 
+// PatchDoctorHandler accepts a JSON Merge Patch or a JSON Patch for the current row
+func PatchDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, patch, perr := readPatch(r)
+	if perr != nil {
+		respondPatchError(w, perr, "Doctor not found")
+		return
+	}
+
+	updated, err := storage.Store.PatchDoctor(ctx, id, version, func(d *models.Doctor) error {
+		return patchInto(d, mediaType, patch)
+	})
+	if err != nil {
+		respondPatchError(w, err, "Doctor not found")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
 func DeleteDoctorHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/doctors/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
-	if err := storage.Store.DeleteDoctor(ctx, id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Doctor not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "delete failed: "+err.Error())
-		}
+	if err := storage.Store.DeleteDoctor(ctx, id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Doctor not found", "delete failed: ")
 		return
 	}
 	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Doctor deleted"})
 }
 
-func DoctorsRouter(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		if strings.HasPrefix(r.URL.Path, "/doctors/") && len(r.URL.Path) > len("/doctors/") {
-			GetDoctorHandler(w, r)
-		} else {
-			GetDoctorsHandler(w, r)
-		}
-	case http.MethodPost:
-		CreateDoctorHandler(w, r)
-	case http.MethodPut:
-		UpdateDoctorHandler(w, r)
-	case http.MethodDelete:
-		DeleteDoctorHandler(w, r)
-	default:
-		utils.RespondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+// RestoreDoctorHandler brings back a soft-deleted doctor
+func RestoreDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+
+	doctor, err := storage.Store.RestoreDoctor(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Doctor not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, doctor.Version)
+	utils.RespondJSON(w, http.StatusOK, doctor)
+}
+
+func GetDoctorAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetDoctorByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+
+	appointments, err := storage.Store.GetAppointmentDetails(ctx, 0, id)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch appointments: "+err.Error())
+		return
+	}
+	if appointments == nil {
+		appointments = []models.AppointmentDetails{}
+	}
+	utils.RespondJSON(w, http.StatusOK, appointments)
+}
+
+func GetDoctorPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetDoctorByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+
+	patients, err := storage.Store.GetDoctorPatients(ctx, id)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch patients: "+err.Error())
+		return
+	}
+	if patients == nil {
+		patients = []models.Patient{}
+	}
+	utils.RespondJSON(w, http.StatusOK, patients)
+}
+
+// CreateDoctorsBatchHandler inserts an array of doctors; large batches are sent with COPY
+func CreateDoctorsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Doctor]{
+		validate: (*models.Doctor).Validate,
+		key:      doctorKey,
+		status:   http.StatusCreated,
+		write:    storage.Store.CreateDoctors,
+		notFound: "Doctor not found",
+	})
+}
+
+// UpdateDoctorsBatchHandler overwrites an array of doctors; each item carries its id and version
+func UpdateDoctorsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Doctor]{
+		validate: (*models.Doctor).Validate,
+		key:      doctorKey,
+		needKey:  true,
+		status:   http.StatusOK,
+		write:    storage.Store.UpdateDoctors,
+		notFound: "Doctor not found",
+	})
+}
+
+// DeleteDoctorsBatchHandler soft-deletes an array of {"id", "version"} references
+func DeleteDoctorsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	runBatchOp(w, r, batchOp[models.BatchRef]{
+		key:     batchRefKey,
+		needKey: true,
+		status:  http.StatusOK,
+		write: func(ctx context.Context, refs []*models.BatchRef, atomic bool) ([]error, error) {
+			return storage.Store.DeleteDoctors(ctx, refs, atomic, user)
+		},
+		notFound: "Doctor not found",
+	})
+}
+
+func doctorKey(d *models.Doctor) (int, int) {
+	return d.ID, d.Version
+}
+
+// doctorSheet describes doctors for spreadsheet export and import; duplicates
+// share the same name and specialization. A non-nil staff keeps only its doctors.
+func doctorSheet(includeDeleted bool, staff map[int]bool) sheetSpec[models.Doctor] {
+	return sheetSpec[models.Doctor]{
+		name: "doctors",
+		load: func(ctx context.Context) ([]models.Doctor, error) {
+			doctors, err := storage.Store.GetAllDoctors(ctx, includeDeleted)
+			return onStaff(doctors, staff, func(d *models.Doctor) int { return d.ID }), err
+		},
+		filter: filterDoctors,
+		create: storage.Store.CreateDoctors,
+		key: func(d *models.Doctor) string {
+			return strings.ToLower(strings.TrimSpace(d.FirstName) + "|" + strings.TrimSpace(d.LastName) + "|" + strings.TrimSpace(d.Specialization))
+		},
+		id: func(d *models.Doctor) int { return d.ID },
+	}
+}
+
+// ExportDoctorsHandler streams the filtered list as CSV or XLSX
+func ExportDoctorsHandler(w http.ResponseWriter, r *http.Request) {
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	staff, ok := departmentStaff(w, r)
+	if !ok {
+		return
+	}
+	exportSheet(w, r, doctorSheet(include, staff))
+}
+
+// ImportDoctorsHandler creates doctors from an uploaded CSV or XLSX file
+func ImportDoctorsHandler(w http.ResponseWriter, r *http.Request) {
+	importSheet[models.Doctor](w, r, doctorSheet(false, nil))
 }
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/dedupe"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+const (
+	defaultDuplicateLimit = 100
+	maxDuplicateLimit     = 1000
+)
+
+// duplicateOptions parses ?min_score= and ?limit=
+func duplicateOptions(w http.ResponseWriter, r *http.Request) (minScore float64, limit int, ok bool) {
+	query := r.URL.Query()
+	minScore, limit = dedupe.DefaultMinScore, defaultDuplicateLimit
+	if s := query.Get("min_score"); s != "" {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f <= 0 || f > 1 {
+			utils.RespondError(w, http.StatusBadRequest, "min_score must be a number above 0 and at most 1")
+			return 0, 0, false
+		}
+		minScore = f
+	}
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxDuplicateLimit {
+			utils.RespondError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxDuplicateLimit))
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return minScore, limit, true
+}
+
+// dismissedPairs loads the pairs reviewers marked as different people
+func dismissedPairs(r *http.Request) (func(a, b int) bool, error) {
+	pairs, err := storage.Store.GetDuplicateDismissals(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	dismissed := make(map[models.DuplicatePair]bool, len(pairs))
+	for _, p := range pairs {
+		dismissed[p] = true
+	}
+	return func(a, b int) bool {
+		return dismissed[models.DuplicatePair{PatientID: min(a, b), DuplicateID: max(a, b)}]
+	}, nil
+}
+
+// GetDuplicatesHandler is the review queue: probable duplicate pairs, best match first
+func GetDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	minScore, limit, ok := duplicateOptions(w, r)
+	if !ok {
+		return
+	}
+
+	patients, err := storage.Store.GetAllPatients(r.Context(), false)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch patients: "+err.Error())
+		return
+	}
+	skip, err := dismissedPairs(r)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch dismissed duplicates: "+err.Error())
+		return
+	}
+
+	candidates := dedupe.Find(patients, minScore, skip)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	utils.RespondJSON(w, http.StatusOK, candidates)
+}
+
+// GetPatientDuplicatesHandler lists probable duplicates of one patient, e.g. right after registration
+func GetPatientDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	minScore, limit, ok := duplicateOptions(w, r)
+	if !ok {
+		return
+	}
+
+	patient, err := storage.Store.GetPatientByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	patients, err := storage.Store.GetAllPatients(ctx, false)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch patients: "+err.Error())
+		return
+	}
+	skip, err := dismissedPairs(r)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch dismissed duplicates: "+err.Error())
+		return
+	}
+
+	candidates := dedupe.Matches(patient, patients, minScore, skip)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	utils.RespondJSON(w, http.StatusOK, candidates)
+}
+
+// DismissDuplicateHandler removes a pair of different people from the review queue
+func DismissDuplicateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var pair models.DuplicatePair
+	if err := json.NewDecoder(r.Body).Decode(&pair); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if pair.PatientID <= 0 || pair.DuplicateID <= 0 || pair.PatientID == pair.DuplicateID {
+		utils.RespondError(w, http.StatusBadRequest, "patient_id and duplicate_id must name two different patients")
+		return
+	}
+	for _, id := range []int{pair.PatientID, pair.DuplicateID} {
+		if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+			respondLookupError(w, err, "Patient "+strconv.Itoa(id)+" not found", "failed to fetch patient: ")
+			return
+		}
+	}
+
+	if err := storage.Store.DismissDuplicate(ctx, pair.PatientID, pair.DuplicateID, currentUser(r)); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to dismiss duplicate: "+err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Pair dismissed from the duplicate queue"})
+}
+
+// MergeRequest names the record merged into the patient of the path
+type MergeRequest struct {
+	DuplicateID int `json:"duplicate_id"`
+}
+
+// MergePatientHandler merges the patient named by duplicate_id into {id}.
+// Appointments and clinical data move to {id}, empty fields of {id} are
+// filled from the duplicate and the duplicate record is removed.
+func MergePatientHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var body MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if body.DuplicateID <= 0 || body.DuplicateID == id {
+		utils.RespondError(w, http.StatusBadRequest, "duplicate_id must name another patient")
+		return
+	}
+
+	merge, survivor, err := storage.Store.MergePatients(ctx, id, body.DuplicateID, currentUser(r))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.RespondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondWriteError(w, err, "Patient not found", "merge failed: ")
+		return
+	}
+	utils.SetETag(w, survivor.Version)
+	utils.RespondJSON(w, http.StatusOK, models.MergeResult{Patient: *survivor, Merge: *merge})
+}
+
+// GetPatientMergesHandler lists the records merged into a patient, or the merge that removed it
+func GetPatientMergesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	merges, err := storage.Store.GetPatientMerges(r.Context(), id)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch merges: "+err.Error())
+		return
+	}
+	if merges == nil {
+		merges = []models.PatientMerge{}
+	}
+	utils.RespondJSON(w, http.StatusOK, merges)
+}
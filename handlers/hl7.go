@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/hl7"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+func GetHL7MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 {
+			utils.RespondError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = l
+	}
+
+	messages, err := storage.Store.GetHL7Messages(ctx, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch hl7 messages: "+err.Error())
+		return
+	}
+	if messages == nil {
+		messages = []models.HL7Message{}
+	}
+	utils.RespondJSON(w, http.StatusOK, messages)
+}
+
+func ReplayHL7MessageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	msg, err := hl7.NewIngestor(storage.Store).Replay(ctx, id)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") || strings.Contains(err.Error(), "not found") {
+			utils.RespondError(w, http.StatusNotFound, "HL7 message not found")
+		} else {
+			utils.RespondError(w, http.StatusUnprocessableEntity, "replay failed: "+err.Error())
+		}
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, msg)
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// preparePayer trims the contact details and upper-cases the code
+func preparePayer(p *models.Payer) error {
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	p.Name = strings.TrimSpace(p.Name)
+	p.Phone = strings.TrimSpace(p.Phone)
+	p.Email = strings.TrimSpace(p.Email)
+	return p.Validate()
+}
+
+// GetPayersHandler lists the insurers; inactive ones with ?inactive=true
+func GetPayersHandler(w http.ResponseWriter, r *http.Request) {
+	inactive := false
+	if s := r.URL.Query().Get("inactive"); s != "" {
+		var err error
+		if inactive, err = strconv.ParseBool(s); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "inactive must be true or false")
+			return
+		}
+	}
+
+	payers, err := storage.Store.GetPayers(r.Context(), inactive)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch payers: "+err.Error())
+		return
+	}
+	if payers == nil {
+		payers = []models.Payer{}
+	}
+	utils.RespondJSON(w, http.StatusOK, payers)
+}
+
+func GetPayerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	payer, err := storage.Store.GetPayer(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Payer not found", "failed to fetch payer: ")
+		return
+	}
+	if utils.NotModified(w, r, payer.Version) {
+		return
+	}
+	utils.SetETag(w, payer.Version)
+	utils.RespondJSON(w, http.StatusOK, payer)
+}
+
+// CreatePayerHandler adds an insurer; it is active unless the body says otherwise
+func CreatePayerHandler(w http.ResponseWriter, r *http.Request) {
+	payer := models.Payer{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&payer); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := preparePayer(&payer); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := storage.Store.CreatePayer(r.Context(), &payer)
+	respondCreated(w, err, payer.Version, created, "failed to create payer: ")
+}
+
+func UpdatePayerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Payer
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := preparePayer(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.Version = id, version
+
+	if err := storage.Store.UpdatePayer(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Payer not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// preparePolicy trims the member details, validates the terms and checks the payer exists
+func preparePolicy(w http.ResponseWriter, r *http.Request, p *models.Policy) bool {
+	p.MemberID = strings.TrimSpace(p.MemberID)
+	p.GroupNumber = strings.TrimSpace(p.GroupNumber)
+	if err := p.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if _, err := storage.Store.GetPayer(r.Context(), p.PayerID); err != nil {
+		respondReferenceError(w, err, "payer_id does not exist", "failed to fetch payer: ")
+		return false
+	}
+	return true
+}
+
+// GetPatientPoliciesHandler lists the insurance policies of a patient, the
+// latest first; admins see soft-deleted ones with ?include_deleted=true
+func GetPatientPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(r.Context(), id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	policies, err := storage.Store.GetPatientPolicies(r.Context(), id, include)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch policies: "+err.Error())
+		return
+	}
+	if policies == nil {
+		policies = []models.Policy{}
+	}
+	utils.RespondJSON(w, http.StatusOK, policies)
+}
+
+func CreatePatientPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var policy models.Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !preparePolicy(w, r, &policy) {
+		return
+	}
+	policy.PatientID, policy.CreatedBy = id, currentUser(r)
+
+	if _, err := storage.Store.GetPatientByID(r.Context(), id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	created, err := storage.Store.CreatePolicy(r.Context(), &policy)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create policy: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	policy, err := storage.Store.GetPolicy(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Policy not found", "failed to fetch policy: ")
+		return
+	}
+	if utils.NotModified(w, r, policy.Version) {
+		return
+	}
+	utils.SetETag(w, policy.Version)
+	utils.RespondJSON(w, http.StatusOK, policy)
+}
+
+func UpdatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Policy
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !preparePolicy(w, r, &updated) {
+		return
+	}
+	updated.ID, updated.Version = id, version
+
+	policy, err := storage.Store.UpdatePolicy(r.Context(), &updated)
+	if err != nil {
+		respondWriteError(w, err, "Policy not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, policy.Version)
+	utils.RespondJSON(w, http.StatusOK, policy)
+}
+
+func DeletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeletePolicy(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Policy not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Policy deleted"})
+}
+
+// RestorePolicyHandler brings back a soft-deleted policy of a patient that is not deleted
+func RestorePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	policy, err := storage.Store.RestorePolicy(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Policy not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, policy.Version)
+	utils.RespondJSON(w, http.StatusOK, policy)
+}
+
+// GetEligibilityHandler tells whether a patient is insured on ?date= (default
+// today) and by which policy
+func GetEligibilityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().In(CalendarLocation).Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(r.Context(), id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	eligibility, err := storage.Store.GetEligibility(r.Context(), id, date)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to check eligibility: "+err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, eligibility)
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// labCodes upper-cases and trims codes and drops empty and repeated ones
+func labCodes(codes []string) []string {
+	out := []string{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !slices.Contains(out, code) {
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// prepareLabTest trims the names and upper-cases the code and panel
+func prepareLabTest(t *models.LabTest) error {
+	t.Code = strings.ToUpper(strings.TrimSpace(t.Code))
+	t.Name = strings.TrimSpace(t.Name)
+	t.Unit = strings.TrimSpace(t.Unit)
+	t.Panel = strings.ToUpper(strings.TrimSpace(t.Panel))
+	return t.Validate()
+}
+
+// GetLabTestsHandler lists the laboratory catalog, optionally one ?panel=
+func GetLabTestsHandler(w http.ResponseWriter, r *http.Request) {
+	tests, err := storage.Store.GetLabTests(r.Context(), strings.TrimSpace(r.URL.Query().Get("panel")))
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch lab tests: "+err.Error())
+		return
+	}
+	if tests == nil {
+		tests = []models.LabTest{}
+	}
+	utils.RespondJSON(w, http.StatusOK, tests)
+}
+
+func GetLabTestHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	test, err := storage.Store.GetLabTest(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Lab test not found", "failed to fetch lab test: ")
+		return
+	}
+	if utils.NotModified(w, r, test.Version) {
+		return
+	}
+	utils.SetETag(w, test.Version)
+	utils.RespondJSON(w, http.StatusOK, test)
+}
+
+func CreateLabTestHandler(w http.ResponseWriter, r *http.Request) {
+	var test models.LabTest
+	if err := json.NewDecoder(r.Body).Decode(&test); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareLabTest(&test); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := storage.Store.CreateLabTest(r.Context(), &test)
+	if errors.Is(err, storage.ErrDuplicate) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create lab test: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func UpdateLabTestHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.LabTest
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareLabTest(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.Version = id, version
+
+	if err := storage.Store.UpdateLabTest(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Lab test not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+func respondLabOrders(w http.ResponseWriter, orders []models.LabOrder, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch lab orders: "+err.Error())
+		return
+	}
+	if orders == nil {
+		orders = []models.LabOrder{}
+	}
+	utils.RespondJSON(w, http.StatusOK, orders)
+}
+
+// CreateAppointmentLabOrderHandler orders tests and panels for the patient of
+// an appointment; the doctor defaults to the appointment's doctor
+func CreateAppointmentLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var order models.LabOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	order.Tests, order.Panels = labCodes(order.Tests), labCodes(order.Panels)
+	order.Notes = strings.TrimSpace(order.Notes)
+	if order.Priority == "" {
+		order.Priority = "routine"
+	}
+	if err := order.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	appointment, err := storage.Store.GetAppointmentByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	order.AppointmentID, order.PatientID = appointment.ID, appointment.PatientID
+	if order.DoctorID == 0 {
+		order.DoctorID = appointment.DoctorID
+	} else if _, err := storage.Store.GetDoctorByID(ctx, order.DoctorID); err != nil {
+		respondReferenceError(w, err, "doctor_id does not exist", "failed to fetch doctor: ")
+		return
+	}
+
+	tests, err := storage.Store.GetLabTestsFor(ctx, order.Tests, order.Panels)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch lab tests: "+err.Error())
+		return
+	}
+	for _, code := range order.Tests {
+		if !slices.ContainsFunc(tests, func(t models.LabTest) bool { return t.Code == code }) {
+			utils.RespondError(w, http.StatusBadRequest, "lab test "+code+" is not in the catalog")
+			return
+		}
+	}
+	for _, panel := range order.Panels {
+		if !slices.ContainsFunc(tests, func(t models.LabTest) bool { return t.Panel == panel }) {
+			utils.RespondError(w, http.StatusBadRequest, "panel "+panel+" has no tests in the catalog")
+			return
+		}
+	}
+	order.OrderedBy = currentUser(r)
+
+	created, err := storage.Store.CreateLabOrder(ctx, &order, tests)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create lab order: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetAppointmentLabOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetAppointmentByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	orders, err := storage.Store.GetAppointmentLabOrders(ctx, id)
+	respondLabOrders(w, orders, err)
+}
+
+// GetPatientLabOrdersHandler lists the lab orders of a patient with their results, optionally by ?status=
+func GetPatientLabOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.LabOrderStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.LabOrderStatuses, ", "))
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	orders, err := storage.Store.GetPatientLabOrders(ctx, id, status, include)
+	respondLabOrders(w, orders, err)
+}
+
+// GetLabOrdersHandler is the laboratory worklist by ?status=. With
+// ?ready_after= it lists the orders whose results became ready after the one
+// with that ready_seq; clients poll with the last ready_seq they saw, from 0.
+func GetLabOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && !slices.Contains(models.LabOrderStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.LabOrderStatuses, ", "))
+		return
+	}
+	limit := 100
+	if s := query.Get("limit"); s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil || l <= 0 {
+			utils.RespondError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = l
+	}
+
+	if s := query.Get("ready_after"); s != "" {
+		after, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 0 {
+			utils.RespondError(w, http.StatusBadRequest, "ready_after must be a non-negative integer")
+			return
+		}
+		orders, err := storage.Store.GetReadyLabOrders(r.Context(), after, limit)
+		respondLabOrders(w, orders, err)
+		return
+	}
+	orders, err := storage.Store.GetLabOrders(r.Context(), status, limit)
+	respondLabOrders(w, orders, err)
+}
+
+func GetLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	order, err := storage.Store.GetLabOrder(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Lab order not found", "failed to fetch lab order: ")
+		return
+	}
+	if utils.NotModified(w, r, order.Version) {
+		return
+	}
+	utils.SetETag(w, order.Version)
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// RecordLabResultsHandler fills in or corrects values of an order by test
+// code and flags them against the reference ranges. The order is resulted
+// once every test has a value.
+func RecordLabResultsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var entries []models.LabResultEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(entries) == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "at least one result is required")
+		return
+	}
+
+	order, err := storage.Store.GetLabOrder(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Lab order not found", "failed to fetch lab order: ")
+		return
+	}
+	results := make([]models.LabResult, 0, len(entries))
+	for _, entry := range entries {
+		code := strings.ToUpper(strings.TrimSpace(entry.Code))
+		i := slices.IndexFunc(order.Results, func(res models.LabResult) bool { return res.Code == code })
+		if i < 0 {
+			utils.RespondError(w, http.StatusBadRequest, "lab test "+code+" is not part of the order")
+			return
+		}
+		if slices.ContainsFunc(results, func(res models.LabResult) bool { return res.Code == code }) {
+			utils.RespondError(w, http.StatusBadRequest, "lab test "+code+" is given more than once")
+			return
+		}
+		result := order.Results[i]
+		if err := result.SetValue(entry.Value); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		results = append(results, result)
+	}
+
+	updated, err := storage.Store.RecordLabResults(ctx, id, version, results, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Lab order not found", "recording results failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+func DeleteLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteLabOrder(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Lab order not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Lab order deleted"})
+}
+
+// RestoreLabOrderHandler brings back a soft-deleted lab order; its patient must not be deleted
+func RestoreLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	order, err := storage.Store.RestoreLabOrder(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Lab order not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, order.Version)
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// GetPatientLabTrendsHandler returns the resulted values of a patient per
+// analyte over time; ?code= takes a comma-separated list of test codes
+func GetPatientLabTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	codes := labCodes(strings.Split(r.URL.Query().Get("code"), ","))
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	trends, err := storage.Store.GetLabTrends(ctx, id, codes)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch lab trends: "+err.Error())
+		return
+	}
+	if trends == nil {
+		trends = []models.LabTrend{}
+	}
+	utils.RespondJSON(w, http.StatusOK, trends)
+}
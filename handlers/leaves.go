@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// GetLeavesHandler lists leave, the latest first, optionally of one ?doctor_id= and ?status=
+func GetLeavesHandler(w http.ResponseWriter, r *http.Request) {
+	doctorID, ok := idParam(w, r, "doctor_id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.LeaveStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.LeaveStatuses, ", "))
+		return
+	}
+
+	leaves, err := storage.Store.GetLeaves(r.Context(), doctorID, status)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch leaves: "+err.Error())
+		return
+	}
+	if leaves == nil {
+		leaves = []models.Leave{}
+	}
+	utils.RespondJSON(w, http.StatusOK, leaves)
+}
+
+// CreateLeaveHandler files a leave request for a doctor; it takes effect once approved
+func CreateLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	var leave models.Leave
+	if err := json.NewDecoder(r.Body).Decode(&leave); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	leave.Kind = strings.ToLower(strings.TrimSpace(leave.Kind))
+	leave.Reason = strings.TrimSpace(leave.Reason)
+	if err := leave.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	leave.RequestedBy = currentUser(r)
+
+	if _, err := storage.Store.GetDoctorByID(r.Context(), leave.DoctorID); err != nil {
+		respondReferenceError(w, err, "doctor_id does not exist", "failed to fetch doctor: ")
+		return
+	}
+
+	created, err := storage.Store.CreateLeave(r.Context(), &leave)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create leave: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	leave, err := storage.Store.GetLeave(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Leave not found", "failed to fetch leave: ")
+		return
+	}
+	if utils.NotModified(w, r, leave.Version) {
+		return
+	}
+	utils.SetETag(w, leave.Version)
+	utils.RespondJSON(w, http.StatusOK, leave)
+}
+
+// decideLeave answers a status change of a leave made by decide
+func decideLeave(w http.ResponseWriter, r *http.Request, decide func(ctx context.Context, id, version int, by string) (*models.Leave, error)) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	leave, err := decide(r.Context(), id, version, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Leave not found", "failed to update leave: ")
+		return
+	}
+	utils.SetETag(w, leave.Version)
+	utils.RespondJSON(w, http.StatusOK, leave)
+}
+
+// ApproveLeaveHandler approves a leave request; 409 while shifts or booked appointments fall in it
+func ApproveLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	decideLeave(w, r, storage.Store.ApproveLeave)
+}
+
+func RejectLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	decideLeave(w, r, storage.Store.RejectLeave)
+}
+
+// CancelLeaveHandler withdraws a requested or approved leave
+func CancelLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	decideLeave(w, r, storage.Store.CancelLeave)
+}
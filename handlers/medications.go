@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// GetMedicationsHandler lists the medication catalog; ?q= matches a name substring or an ATC code prefix
+func GetMedicationsHandler(w http.ResponseWriter, r *http.Request) {
+	medications, err := storage.Store.GetMedications(r.Context(), strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch medications: "+err.Error())
+		return
+	}
+	if medications == nil {
+		medications = []models.Medication{}
+	}
+	utils.RespondJSON(w, http.StatusOK, medications)
+}
+
+func GetMedicationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	medication, err := storage.Store.GetMedication(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Medication not found", "failed to fetch medication: ")
+		return
+	}
+	if utils.NotModified(w, r, medication.Version) {
+		return
+	}
+	utils.SetETag(w, medication.Version)
+	utils.RespondJSON(w, http.StatusOK, medication)
+}
+
+// prepareMedication trims the names and upper-cases the ATC code
+func prepareMedication(m *models.Medication) error {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Strength = strings.TrimSpace(m.Strength)
+	m.Form = strings.TrimSpace(m.Form)
+	m.ATCCode = strings.ToUpper(strings.TrimSpace(m.ATCCode))
+	return m.Validate()
+}
+
+func CreateMedicationHandler(w http.ResponseWriter, r *http.Request) {
+	var medication models.Medication
+	if err := json.NewDecoder(r.Body).Decode(&medication); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareMedication(&medication); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := storage.Store.CreateMedication(r.Context(), &medication)
+	if errors.Is(err, storage.ErrDuplicate) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create medication: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func UpdateMedicationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Medication
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareMedication(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.Version = id, version
+
+	if err := storage.Store.UpdateMedication(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Medication not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
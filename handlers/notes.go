@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// readNote decodes the SOAP sections of a note, trimmed and validated
+func readNote(w http.ResponseWriter, r *http.Request) (*models.ClinicalNote, bool) {
+	var n models.ClinicalNote
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	n.Subjective = strings.TrimSpace(n.Subjective)
+	n.Objective = strings.TrimSpace(n.Objective)
+	n.Assessment = strings.TrimSpace(n.Assessment)
+	n.Plan = strings.TrimSpace(n.Plan)
+	if err := n.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &n, true
+}
+
+func respondNotes(w http.ResponseWriter, notes []models.ClinicalNote, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch notes: "+err.Error())
+		return
+	}
+	if notes == nil {
+		notes = []models.ClinicalNote{}
+	}
+	utils.RespondJSON(w, http.StatusOK, notes)
+}
+
+// CreateAppointmentNoteHandler starts a draft note for an appointment; the
+// author is the user of the token
+func CreateAppointmentNoteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	note, ok := readNote(w, r)
+	if !ok {
+		return
+	}
+
+	appointment, err := storage.Store.GetAppointmentByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	note.AppointmentID, note.PatientID, note.Author = id, appointment.PatientID, currentUser(r)
+
+	created, err := storage.Store.CreateNote(ctx, note)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create note: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetAppointmentNotesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetAppointmentByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	notes, err := storage.Store.GetAppointmentNotes(ctx, id)
+	respondNotes(w, notes, err)
+}
+
+// GetPatientNotesHandler lists the notes of a patient, newest first, optionally by ?status=
+func GetPatientNotesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.NoteStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.NoteStatuses, ", "))
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	notes, err := storage.Store.GetPatientNotes(ctx, id, status, include)
+	respondNotes(w, notes, err)
+}
+
+func GetNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := storage.Store.GetNote(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Note not found", "failed to fetch note: ")
+		return
+	}
+	if utils.NotModified(w, r, note.Version) {
+		return
+	}
+	utils.SetETag(w, note.Version)
+	utils.RespondJSON(w, http.StatusOK, note)
+}
+
+// UpdateNoteHandler replaces the sections of a draft; only its author may edit it
+func UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	note, ok := readNote(w, r)
+	if !ok {
+		return
+	}
+	note.ID, note.Version = id, version
+
+	updated, err := storage.Store.UpdateNote(r.Context(), note, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Note not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// SignNoteHandler signs a draft of the current user; it needs an assessment and a plan
+func SignNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	note, err := storage.Store.SignNote(r.Context(), id, version, currentUser(r), func(n *models.ClinicalNote) error {
+		if err := n.Signable(); err != nil {
+			return &patchError{http.StatusBadRequest, err.Error()}
+		}
+		return nil
+	})
+	if err != nil {
+		respondPatchError(w, err, "Note not found")
+		return
+	}
+	utils.SetETag(w, note.Version)
+	utils.RespondJSON(w, http.StatusOK, note)
+}
+
+// AddNoteAddendumHandler appends a correction to a signed note; any clinician may add one
+func AddNoteAddendumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var addendum models.NoteAddendum
+	if err := json.NewDecoder(r.Body).Decode(&addendum); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	addendum.Text = strings.TrimSpace(addendum.Text)
+	if err := addendum.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	addendum.NoteID, addendum.Author = id, currentUser(r)
+
+	note, err := storage.Store.AddAddendum(r.Context(), &addendum)
+	if err != nil {
+		respondWriteError(w, err, "Note not found", "failed to add addendum: ")
+		return
+	}
+	utils.SetETag(w, note.Version)
+	utils.RespondJSON(w, http.StatusCreated, note)
+}
+
+// DeleteNoteHandler soft-deletes a draft of the current user; signed notes cannot be deleted
+func DeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteNote(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Note not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Note deleted"})
+}
+
+// RestoreNoteHandler brings back a soft-deleted note; its patient must not be deleted
+func RestoreNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := storage.Store.RestoreNote(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Note not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, note.Version)
+	utils.RespondJSON(w, http.StatusOK, note)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/TeseySTD/GoHospitalApi/openapi"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+var (
+	specOnce sync.Once
+	spec     map[string]any
+)
+
+// OpenAPISpec returns the document built from the route table
+func OpenAPISpec() map[string]any {
+	specOnce.Do(func() {
+		spec = openapi.Build(openapi.Info{
+			Title:       "Hospital REST API",
+			Version:     "1.0.0",
+			Description: "Patients, doctors and appointments. Obtain a token from POST /login and send it as `Authorization: Bearer <token>`.",
+		}, Operations())
+	})
+	return spec
+}
+
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	utils.RespondJSON(w, http.StatusOK, OpenAPISpec())
+}
+
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(docsPage))
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Hospital REST API - Docs</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({
+            url: "/openapi.json",
+            dom_id: "#swagger-ui",
+            persistAuthorization: true
+        });
+    </script>
+</body>
+</html>`
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/jsonpatch"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+const maxPatchSize = 1 << 20
+
+// patchError carries the HTTP status out of the storage transaction callback
+type patchError struct {
+	status  int
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+type validator interface {
+	Validate() error
+}
+
+// readPatch checks the media type and reads the body before a transaction is opened
+func readPatch(r *http.Request) (string, []byte, *patchError) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != jsonpatch.MergePatchType && mediaType != jsonpatch.JSONPatchType) {
+		return "", nil, &patchError{http.StatusUnsupportedMediaType,
+			"Content-Type must be " + jsonpatch.MergePatchType + " or " + jsonpatch.JSONPatchType}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPatchSize))
+	if err != nil {
+		return "", nil, &patchError{http.StatusBadRequest, "Invalid request body"}
+	}
+	return mediaType, body, nil
+}
+
+// patchInto applies the patch to the JSON form of v, decodes the result back
+// into v and validates it
+func patchInto(v validator, mediaType string, patch []byte) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	patched, err := jsonpatch.Apply(mediaType, doc, patch)
+	switch {
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return &patchError{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return &patchError{http.StatusConflict, err.Error()}
+	case err != nil:
+		return &patchError{http.StatusUnprocessableEntity, err.Error()}
+	}
+
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &patchError{http.StatusUnprocessableEntity, "patched document is invalid: " + err.Error()}
+	}
+
+	if err := v.Validate(); err != nil {
+		return &patchError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return nil
+}
+
+func respondPatchError(w http.ResponseWriter, err error, notFound string) {
+	var pe *patchError
+	switch {
+	case errors.As(err, &pe):
+		if pe.status == http.StatusUnsupportedMediaType {
+			w.Header().Set("Accept-Patch", jsonpatch.MergePatchType+", "+jsonpatch.JSONPatchType)
+		}
+		utils.RespondError(w, pe.status, pe.message)
+	default:
+		respondWriteError(w, err, notFound, "update failed: ")
+	}
+}
+
+// respondWriteError maps errors of versioned writes (PUT, PATCH, DELETE)
+func respondWriteError(w http.ResponseWriter, err error, notFound, prefix string) {
+	switch {
+	case errors.Is(err, storage.ErrVersionMismatch):
+		utils.RespondError(w, http.StatusPreconditionFailed, "If-Match does not match the current version")
+	case errors.Is(err, storage.ErrDuplicate), errors.Is(err, storage.ErrNotDeleted), errors.Is(err, storage.ErrParentDeleted),
+		errors.Is(err, storage.ErrNotActive), errors.Is(err, storage.ErrNoteSigned), errors.Is(err, storage.ErrNoteDraft),
+		errors.Is(err, storage.ErrBedOccupied), errors.Is(err, storage.ErrBedOutOfService), errors.Is(err, storage.ErrNotAdmitted),
+		errors.Is(err, storage.ErrDepartmentCycle), errors.Is(err, storage.ErrShiftOverlap), errors.Is(err, storage.ErrOnLeave),
+		errors.Is(err, storage.ErrOffShift), errors.Is(err, storage.ErrLeaveDecided), errors.Is(err, storage.ErrLeaveConflict),
+		errors.Is(err, storage.ErrInvoiceNotDraft), errors.Is(err, storage.ErrInvoiceNotOpen), errors.Is(err, storage.ErrAmountExceeded),
+		errors.Is(err, storage.ErrInvoicePaid), errors.Is(err, storage.ErrNotClaimable), errors.Is(err, storage.ErrClaimTransition),
+		errors.Is(err, storage.ErrInvoiceClaimed):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, storage.ErrNotAuthor):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case strings.Contains(err.Error(), "not found"):
+		utils.RespondError(w, http.StatusNotFound, notFound)
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, prefix+err.Error())
+	}
+}
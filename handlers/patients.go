@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/TeseySTD/GoHospitalApi/icd10"
 	"github.com/TeseySTD/GoHospitalApi/models"
 	"github.com/TeseySTD/GoHospitalApi/storage"
 	"github.com/TeseySTD/GoHospitalApi/utils"
@@ -13,25 +17,54 @@ import (
 
 func GetPatientsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
 
-	patients, err := storage.Store.GetAllPatients(ctx)
+	patients, err := loadPatients(ctx, r.URL.Query(), include)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch patients: "+err.Error())
 		return
 	}
 
-	query := r.URL.Query()
+	utils.RespondJSON(w, http.StatusOK, filterPatients(patients, r.URL.Query()))
+}
+
+// loadPatients reads the rows the list query can match: with ?icd10= or
+// ?problem_status= only live patients that have such a problem
+func loadPatients(ctx context.Context, query url.Values, includeDeleted bool) ([]models.Patient, error) {
+	code, status := query.Get("icd10"), query.Get("problem_status")
+	if code == "" && status == "" {
+		return storage.Store.GetAllPatients(ctx, includeDeleted)
+	}
+	prefix, ok := icd10.NormalizePrefix(code)
+	if code != "" && !ok {
+		return nil, nil // no code starts with it
+	}
+	return storage.Store.GetPatientsByProblemCode(ctx, prefix, status)
+}
+
+// filterPatients applies the list query parameters; it never returns nil
+func filterPatients(patients []models.Patient, query url.Values) []models.Patient {
 	firstName := strings.ToLower(query.Get("first_name"))
 	lastName := strings.ToLower(query.Get("last_name"))
 	ageStr := query.Get("age")
 	diagnosis := strings.ToLower(query.Get("diagnosis"))
+	dateOfBirth := query.Get("date_of_birth")
+	sex := strings.ToLower(query.Get("sex"))
+	mrn := strings.ToUpper(query.Get("mrn"))
+	nationalID := query.Get("national_id")
+	phone := digits(query.Get("phone"))
+	email := strings.ToLower(query.Get("email"))
+	city := strings.ToLower(query.Get("city"))
 
-	if firstName == "" && lastName == "" && ageStr == "" && diagnosis == "" {
+	if firstName == "" && lastName == "" && ageStr == "" && diagnosis == "" && dateOfBirth == "" &&
+		sex == "" && mrn == "" && nationalID == "" && phone == "" && email == "" && city == "" {
 		if patients == nil {
-			patients = []models.Patient{}
+			return []models.Patient{}
 		}
-		utils.RespondJSON(w, http.StatusOK, patients)
-		return
+		return patients
 	}
 
 	var filtered []models.Patient
@@ -53,6 +86,27 @@ func GetPatientsHandler(w http.ResponseWriter, r *http.Request) {
 		if diagnosis != "" && !strings.Contains(strings.ToLower(p.Diagnosis), diagnosis) {
 			match = false
 		}
+		if dateOfBirth != "" && p.DateOfBirth != dateOfBirth {
+			match = false
+		}
+		if sex != "" && p.Sex != sex {
+			match = false
+		}
+		if mrn != "" && p.MRN != mrn {
+			match = false
+		}
+		if nationalID != "" && p.NationalID != nationalID {
+			match = false
+		}
+		if phone != "" && !strings.Contains(digits(p.Phone), phone) {
+			match = false
+		}
+		if email != "" && !strings.Contains(strings.ToLower(p.Email), email) {
+			match = false
+		}
+		if city != "" && !strings.Contains(strings.ToLower(p.City), city) {
+			match = false
+		}
 
 		if match {
 			filtered = append(filtered, p)
@@ -62,12 +116,26 @@ func GetPatientsHandler(w http.ResponseWriter, r *http.Request) {
 	if filtered == nil {
 		filtered = []models.Patient{}
 	}
-	utils.RespondJSON(w, http.StatusOK, filtered)
+	return filtered
+}
+
+// digits keeps only the digits so phone numbers match regardless of formatting
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
 }
 
 func GetPatientHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/patients/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	patient, err := storage.Store.GetPatientByID(ctx, id)
 	if err != nil {
@@ -78,6 +146,10 @@ func GetPatientHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if utils.NotModified(w, r, patient.Version) {
+		return
+	}
+	utils.SetETag(w, patient.Version)
 	utils.RespondJSON(w, http.StatusOK, patient)
 }
 
@@ -89,67 +161,248 @@ func CreatePatientHandler(w http.ResponseWriter, r *http.Request) {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := patient.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	created, err := storage.Store.CreatePatient(ctx, &patient)
+	if errors.Is(err, storage.ErrDuplicate) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "failed to create patient: "+err.Error())
 		return
 	}
+	utils.SetETag(w, created.Version)
 	utils.RespondJSON(w, http.StatusCreated, created)
 }
 
 func UpdatePatientHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/patients/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var updated models.Patient
 	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := updated.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	updated.ID = id
+	updated.Version = version
 
 	if err := storage.Store.UpdatePatient(ctx, &updated); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Patient not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "update failed: "+err.Error())
-		}
+		respondWriteError(w, err, "Patient not found", "update failed: ")
 		return
 	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// PatchPatientHandler accepts a JSON Merge Patch or a JSON Patch for the current row
+func PatchPatientHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, patch, perr := readPatch(r)
+	if perr != nil {
+		respondPatchError(w, perr, "Patient not found")
+		return
+	}
+
+	updated, err := storage.Store.PatchPatient(ctx, id, version, func(p *models.Patient) error {
+		return patchInto(p, mediaType, patch)
+	})
+	if err != nil {
+		respondPatchError(w, err, "Patient not found")
+		return
+	}
+	utils.SetETag(w, updated.Version)
 	utils.RespondJSON(w, http.StatusOK, updated)
 }
 
 func DeletePatientHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := utils.ExtractID(r.URL.Path, "/patients/")
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
 
-	if err := storage.Store.DeletePatient(ctx, id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondError(w, http.StatusNotFound, "Patient not found")
-		} else {
-			utils.RespondError(w, http.StatusInternalServerError, "delete failed: "+err.Error())
-		}
+	if err := storage.Store.DeletePatient(ctx, id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Patient not found", "delete failed: ")
 		return
 	}
 	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Patient deleted"})
 }
 
-func PatientsRouter(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		if strings.HasPrefix(r.URL.Path, "/patients/") && len(r.URL.Path) > len("/patients/") {
-			GetPatientHandler(w, r)
-		} else {
-			GetPatientsHandler(w, r)
-		}
-	case http.MethodPost:
-		CreatePatientHandler(w, r)
-	case http.MethodPut:
-		UpdatePatientHandler(w, r)
-	case http.MethodDelete:
-		DeletePatientHandler(w, r)
-	default:
-		utils.RespondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+// RestorePatientHandler brings back a soft-deleted patient with the appointments
+// and problems that were deleted together with it
+func RestorePatientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patient, err := storage.Store.RestorePatient(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Patient not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, patient.Version)
+	utils.RespondJSON(w, http.StatusOK, patient)
+}
+
+func GetPatientAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	appointments, err := storage.Store.GetAppointmentDetails(ctx, id, 0)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch appointments: "+err.Error())
+		return
+	}
+	if appointments == nil {
+		appointments = []models.AppointmentDetails{}
+	}
+	utils.RespondJSON(w, http.StatusOK, appointments)
+}
+
+// GetPatientTimelineHandler merges everything recorded for the patient in chronological order
+func GetPatientTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	events, err := storage.Store.GetPatientTimeline(ctx, id, CalendarLocation.String())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch timeline: "+err.Error())
+		return
+	}
+	if events == nil {
+		events = []models.TimelineEvent{}
 	}
+	utils.RespondJSON(w, http.StatusOK, events)
+}
+
+// CreatePatientsBatchHandler inserts an array of patients; large batches are sent with COPY
+func CreatePatientsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Patient]{
+		validate: (*models.Patient).Validate,
+		key:      patientKey,
+		status:   http.StatusCreated,
+		write:    storage.Store.CreatePatients,
+		notFound: "Patient not found",
+	})
+}
+
+// UpdatePatientsBatchHandler overwrites an array of patients; each item carries its id and version
+func UpdatePatientsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runBatchOp(w, r, batchOp[models.Patient]{
+		validate: (*models.Patient).Validate,
+		key:      patientKey,
+		needKey:  true,
+		status:   http.StatusOK,
+		write:    storage.Store.UpdatePatients,
+		notFound: "Patient not found",
+	})
+}
+
+// DeletePatientsBatchHandler soft-deletes an array of {"id", "version"} references
+func DeletePatientsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	runBatchOp(w, r, batchOp[models.BatchRef]{
+		key:     batchRefKey,
+		needKey: true,
+		status:  http.StatusOK,
+		write: func(ctx context.Context, refs []*models.BatchRef, atomic bool) ([]error, error) {
+			return storage.Store.DeletePatients(ctx, refs, atomic, user)
+		},
+		notFound: "Patient not found",
+	})
+}
+
+func patientKey(p *models.Patient) (int, int) {
+	return p.ID, p.Version
+}
+
+// patientSheet describes patients for spreadsheet export and import. Duplicates
+// share the national ID, or the name and date of birth (age for legacy rows).
+// query selects the rows to load for export; import passes nil to check all live rows.
+func patientSheet(query url.Values, includeDeleted bool) sheetSpec[models.Patient] {
+	return sheetSpec[models.Patient]{
+		name: "patients",
+		load: func(ctx context.Context) ([]models.Patient, error) {
+			return loadPatients(ctx, query, includeDeleted)
+		},
+		filter: filterPatients,
+		create: storage.Store.CreatePatients,
+		key: func(p *models.Patient) string {
+			if p.NationalID != "" {
+				return "national_id|" + p.NationalID
+			}
+			if p.DateOfBirth != "" {
+				return strings.ToLower(strings.TrimSpace(p.FirstName)+"|"+strings.TrimSpace(p.LastName)) + "|" + p.DateOfBirth
+			}
+			return strings.ToLower(strings.TrimSpace(p.FirstName)+"|"+strings.TrimSpace(p.LastName)) + "|" + strconv.Itoa(p.Age)
+		},
+		id:        func(p *models.Patient) int { return p.ID },
+		normalize: spreadsheetDate,
+	}
+}
+
+// ExportPatientsHandler streams the filtered list as CSV or XLSX
+func ExportPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	exportSheet(w, r, patientSheet(r.URL.Query(), include))
+}
+
+// ImportPatientsHandler creates patients from an uploaded CSV or XLSX file
+func ImportPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	importSheet[models.Patient](w, r, patientSheet(nil, false))
 }
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/interactions"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+var prescriptionStatuses = []string{models.PrescriptionActive, models.PrescriptionDiscontinued}
+
+// today is the current date in the calendar time zone
+func today() string {
+	return time.Now().In(CalendarLocation).Format("2006-01-02")
+}
+
+func respondPrescriptions(w http.ResponseWriter, prescriptions []models.Prescription, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch prescriptions: "+err.Error())
+		return
+	}
+	if prescriptions == nil {
+		prescriptions = []models.Prescription{}
+	}
+	utils.RespondJSON(w, http.StatusOK, prescriptions)
+}
+
+// Interactions is the drug interaction table used to check new prescriptions
+var Interactions = interactions.Bundled()
+
+// checkPrescription runs the safety rules for giving m to a patient with the
+// given allergies and current medications. Severe allergies and
+// contraindicated combinations block the prescription; other findings are
+// warnings that need an override reason.
+func checkPrescription(m *models.Medication, allergies []models.Allergy, current []models.Prescription) models.PrescriptionCheck {
+	check := models.PrescriptionCheck{Alerts: []models.PrescriptionAlert{}}
+	for _, a := range allergies {
+		if a.Status != "active" || !allergyCovers(&a, m) {
+			continue
+		}
+		message := "Patient is allergic to " + a.Substance
+		if a.Reaction != "" {
+			message += " (" + a.Reaction + ")"
+		}
+		check.Alerts = append(check.Alerts, models.PrescriptionAlert{
+			Kind: "allergy", Severity: a.Severity, Blocking: a.Severity == "severe", Message: message, AllergyID: a.ID,
+		})
+	}
+	for _, p := range current {
+		if p.MedicationID == m.ID || m.ATCCode != "" && p.ATCCode == m.ATCCode {
+			check.Alerts = append(check.Alerts, models.PrescriptionAlert{
+				Kind: "duplicate", Severity: interactions.Moderate, Message: "Patient already takes " + p.MedicationName, PrescriptionID: p.ID,
+			})
+			continue
+		}
+		// rules are sorted by severity; the most serious one describes the pair
+		if rules := Interactions.Between(m.ATCCode, p.ATCCode); len(rules) > 0 {
+			check.Alerts = append(check.Alerts, models.PrescriptionAlert{
+				Kind: "interaction", Severity: rules[0].Severity, Blocking: rules[0].Severity == interactions.Contraindicated,
+				Message: rules[0].Description + " (" + p.MedicationName + ")", PrescriptionID: p.ID,
+			})
+		}
+	}
+
+	slices.SortStableFunc(check.Alerts, func(a, b models.PrescriptionAlert) int {
+		if a.Blocking == b.Blocking {
+			return 0
+		}
+		if a.Blocking {
+			return -1
+		}
+		return 1
+	})
+	for _, alert := range check.Alerts {
+		if alert.Blocking {
+			check.Blocked = true
+		} else {
+			check.Override = true
+		}
+	}
+	return check
+}
+
+// allergyCovers reports whether an allergy applies to a medication: its ATC
+// code falls in the allergy's ATC group or its name contains the substance
+func allergyCovers(a *models.Allergy, m *models.Medication) bool {
+	if a.ATCCode != "" && m.ATCCode != "" && strings.HasPrefix(m.ATCCode, a.ATCCode) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(m.Name), strings.ToLower(a.Substance))
+}
+
+// alertResponse is the error body of a prescription stopped by the safety check
+type alertResponse struct {
+	Error string `json:"error"`
+	models.PrescriptionCheck
+}
+
+// readPrescription decodes and validates a prescription for the patient of
+// the appointment {id}, fills in the appointment, patient and doctor and
+// loads the prescribed medication
+func readPrescription(w http.ResponseWriter, r *http.Request) (*models.Prescription, *models.Medication, bool) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	var p models.Prescription
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, nil, false
+	}
+	p.Dose = strings.TrimSpace(p.Dose)
+	p.Frequency = strings.TrimSpace(p.Frequency)
+	p.Instructions = strings.TrimSpace(p.Instructions)
+	p.OverrideReason = strings.TrimSpace(p.OverrideReason)
+	if p.StartDate == "" {
+		p.StartDate = today()
+	}
+	if err := p.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	appointment, err := storage.Store.GetAppointmentByID(ctx, id)
+	if err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return nil, nil, false
+	}
+	p.AppointmentID, p.PatientID = appointment.ID, appointment.PatientID
+	if p.DoctorID == 0 {
+		p.DoctorID = appointment.DoctorID
+	} else if _, err := storage.Store.GetDoctorByID(ctx, p.DoctorID); err != nil {
+		respondReferenceError(w, err, "doctor_id does not exist", "failed to fetch doctor: ")
+		return nil, nil, false
+	}
+	medication, err := storage.Store.GetMedication(ctx, p.MedicationID)
+	if err != nil {
+		respondReferenceError(w, err, "medication_id does not exist", "failed to fetch medication: ")
+		return nil, nil, false
+	}
+	return &p, medication, true
+}
+
+// errRefused rolls back a prescription write the safety check does not let through
+var errRefused = errors.New("refused by the safety check")
+
+// prescriptionCheck checks p against the patient's active allergies and its
+// other current medications, read through st
+func prescriptionCheck(ctx context.Context, st *storage.Storage, p *models.Prescription, m *models.Medication) (models.PrescriptionCheck, error) {
+	allergies, err := st.GetPatientAllergies(ctx, p.PatientID, "active", false)
+	if err != nil {
+		return models.PrescriptionCheck{}, fmt.Errorf("failed to fetch allergies: %w", err)
+	}
+	current, err := st.GetCurrentMedications(ctx, p.PatientID, today())
+	if err != nil {
+		return models.PrescriptionCheck{}, fmt.Errorf("failed to fetch current medications: %w", err)
+	}
+	current = slices.DeleteFunc(current, func(c models.Prescription) bool { return c.ID == p.ID })
+	return checkPrescription(m, allergies, current), nil
+}
+
+// checkRefusal returns the status and error answering a prescription the
+// check stops, or 0: blocking alerts answer 422, warnings without an override reason 409
+func checkRefusal(check models.PrescriptionCheck, overrideReason string) (int, string) {
+	switch {
+	case check.Blocked:
+		return http.StatusUnprocessableEntity, "the prescription is blocked by the safety check"
+	case check.Override && overrideReason == "":
+		return http.StatusConflict, "the safety check raised warnings; repeat with an override_reason to accept them"
+	}
+	return 0, ""
+}
+
+// CheckAppointmentPrescriptionHandler runs the safety check for a prescription without saving it
+func CheckAppointmentPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	p, medication, ok := readPrescription(w, r)
+	if !ok {
+		return
+	}
+	check, err := prescriptionCheck(r.Context(), storage.Store, p, medication)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, check)
+}
+
+// CreateAppointmentPrescriptionHandler records a medication order for the
+// patient of an appointment; the doctor defaults to the appointment's doctor.
+// A blocking alert answers 422; warnings answer 409 until the request repeats
+// with an override_reason, and are then saved with the prescription. The
+// check and the insert run in one transaction with the patient locked.
+func CreateAppointmentPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, medication, ok := readPrescription(w, r)
+	if !ok {
+		return
+	}
+	p.PrescribedBy = currentUser(r)
+
+	var check models.PrescriptionCheck
+	var created *models.Prescription
+	err := storage.Store.InTx(ctx, func(st *storage.Storage) error {
+		if err := st.LockPatient(ctx, p.PatientID); err != nil {
+			return err
+		}
+		var err error
+		if check, err = prescriptionCheck(ctx, st, p, medication); err != nil {
+			return err
+		}
+		if status, _ := checkRefusal(check, p.OverrideReason); status != 0 {
+			return errRefused
+		}
+		p.Alerts = check.Alerts
+		if len(p.Alerts) == 0 {
+			p.OverrideReason = ""
+		}
+		created, err = st.CreatePrescription(ctx, p)
+		return err
+	})
+	if errors.Is(err, errRefused) {
+		status, message := checkRefusal(check, p.OverrideReason)
+		utils.RespondJSON(w, status, alertResponse{message, check})
+		return
+	}
+	if err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to create prescription: ")
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetAppointmentPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetAppointmentByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	prescriptions, err := storage.Store.GetAppointmentPrescriptions(ctx, id)
+	respondPrescriptions(w, prescriptions, err)
+}
+
+// GetPatientPrescriptionsHandler lists the prescription history of a patient, optionally by ?status=
+func GetPatientPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(prescriptionStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(prescriptionStatuses, ", "))
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	prescriptions, err := storage.Store.GetPatientPrescriptions(ctx, id, status, include)
+	respondPrescriptions(w, prescriptions, err)
+}
+
+// GetPatientMedicationsHandler lists what the patient currently takes: active
+// prescriptions whose course has not ended
+func GetPatientMedicationsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	prescriptions, err := storage.Store.GetCurrentMedications(ctx, id, today())
+	respondPrescriptions(w, prescriptions, err)
+}
+
+func GetPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	prescription, err := storage.Store.GetPrescription(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Prescription not found", "failed to fetch prescription: ")
+		return
+	}
+	if utils.NotModified(w, r, prescription.Version) {
+		return
+	}
+	utils.SetETag(w, prescription.Version)
+	utils.RespondJSON(w, http.StatusOK, prescription)
+}
+
+// DiscontinuePrescriptionHandler stops an active prescription with a reason
+func DiscontinuePrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var body models.Discontinuation
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		utils.RespondError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	prescription, err := storage.Store.DiscontinuePrescription(r.Context(), id, version, body.Reason, currentUser(r))
+	if err != nil {
+		respondWriteError(w, err, "Prescription not found", "discontinue failed: ")
+		return
+	}
+	utils.SetETag(w, prescription.Version)
+	utils.RespondJSON(w, http.StatusOK, prescription)
+}
+
+func DeletePrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeletePrescription(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Prescription not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Prescription deleted"})
+}
+
+// RestorePrescriptionRequest is the optional body of a prescription restore
+type RestorePrescriptionRequest struct {
+	OverrideReason string `json:"override_reason"` // accepts the warnings of the new check
+}
+
+// RestorePrescriptionHandler brings back a soft-deleted prescription; its
+// patient must not be deleted. A course still running is checked again like a
+// new prescription: a blocking alert answers 422, and warnings answer 409
+// until the request repeats with an override_reason for them; the reason
+// given when it was prescribed covered other findings.
+func RestorePrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var body RestorePrescriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	body.OverrideReason = strings.TrimSpace(body.OverrideReason)
+
+	var check models.PrescriptionCheck
+	var prescription *models.Prescription
+	err = storage.Store.InTx(ctx, func(st *storage.Storage) error {
+		// the patient first, in the order create and patient delete lock
+		if err := st.LockPrescriptionPatient(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		if prescription, err = st.RestorePrescription(ctx, id); err != nil {
+			return err
+		}
+		if prescription.Status != models.PrescriptionActive || prescription.EndDate != "" && prescription.EndDate < today() {
+			return nil
+		}
+		medication, err := st.GetMedication(ctx, prescription.MedicationID)
+		if err != nil {
+			return err
+		}
+		if check, err = prescriptionCheck(ctx, st, prescription, medication); err != nil {
+			return err
+		}
+		if status, _ := checkRefusal(check, body.OverrideReason); status != 0 {
+			return errRefused
+		}
+		prescription.Alerts, prescription.OverrideReason = check.Alerts, body.OverrideReason
+		if len(check.Alerts) == 0 {
+			prescription.OverrideReason = ""
+		}
+		return st.SetPrescriptionAlerts(ctx, prescription)
+	})
+	if errors.Is(err, errRefused) {
+		status, message := checkRefusal(check, body.OverrideReason)
+		utils.RespondJSON(w, status, alertResponse{message, check})
+		return
+	}
+	if err != nil {
+		respondWriteError(w, err, "Prescription not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, prescription.Version)
+	utils.RespondJSON(w, http.StatusOK, prescription)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/TeseySTD/GoHospitalApi/interactions"
+	"github.com/TeseySTD/GoHospitalApi/models"
+)
+
+func TestAllergyCovers(t *testing.T) {
+	amoxicillin := &models.Medication{ID: 1, Name: "Amoxicillin 500 mg", ATCCode: "J01CA04"}
+	tests := []struct {
+		name    string
+		allergy models.Allergy
+		want    bool
+	}{
+		{"ATC group", models.Allergy{Substance: "Penicillins", ATCCode: "J01C"}, true},
+		{"exact ATC code", models.Allergy{Substance: "Amoxicillin", ATCCode: "J01CA04"}, true},
+		{"other ATC group", models.Allergy{Substance: "Cephalosporins", ATCCode: "J01D"}, false},
+		{"substance in the name", models.Allergy{Substance: "amoxicillin"}, true},
+		{"other substance", models.Allergy{Substance: "Latex"}, false},
+	}
+	for _, tt := range tests {
+		if got := allergyCovers(&tt.allergy, amoxicillin); got != tt.want {
+			t.Errorf("%s: allergyCovers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPrescription(t *testing.T) {
+	table, err := interactions.Load(strings.NewReader(
+		"B01AA\tM01A\tcontraindicated\tBleeding risk\n" +
+			"C09A\tC03D\tmoderate\tRisk of hyperkalaemia\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := Interactions
+	Interactions = table
+	defer func() { Interactions = saved }()
+
+	ibuprofen := &models.Medication{ID: 1, Name: "Ibuprofen", ATCCode: "M01AE01"}
+	enalapril := &models.Medication{ID: 2, Name: "Enalapril", ATCCode: "C09AA02"}
+	warfarin := models.Prescription{ID: 10, MedicationID: 3, MedicationName: "Warfarin", ATCCode: "B01AA03"}
+	spironolactone := models.Prescription{ID: 11, MedicationID: 4, MedicationName: "Spironolactone", ATCCode: "C03DA01"}
+
+	tests := []struct {
+		name      string
+		m         *models.Medication
+		allergies []models.Allergy
+		current   []models.Prescription
+		reason    string
+		blocked   bool
+		override  bool
+		status    int
+	}{
+		{name: "nothing found", m: ibuprofen, status: 0},
+		{
+			name:      "severe allergy blocks",
+			m:         ibuprofen,
+			allergies: []models.Allergy{{ID: 1, Substance: "NSAIDs", ATCCode: "M01A", Severity: "severe", Status: "active"}},
+			reason:    "accepted",
+			blocked:   true, status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:      "mild allergy needs a reason",
+			m:         ibuprofen,
+			allergies: []models.Allergy{{ID: 1, Substance: "ibuprofen", Severity: "mild", Status: "active"}},
+			override:  true, status: http.StatusConflict,
+		},
+		{
+			name:      "inactive allergy is ignored",
+			m:         ibuprofen,
+			allergies: []models.Allergy{{ID: 1, Substance: "ibuprofen", Severity: "severe", Status: "resolved"}},
+			status:    0,
+		},
+		{
+			name:    "contraindicated blocks",
+			m:       ibuprofen,
+			current: []models.Prescription{warfarin},
+			reason:  "accepted",
+			blocked: true, status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "moderate needs a reason",
+			m:        enalapril,
+			current:  []models.Prescription{spironolactone},
+			override: true, status: http.StatusConflict,
+		},
+		{
+			name:     "moderate with a reason",
+			m:        enalapril,
+			current:  []models.Prescription{spironolactone},
+			reason:   "potassium monitored weekly",
+			override: true, status: 0,
+		},
+		{
+			name:     "duplicate needs a reason",
+			m:        ibuprofen,
+			current:  []models.Prescription{{ID: 12, MedicationID: 5, MedicationName: "Ibuprofen gel", ATCCode: "M01AE01"}},
+			override: true, status: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		check := checkPrescription(tt.m, tt.allergies, tt.current)
+		if check.Blocked != tt.blocked || check.Override != tt.override {
+			t.Errorf("%s: blocked = %v, override = %v, want %v, %v", tt.name, check.Blocked, check.Override, tt.blocked, tt.override)
+		}
+		if status, _ := checkRefusal(check, tt.reason); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
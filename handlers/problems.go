@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/icd10"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// ICD10 is the code list used for lookups and problem descriptions
+var ICD10 = icd10.Bundled()
+
+const (
+	defaultCodeLimit = 20
+	maxCodeLimit     = 100
+)
+
+// SearchICD10Handler finds codes by code prefix or by words of the description
+func SearchICD10Handler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		utils.RespondError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := defaultCodeLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxCodeLimit {
+			utils.RespondError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxCodeLimit))
+			return
+		}
+		limit = n
+	}
+	utils.RespondJSON(w, http.StatusOK, ICD10.Search(q, limit))
+}
+
+func GetICD10CodeHandler(w http.ResponseWriter, r *http.Request) {
+	code, ok := ICD10.Lookup(r.PathValue("code"))
+	if !ok {
+		utils.RespondError(w, http.StatusNotFound, "ICD-10 code not found")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, code)
+}
+
+// prepareProblem normalizes the code, defaults the status and takes the
+// description from the code list when it is empty
+func prepareProblem(p *models.Problem) error {
+	if p.Status == "" {
+		p.Status = "active"
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	p.Code, _ = icd10.Normalize(p.Code)
+	p.Description = strings.TrimSpace(p.Description)
+	if p.Description == "" {
+		code, ok := ICD10.Lookup(p.Code)
+		if !ok {
+			return errors.New("code " + p.Code + " is not in the ICD-10 code list; description is required")
+		}
+		p.Description = code.Description
+	}
+	return nil
+}
+
+// problemAppointment checks that the appointment a problem was diagnosed at,
+// if any, is one of the patient's; it answers the error and returns false otherwise
+func problemAppointment(w http.ResponseWriter, r *http.Request, p *models.Problem) bool {
+	if p.AppointmentID == 0 {
+		return true
+	}
+	appointment, err := storage.Store.GetAppointmentByID(r.Context(), p.AppointmentID)
+	if err != nil {
+		respondReferenceError(w, err, "appointment_id does not exist", "failed to fetch appointment: ")
+		return false
+	}
+	if appointment.PatientID != p.PatientID {
+		utils.RespondError(w, http.StatusBadRequest, "appointment_id belongs to another patient")
+		return false
+	}
+	return true
+}
+
+// problemIDs parses {id} and {problem_id}
+func problemIDs(w http.ResponseWriter, r *http.Request) (patientID, id int, ok bool) {
+	patientID, err := utils.PathID(r, "id")
+	if err == nil {
+		id, err = utils.PathID(r, "problem_id")
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return 0, 0, false
+	}
+	return patientID, id, true
+}
+
+// GetPatientProblemsHandler lists the problem list of a patient, optionally by ?status=
+func GetPatientProblemsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.ProblemStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.ProblemStatuses, ", "))
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+
+	problems, err := storage.Store.GetPatientProblems(ctx, id, status, include)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch problems: "+err.Error())
+		return
+	}
+	if problems == nil {
+		problems = []models.Problem{}
+	}
+	utils.RespondJSON(w, http.StatusOK, problems)
+}
+
+func GetPatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := problemIDs(w, r)
+	if !ok {
+		return
+	}
+
+	problem, err := storage.Store.GetProblem(r.Context(), patientID, id)
+	if err != nil {
+		respondLookupError(w, err, "Problem not found", "failed to fetch problem: ")
+		return
+	}
+	if utils.NotModified(w, r, problem.Version) {
+		return
+	}
+	utils.SetETag(w, problem.Version)
+	utils.RespondJSON(w, http.StatusOK, problem)
+}
+
+func CreatePatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var problem models.Problem
+	if err := json.NewDecoder(r.Body).Decode(&problem); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareProblem(&problem); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	problem.PatientID = id
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	if !problemAppointment(w, r, &problem) {
+		return
+	}
+
+	created, err := storage.Store.CreateProblem(ctx, &problem)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to create problem: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func UpdatePatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := problemIDs(w, r)
+	if !ok {
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Problem
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareProblem(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.PatientID, updated.Version = id, patientID, version
+	if !problemAppointment(w, r, &updated) {
+		return
+	}
+
+	if err := storage.Store.UpdateProblem(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Problem not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// PatchPatientProblemHandler accepts a JSON Merge Patch or a JSON Patch; a
+// changed code without a new description takes the description from the code list
+func PatchPatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := problemIDs(w, r)
+	if !ok {
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, patch, perr := readPatch(r)
+	if perr != nil {
+		respondPatchError(w, perr, "Problem not found")
+		return
+	}
+
+	updated, err := storage.Store.PatchProblem(r.Context(), patientID, id, version, func(p *models.Problem) error {
+		before := *p
+		if err := patchInto(p, mediaType, patch); err != nil {
+			return err
+		}
+		if p.Code != before.Code && p.Description == before.Description {
+			p.Description = ""
+		}
+		if err := prepareProblem(p); err != nil {
+			return &patchError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		if p.AppointmentID != before.AppointmentID && p.AppointmentID != 0 {
+			appointment, err := storage.Store.GetAppointmentByID(r.Context(), p.AppointmentID)
+			if err != nil && !strings.Contains(err.Error(), "no rows") {
+				return err
+			}
+			if err != nil || appointment.PatientID != patientID {
+				return &patchError{http.StatusUnprocessableEntity, "appointment_id must be an appointment of the patient"}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondPatchError(w, err, "Problem not found")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+func DeletePatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := problemIDs(w, r)
+	if !ok {
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteProblem(r.Context(), patientID, id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Problem not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Problem deleted"})
+}
+
+// RestorePatientProblemHandler brings back a soft-deleted problem of a patient that is not deleted
+func RestorePatientProblemHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, id, ok := problemIDs(w, r)
+	if !ok {
+		return
+	}
+
+	problem, err := storage.Store.RestoreProblem(r.Context(), patientID, id)
+	if err != nil {
+		respondWriteError(w, err, "Problem not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, problem.Version)
+	utils.RespondJSON(w, http.StatusOK, problem)
+}
@@ -11,25 +11,17 @@ func RootHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	routes := Routes()
+	endpoints := make(map[string]string, len(routes))
+	for _, rt := range routes {
+		endpoints[rt.Method+" "+rt.Path] = rt.Summary
+	}
+
 	info := map[string]interface{}{
-		"message": "Hospital REST API",
-		"endpoints": map[string]string{
-			"GET /patients":             "Get all patients",
-			"GET /patients/{id}":        "Get patient by ID",
-			"POST /patients":            "Create a new patient",
-			"PUT /patients/{id}":        "Update patient",
-			"DELETE /patients/{id}":     "Delete patient",
-			"GET /doctors":              "Get all doctors",
-			"GET /doctors/{id}":         "Get doctor by ID",
-			"POST /doctors":             "Create a new doctor",
-			"PUT /doctors/{id}":         "Update doctor",
-			"DELETE /doctors/{id}":      "Delete doctor",
-			"GET /appointments":         "Get all appointments",
-			"GET /appointments/{id}":    "Get appointment by ID",
-			"POST /appointments":        "Create a new appointment",
-			"PUT /appointments/{id}":    "Update appointment",
-			"DELETE /appointments/{id}": "Delete appointment",
-		},
+		"message":   "Hospital REST API",
+		"docs":      "/docs",
+		"openapi":   "/openapi.json",
+		"endpoints": endpoints,
 	}
 	utils.RespondJSON(w, http.StatusOK, info)
 }
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/specialties"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// maxRosterRange bounds the period of a shift listing or a rotation run
+const maxRosterRange = 92 * 24 * time.Hour
+
+// rosterRange reads ?from= and ?to=; the default is the week from now
+func rosterRange(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	if from, ok = timeParam(w, r, "from"); !ok {
+		return
+	}
+	if to, ok = timeParam(w, r, "to"); !ok {
+		return
+	}
+	if from.IsZero() {
+		from = time.Now()
+	}
+	if to.IsZero() {
+		to = from.Add(7 * 24 * time.Hour)
+	}
+	if !to.After(from) || to.Sub(from) > maxRosterRange {
+		utils.RespondError(w, http.StatusBadRequest, fmt.Sprintf("to must be after from and at most %d days later", int(maxRosterRange.Hours()/24)))
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// checkDepartment answers 400 when the department_id in the body does not exist; 0 is skipped
+func checkDepartment(w http.ResponseWriter, r *http.Request, departmentID int) bool {
+	if departmentID == 0 {
+		return true
+	}
+	if _, err := storage.Store.GetDepartment(r.Context(), departmentID); err != nil {
+		respondReferenceError(w, err, "department_id does not exist", "failed to fetch department: ")
+		return false
+	}
+	return true
+}
+
+// readShift decodes and validates a shift; its doctor and department must exist
+func readShift(w http.ResponseWriter, r *http.Request) (*models.Shift, bool) {
+	var shift models.Shift
+	if err := json.NewDecoder(r.Body).Decode(&shift); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	shift.Kind = strings.ToLower(strings.TrimSpace(shift.Kind))
+	shift.Note = strings.TrimSpace(shift.Note)
+	shift.RotationID = 0
+	if err := shift.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if _, err := storage.Store.GetDoctorByID(r.Context(), shift.DoctorID); err != nil {
+		respondReferenceError(w, err, "doctor_id does not exist", "failed to fetch doctor: ")
+		return nil, false
+	}
+	if !checkDepartment(w, r, shift.DepartmentID) {
+		return nil, false
+	}
+	return &shift, true
+}
+
+func respondShifts(w http.ResponseWriter, shifts []models.Shift, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch shifts: "+err.Error())
+		return
+	}
+	if shifts == nil {
+		shifts = []models.Shift{}
+	}
+	utils.RespondJSON(w, http.StatusOK, shifts)
+}
+
+// GetShiftsHandler lists the roster between ?from= and ?to=, optionally of one
+// ?doctor_id=, ?department_id= (with sub-departments) and ?kind=
+func GetShiftsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := rosterRange(w, r)
+	if !ok {
+		return
+	}
+	doctorID, ok := idParam(w, r, "doctor_id")
+	if !ok {
+		return
+	}
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && !slices.Contains(models.ShiftKinds, kind) {
+		utils.RespondError(w, http.StatusBadRequest, "kind must be one of "+strings.Join(models.ShiftKinds, ", "))
+		return
+	}
+
+	shifts, err := storage.Store.GetShifts(r.Context(), from, to, doctorID, departmentID, kind)
+	respondShifts(w, shifts, err)
+}
+
+// CreateShiftHandler rosters a doctor; 409 when the doctor already works then or is on leave
+func CreateShiftHandler(w http.ResponseWriter, r *http.Request) {
+	shift, ok := readShift(w, r)
+	if !ok {
+		return
+	}
+	shift.CreatedBy = currentUser(r)
+
+	created, err := storage.Store.CreateShift(r.Context(), shift)
+	if err != nil {
+		respondWriteError(w, err, "Doctor not found", "failed to create shift: ")
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+func GetShiftHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shift, err := storage.Store.GetShift(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Shift not found", "failed to fetch shift: ")
+		return
+	}
+	if utils.NotModified(w, r, shift.Version) {
+		return
+	}
+	utils.SetETag(w, shift.Version)
+	utils.RespondJSON(w, http.StatusOK, shift)
+}
+
+func UpdateShiftHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	shift, ok := readShift(w, r)
+	if !ok {
+		return
+	}
+	shift.ID, shift.Version = id, version
+
+	updated, err := storage.Store.UpdateShift(r.Context(), shift)
+	if err != nil {
+		respondWriteError(w, err, "Shift not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+func DeleteShiftHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteShift(r.Context(), id, version); err != nil {
+		respondWriteError(w, err, "Shift not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Shift deleted"})
+}
+
+// respondOnShift answers who works a kind of shift at ?at= (default now),
+// optionally of a ?specialty= (code, name or synonym) and a ?department_id=
+func respondOnShift(w http.ResponseWriter, r *http.Request, kind string) {
+	at, ok := timeParam(w, r, "at")
+	if !ok {
+		return
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+	var specialty string
+	if text := r.URL.Query().Get("specialty"); text != "" {
+		s, found := specialties.Normalize(text)
+		if !found {
+			utils.RespondError(w, http.StatusBadRequest, "specialty must be a specialty, its code or a synonym; see GET /specialties")
+			return
+		}
+		specialty = s.Code
+	}
+
+	shifts, err := storage.Store.GetShiftsAt(r.Context(), kind, at, departmentID)
+	if specialty != "" {
+		shifts = slices.DeleteFunc(shifts, func(s models.Shift) bool { return s.SpecialtyCode != specialty })
+	}
+	respondShifts(w, shifts, err)
+}
+
+// GetOnCallHandler lists the doctors on call at a moment, e.g. the cardiologist on call now
+func GetOnCallHandler(w http.ResponseWriter, r *http.Request) {
+	respondOnShift(w, r, models.ShiftOnCall)
+}
+
+// GetOnDutyHandler lists the doctors on a duty shift at a moment
+func GetOnDutyHandler(w http.ResponseWriter, r *http.Request) {
+	respondOnShift(w, r, models.ShiftDuty)
+}
+
+// GetDoctorAvailabilityHandler lists the shifts of a doctor on ?date= (default
+// today) and the appointment slots in them that are still free
+func GetDoctorAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().In(CalendarLocation).Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+
+	if _, err := storage.Store.GetDoctorByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Doctor not found", "failed to fetch doctor: ")
+		return
+	}
+	availability, err := storage.Store.GetAvailability(ctx, id, date)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch availability: "+err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, availability)
+}
+
+// readRotation decodes and validates a rotation; its department and doctors must exist
+func readRotation(w http.ResponseWriter, r *http.Request) (*models.OnCallRotation, bool) {
+	var rotation models.OnCallRotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	rotation.Name = strings.TrimSpace(rotation.Name)
+	if err := rotation.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if !checkDepartment(w, r, rotation.DepartmentID) {
+		return nil, false
+	}
+	for _, id := range rotation.DoctorIDs {
+		if _, err := storage.Store.GetDoctorByID(r.Context(), id); err != nil {
+			respondReferenceError(w, err, fmt.Sprintf("doctor %d in doctor_ids does not exist", id), "failed to fetch doctor: ")
+			return nil, false
+		}
+	}
+	return &rotation, true
+}
+
+func GetRotationsHandler(w http.ResponseWriter, r *http.Request) {
+	rotations, err := storage.Store.GetRotations(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch rotations: "+err.Error())
+		return
+	}
+	if rotations == nil {
+		rotations = []models.OnCallRotation{}
+	}
+	utils.RespondJSON(w, http.StatusOK, rotations)
+}
+
+func CreateRotationHandler(w http.ResponseWriter, r *http.Request) {
+	rotation, ok := readRotation(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := storage.Store.CreateRotation(r.Context(), rotation)
+	respondCreated(w, err, rotation.Version, created, "failed to create rotation: ")
+}
+
+func GetRotationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rotation, err := storage.Store.GetRotation(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Rotation not found", "failed to fetch rotation: ")
+		return
+	}
+	if utils.NotModified(w, r, rotation.Version) {
+		return
+	}
+	utils.SetETag(w, rotation.Version)
+	utils.RespondJSON(w, http.StatusOK, rotation)
+}
+
+func UpdateRotationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	rotation, ok := readRotation(w, r)
+	if !ok {
+		return
+	}
+	rotation.ID, rotation.Version = id, version
+
+	if err := storage.Store.UpdateRotation(r.Context(), rotation); err != nil {
+		respondWriteError(w, err, "Rotation not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, rotation.Version)
+	utils.RespondJSON(w, http.StatusOK, rotation)
+}
+
+// GenerateRotationHandler rosters the on-call turns of a rotation between
+// ?from= and ?to=; turns whose doctor is on leave or busy are reported as skipped
+func GenerateRotationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to, ok := rosterRange(w, r)
+	if !ok {
+		return
+	}
+
+	run, err := storage.Store.GenerateRotation(r.Context(), id, from, to, currentUser(r))
+	if err != nil {
+		respondLookupError(w, err, "Rotation not found", "failed to generate shifts: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/claimfile"
+	"github.com/TeseySTD/GoHospitalApi/icd10"
+	"github.com/TeseySTD/GoHospitalApi/middleware"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/news2"
+	"github.com/TeseySTD/GoHospitalApi/openapi"
+	"github.com/TeseySTD/GoHospitalApi/specialties"
+	"github.com/TeseySTD/GoHospitalApi/xlsx"
+)
+
+// Route is one entry of the central route table. main registers the handler
+// with the middleware chain derived from Access, and the OpenAPI document is
+// generated from the same entries.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Access  middleware.Access
+	Feed    string // feed kind for middleware.AccessFeed
+
+	Summary     string
+	Tag         string
+	Params      []openapi.Param
+	Request     any
+	Response    any
+	Status      int
+	ContentType string
+	Patch       bool
+	Upload      []string
+	AlsoStatus  []int
+	Versioned   bool // ETag on responses, If-Match on writes
+	Idempotent  bool // honours the Idempotency-Key header
+	Errors      []int
+}
+
+const (
+	public   = middleware.AccessPublic
+	read     = middleware.AccessRead
+	admin    = middleware.AccessAdmin
+	feed     = middleware.AccessFeed
+	clinical = middleware.AccessClinical
+)
+
+var (
+	patientFilters = []openapi.Param{
+		{Name: "first_name", Description: "Case-insensitive substring"},
+		{Name: "last_name", Description: "Case-insensitive substring"},
+		{Name: "age", Type: "integer"},
+		{Name: "diagnosis", Description: "Case-insensitive substring"},
+		{Name: "date_of_birth", Description: "YYYY-MM-DD"},
+		{Name: "sex", Description: "male, female, other or unknown"},
+		{Name: "mrn", Description: "Medical record number"},
+		{Name: "national_id"},
+		{Name: "phone", Description: "Digits, formatting is ignored"},
+		{Name: "email", Description: "Case-insensitive substring"},
+		{Name: "city", Description: "Case-insensitive substring"},
+		{Name: "icd10", Description: "ICD-10 code prefix of a recorded problem, e.g. J45 or E11.9"},
+		{Name: "problem_status", Description: "Status of the matching problem: " + strings.Join(models.ProblemStatuses, ", ")},
+		withDeleted,
+	}
+	doctorFilters = []openapi.Param{
+		{Name: "first_name", Description: "Case-insensitive substring"},
+		{Name: "last_name", Description: "Case-insensitive substring"},
+		{Name: "specialization", Description: "Case-insensitive substring"},
+		{Name: "specialty", Description: "Specialty code, e.g. cardiology; see /specialties"},
+		{Name: "department_id", Type: "integer", Description: "On the staff of the department or its sub-departments"},
+		{Name: "experience", Type: "integer"},
+		{Name: "min_experience", Type: "integer"},
+		withDeleted,
+	}
+	spreadsheetUpload = []string{"text/csv", xlsx.ContentType}
+	importOptions     = []openapi.Param{
+		{Name: "map", Description: "field:Column renames a column, repeatable"},
+		{Name: "dry_run", Type: "boolean", Description: "Only validate and report"},
+		{Name: "duplicates", Description: "skip (default), fail or allow"},
+	}
+	exportFormat = openapi.Param{Name: "format", Description: "csv (default) or xlsx"}
+	batchMode    = []openapi.Param{
+		{Name: "mode", Description: "atomic (default): all items or none; partial: skip failed items"},
+	}
+	duplicateParams = []openapi.Param{
+		{Name: "min_score", Type: "number", Description: "0 to 1, default 0.65"},
+		{Name: "limit", Type: "integer", Description: "Default 100"},
+	}
+	searchParams = []openapi.Param{
+		{Name: "q", Required: true, Description: "Words to find; each word also matches as a prefix"},
+		{Name: "type", Description: "Comma-separated: patient, doctor, appointment (default all)"},
+		{Name: "limit", Type: "integer", Description: "1-100, default 20"},
+	}
+	appointmentFilters = []openapi.Param{
+		{Name: "patient_id", Type: "integer"},
+		{Name: "doctor_id", Type: "integer"},
+		{Name: "department_id", Type: "integer", Description: "With a doctor on the staff of the department or its sub-departments"},
+		{Name: "date", Description: "YYYY-MM-DD"},
+		{Name: "status", Description: "Case-insensitive exact match"},
+		withDeleted,
+	}
+	rosterPeriod = []openapi.Param{
+		{Name: "from", Description: "RFC 3339 timestamp, default now"},
+		{Name: "to", Description: "RFC 3339 timestamp, default a week after from; at most 92 days after it"},
+	}
+	onShiftParams = []openapi.Param{
+		{Name: "specialty", Description: "Specialty code, name or synonym, e.g. cardiologist"},
+		{Name: "department_id", Type: "integer", Description: "Includes sub-departments"},
+		{Name: "at", Description: "RFC 3339 timestamp, default now"},
+	}
+	withDeleted = openapi.Param{Name: "include_deleted", Type: "boolean", Description: "Also list soft-deleted rows (admins only)"}
+)
+
+// Routes returns every route of the API
+func Routes() []Route {
+	return []Route{
+		{Method: "GET", Path: "/", Handler: RootHandler, Access: public, Summary: "API information", Tag: "meta", Response: map[string]any{}},
+		{Method: "GET", Path: "/openapi.json", Handler: OpenAPIHandler, Access: public, Summary: "OpenAPI document", Tag: "meta", Response: map[string]any{}},
+		{Method: "GET", Path: "/docs", Handler: DocsHandler, Access: public, Summary: "Interactive API documentation", Tag: "meta", Response: "", ContentType: "text/html"},
+
+		{Method: "POST", Path: "/login", Handler: LoginHandler, Access: public, Summary: "Get a JWT for a user", Tag: "auth", Request: LoginRequest{}, Response: LoginResponse{}, Errors: []int{400, 401}},
+		{Method: "GET", Path: "/users", Handler: UsersListHandler, Access: public, Summary: "List test users", Tag: "auth", Response: map[string]any{}},
+
+		{Method: "GET", Path: "/patients", Handler: GetPatientsHandler, Access: read, Summary: "Get all patients", Tag: "patients", Params: patientFilters, Response: []models.Patient{}, Errors: []int{400, 403}},
+		{Method: "POST", Path: "/patients", Handler: CreatePatientHandler, Access: read, Summary: "Create a new patient", Tag: "patients", Request: models.Patient{}, Response: models.Patient{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/patients/export", Handler: ExportPatientsHandler, Access: read, Summary: "Export the filtered patients list as CSV or XLSX", Tag: "patients", Params: append([]openapi.Param{exportFormat}, patientFilters...), Response: "", ContentType: "text/csv", Errors: []int{400, 403}},
+		{Method: "POST", Path: "/patients/import", Handler: ImportPatientsHandler, Access: read, Summary: "Import patients from a CSV or XLSX file", Tag: "patients", Params: importOptions, Upload: spreadsheetUpload, Response: models.ImportReport{}, Status: 201, AlsoStatus: []int{200, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "POST", Path: "/patients/batch", Handler: CreatePatientsBatchHandler, Access: read, Summary: "Create patients in bulk", Tag: "patients", Params: batchMode, Request: []models.Patient{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "PUT", Path: "/patients/batch", Handler: UpdatePatientsBatchHandler, Access: read, Summary: "Update patients in bulk", Tag: "patients", Params: batchMode, Request: []models.Patient{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "DELETE", Path: "/patients/batch", Handler: DeletePatientsBatchHandler, Access: read, Summary: "Soft-delete patients in bulk", Tag: "patients", Params: batchMode, Request: []models.BatchRef{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "GET", Path: "/patients/duplicates", Handler: GetDuplicatesHandler, Access: admin, Summary: "Review queue of probable duplicate patients, best match first", Tag: "duplicates", Params: duplicateParams, Response: []models.DuplicateCandidate{}, Errors: []int{400}},
+		{Method: "POST", Path: "/patients/duplicates/dismiss", Handler: DismissDuplicateHandler, Access: admin, Summary: "Mark two patients as different people", Tag: "duplicates", Request: models.DuplicatePair{}, Response: map[string]string{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}", Handler: GetPatientHandler, Access: read, Summary: "Get patient by ID", Tag: "patients", Response: models.Patient{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/patients/{id}", Handler: UpdatePatientHandler, Access: read, Summary: "Update patient", Tag: "patients", Request: models.Patient{}, Response: models.Patient{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "PATCH", Path: "/patients/{id}", Handler: PatchPatientHandler, Access: read, Summary: "Partially update patient", Tag: "patients", Request: models.Patient{}, Patch: true, Response: models.Patient{}, Versioned: true, Errors: []int{400, 404, 409, 415, 422}},
+		{Method: "DELETE", Path: "/patients/{id}", Handler: DeletePatientHandler, Access: read, Summary: "Soft-delete patient; restorable until purged", Tag: "patients", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/patients/{id}/restore", Handler: RestorePatientHandler, Access: admin, Summary: "Restore a soft-deleted patient with the records deleted together with it", Tag: "patients", Response: models.Patient{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/appointments", Handler: GetPatientAppointmentsHandler, Access: read, Summary: "Appointments of a patient", Tag: "patients", Response: []models.AppointmentDetails{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/timeline", Handler: GetPatientTimelineHandler, Access: read, Summary: "Appointments, status changes and clinical records in chronological order", Tag: "patients", Response: []models.TimelineEvent{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/duplicates", Handler: GetPatientDuplicatesHandler, Access: read, Summary: "Probable duplicates of a patient", Tag: "duplicates", Params: duplicateParams, Response: []models.DuplicateCandidate{}, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/patients/{id}/merge", Handler: MergePatientHandler, Access: admin, Summary: "Merge a duplicate into this patient; its appointments and clinical data move here", Tag: "duplicates", Request: MergeRequest{}, Response: models.MergeResult{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/merges", Handler: GetPatientMergesHandler, Access: read, Summary: "Records merged into a patient", Tag: "duplicates", Response: []models.PatientMerge{}, Errors: []int{400}},
+		{Method: "GET", Path: "/patients/{id}/problems", Handler: GetPatientProblemsHandler, Access: read, Summary: "Problem list of a patient, open problems first", Tag: "problems", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.ProblemStatuses, ", ")}, withDeleted}, Response: []models.Problem{}, Errors: []int{400, 403, 404}},
+		{Method: "POST", Path: "/patients/{id}/problems", Handler: CreatePatientProblemHandler, Access: read, Summary: "Record a coded problem for a patient", Tag: "problems", Request: models.Problem{}, Response: models.Problem{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/problems/{problem_id}", Handler: GetPatientProblemHandler, Access: read, Summary: "Get a problem of a patient", Tag: "problems", Response: models.Problem{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/patients/{id}/problems/{problem_id}", Handler: UpdatePatientProblemHandler, Access: read, Summary: "Update a problem", Tag: "problems", Request: models.Problem{}, Response: models.Problem{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PATCH", Path: "/patients/{id}/problems/{problem_id}", Handler: PatchPatientProblemHandler, Access: read, Summary: "Partially update a problem, e.g. resolve it", Tag: "problems", Request: models.Problem{}, Patch: true, Response: models.Problem{}, Versioned: true, Errors: []int{400, 404, 409, 415, 422}},
+		{Method: "DELETE", Path: "/patients/{id}/problems/{problem_id}", Handler: DeletePatientProblemHandler, Access: read, Summary: "Soft-delete a problem entered in error", Tag: "problems", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/patients/{id}/problems/{problem_id}/restore", Handler: RestorePatientProblemHandler, Access: admin, Summary: "Restore a soft-deleted problem", Tag: "problems", Response: models.Problem{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/allergies", Handler: GetPatientAllergiesHandler, Access: read, Summary: "Allergies of a patient, active and severe ones first", Tag: "allergies", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.AllergyStatuses, ", ")}, withDeleted}, Response: []models.Allergy{}, Errors: []int{400, 403, 404}},
+		{Method: "POST", Path: "/patients/{id}/allergies", Handler: CreatePatientAllergyHandler, Access: read, Summary: "Record an allergy; atc_code names the drugs it covers for prescription checks", Tag: "allergies", Request: models.Allergy{}, Response: models.Allergy{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/allergies/{allergy_id}", Handler: GetPatientAllergyHandler, Access: read, Summary: "Get an allergy of a patient", Tag: "allergies", Response: models.Allergy{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/patients/{id}/allergies/{allergy_id}", Handler: UpdatePatientAllergyHandler, Access: read, Summary: "Update an allergy", Tag: "allergies", Request: models.Allergy{}, Response: models.Allergy{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "DELETE", Path: "/patients/{id}/allergies/{allergy_id}", Handler: DeletePatientAllergyHandler, Access: read, Summary: "Soft-delete an allergy entered in error", Tag: "allergies", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/patients/{id}/allergies/{allergy_id}/restore", Handler: RestorePatientAllergyHandler, Access: admin, Summary: "Restore a soft-deleted allergy", Tag: "allergies", Response: models.Allergy{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/calendar.ics", Handler: PatientCalendarHandler, Access: feed, Feed: feedPatient, Summary: "Patient appointments as iCalendar feed", Tag: "calendar", Response: "", ContentType: "text/calendar", Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/calendar-token", Handler: PatientCalendarTokenHandler, Access: admin, Summary: "Get a calendar subscription URL for a patient", Tag: "calendar", Response: map[string]string{}, Errors: []int{400}},
+
+		{Method: "GET", Path: "/doctors", Handler: GetDoctorsHandler, Access: read, Summary: "Get all doctors", Tag: "doctors", Params: doctorFilters, Response: []models.Doctor{}, Errors: []int{400, 403}},
+		{Method: "POST", Path: "/doctors", Handler: CreateDoctorHandler, Access: read, Summary: "Create a new doctor", Tag: "doctors", Request: models.Doctor{}, Response: models.Doctor{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400}},
+		{Method: "GET", Path: "/doctors/export", Handler: ExportDoctorsHandler, Access: read, Summary: "Export the filtered doctors list as CSV or XLSX", Tag: "doctors", Params: append([]openapi.Param{exportFormat}, doctorFilters...), Response: "", ContentType: "text/csv", Errors: []int{400, 403}},
+		{Method: "POST", Path: "/doctors/import", Handler: ImportDoctorsHandler, Access: read, Summary: "Import doctors from a CSV or XLSX file", Tag: "doctors", Params: importOptions, Upload: spreadsheetUpload, Response: models.ImportReport{}, Status: 201, AlsoStatus: []int{200, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "POST", Path: "/doctors/batch", Handler: CreateDoctorsBatchHandler, Access: read, Summary: "Create doctors in bulk", Tag: "doctors", Params: batchMode, Request: []models.Doctor{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "PUT", Path: "/doctors/batch", Handler: UpdateDoctorsBatchHandler, Access: read, Summary: "Update doctors in bulk", Tag: "doctors", Params: batchMode, Request: []models.Doctor{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "DELETE", Path: "/doctors/batch", Handler: DeleteDoctorsBatchHandler, Access: read, Summary: "Soft-delete doctors in bulk", Tag: "doctors", Params: batchMode, Request: []models.BatchRef{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "GET", Path: "/doctors/{id}", Handler: GetDoctorHandler, Access: read, Summary: "Get doctor by ID", Tag: "doctors", Response: models.Doctor{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/doctors/{id}", Handler: UpdateDoctorHandler, Access: read, Summary: "Update doctor", Tag: "doctors", Request: models.Doctor{}, Response: models.Doctor{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PATCH", Path: "/doctors/{id}", Handler: PatchDoctorHandler, Access: read, Summary: "Partially update doctor", Tag: "doctors", Request: models.Doctor{}, Patch: true, Response: models.Doctor{}, Versioned: true, Errors: []int{400, 404, 409, 415, 422}},
+		{Method: "DELETE", Path: "/doctors/{id}", Handler: DeleteDoctorHandler, Access: read, Summary: "Soft-delete doctor; restorable until purged", Tag: "doctors", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/doctors/{id}/restore", Handler: RestoreDoctorHandler, Access: admin, Summary: "Restore a soft-deleted doctor", Tag: "doctors", Response: models.Doctor{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/doctors/{id}/appointments", Handler: GetDoctorAppointmentsHandler, Access: read, Summary: "Appointments of a doctor", Tag: "doctors", Response: []models.AppointmentDetails{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/doctors/{id}/departments", Handler: GetDoctorDepartmentsHandler, Access: read, Summary: "Departments of a doctor with the role in each", Tag: "departments", Response: []models.DepartmentMember{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/doctors/{id}/availability", Handler: GetDoctorAvailabilityHandler, Access: read, Summary: "Shifts of a doctor on a day and the free appointment slots in them", Tag: "roster", Params: []openapi.Param{{Name: "date", Description: "YYYY-MM-DD, default today"}}, Response: models.Availability{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/doctors/{id}/patients", Handler: GetDoctorPatientsHandler, Access: read, Summary: "Patients that have appointments with a doctor", Tag: "doctors", Response: []models.Patient{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/doctors/{id}/calendar.ics", Handler: DoctorCalendarHandler, Access: feed, Feed: feedDoctor, Summary: "Doctor schedule as iCalendar feed", Tag: "calendar", Response: "", ContentType: "text/calendar", Errors: []int{400, 404}},
+		{Method: "GET", Path: "/doctors/{id}/calendar-token", Handler: DoctorCalendarTokenHandler, Access: admin, Summary: "Get a calendar subscription URL for a doctor", Tag: "calendar", Response: map[string]string{}, Errors: []int{400}},
+
+		{Method: "GET", Path: "/appointments", Handler: GetAppointmentsHandler, Access: read, Summary: "Get all appointments", Tag: "appointments", Params: appointmentFilters, Response: []models.Appointment{}, Errors: []int{400, 403}},
+		{Method: "POST", Path: "/appointments", Handler: CreateAppointmentHandler, Access: read, Summary: "Create a new appointment; 409 when the doctor is on approved leave or, if rostered, has no shift then. policy_id records the insurance covering the day, 0 for self-pay", Tag: "appointments", Request: models.Appointment{}, Response: models.Appointment{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/appointments/export", Handler: ExportAppointmentsHandler, Access: read, Summary: "Export the filtered appointments list as CSV or XLSX", Tag: "appointments", Params: append([]openapi.Param{exportFormat}, appointmentFilters...), Response: "", ContentType: "text/csv", Errors: []int{400, 403}},
+		{Method: "POST", Path: "/appointments/import", Handler: ImportAppointmentsHandler, Access: read, Summary: "Import appointments from a CSV or XLSX file", Tag: "appointments", Params: importOptions, Upload: spreadsheetUpload, Response: models.ImportReport{}, Status: 201, AlsoStatus: []int{200, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "POST", Path: "/appointments/batch", Handler: CreateAppointmentsBatchHandler, Access: read, Summary: "Create appointments in bulk", Tag: "appointments", Params: batchMode, Request: []models.Appointment{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Idempotent: true, Errors: []int{400, 413}},
+		{Method: "PUT", Path: "/appointments/batch", Handler: UpdateAppointmentsBatchHandler, Access: read, Summary: "Update appointments in bulk", Tag: "appointments", Params: batchMode, Request: []models.Appointment{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "DELETE", Path: "/appointments/batch", Handler: DeleteAppointmentsBatchHandler, Access: read, Summary: "Soft-delete appointments in bulk", Tag: "appointments", Params: batchMode, Request: []models.BatchRef{}, Response: models.BatchResponse{}, AlsoStatus: []int{207, 422}, Errors: []int{400, 413}},
+		{Method: "GET", Path: "/appointments/{id}", Handler: GetAppointmentHandler, Access: read, Summary: "Get appointment by ID", Tag: "appointments", Response: models.Appointment{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/appointments/{id}", Handler: UpdateAppointmentHandler, Access: read, Summary: "Update appointment", Tag: "appointments", Request: models.Appointment{}, Response: models.Appointment{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "PATCH", Path: "/appointments/{id}", Handler: PatchAppointmentHandler, Access: read, Summary: "Partially update appointment", Tag: "appointments", Request: models.Appointment{}, Patch: true, Response: models.Appointment{}, Versioned: true, Errors: []int{400, 404, 409, 415, 422}},
+		{Method: "DELETE", Path: "/appointments/{id}", Handler: DeleteAppointmentHandler, Access: read, Summary: "Soft-delete appointment; restorable until purged", Tag: "appointments", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/appointments/{id}/restore", Handler: RestoreAppointmentHandler, Access: admin, Summary: "Restore a soft-deleted appointment", Tag: "appointments", Response: models.Appointment{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/appointments/{id}/prescriptions", Handler: GetAppointmentPrescriptionsHandler, Access: read, Summary: "Prescriptions written during an appointment", Tag: "prescriptions", Response: []models.Prescription{}, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/appointments/{id}/prescriptions", Handler: CreateAppointmentPrescriptionHandler, Access: read, Summary: "Prescribe a medication to the patient of an appointment; checked against allergies and current medications, warnings need an override_reason", Tag: "prescriptions", Request: models.Prescription{}, Response: models.Prescription{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409, 422}},
+		{Method: "POST", Path: "/appointments/{id}/prescriptions/check", Handler: CheckAppointmentPrescriptionHandler, Access: read, Summary: "Run the allergy and interaction check for a prescription without saving it", Tag: "prescriptions", Request: models.Prescription{}, Response: models.PrescriptionCheck{}, Errors: []int{400, 404}},
+
+		{Method: "GET", Path: "/patients/{id}/prescriptions", Handler: GetPatientPrescriptionsHandler, Access: read, Summary: "Prescription history of a patient, active ones first", Tag: "prescriptions", Params: []openapi.Param{{Name: "status", Description: strings.Join(prescriptionStatuses, ", ")}, withDeleted}, Response: []models.Prescription{}, Errors: []int{400, 403, 404}},
+		{Method: "GET", Path: "/patients/{id}/medications", Handler: GetPatientMedicationsHandler, Access: read, Summary: "Current medications of a patient: active prescriptions whose course has not ended", Tag: "prescriptions", Response: []models.Prescription{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/prescriptions/{id}", Handler: GetPrescriptionHandler, Access: read, Summary: "Get a prescription", Tag: "prescriptions", Response: models.Prescription{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/prescriptions/{id}/discontinue", Handler: DiscontinuePrescriptionHandler, Access: read, Summary: "Discontinue an active prescription with a reason", Tag: "prescriptions", Request: models.Discontinuation{}, Response: models.Prescription{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/prescriptions/{id}", Handler: DeletePrescriptionHandler, Access: read, Summary: "Soft-delete a prescription entered in error", Tag: "prescriptions", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/prescriptions/{id}/restore", Handler: RestorePrescriptionHandler, Access: admin, Summary: "Restore a soft-deleted prescription; a running course is checked again and its warnings need a new override_reason", Tag: "prescriptions", Request: RestorePrescriptionRequest{}, Response: models.Prescription{}, Versioned: true, Errors: []int{400, 404, 409, 422}},
+
+		{Method: "GET", Path: "/medications", Handler: GetMedicationsHandler, Access: read, Summary: "Medication catalog", Tag: "medications", Params: []openapi.Param{{Name: "q", Description: "Name substring or ATC code prefix"}}, Response: []models.Medication{}},
+		{Method: "POST", Path: "/medications", Handler: CreateMedicationHandler, Access: admin, Summary: "Add a medication to the catalog", Tag: "medications", Request: models.Medication{}, Response: models.Medication{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/medications/{id}", Handler: GetMedicationHandler, Access: read, Summary: "Get a medication", Tag: "medications", Response: models.Medication{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/medications/{id}", Handler: UpdateMedicationHandler, Access: admin, Summary: "Update a medication", Tag: "medications", Request: models.Medication{}, Response: models.Medication{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "GET", Path: "/lab-tests", Handler: GetLabTestsHandler, Access: read, Summary: "Laboratory catalog with units and reference ranges", Tag: "labs", Params: []openapi.Param{{Name: "panel", Description: "e.g. CBC"}}, Response: []models.LabTest{}},
+		{Method: "POST", Path: "/lab-tests", Handler: CreateLabTestHandler, Access: admin, Summary: "Add a test to the laboratory catalog", Tag: "labs", Request: models.LabTest{}, Response: models.LabTest{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/lab-tests/{id}", Handler: GetLabTestHandler, Access: read, Summary: "Get a lab test", Tag: "labs", Response: models.LabTest{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/lab-tests/{id}", Handler: UpdateLabTestHandler, Access: admin, Summary: "Update a lab test; results already ordered keep their ranges", Tag: "labs", Request: models.LabTest{}, Response: models.LabTest{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/appointments/{id}/lab-orders", Handler: GetAppointmentLabOrdersHandler, Access: read, Summary: "Lab orders placed during an appointment", Tag: "labs", Response: []models.LabOrder{}, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/appointments/{id}/lab-orders", Handler: CreateAppointmentLabOrderHandler, Access: read, Summary: "Order lab tests and panels for the patient of an appointment", Tag: "labs", Request: models.LabOrder{}, Response: models.LabOrder{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/lab-orders", Handler: GetPatientLabOrdersHandler, Access: read, Summary: "Lab orders of a patient with their results, newest first", Tag: "labs", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.LabOrderStatuses, ", ")}, withDeleted}, Response: []models.LabOrder{}, Errors: []int{400, 403, 404}},
+		{Method: "GET", Path: "/patients/{id}/lab-trends", Handler: GetPatientLabTrendsHandler, Access: read, Summary: "Results of a patient per analyte over time", Tag: "labs", Params: []openapi.Param{{Name: "code", Description: "Comma-separated test codes, e.g. HGB,GLU; all when empty"}}, Response: []models.LabTrend{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/lab-orders", Handler: GetLabOrdersHandler, Access: read, Summary: "Laboratory worklist; with ready_after, the orders whose results became ready after that ready_seq", Tag: "labs", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.LabOrderStatuses, ", ")}, {Name: "ready_after", Type: "integer", Description: "Last ready_seq seen; 0 to start"}, {Name: "limit", Type: "integer"}}, Response: []models.LabOrder{}, Errors: []int{400}},
+		{Method: "GET", Path: "/lab-orders/{id}", Handler: GetLabOrderHandler, Access: read, Summary: "Get a lab order with its results", Tag: "labs", Response: models.LabOrder{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/lab-orders/{id}/results", Handler: RecordLabResultsHandler, Access: read, Summary: "Enter or correct results by test code; values are flagged against the reference ranges", Tag: "labs", Request: []models.LabResultEntry{}, Response: models.LabOrder{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "DELETE", Path: "/lab-orders/{id}", Handler: DeleteLabOrderHandler, Access: read, Summary: "Soft-delete a lab order placed in error", Tag: "labs", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/lab-orders/{id}/restore", Handler: RestoreLabOrderHandler, Access: admin, Summary: "Restore a soft-deleted lab order", Tag: "labs", Response: models.LabOrder{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "POST", Path: "/patients/{id}/vitals", Handler: CreatePatientVitalsHandler, Access: read, Summary: "Record vital signs and compute the NEWS2 early-warning score; temperature_unit F is converted to °C", Tag: "vitals", Request: models.Vitals{}, Response: models.Vitals{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/vitals", Handler: GetPatientVitalsHandler, Access: read, Summary: "Vital signs of a patient in time order", Tag: "vitals", Params: []openapi.Param{{Name: "from", Description: "RFC 3339 timestamp, inclusive"}, {Name: "to", Description: "RFC 3339 timestamp, exclusive"}, withDeleted}, Response: []models.Vitals{}, Errors: []int{400, 403, 404}},
+		{Method: "GET", Path: "/appointments/{id}/vitals", Handler: GetAppointmentVitalsHandler, Access: read, Summary: "Vital signs taken during an appointment", Tag: "vitals", Response: []models.Vitals{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/vitals/alerts", Handler: GetVitalsAlertsHandler, Access: read, Summary: "Patients whose latest NEWS2 needs a clinical response, highest score first", Tag: "vitals", Params: []openapi.Param{{Name: "min_risk", Description: strings.Join(news2.Risks, ", ") + "; default low-medium"}, {Name: "hours", Type: "integer", Description: "Only vitals taken in the last hours, default 24"}}, Response: []models.VitalsAlert{}, Errors: []int{400}},
+		{Method: "GET", Path: "/vitals/{id}", Handler: GetVitalsHandler, Access: read, Summary: "Get a set of vital signs", Tag: "vitals", Response: models.Vitals{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "DELETE", Path: "/vitals/{id}", Handler: DeleteVitalsHandler, Access: read, Summary: "Soft-delete vital signs entered in error", Tag: "vitals", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/vitals/{id}/restore", Handler: RestoreVitalsHandler, Access: admin, Summary: "Restore soft-deleted vital signs", Tag: "vitals", Response: models.Vitals{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "POST", Path: "/appointments/{id}/notes", Handler: CreateAppointmentNoteHandler, Access: clinical, Summary: "Start a draft SOAP note for an appointment; the author is the current user", Tag: "notes", Request: models.ClinicalNote{}, Response: models.ClinicalNote{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/appointments/{id}/notes", Handler: GetAppointmentNotesHandler, Access: clinical, Summary: "Clinical notes of an appointment with their addenda", Tag: "notes", Response: []models.ClinicalNote{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/notes", Handler: GetPatientNotesHandler, Access: clinical, Summary: "Clinical notes of a patient, newest first", Tag: "notes", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.NoteStatuses, ", ")}, withDeleted}, Response: []models.ClinicalNote{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/notes/{id}", Handler: GetNoteHandler, Access: clinical, Summary: "Get a clinical note with its addenda", Tag: "notes", Response: models.ClinicalNote{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/notes/{id}", Handler: UpdateNoteHandler, Access: clinical, Summary: "Edit a draft note; only its author can, and signed notes are immutable", Tag: "notes", Request: models.ClinicalNote{}, Response: models.ClinicalNote{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/notes/{id}/sign", Handler: SignNoteHandler, Access: clinical, Summary: "Sign a draft note of the current user; it needs an assessment and a plan", Tag: "notes", Response: models.ClinicalNote{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/notes/{id}/addenda", Handler: AddNoteAddendumHandler, Access: clinical, Summary: "Add an addendum to a signed note", Tag: "notes", Request: models.NoteAddendum{}, Response: models.ClinicalNote{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/notes/{id}", Handler: DeleteNoteHandler, Access: clinical, Summary: "Soft-delete a draft note of the current user", Tag: "notes", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/notes/{id}/restore", Handler: RestoreNoteHandler, Access: admin, Summary: "Restore a soft-deleted note", Tag: "notes", Response: models.ClinicalNote{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "GET", Path: "/departments", Handler: GetDepartmentsHandler, Access: read, Summary: "Departments of the hospital", Tag: "departments", Response: []models.Department{}},
+		{Method: "POST", Path: "/departments", Handler: CreateDepartmentHandler, Access: admin, Summary: "Add a department", Tag: "departments", Request: models.Department{}, Response: models.Department{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/departments/tree", Handler: GetDepartmentTreeHandler, Access: read, Summary: "Departments nested under their parents", Tag: "departments", Response: []models.Department{}},
+		{Method: "GET", Path: "/departments/{id}", Handler: GetDepartmentHandler, Access: read, Summary: "Get a department", Tag: "departments", Response: models.Department{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/departments/{id}", Handler: UpdateDepartmentHandler, Access: admin, Summary: "Update a department; 409 when it would be nested under itself", Tag: "departments", Request: models.Department{}, Response: models.Department{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/departments/{id}/members", Handler: GetDepartmentMembersHandler, Access: read, Summary: "Staff of a department, the most senior first", Tag: "departments", Params: []openapi.Param{{Name: "role", Description: strings.Join(models.DepartmentRoles, ", ")}, {Name: "subdepartments", Type: "boolean", Description: "Also list the staff of the departments nested under it"}}, Response: []models.DepartmentMember{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/departments/{id}/head", Handler: GetDepartmentHeadHandler, Access: read, Summary: "Head of a department", Tag: "departments", Response: models.DepartmentMember{}, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/departments/{id}/members/{doctor_id}", Handler: SetDepartmentMemberHandler, Access: admin, Summary: "Put a doctor on the staff of a department or change the role; 409 for a second head", Tag: "departments", Request: models.DepartmentMember{}, Response: models.DepartmentMember{}, AlsoStatus: []int{201}, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/departments/{id}/members/{doctor_id}", Handler: RemoveDepartmentMemberHandler, Access: admin, Summary: "Take a doctor off the staff of a department", Tag: "departments", Response: map[string]string{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/specialties", Handler: GetSpecialtiesHandler, Access: read, Summary: "Specialty vocabulary for doctor specializations", Tag: "departments", Params: []openapi.Param{{Name: "q", Description: "Case-insensitive substring of the code, name or a synonym"}}, Response: []specialties.Specialty{}},
+		{Method: "GET", Path: "/wards", Handler: GetWardsHandler, Access: read, Summary: "Wards of the hospital", Tag: "wards", Params: []openapi.Param{{Name: "department_id", Type: "integer"}}, Response: []models.Ward{}, Errors: []int{400}},
+		{Method: "POST", Path: "/wards", Handler: CreateWardHandler, Access: admin, Summary: "Add a ward to a department", Tag: "wards", Request: models.Ward{}, Response: models.Ward{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/wards/occupancy", Handler: GetWardOccupancyHandler, Access: read, Summary: "Free, occupied and out-of-service beds per ward", Tag: "wards", Params: []openapi.Param{{Name: "department_id", Type: "integer"}}, Response: []models.WardOccupancy{}, Errors: []int{400}},
+		{Method: "GET", Path: "/wards/{id}", Handler: GetWardHandler, Access: read, Summary: "Get a ward with its rooms and beds", Tag: "wards", Response: models.Ward{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/wards/{id}", Handler: UpdateWardHandler, Access: admin, Summary: "Update a ward", Tag: "wards", Request: models.Ward{}, Response: models.Ward{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/wards/{id}/rooms", Handler: CreateWardRoomHandler, Access: admin, Summary: "Add a room to a ward", Tag: "wards", Request: models.Room{}, Response: models.Room{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "PUT", Path: "/rooms/{id}", Handler: UpdateRoomHandler, Access: admin, Summary: "Renumber a room", Tag: "wards", Request: models.Room{}, Response: models.Room{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/rooms/{id}/beds", Handler: CreateRoomBedHandler, Access: admin, Summary: "Add a bed to a room", Tag: "wards", Request: models.Bed{}, Response: models.Bed{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "PUT", Path: "/beds/{id}", Handler: UpdateBedHandler, Access: admin, Summary: "Relabel a bed or take it out of service; an occupied bed stays in service", Tag: "wards", Request: models.Bed{}, Response: models.Bed{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/beds", Handler: GetBedBoardHandler, Access: read, Summary: "Live bed board: every bed with its current occupant", Tag: "wards", Params: []openapi.Param{{Name: "ward_id", Type: "integer"}, {Name: "department_id", Type: "integer"}, {Name: "status", Description: strings.Join(models.BedStatuses, ", ")}}, Response: []models.BedStatus{}, Errors: []int{400}},
+
+		{Method: "POST", Path: "/patients/{id}/admissions", Handler: CreatePatientAdmissionHandler, Access: read, Summary: "Admit a patient into a free bed under an attending doctor", Tag: "admissions", Request: models.Admission{}, Response: models.Admission{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/admissions", Handler: GetPatientAdmissionsHandler, Access: read, Summary: "Inpatient stays of a patient, the current one first", Tag: "admissions", Params: []openapi.Param{withDeleted}, Response: []models.Admission{}, Errors: []int{400, 403, 404}},
+		{Method: "GET", Path: "/admissions", Handler: GetAdmissionsHandler, Access: read, Summary: "Inpatient census", Tag: "admissions", Params: []openapi.Param{{Name: "status", Description: strings.Join(models.AdmissionStatuses, ", ") + "; default admitted"}, {Name: "ward_id", Type: "integer"}, {Name: "department_id", Type: "integer"}}, Response: []models.Admission{}, Errors: []int{400}},
+		{Method: "GET", Path: "/admissions/{id}", Handler: GetAdmissionHandler, Access: read, Summary: "Get an admission with its bed movements", Tag: "admissions", Response: models.Admission{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/admissions/{id}/transfer", Handler: TransferAdmissionHandler, Access: read, Summary: "Move an admitted patient to another free bed, optionally to another attending doctor", Tag: "admissions", Request: models.Transfer{}, Response: models.Admission{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/admissions/{id}/discharge", Handler: DischargeAdmissionHandler, Access: read, Summary: "Discharge a patient and free the bed", Tag: "admissions", Request: models.Discharge{}, Response: models.Admission{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/admissions/{id}", Handler: DeleteAdmissionHandler, Access: read, Summary: "Soft-delete an admission entered in error", Tag: "admissions", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/admissions/{id}/restore", Handler: RestoreAdmissionHandler, Access: admin, Summary: "Restore a soft-deleted admission", Tag: "admissions", Response: models.Admission{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "GET", Path: "/shifts", Handler: GetShiftsHandler, Access: read, Summary: "Roster of duty and on-call shifts in a period", Tag: "roster", Params: append([]openapi.Param{{Name: "doctor_id", Type: "integer"}, {Name: "department_id", Type: "integer", Description: "Includes sub-departments"}, {Name: "kind", Description: strings.Join(models.ShiftKinds, " or ")}}, rosterPeriod...), Response: []models.Shift{}, Errors: []int{400}},
+		{Method: "POST", Path: "/shifts", Handler: CreateShiftHandler, Access: admin, Summary: "Roster a doctor; 409 when the doctor already works then or is on approved leave", Tag: "roster", Request: models.Shift{}, Response: models.Shift{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/shifts/{id}", Handler: GetShiftHandler, Access: read, Summary: "Get a shift", Tag: "roster", Response: models.Shift{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/shifts/{id}", Handler: UpdateShiftHandler, Access: admin, Summary: "Move or reassign a shift", Tag: "roster", Request: models.Shift{}, Response: models.Shift{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/shifts/{id}", Handler: DeleteShiftHandler, Access: admin, Summary: "Take a shift off the roster", Tag: "roster", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/on-call", Handler: GetOnCallHandler, Access: read, Summary: "Doctors on call at a moment, e.g. the cardiologist on call now", Tag: "roster", Params: onShiftParams, Response: []models.Shift{}, Errors: []int{400}},
+		{Method: "GET", Path: "/on-duty", Handler: GetOnDutyHandler, Access: read, Summary: "Doctors on a duty shift at a moment", Tag: "roster", Params: onShiftParams, Response: []models.Shift{}, Errors: []int{400}},
+		{Method: "GET", Path: "/rotations", Handler: GetRotationsHandler, Access: read, Summary: "On-call rotations", Tag: "roster", Response: []models.OnCallRotation{}},
+		{Method: "POST", Path: "/rotations", Handler: CreateRotationHandler, Access: admin, Summary: "Add an on-call rotation", Tag: "roster", Request: models.OnCallRotation{}, Response: models.OnCallRotation{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/rotations/{id}", Handler: GetRotationHandler, Access: read, Summary: "Get an on-call rotation", Tag: "roster", Response: models.OnCallRotation{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/rotations/{id}", Handler: UpdateRotationHandler, Access: admin, Summary: "Update an on-call rotation; shifts generated before are kept", Tag: "roster", Request: models.OnCallRotation{}, Response: models.OnCallRotation{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/rotations/{id}/generate", Handler: GenerateRotationHandler, Access: admin, Summary: "Roster the on-call turns of a rotation in a period; turns of doctors on leave or busy are skipped", Tag: "roster", Params: rosterPeriod, Response: models.RotationRun{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/leaves", Handler: GetLeavesHandler, Access: read, Summary: "Leave of doctors, the latest first", Tag: "roster", Params: []openapi.Param{{Name: "doctor_id", Type: "integer"}, {Name: "status", Description: strings.Join(models.LeaveStatuses, ", ")}}, Response: []models.Leave{}, Errors: []int{400}},
+		{Method: "POST", Path: "/leaves", Handler: CreateLeaveHandler, Access: read, Summary: "Request leave for a doctor", Tag: "roster", Request: models.Leave{}, Response: models.Leave{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400}},
+		{Method: "GET", Path: "/leaves/{id}", Handler: GetLeaveHandler, Access: read, Summary: "Get a leave", Tag: "roster", Response: models.Leave{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/leaves/{id}/approve", Handler: ApproveLeaveHandler, Access: admin, Summary: "Approve a leave request; 409 while shifts or booked appointments fall in it", Tag: "roster", Response: models.Leave{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/leaves/{id}/reject", Handler: RejectLeaveHandler, Access: admin, Summary: "Reject a leave request", Tag: "roster", Response: models.Leave{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/leaves/{id}/cancel", Handler: CancelLeaveHandler, Access: read, Summary: "Withdraw a requested or approved leave", Tag: "roster", Response: models.Leave{}, Versioned: true, Errors: []int{400, 404, 409}},
+
+		{Method: "GET", Path: "/services", Handler: GetServicesHandler, Access: read, Summary: "Priced services catalog", Tag: "billing", Params: []openapi.Param{{Name: "inactive", Type: "boolean", Description: "Also list withdrawn services"}}, Response: []models.Service{}, Errors: []int{400}},
+		{Method: "POST", Path: "/services", Handler: CreateServiceHandler, Access: admin, Summary: "Add a service to the catalog; prices are in minor units", Tag: "billing", Request: models.Service{}, Response: models.Service{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/services/{id}", Handler: GetServiceHandler, Access: read, Summary: "Get a service", Tag: "billing", Response: models.Service{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/services/{id}", Handler: UpdateServiceHandler, Access: admin, Summary: "Update a service; lines already invoiced keep their price", Tag: "billing", Request: models.Service{}, Response: models.Service{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/invoices", Handler: GetInvoicesHandler, Access: read, Summary: "Invoices without their lines, the latest first", Tag: "billing", Params: []openapi.Param{{Name: "patient_id", Type: "integer"}, {Name: "appointment_id", Type: "integer"}, {Name: "status", Description: strings.Join(models.InvoiceStatuses, ", ")}}, Response: []models.Invoice{}, Errors: []int{400}},
+		{Method: "POST", Path: "/invoices", Handler: CreateInvoiceHandler, Access: read, Summary: "Draft an invoice; completed appointments get one automatically", Tag: "billing", Request: models.Invoice{}, Response: models.Invoice{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/invoices/{id}", Handler: GetInvoiceHandler, Access: read, Summary: "Get an invoice with its lines and payments", Tag: "billing", Response: models.Invoice{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/invoices/{id}", Handler: UpdateInvoiceHandler, Access: read, Summary: "Set the discount, due date and notes of a draft", Tag: "billing", Request: models.Invoice{}, Response: models.Invoice{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/invoices/{id}/invoice.pdf", Handler: InvoicePDFHandler, Access: read, Summary: "Printable invoice", Tag: "billing", Response: "", ContentType: "application/pdf", Errors: []int{400, 404}},
+		{Method: "POST", Path: "/invoices/{id}/lines", Handler: AddInvoiceLineHandler, Access: read, Summary: "Add a line to a draft; a service_id prices it from the catalog", Tag: "billing", Request: models.InvoiceLine{}, Response: models.Invoice{}, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/invoices/{id}/lines/{line_id}", Handler: DeleteInvoiceLineHandler, Access: read, Summary: "Remove a line from a draft", Tag: "billing", Response: models.Invoice{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/invoices/{id}/issue", Handler: IssueInvoiceHandler, Access: read, Summary: "Number a draft and open it for payment", Tag: "billing", Response: models.Invoice{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/invoices/{id}/void", Handler: VoidInvoiceHandler, Access: admin, Summary: "Void an invoice nothing is paid on; its appointment can be invoiced again. Unsent claims on it are rejected; 409 while a payer has one", Tag: "billing", Response: models.Invoice{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/invoices/{id}/payments", Handler: CreatePaymentHandler, Access: read, Summary: "Record a full or partial payment; 409 over the balance", Tag: "billing", Request: models.Payment{}, Response: models.Invoice{}, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/invoices/{id}/refunds", Handler: CreateRefundHandler, Access: admin, Summary: "Refund money paid on an invoice; 409 over what was paid", Tag: "billing", Request: models.Payment{}, Response: models.Invoice{}, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/balance", Handler: GetPatientBalanceHandler, Access: read, Summary: "What a patient owes, with the unpaid invoices", Tag: "billing", Response: models.PatientBalance{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/billing/outstanding", Handler: GetOutstandingBalancesHandler, Access: read, Summary: "Patients who owe money, the largest balance first", Tag: "billing", Response: []models.PatientBalance{}},
+
+		{Method: "GET", Path: "/payers", Handler: GetPayersHandler, Access: read, Summary: "Insurance payers", Tag: "insurance", Params: []openapi.Param{{Name: "inactive", Type: "boolean", Description: "Also list inactive payers"}}, Response: []models.Payer{}, Errors: []int{400}},
+		{Method: "POST", Path: "/payers", Handler: CreatePayerHandler, Access: admin, Summary: "Add an insurance payer; the code identifies it in claim files", Tag: "insurance", Request: models.Payer{}, Response: models.Payer{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 409}},
+		{Method: "GET", Path: "/payers/{id}", Handler: GetPayerHandler, Access: read, Summary: "Get an insurance payer", Tag: "insurance", Response: models.Payer{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/payers/{id}", Handler: UpdatePayerHandler, Access: admin, Summary: "Update an insurance payer; policies of an inactive payer cover nothing", Tag: "insurance", Request: models.Payer{}, Response: models.Payer{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/patients/{id}/policies", Handler: GetPatientPoliciesHandler, Access: read, Summary: "Insurance policies of a patient, the latest first", Tag: "insurance", Params: []openapi.Param{withDeleted}, Response: []models.Policy{}, Errors: []int{400, 403, 404}},
+		{Method: "POST", Path: "/patients/{id}/policies", Handler: CreatePatientPolicyHandler, Access: read, Summary: "Insure a patient with a payer for a period", Tag: "insurance", Request: models.Policy{}, Response: models.Policy{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/patients/{id}/eligibility", Handler: GetEligibilityHandler, Access: read, Summary: "Whether a patient is insured on a day and by which policy", Tag: "insurance", Params: []openapi.Param{{Name: "date", Description: "YYYY-MM-DD, default today"}}, Response: models.Eligibility{}, Errors: []int{400, 404}},
+		{Method: "GET", Path: "/policies/{id}", Handler: GetPolicyHandler, Access: read, Summary: "Get an insurance policy", Tag: "insurance", Response: models.Policy{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "PUT", Path: "/policies/{id}", Handler: UpdatePolicyHandler, Access: read, Summary: "Update an insurance policy; booked appointments and claims made keep theirs", Tag: "insurance", Request: models.Policy{}, Response: models.Policy{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "DELETE", Path: "/policies/{id}", Handler: DeletePolicyHandler, Access: read, Summary: "Soft-delete a policy entered in error", Tag: "insurance", Response: map[string]string{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/policies/{id}/restore", Handler: RestorePolicyHandler, Access: admin, Summary: "Restore a soft-deleted policy", Tag: "insurance", Response: models.Policy{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/claims", Handler: GetClaimsHandler, Access: read, Summary: "Insurance claims without their lines, the latest first", Tag: "insurance", Params: []openapi.Param{{Name: "patient_id", Type: "integer"}, {Name: "payer_id", Type: "integer"}, {Name: "status", Description: strings.Join(models.ClaimStatuses, ", ")}}, Response: []models.Claim{}, Errors: []int{400}},
+		{Method: "GET", Path: "/claims/{id}", Handler: GetClaimHandler, Access: read, Summary: "Get a claim with its lines and status history", Tag: "insurance", Response: models.Claim{}, Versioned: true, Errors: []int{400, 404}},
+		{Method: "POST", Path: "/invoices/{id}/claim", Handler: CreateClaimHandler, Access: read, Summary: "Claim the payer's share of an issued invoice; 409 when not covered or already claimed", Tag: "insurance", Response: models.Claim{}, Status: 201, Versioned: true, Idempotent: true, Errors: []int{400, 404, 409}},
+		{Method: "POST", Path: "/claims/generate", Handler: GenerateClaimsHandler, Access: read, Summary: "Claim the invoices of the visits completed in a period; uncovered ones are skipped", Tag: "insurance", Params: []openapi.Param{{Name: "from", Description: "YYYY-MM-DD, default a week before to"}, {Name: "to", Description: "YYYY-MM-DD, default today"}}, Response: models.ClaimRun{}, Idempotent: true, Errors: []int{400}},
+		{Method: "POST", Path: "/claims/{id}/status", Handler: ChangeClaimStatusHandler, Access: read, Summary: "Record a claim submitted, accepted, rejected or paid; paid posts an insurance payment to the invoice and refunds the patient what they paid of the insured share", Tag: "insurance", Request: models.ClaimStatusChange{}, Response: models.Claim{}, Versioned: true, Errors: []int{400, 404, 409}},
+		{Method: "GET", Path: "/claims/export", Handler: ExportClaimsHandler, Access: read, Summary: "Claims of a payer as an X12 837P claim file", Tag: "insurance", Params: []openapi.Param{{Name: "payer_id", Type: "integer", Description: "Required"}, {Name: "status", Description: strings.Join(models.ClaimStatuses, ", ") + "; default ready"}}, Response: "", ContentType: claimfile.ContentType, Errors: []int{400}},
+		{Method: "POST", Path: "/claims/submit", Handler: SubmitClaimsHandler, Access: read, Summary: "Mark the ready claims of a payer submitted and download them as an X12 837P claim file", Tag: "insurance", Params: []openapi.Param{{Name: "payer_id", Type: "integer", Description: "Required"}}, Response: "", ContentType: claimfile.ContentType, Idempotent: true, Errors: []int{400}},
+
+		{Method: "GET", Path: "/search", Handler: SearchHandler, Access: read, Summary: "Ranked search across patients, doctors and appointments; tolerates typos and Cyrillic/Latin spelling", Tag: "search", Params: searchParams, Response: []models.SearchResult{}, Errors: []int{400}},
+
+		{Method: "GET", Path: "/icd10", Handler: SearchICD10Handler, Access: read, Summary: "Search ICD-10 codes by code prefix or description words", Tag: "problems", Params: []openapi.Param{{Name: "q", Required: true}, {Name: "limit", Type: "integer", Description: "1-100, default 20"}}, Response: []icd10.Code{}, Errors: []int{400}},
+		{Method: "GET", Path: "/icd10/{code}", Handler: GetICD10CodeHandler, Access: read, Summary: "Look up an ICD-10 code", Tag: "problems", Params: []openapi.Param{{Name: "code", In: "path", Description: "With or without the dot, e.g. J45.9 or J459"}}, Response: icd10.Code{}, Errors: []int{404}},
+
+		{Method: "GET", Path: "/hl7/messages", Handler: GetHL7MessagesHandler, Access: admin, Summary: "List received HL7 messages", Tag: "hl7", Params: []openapi.Param{{Name: "status"}, {Name: "limit", Type: "integer"}}, Response: []models.HL7Message{}, Errors: []int{400}},
+		{Method: "POST", Path: "/hl7/messages/{id}/replay", Handler: ReplayHL7MessageHandler, Access: admin, Summary: "Re-apply a logged HL7 message", Tag: "hl7", Response: models.HL7Message{}, Errors: []int{400, 404, 422}},
+	}
+}
+
+// Operations converts the route table for the OpenAPI generator
+func Operations() []openapi.Operation {
+	routes := Routes()
+	ops := make([]openapi.Operation, 0, len(routes))
+	for _, rt := range routes {
+		op := openapi.Operation{
+			Method:      rt.Method,
+			Path:        rt.Path,
+			Summary:     rt.Summary,
+			Tag:         rt.Tag,
+			Params:      rt.Params,
+			Request:     rt.Request,
+			Response:    rt.Response,
+			Status:      rt.Status,
+			ContentType: rt.ContentType,
+			Patch:       rt.Patch,
+			Upload:      rt.Upload,
+			AlsoStatus:  rt.AlsoStatus,
+			Errors:      rt.Errors,
+			Public:      rt.Access == public,
+			FeedToken:   rt.Access == feed,
+		}
+		if rt.Idempotent {
+			op.Params = append(op.Params, openapi.Param{Name: middleware.IdempotencyHeader, In: "header", Description: "Retries with the same key replay the first response instead of creating a duplicate"})
+			op.Errors = append(op.Errors, http.StatusConflict, http.StatusUnprocessableEntity)
+		}
+		if rt.Versioned {
+			op.ETag = rt.Method != http.MethodDelete
+			switch rt.Method {
+			case http.MethodGet:
+				op.Params = append(op.Params, openapi.Param{Name: "If-None-Match", In: "header", Description: "ETag from a previous response; 304 when unchanged"})
+				op.Errors = append(op.Errors, http.StatusNotModified)
+			case http.MethodPut, http.MethodPatch, http.MethodDelete:
+				op.Params = append(op.Params, openapi.Param{Name: "If-Match", In: "header", Required: true, Description: `ETag from a previous response, or "*"`})
+				op.Errors = append(op.Errors, http.StatusPreconditionFailed, http.StatusPreconditionRequired)
+			}
+		}
+		switch {
+		case rt.Access == admin:
+			op.Description = "Requires the admin role."
+			op.Errors = append(op.Errors, http.StatusForbidden)
+		case rt.Access == clinical:
+			op.Description = "Requires a clinical role."
+			op.Errors = append(op.Errors, http.StatusForbidden)
+		case rt.Access == read && rt.Method != http.MethodGet:
+			op.Description = "Not available to the reader role."
+			op.Errors = append(op.Errors, http.StatusForbidden)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
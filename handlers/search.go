@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/TeseySTD/GoHospitalApi/dedupe"
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/translit"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 10
+
+	// a word is highlighted when it starts with a query term or is this similar to one
+	highlightSimilarity = 0.45
+)
+
+var searchTypes = []string{storage.SearchPatient, storage.SearchDoctor, storage.SearchAppointment}
+
+// SearchHandler ranks patients, doctors and appointments matching ?q=.
+// Cyrillic and Latin spellings match each other and small typos are tolerated.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	terms := translit.Words(query.Get("q"))
+	if len(terms) == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "q must contain at least one letter or digit")
+		return
+	}
+	if len(terms) > maxSearchTerms {
+		utils.RespondError(w, http.StatusBadRequest, "q is limited to "+strconv.Itoa(maxSearchTerms)+" words")
+		return
+	}
+
+	types := searchTypes
+	if s := query.Get("type"); s != "" {
+		types = strings.Split(s, ",")
+		for _, t := range types {
+			if !slices.Contains(searchTypes, t) {
+				utils.RespondError(w, http.StatusBadRequest, "type must be a comma-separated list of "+strings.Join(searchTypes, ", "))
+				return
+			}
+		}
+	}
+	limit := defaultSearchLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			utils.RespondError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	// every term is a prefix so results show up while the user is still typing
+	prefixes := make([]string, len(terms))
+	for i, t := range terms {
+		prefixes[i] = t + ":*"
+	}
+
+	results, err := storage.Store.Search(r.Context(), strings.Join(terms, " "), strings.Join(prefixes, " & "), types, limit)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "search failed: "+err.Error())
+		return
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	for i := range results {
+		results[i].Highlights = highlight(results[i].Fields, terms)
+	}
+	utils.RespondJSON(w, http.StatusOK, results)
+}
+
+// highlight returns the fields containing a matched word, HTML-escaped and
+// with <mark> around the matched words
+func highlight(fields map[string]string, terms []string) map[string]string {
+	out := map[string]string{}
+	for name, value := range fields {
+		var b strings.Builder
+		matched := false
+		start := -1
+		flush := func(end int) {
+			word := value[start:end]
+			if matchesTerm(word, terms) {
+				matched = true
+				b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			start = -1
+		}
+		for i, r := range value {
+			inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’'
+			switch {
+			case inWord && start < 0:
+				start = i
+			case !inWord && start >= 0:
+				flush(i)
+			}
+			if !inWord {
+				b.WriteString(html.EscapeString(string(r)))
+			}
+		}
+		if start >= 0 {
+			flush(len(value))
+		}
+		if matched {
+			out[name] = b.String()
+		}
+	}
+	return out
+}
+
+func matchesTerm(word string, terms []string) bool {
+	folded := translit.Fold(word)
+	for _, t := range terms {
+		if strings.HasPrefix(folded, t) || dedupe.Similarity(folded, t) >= highlightSimilarity {
+			return true
+		}
+	}
+	return false
+}
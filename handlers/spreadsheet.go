@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+	"github.com/TeseySTD/GoHospitalApi/xlsx"
+)
+
+const (
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+
+	maxImportSize = 32 << 20
+)
+
+// Duplicate handling for imports
+const (
+	duplicatesSkip  = "skip"
+	duplicatesFail  = "fail"
+	duplicatesAllow = "allow"
+)
+
+// sheetSpec connects an entity to spreadsheet export and import. Columns are
+// the json names of the model fields.
+type sheetSpec[T any] struct {
+	name      string // file and sheet name
+	load      func(ctx context.Context) ([]T, error)
+	filter    func(items []T, query url.Values) []T
+	create    func(ctx context.Context, items []*T, atomic bool) ([]error, error)
+	key       func(*T) string // rows with equal keys are duplicates
+	id        func(*T) int
+	normalize func(field, value string) string // optional, e.g. spreadsheet dates
+}
+
+type sheetField struct {
+	name  string
+	index int
+}
+
+// sheetColumn is a file column matched to a model field
+type sheetColumn struct {
+	col int
+	sheetField
+}
+
+// sheetFields lists the json fields of T in declaration order
+func sheetFields[T any]() []sheetField {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	var out []sheetField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if !f.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		out = append(out, sheetField{name: name, index: i})
+	}
+	return out
+}
+
+// exportSheet streams the filtered list as CSV (default) or XLSX (?format=xlsx)
+func exportSheet[T any](w http.ResponseWriter, r *http.Request, spec sheetSpec[T]) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatXLSX {
+		utils.RespondError(w, http.StatusBadRequest, "format must be csv or xlsx")
+		return
+	}
+
+	items, err := spec.load(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch "+spec.name+": "+err.Error())
+		return
+	}
+	items = spec.filter(items, query)
+
+	fields := sheetFields[T]()
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	filename := fmt.Sprintf("%s-%s.%s", spec.name, time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for i := range items {
+			v := reflect.ValueOf(&items[i]).Elem()
+			row := make([]string, len(fields))
+			for j, f := range fields {
+				if cell := cellValue(v.Field(f.index)); cell != nil {
+					row[j] = fmt.Sprint(cell)
+				}
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", xlsx.ContentType)
+	xw, err := xlsx.NewWriter(w, spec.name)
+	if err != nil {
+		return
+	}
+	headerCells := make([]any, len(header))
+	for i, h := range header {
+		headerCells[i] = h
+	}
+	xw.WriteRow(headerCells)
+	for i := range items {
+		v := reflect.ValueOf(&items[i]).Elem()
+		row := make([]any, len(fields))
+		for j, f := range fields {
+			row[j] = cellValue(v.Field(f.index))
+		}
+		if err := xw.WriteRow(row); err != nil {
+			return
+		}
+	}
+	xw.Close()
+}
+
+func cellValue(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return escapeFormula(v.String())
+	default:
+		return escapeFormula(fmt.Sprint(v.Interface()))
+	}
+}
+
+// escapeFormula quotes text a spreadsheet would run as a formula; import
+// drops the quote again
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeFormula undoes escapeFormula
+func unescapeFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// importSheet creates rows from an uploaded CSV or XLSX file. The first row
+// holds column headers; ?map=field:Header renames columns. With
+// ?dry_run=true only the validation report is returned. Duplicates (within
+// the file or of existing rows) are skipped by default, rejected with
+// ?duplicates=fail or imported with ?duplicates=allow. Nothing is written
+// unless every remaining row is valid.
+func importSheet[T any, PT interface {
+	*T
+	validator
+}](w http.ResponseWriter, r *http.Request, spec sheetSpec[T]) {
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+	duplicates := query.Get("duplicates")
+	if duplicates == "" {
+		duplicates = duplicatesSkip
+	}
+	if duplicates != duplicatesSkip && duplicates != duplicatesFail && duplicates != duplicatesAllow {
+		utils.RespondError(w, http.StatusBadRequest, "duplicates must be skip, fail or allow")
+		return
+	}
+
+	rows, err := readSheet(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "file too large")
+			return
+		}
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(rows) < 2 {
+		utils.RespondError(w, http.StatusBadRequest, "file must have a header row and at least one data row")
+		return
+	}
+
+	report := models.ImportReport{DryRun: dryRun, Columns: map[string]string{}}
+	columns, err := mapColumns[T](rows[0], query["map"], &report)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := spec.load(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch "+spec.name+": "+err.Error())
+		return
+	}
+	known := map[string]string{}
+	for i := range existing {
+		known[spec.key(&existing[i])] = fmt.Sprintf("%s %d", strings.TrimSuffix(spec.name, "s"), spec.id(&existing[i]))
+	}
+
+	var items []*T
+	var itemRows []int // index into report.Rows for every item
+	for n, cells := range rows[1:] {
+		if blankRow(cells) {
+			continue
+		}
+		row := models.ImportRow{Row: n + 2}
+		item := new(T)
+		v := reflect.ValueOf(item).Elem()
+		for _, f := range columns {
+			if f.col >= len(cells) {
+				continue
+			}
+			value := unescapeFormula(strings.TrimSpace(cells[f.col]))
+			if spec.normalize != nil {
+				value = spec.normalize(f.name, value)
+			}
+			if err := setCell(v.Field(f.index), value); err != nil {
+				row.Errors = append(row.Errors, f.name+": "+err.Error())
+			}
+		}
+		if len(row.Errors) == 0 {
+			if err := PT(item).Validate(); err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+
+		report.Total++
+		switch {
+		case len(row.Errors) > 0:
+			row.Status = models.ImportInvalid
+			report.Invalid++
+		default:
+			key := spec.key(item)
+			if dup, ok := known[key]; ok {
+				row.DuplicateOf = dup
+			} else {
+				known[key] = fmt.Sprintf("row %d", row.Row)
+			}
+			if row.DuplicateOf != "" {
+				report.Duplicates++
+				if duplicates == duplicatesSkip {
+					row.Status = models.ImportSkipped
+					break
+				}
+				if duplicates == duplicatesFail {
+					row.Status = models.ImportDuplicate
+					break
+				}
+			}
+			row.Status = models.ImportValid
+			report.Valid++
+			items = append(items, item)
+			itemRows = append(itemRows, len(report.Rows))
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	rejected := report.Invalid > 0 || (duplicates == duplicatesFail && report.Duplicates > 0)
+	switch {
+	case dryRun:
+		utils.RespondJSON(w, http.StatusOK, report)
+		return
+	case rejected:
+		utils.RespondJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	case len(items) == 0:
+		utils.RespondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	errs, err := spec.create(r.Context(), items, true)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "import failed: "+err.Error())
+		return
+	}
+	failed := false
+	for i, item := range items {
+		row := &report.Rows[itemRows[i]]
+		if errs[i] != nil {
+			failed = true
+			row.Status = models.ImportInvalid
+			row.Errors = append(row.Errors, errs[i].Error())
+			continue
+		}
+		row.Status = models.ImportCreated
+		row.ID = spec.id(item)
+		report.Created++
+	}
+	if failed {
+		report.Created = 0
+		utils.RespondJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, report)
+}
+
+// readOnlyColumns are exported but never imported
+var readOnlyColumns = map[string]bool{"id": true, "version": true, "mrn": true, "deleted_at": true, "deleted_by": true}
+
+// mapColumns matches header cells to model fields
+func mapColumns[T any](header []string, mapping []string, report *models.ImportReport) ([]sheetColumn, error) {
+	fields := map[string]sheetField{}
+	for _, f := range sheetFields[T]() {
+		if !readOnlyColumns[f.name] {
+			fields[f.name] = f
+		}
+	}
+
+	wanted := map[string]string{} // normalized header -> field
+	for name := range fields {
+		wanted[headerKey(name)] = name
+	}
+	for _, m := range mapping {
+		field, column, ok := strings.Cut(m, ":")
+		if !ok {
+			return nil, fmt.Errorf("map %q must be field:Column", m)
+		}
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("map %q: unknown field %q", m, field)
+		}
+		for k, v := range wanted {
+			if v == field {
+				delete(wanted, k)
+			}
+		}
+		wanted[headerKey(column)] = field
+	}
+
+	var columns []sheetColumn
+	for i, h := range header {
+		field, ok := wanted[headerKey(h)]
+		if !ok {
+			if strings.TrimSpace(h) != "" {
+				report.Ignored = append(report.Ignored, h)
+			}
+			continue
+		}
+		if prev, dup := report.Columns[field]; dup {
+			return nil, fmt.Errorf("columns %q and %q both map to %s", prev, h, field)
+		}
+		columns = append(columns, sheetColumn{col: i, sheetField: fields[field]})
+		report.Columns[field] = h
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("no column matches a known field; use map=field:Column")
+	}
+	return columns, nil
+}
+
+// headerKey compares headers case-insensitively and treats spaces and dashes like underscores
+func headerKey(h string) string {
+	h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+}
+
+func blankRow(cells []string) bool {
+	for _, c := range cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func setCell(v reflect.Value, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			// spreadsheets often store whole numbers as 36.0
+			f, ferr := strconv.ParseFloat(value, 64)
+			if ferr != nil || f != math.Trunc(f) {
+				return fmt.Errorf("%q is not a whole number", value)
+			}
+			n = int64(f)
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot be imported")
+	}
+	return nil
+}
+
+// readSheet reads a CSV or XLSX file sent as the raw body or as the "file"
+// field of a multipart form
+func readSheet(w http.ResponseWriter, r *http.Request) ([][]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var data []byte
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart upload must have a \"file\" field: %w", err)
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	// xlsx files are zip archives
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return xlsx.ReadRows(bytes.NewReader(data), int64(len(data)))
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	// spreadsheets in many locales save CSV with semicolons
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		cr.Comma = ';'
+	}
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	return rows, nil
+}
+
+// spreadsheetDate turns spreadsheet serial numbers in date, date of birth and time columns into text
+func spreadsheetDate(field, value string) string {
+	t, ok := xlsx.SerialTime(value)
+	if !ok {
+		return value
+	}
+	switch field {
+	case "date", "date_of_birth":
+		return t.Format("2006-01-02")
+	case "time":
+		return t.Format("15:04:05")
+	}
+	return value
+}
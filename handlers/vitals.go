@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/news2"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// timeParam parses an optional RFC 3339 query parameter; the zero time means absent
+func timeParam(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp such as 2024-05-01T08:00:00Z")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// prepareVitals converts the temperature to °C, defaults the time taken,
+// validates the signs and computes NEWS2
+func prepareVitals(v *models.Vitals) error {
+	switch strings.ToUpper(strings.TrimSpace(v.TemperatureUnit)) {
+	case "", "C":
+	case "F":
+		if v.Temperature != nil {
+			c := math.Round((*v.Temperature-32)*5/9*10) / 10
+			v.Temperature = &c
+		}
+	default:
+		return errors.New("temperature_unit must be C or F")
+	}
+	v.TemperatureUnit = ""
+	v.Consciousness = strings.ToLower(strings.TrimSpace(v.Consciousness))
+	v.Notes = strings.TrimSpace(v.Notes)
+	if v.TakenAt.IsZero() {
+		v.TakenAt = time.Now()
+	}
+	if err := v.Validate(); err != nil {
+		return err
+	}
+	v.NEWS2 = news2.Score(v.Observation())
+	return nil
+}
+
+func respondVitals(w http.ResponseWriter, vitals []models.Vitals, err error) {
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch vitals: "+err.Error())
+		return
+	}
+	if vitals == nil {
+		vitals = []models.Vitals{}
+	}
+	utils.RespondJSON(w, http.StatusOK, vitals)
+}
+
+// CreatePatientVitalsHandler records a set of vital signs and scores it; an
+// appointment_id links it to an appointment of the same patient
+func CreatePatientVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var vitals models.Vitals
+	if err := json.NewDecoder(r.Body).Decode(&vitals); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareVitals(&vitals); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	vitals.PatientID = id
+	vitals.RecordedBy = currentUser(r)
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	if vitals.AppointmentID != 0 {
+		appointment, err := storage.Store.GetAppointmentByID(ctx, vitals.AppointmentID)
+		if err != nil {
+			respondReferenceError(w, err, "appointment_id does not exist", "failed to fetch appointment: ")
+			return
+		}
+		if appointment.PatientID != id {
+			utils.RespondError(w, http.StatusBadRequest, "appointment_id belongs to another patient")
+			return
+		}
+	}
+
+	created, err := storage.Store.CreateVitals(ctx, &vitals)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to record vitals: "+err.Error())
+		return
+	}
+	utils.SetETag(w, created.Version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+// GetPatientVitalsHandler returns the vital signs of a patient as a time
+// series, optionally between ?from= and ?to=
+func GetPatientVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, ok := timeParam(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := timeParam(w, r, "to")
+	if !ok {
+		return
+	}
+	include, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.Store.GetPatientByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Patient not found", "failed to fetch patient: ")
+		return
+	}
+	vitals, err := storage.Store.GetPatientVitals(ctx, id, from, to, include)
+	respondVitals(w, vitals, err)
+}
+
+func GetAppointmentVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := storage.Store.GetAppointmentByID(ctx, id); err != nil {
+		respondLookupError(w, err, "Appointment not found", "failed to fetch appointment: ")
+		return
+	}
+	vitals, err := storage.Store.GetAppointmentVitals(ctx, id)
+	respondVitals(w, vitals, err)
+}
+
+func GetVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vitals, err := storage.Store.GetVitals(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Vitals not found", "failed to fetch vitals: ")
+		return
+	}
+	if utils.NotModified(w, r, vitals.Version) {
+		return
+	}
+	utils.SetETag(w, vitals.Version)
+	utils.RespondJSON(w, http.StatusOK, vitals)
+}
+
+func DeleteVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := storage.Store.DeleteVitals(r.Context(), id, version, currentUser(r)); err != nil {
+		respondWriteError(w, err, "Vitals not found", "delete failed: ")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "Vitals deleted"})
+}
+
+// RestoreVitalsHandler brings back a soft-deleted set of vital signs; its patient must not be deleted
+func RestoreVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vitals, err := storage.Store.RestoreVitals(r.Context(), id)
+	if err != nil {
+		respondWriteError(w, err, "Vitals not found", "restore failed: ")
+		return
+	}
+	utils.SetETag(w, vitals.Version)
+	utils.RespondJSON(w, http.StatusOK, vitals)
+}
+
+// GetVitalsAlertsHandler lists patients whose latest vital signs from the last
+// ?hours= (default 24) score at least ?min_risk= (default low-medium)
+func GetVitalsAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minRisk := query.Get("min_risk")
+	if minRisk == "" {
+		minRisk = news2.RiskLowMedium
+	}
+	i := slices.Index(news2.Risks, minRisk)
+	if i < 0 {
+		utils.RespondError(w, http.StatusBadRequest, "min_risk must be one of "+strings.Join(news2.Risks, ", "))
+		return
+	}
+	hours := 24
+	if s := query.Get("hours"); s != "" {
+		h, err := strconv.Atoi(s)
+		if err != nil || h <= 0 {
+			utils.RespondError(w, http.StatusBadRequest, "hours must be a positive integer")
+			return
+		}
+		hours = h
+	}
+
+	alerts, err := storage.Store.GetVitalsAlerts(r.Context(), time.Now().Add(-time.Duration(hours)*time.Hour), news2.Risks[i:])
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch vitals alerts: "+err.Error())
+		return
+	}
+	if alerts == nil {
+		alerts = []models.VitalsAlert{}
+	}
+	utils.RespondJSON(w, http.StatusOK, alerts)
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+// idParam parses an optional id query parameter; 0 means absent
+func idParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		utils.RespondError(w, http.StatusBadRequest, name+" must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// respondCreated answers a catalog insert, mapping a taken code or name to 409
+func respondCreated(w http.ResponseWriter, err error, version int, created any, prefix string) {
+	if errors.Is(err, storage.ErrDuplicate) {
+		utils.RespondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, prefix+err.Error())
+		return
+	}
+	utils.SetETag(w, version)
+	utils.RespondJSON(w, http.StatusCreated, created)
+}
+
+// prepareDepartment trims the name and upper-cases the code
+func prepareDepartment(d *models.Department) error {
+	d.Code = strings.ToUpper(strings.TrimSpace(d.Code))
+	d.Name = strings.TrimSpace(d.Name)
+	d.Children = nil
+	return d.Validate()
+}
+
+func GetDepartmentsHandler(w http.ResponseWriter, r *http.Request) {
+	departments, err := storage.Store.GetDepartments(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch departments: "+err.Error())
+		return
+	}
+	if departments == nil {
+		departments = []models.Department{}
+	}
+	utils.RespondJSON(w, http.StatusOK, departments)
+}
+
+func GetDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	department, err := storage.Store.GetDepartment(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Department not found", "failed to fetch department: ")
+		return
+	}
+	if utils.NotModified(w, r, department.Version) {
+		return
+	}
+	utils.SetETag(w, department.Version)
+	utils.RespondJSON(w, http.StatusOK, department)
+}
+
+func CreateDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	var department models.Department
+	if err := json.NewDecoder(r.Body).Decode(&department); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareDepartment(&department); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkParentDepartment(w, r, department.ParentID) {
+		return
+	}
+
+	created, err := storage.Store.CreateDepartment(r.Context(), &department)
+	respondCreated(w, err, department.Version, created, "failed to create department: ")
+}
+
+func UpdateDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updated models.Department
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := prepareDepartment(&updated); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated.ID, updated.Version = id, version
+	if !checkParentDepartment(w, r, updated.ParentID) {
+		return
+	}
+
+	if err := storage.Store.UpdateDepartment(r.Context(), &updated); err != nil {
+		respondWriteError(w, err, "Department not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, updated.Version)
+	utils.RespondJSON(w, http.StatusOK, updated)
+}
+
+// readWard decodes and validates a ward; its department must exist
+func readWard(w http.ResponseWriter, r *http.Request) (*models.Ward, bool) {
+	var ward models.Ward
+	if err := json.NewDecoder(r.Body).Decode(&ward); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	ward.Code = strings.ToUpper(strings.TrimSpace(ward.Code))
+	ward.Name = strings.TrimSpace(ward.Name)
+	ward.Rooms = nil
+	if err := ward.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if _, err := storage.Store.GetDepartment(r.Context(), ward.DepartmentID); err != nil {
+		respondReferenceError(w, err, "department_id does not exist", "failed to fetch department: ")
+		return nil, false
+	}
+	return &ward, true
+}
+
+// GetWardsHandler lists the wards, optionally of one ?department_id=
+func GetWardsHandler(w http.ResponseWriter, r *http.Request) {
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+
+	wards, err := storage.Store.GetWards(r.Context(), departmentID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch wards: "+err.Error())
+		return
+	}
+	if wards == nil {
+		wards = []models.Ward{}
+	}
+	utils.RespondJSON(w, http.StatusOK, wards)
+}
+
+// GetWardHandler returns a ward with its rooms and beds
+func GetWardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ward, err := storage.Store.GetWard(r.Context(), id)
+	if err != nil {
+		respondLookupError(w, err, "Ward not found", "failed to fetch ward: ")
+		return
+	}
+	if utils.NotModified(w, r, ward.Version) {
+		return
+	}
+	utils.SetETag(w, ward.Version)
+	utils.RespondJSON(w, http.StatusOK, ward)
+}
+
+func CreateWardHandler(w http.ResponseWriter, r *http.Request) {
+	ward, ok := readWard(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := storage.Store.CreateWard(r.Context(), ward)
+	respondCreated(w, err, ward.Version, created, "failed to create ward: ")
+}
+
+func UpdateWardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	ward, ok := readWard(w, r)
+	if !ok {
+		return
+	}
+	ward.ID, ward.Version = id, version
+
+	if err := storage.Store.UpdateWard(r.Context(), ward); err != nil {
+		respondWriteError(w, err, "Ward not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, ward.Version)
+	utils.RespondJSON(w, http.StatusOK, ward)
+}
+
+// readRoom decodes and validates the number of a room
+func readRoom(w http.ResponseWriter, r *http.Request) (*models.Room, bool) {
+	var room models.Room
+	if err := json.NewDecoder(r.Body).Decode(&room); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	room.Number = strings.TrimSpace(room.Number)
+	room.Beds = nil
+	if err := room.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &room, true
+}
+
+// CreateWardRoomHandler adds a room to the ward in the path
+func CreateWardRoomHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	room, ok := readRoom(w, r)
+	if !ok {
+		return
+	}
+	room.WardID = id
+
+	if _, err := storage.Store.GetWard(ctx, id); err != nil {
+		respondLookupError(w, err, "Ward not found", "failed to fetch ward: ")
+		return
+	}
+	created, err := storage.Store.CreateRoom(ctx, room)
+	respondCreated(w, err, room.Version, created, "failed to create room: ")
+}
+
+func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	room, ok := readRoom(w, r)
+	if !ok {
+		return
+	}
+	room.ID, room.Version = id, version
+
+	if err := storage.Store.UpdateRoom(r.Context(), room); err != nil {
+		respondWriteError(w, err, "Room not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, room.Version)
+	utils.RespondJSON(w, http.StatusOK, room)
+}
+
+// readBed decodes and validates a bed; in_service defaults to true
+func readBed(w http.ResponseWriter, r *http.Request) (*models.Bed, bool) {
+	bed := models.Bed{InService: true}
+	if err := json.NewDecoder(r.Body).Decode(&bed); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	bed.Label = strings.TrimSpace(bed.Label)
+	if err := bed.Validate(); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &bed, true
+}
+
+// CreateRoomBedHandler adds a bed to the room in the path
+func CreateRoomBedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bed, ok := readBed(w, r)
+	if !ok {
+		return
+	}
+	bed.RoomID = id
+
+	if _, err := storage.Store.GetRoom(ctx, id); err != nil {
+		respondLookupError(w, err, "Room not found", "failed to fetch room: ")
+		return
+	}
+	created, err := storage.Store.CreateBed(ctx, bed)
+	respondCreated(w, err, bed.Version, created, "failed to create bed: ")
+}
+
+// UpdateBedHandler relabels a bed or takes it in or out of service; an occupied bed cannot be closed
+func UpdateBedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.PathID(r, "id")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := utils.IfMatch(w, r)
+	if !ok {
+		return
+	}
+	bed, ok := readBed(w, r)
+	if !ok {
+		return
+	}
+	bed.ID, bed.Version = id, version
+
+	if err := storage.Store.UpdateBed(r.Context(), bed); err != nil {
+		respondWriteError(w, err, "Bed not found", "update failed: ")
+		return
+	}
+	utils.SetETag(w, bed.Version)
+	utils.RespondJSON(w, http.StatusOK, bed)
+}
+
+// GetBedBoardHandler is the live occupancy board: every bed with its
+// occupant, optionally by ?ward_id=, ?department_id= and ?status=
+func GetBedBoardHandler(w http.ResponseWriter, r *http.Request) {
+	wardID, ok := idParam(w, r, "ward_id")
+	if !ok {
+		return
+	}
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.BedStatuses, status) {
+		utils.RespondError(w, http.StatusBadRequest, "status must be one of "+strings.Join(models.BedStatuses, ", "))
+		return
+	}
+
+	beds, err := storage.Store.GetBedBoard(r.Context(), wardID, departmentID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch beds: "+err.Error())
+		return
+	}
+	out := []models.BedStatus{}
+	for _, b := range beds {
+		if status == "" || b.Status == status {
+			out = append(out, b)
+		}
+	}
+	utils.RespondJSON(w, http.StatusOK, out)
+}
+
+// GetWardOccupancyHandler counts free, occupied and closed beds per ward, optionally of one ?department_id=
+func GetWardOccupancyHandler(w http.ResponseWriter, r *http.Request) {
+	departmentID, ok := idParam(w, r, "department_id")
+	if !ok {
+		return
+	}
+
+	occupancy, err := storage.Store.GetWardOccupancy(r.Context(), departmentID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "failed to fetch occupancy: "+err.Error())
+		return
+	}
+	if occupancy == nil {
+		occupancy = []models.WardOccupancy{}
+	}
+	utils.RespondJSON(w, http.StatusOK, occupancy)
+}
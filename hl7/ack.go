@@ -0,0 +1,54 @@
+package hl7
+
+import (
+	"strings"
+	"time"
+)
+
+// Acknowledgment codes (MSA-1)
+const (
+	AckAccept = "AA"
+	AckError  = "AE"
+	AckReject = "AR"
+)
+
+// BuildACK creates an acknowledgment for msg. Sender and receiver are swapped
+// so the reply goes back to the originating application.
+func BuildACK(msg *Message, code, text string) string {
+	fs := string(msg.fieldSep)
+	cs := string(msg.componentSep)
+
+	msh := []string{
+		"MSH",
+		string(msg.componentSep) + string(msg.repeatSep) + string(msg.escapeChar) + string(msg.subSep),
+		msg.Field("MSH", 5),
+		msg.Field("MSH", 6),
+		msg.Field("MSH", 3),
+		msg.Field("MSH", 4),
+		time.Now().Format("20060102150405"),
+		"",
+		"ACK" + cs + msg.Component("MSH", 9, 2) + cs + "ACK",
+		"ACK" + msg.ControlID(),
+		msg.Field("MSH", 11),
+		msg.Field("MSH", 12),
+	}
+	msa := []string{"MSA", code, msg.ControlID()}
+	if text != "" {
+		msa = append(msa, msg.escape(text))
+	}
+
+	segments := []string{strings.Join(msh, fs), strings.Join(msa, fs)}
+	if code != AckAccept && text != "" {
+		// ERR-3 error code 207 = application internal error, ERR-4 severity E
+		segments = append(segments, strings.Join([]string{"ERR", "", "", "207", "E", "", "", "", msg.escape(text)}, fs))
+	}
+	return strings.Join(segments, "\r") + "\r"
+}
+
+// BuildRawReject is used when the payload could not be parsed at all, so there
+// is no header to echo back.
+func BuildRawReject(text string) string {
+	msg := &Message{fieldSep: '|', componentSep: '^', repeatSep: '~', escapeChar: '\\', subSep: '&'}
+	msg.Segments = []Segment{{Fields: []string{"MSH", "|", "^~\\&", "", "", "", "", "", "", "ACK"}}}
+	return BuildACK(msg, AckReject, text)
+}
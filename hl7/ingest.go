@@ -0,0 +1,311 @@
+package hl7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/storage"
+)
+
+// Message log statuses
+const (
+	StatusReceived  = "received"
+	StatusProcessed = "processed"
+	StatusFailed    = "failed"
+	StatusRejected  = "rejected"
+)
+
+var errUnsupported = errors.New("unsupported message type")
+
+// Ingestor applies ADT and SIU messages from the legacy registration system to storage
+type Ingestor struct {
+	store *storage.Storage
+	now   func() time.Time
+}
+
+func NewIngestor(store *storage.Storage) *Ingestor {
+	return &Ingestor{store: store, now: time.Now}
+}
+
+// Handle is an MLLP Handler: it logs the raw message, applies it and builds the ACK
+func (in *Ingestor) Handle(ctx context.Context, raw string) string {
+	msg, err := Parse(raw)
+	if err != nil {
+		in.log(ctx, &models.HL7Message{Raw: raw, Status: StatusRejected, Error: err.Error()})
+		return BuildRawReject(err.Error())
+	}
+
+	entry := in.log(ctx, &models.HL7Message{
+		ControlID:   msg.ControlID(),
+		MessageType: msg.Type(),
+		Raw:         raw,
+		Status:      StatusReceived,
+	})
+
+	err = in.Apply(ctx, msg)
+	status, code, text := StatusProcessed, AckAccept, ""
+	switch {
+	case errors.Is(err, errUnsupported):
+		status, code, text = StatusRejected, AckReject, err.Error()
+	case err != nil:
+		status, code, text = StatusFailed, AckError, err.Error()
+	}
+
+	if entry != nil {
+		if err := in.store.SetHL7MessageStatus(ctx, entry.ID, status, text); err != nil {
+			log.Printf("hl7: failed to update message %d status: %v", entry.ID, err)
+		}
+	}
+	return BuildACK(msg, code, text)
+}
+
+// Replay re-applies a previously logged message and records the new outcome
+func (in *Ingestor) Replay(ctx context.Context, id int) (*models.HL7Message, error) {
+	entry, err := in.store.GetHL7MessageByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := Parse(entry.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Status, entry.Error = StatusProcessed, ""
+	if err := in.Apply(ctx, msg); err != nil {
+		entry.Status, entry.Error = StatusFailed, err.Error()
+		if errors.Is(err, errUnsupported) {
+			entry.Status = StatusRejected
+		}
+	}
+	if err := in.store.SetHL7MessageStatus(ctx, entry.ID, entry.Status, entry.Error); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Apply dispatches a parsed message by type. All writes of a message are made
+// in one transaction, so a message that fails leaves nothing behind.
+func (in *Ingestor) Apply(ctx context.Context, msg *Message) error {
+	return in.store.InTx(ctx, func(st *storage.Storage) error {
+		tx := &Ingestor{store: st, now: in.now}
+		switch msg.Type() {
+		case "ADT^A04", "ADT^A08":
+			_, err := tx.upsertPatient(ctx, msg)
+			return err
+		case "SIU^S12":
+			return tx.createAppointment(ctx, msg)
+		default:
+			return fmt.Errorf("%w: %s", errUnsupported, msg.Type())
+		}
+	})
+}
+
+func (in *Ingestor) log(ctx context.Context, m *models.HL7Message) *models.HL7Message {
+	entry, err := in.store.LogHL7Message(ctx, m)
+	if err != nil {
+		log.Printf("hl7: failed to log message %q: %v", m.ControlID, err)
+		return nil
+	}
+	return entry
+}
+
+// upsertPatient creates or updates the patient identified by PID-3
+func (in *Ingestor) upsertPatient(ctx context.Context, msg *Message) (int, error) {
+	externalID := patientExternalID(msg)
+	if externalID == "" {
+		return 0, errors.New("PID-3 patient identifier is required")
+	}
+
+	p, err := in.patientFromPID(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := in.store.FindLink(ctx, storage.LinkPatient, externalID)
+	if err != nil {
+		return 0, err
+	}
+
+	if id != 0 {
+		existing, err := in.store.GetPatientByID(ctx, id)
+		if err == nil {
+			p.ID = id
+			p.FillMissing(existing)
+			return id, in.store.UpdatePatient(ctx, p)
+		}
+		if !strings.Contains(err.Error(), "no rows") {
+			return 0, err
+		}
+		// a soft-deleted patient keeps its history; registering a second one
+		// would orphan it, so the patient must be restored first
+		deleted, err := in.store.PatientDeleted(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if deleted {
+			return 0, fmt.Errorf("patient %d linked to %s is deleted; restore the patient first", id, externalID)
+		}
+		// the linked patient was purged, register it again
+	}
+
+	created, err := in.store.CreatePatient(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	if err := in.store.SaveLink(ctx, storage.LinkPatient, externalID, created.ID); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+func (in *Ingestor) patientFromPID(msg *Message) (*models.Patient, error) {
+	p := &models.Patient{
+		LastName:  msg.Component("PID", 5, 1),
+		FirstName: msg.Component("PID", 5, 2),
+		Diagnosis: msg.Component("DG1", 3, 2),
+	}
+	if p.Diagnosis == "" {
+		p.Diagnosis = msg.Field("DG1", 4)
+	}
+	if p.LastName == "" || p.FirstName == "" {
+		return nil, errors.New("PID-5 patient name must contain family and given name")
+	}
+
+	if dob := msg.Field("PID", 7); dob != "" {
+		born, err := ParseTimestamp(dob)
+		if err != nil {
+			return nil, fmt.Errorf("PID-7: %w", err)
+		}
+		p.DateOfBirth = born.Format("2006-01-02")
+		p.Age = models.AgeOn(born, in.now())
+	}
+
+	p.Sex = sexes[strings.ToUpper(msg.Field("PID", 8))]
+	p.AddressLine = msg.Component("PID", 11, 1)
+	p.City = msg.Component("PID", 11, 3)
+	p.PostalCode = msg.Component("PID", 11, 5)
+	p.Country = msg.Component("PID", 11, 6)
+	p.Phone = msg.Component("PID", 13, 1)
+	p.NationalID = msg.Field("PID", 19)
+
+	p.EmergencyContactName = strings.TrimSpace(msg.Component("NK1", 2, 2) + " " + msg.Component("NK1", 2, 1))
+	p.EmergencyContactRelation = msg.Component("NK1", 3, 2)
+	if p.EmergencyContactRelation == "" {
+		p.EmergencyContactRelation = msg.Component("NK1", 3, 1)
+	}
+	p.EmergencyContactPhone = msg.Component("NK1", 5, 1)
+	return p, nil
+}
+
+// HL7 table 0001 administrative sex
+var sexes = map[string]string{"M": "male", "F": "female", "O": "other", "A": "other", "U": "unknown"}
+
+// createAppointment books the SIU^S12 appointment. Replaying the same placer ID updates the existing row.
+func (in *Ingestor) createAppointment(ctx context.Context, msg *Message) error {
+	placerID := msg.Component("SCH", 1, 1)
+	if placerID == "" {
+		placerID = msg.Component("SCH", 2, 1)
+	}
+	if placerID == "" {
+		return errors.New("SCH-1 or SCH-2 appointment identifier is required")
+	}
+
+	patientID, err := in.upsertPatient(ctx, msg)
+	if err != nil {
+		return err
+	}
+	doctorID, err := in.resolveDoctor(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	start := msg.Component("SCH", 11, 4)
+	if start == "" {
+		start = msg.Field("AIS", 4)
+	}
+	if start == "" {
+		return errors.New("appointment start time (SCH-11.4 or AIS-4) is required")
+	}
+	at, err := ParseTimestamp(start)
+	if err != nil {
+		return err
+	}
+
+	status := msg.Component("SCH", 25, 2)
+	if status == "" {
+		status = msg.Component("SCH", 25, 1)
+	}
+	if status == "" {
+		status = "Scheduled"
+	}
+
+	a := &models.Appointment{
+		PatientID: patientID,
+		DoctorID:  doctorID,
+		Date:      at.Format("2006-01-02"),
+		Time:      at.Format("15:04:05"),
+		Status:    status,
+	}
+
+	id, err := in.store.FindLink(ctx, storage.LinkAppointment, placerID)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		a.ID = id
+		err := in.store.UpdateAppointment(ctx, a)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+	}
+
+	created, err := in.store.CreateAppointment(ctx, a)
+	if err != nil {
+		return err
+	}
+	return in.store.SaveLink(ctx, storage.LinkAppointment, placerID, created.ID)
+}
+
+// resolveDoctor maps AIP-3 (id^family^given) to a local doctor, falling back to a name match
+func (in *Ingestor) resolveDoctor(ctx context.Context, msg *Message) (int, error) {
+	externalID := msg.Component("AIP", 3, 1)
+	lastName := msg.Component("AIP", 3, 2)
+	firstName := msg.Component("AIP", 3, 3)
+
+	if externalID != "" {
+		id, err := in.store.FindLink(ctx, storage.LinkDoctor, externalID)
+		if err != nil || id != 0 {
+			return id, err
+		}
+	}
+	if lastName == "" {
+		return 0, errors.New("AIP-3 doctor is not linked and has no name to match")
+	}
+
+	d, err := in.store.FindDoctorByName(ctx, firstName, lastName)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return 0, fmt.Errorf("doctor %s %s not found", firstName, lastName)
+		}
+		return 0, err
+	}
+	if externalID != "" {
+		if err := in.store.SaveLink(ctx, storage.LinkDoctor, externalID, d.ID); err != nil {
+			return 0, err
+		}
+	}
+	return d.ID, nil
+}
+
+func patientExternalID(msg *Message) string {
+	id := msg.Component("PID", 3, 1)
+	if authority := msg.Component("PID", 3, 4); id != "" && authority != "" {
+		return authority + ":" + id
+	}
+	return id
+}
@@ -0,0 +1,158 @@
+package hl7
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Segment is one HL7 segment split into fields. Fields[0] is the segment name,
+// so field numbers match the HL7 spec (PID-3 is Fields[3]).
+type Segment struct {
+	Fields []string
+}
+
+// Message is a parsed HL7 v2 message
+type Message struct {
+	Raw      string
+	Segments []Segment
+
+	fieldSep     byte
+	componentSep byte
+	repeatSep    byte
+	escapeChar   byte
+	subSep       byte
+}
+
+// Parse splits a raw pipe-delimited message. Delimiters are taken from MSH-1/MSH-2.
+func Parse(raw string) (*Message, error) {
+	raw = strings.Trim(raw, "\r\n\x0b\x1c")
+	if !strings.HasPrefix(raw, "MSH") || len(raw) < 8 {
+		return nil, errors.New("message must start with an MSH segment")
+	}
+
+	m := &Message{
+		Raw:          raw,
+		fieldSep:     raw[3],
+		componentSep: raw[4],
+		repeatSep:    raw[5],
+		escapeChar:   raw[6],
+		subSep:       raw[7],
+	}
+
+	lines := strings.FieldsFunc(raw, func(r rune) bool { return r == '\r' || r == '\n' })
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, string(m.fieldSep))
+		if fields[0] == "MSH" {
+			// MSH-1 is the field separator itself, so shift everything by one
+			fields = append([]string{"MSH", string(m.fieldSep)}, fields[1:]...)
+		}
+		m.Segments = append(m.Segments, Segment{Fields: fields})
+	}
+	return m, nil
+}
+
+// Segment returns the first segment with the given name
+func (m *Message) Segment(name string) (Segment, bool) {
+	for _, s := range m.Segments {
+		if len(s.Fields) > 0 && s.Fields[0] == name {
+			return s, true
+		}
+	}
+	return Segment{}, false
+}
+
+// Field returns an unescaped field value, or "" when it is absent.
+// Only the first repetition is considered.
+func (m *Message) Field(segment string, field int) string {
+	return m.Component(segment, field, 1)
+}
+
+// Component returns a component (1-based) of the first repetition of a field
+func (m *Message) Component(segment string, field, component int) string {
+	s, ok := m.Segment(segment)
+	if !ok || field >= len(s.Fields) {
+		return ""
+	}
+	value := s.Fields[field]
+	if segment == "MSH" && field <= 2 {
+		return value
+	}
+	value = strings.SplitN(value, string(m.repeatSep), 2)[0]
+	parts := strings.Split(value, string(m.componentSep))
+	if component-1 >= len(parts) {
+		return ""
+	}
+	if component == 1 && len(parts) == 1 {
+		return m.unescape(value)
+	}
+	return m.unescape(parts[component-1])
+}
+
+// Type returns the message code and trigger event, e.g. "ADT^A04"
+func (m *Message) Type() string {
+	code := m.Component("MSH", 9, 1)
+	event := m.Component("MSH", 9, 2)
+	if event == "" {
+		return code
+	}
+	return code + "^" + event
+}
+
+func (m *Message) ControlID() string {
+	return m.Field("MSH", 10)
+}
+
+func (m *Message) unescape(s string) string {
+	esc := string(m.escapeChar)
+	if !strings.Contains(s, esc) {
+		return s
+	}
+	r := strings.NewReplacer(
+		esc+"F"+esc, string(m.fieldSep),
+		esc+"S"+esc, string(m.componentSep),
+		esc+"R"+esc, string(m.repeatSep),
+		esc+"T"+esc, string(m.subSep),
+		esc+"E"+esc, esc,
+	)
+	return r.Replace(s)
+}
+
+func (m *Message) escape(s string) string {
+	esc := string(m.escapeChar)
+	r := strings.NewReplacer(
+		esc, esc+"E"+esc,
+		string(m.fieldSep), esc+"F"+esc,
+		string(m.componentSep), esc+"S"+esc,
+		string(m.repeatSep), esc+"R"+esc,
+		string(m.subSep), esc+"T"+esc,
+	)
+	return r.Replace(s)
+}
+
+// ParseTimestamp reads an HL7 DTM value (YYYY[MM[DD[HH[MM[SS]]]]]), ignoring
+// fractional seconds and the timezone offset.
+func ParseTimestamp(v string) (time.Time, error) {
+	if i := strings.IndexAny(v, "+-"); i > 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '.'); i > 0 {
+		v = v[:i]
+	}
+	layouts := map[int]string{
+		4:  "2006",
+		6:  "200601",
+		8:  "20060102",
+		10: "2006010215",
+		12: "200601021504",
+		14: "20060102150405",
+	}
+	layout, ok := layouts[len(v)]
+	if !ok {
+		return time.Time{}, errors.New("invalid HL7 timestamp: " + v)
+	}
+	return time.Parse(layout, v)
+}
@@ -0,0 +1,131 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// MLLP framing bytes
+const (
+	startBlock = 0x0b
+	endBlock   = 0x1c
+	carriageCR = 0x0d
+)
+
+// maxMessageSize guards against a peer that never sends the end block
+const maxMessageSize = 1 << 20
+
+// Handler processes one raw message and returns the raw ACK to send back
+type Handler func(ctx context.Context, raw string) string
+
+// Server is an MLLP listener
+type Server struct {
+	Addr        string
+	Handler     Handler
+	IdleTimeout time.Duration
+}
+
+// ListenAndServe accepts connections until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	log.Printf("HL7 MLLP listener on %s", s.Addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("mllp accept: %v", err)
+			continue
+		}
+		go s.serve(ctx, conn)
+	}
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	idle := s.IdleTimeout
+	if idle == 0 {
+		idle = 5 * time.Minute
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		raw, err := ReadFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("mllp read from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		ack := s.Handler(ctx, raw)
+		conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err := WriteFrame(conn, ack); err != nil {
+			log.Printf("mllp write to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// ReadFrame reads one <VT>message<FS><CR> block. Bytes before the start block are discarded.
+func ReadFrame(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b == endBlock {
+			next, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if next != carriageCR {
+				return "", errors.New("mllp: end block not followed by carriage return")
+			}
+			return string(buf), nil
+		}
+		buf = append(buf, b)
+		if len(buf) > maxMessageSize {
+			return "", errors.New("mllp: message too large")
+		}
+	}
+}
+
+func WriteFrame(w io.Writer, msg string) error {
+	frame := make([]byte, 0, len(msg)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, msg...)
+	frame = append(frame, endBlock, carriageCR)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,109 @@
+// Package ical writes RFC 5545 calendars
+package ical
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Event statuses (RFC 5545 3.8.1.11)
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+type Event struct {
+	UID         string
+	Sequence    int
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	Status      string
+	Modified    time.Time
+}
+
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+const utcFormat = "20060102T150405Z"
+
+// WriteTo serializes the calendar with CRLF line endings and folded long lines.
+// All times are written in UTC so clients do not need a VTIMEZONE definition.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	line := func(name, value string) {
+		b.WriteString(fold(name + ":" + value))
+		b.WriteString("\r\n")
+	}
+
+	stamp := time.Now().UTC().Format(utcFormat)
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", stamp)
+		if !e.Modified.IsZero() {
+			line("LAST-MODIFIED", e.Modified.UTC().Format(utcFormat))
+		}
+		line("SEQUENCE", fmt.Sprint(e.Sequence))
+		line("DTSTART", e.Start.UTC().Format(utcFormat))
+		line("DTEND", e.End.UTC().Format(utcFormat))
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", escape(e.Location))
+		}
+		if e.Status != "" {
+			line("STATUS", e.Status)
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// fold splits content lines longer than 75 octets without breaking UTF-8 sequences
+func fold(s string) string {
+	if len(s) <= 75 {
+		return s
+	}
+	var b strings.Builder
+	limit := 75
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > limit {
+			b.WriteString("\r\n ")
+			n = 1
+			limit = 75
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+// Package icd10 looks up ICD-10 diagnosis codes in a tab-separated code list.
+// A list of common codes is bundled; a full list in the same format can be
+// loaded with Load.
+package icd10
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//go:embed codes.tsv
+var bundled string
+
+// Code is one entry of the code list
+type Code struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Catalog is a code list sorted by code
+type Catalog struct {
+	codes []Code
+	index map[string]int
+}
+
+var (
+	codePattern   = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+	prefixPattern = regexp.MustCompile(`^[A-Z]([0-9]([0-9A-Z](\.[0-9A-Z]{0,4})?)?)?$`)
+)
+
+// Bundled returns the code list shipped with the binary
+func Bundled() *Catalog {
+	c, err := Load(strings.NewReader(bundled))
+	if err != nil {
+		panic("icd10: bundled code list: " + err.Error())
+	}
+	return c
+}
+
+// Load reads "code<TAB>description" lines. Empty lines and lines starting
+// with # are skipped; codes may be written with or without the dot.
+func Load(r io.Reader) (*Catalog, error) {
+	c := &Catalog{index: map[string]int{}}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		code, description, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected code and description separated by a tab", line)
+		}
+		normalized, ok := Normalize(code)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid code %q", line, code)
+		}
+		if _, dup := c.index[normalized]; dup {
+			continue
+		}
+		c.index[normalized] = len(c.codes)
+		c.codes = append(c.codes, Code{Code: normalized, Description: strings.TrimSpace(description)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(c.codes, func(a, b Code) int { return strings.Compare(a.Code, b.Code) })
+	for i, code := range c.codes {
+		c.index[code.Code] = i
+	}
+	return c, nil
+}
+
+// Len returns the number of codes in the list
+func (c *Catalog) Len() int {
+	return len(c.codes)
+}
+
+// Normalize upper-cases a code and inserts the dot after the category
+// ("j459" becomes "J45.9"). ok is false when the result is not a valid code.
+func Normalize(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) > 3 && !strings.Contains(code, ".") {
+		code = code[:3] + "." + code[3:]
+	}
+	return code, codePattern.MatchString(code)
+}
+
+// NormalizePrefix is Normalize for the beginning of a code such as "J4" or "J45.";
+// ok is false when no code can start with it.
+func NormalizePrefix(prefix string) (string, bool) {
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	if len(prefix) > 3 && !strings.Contains(prefix, ".") {
+		prefix = prefix[:3] + "." + prefix[3:]
+	}
+	return prefix, prefixPattern.MatchString(prefix)
+}
+
+// Lookup returns the entry for a code in any accepted spelling
+func (c *Catalog) Lookup(code string) (Code, bool) {
+	normalized, ok := Normalize(code)
+	if !ok {
+		return Code{}, false
+	}
+	i, ok := c.index[normalized]
+	if !ok {
+		return Code{}, false
+	}
+	return c.codes[i], true
+}
+
+// Search returns up to limit codes. Codes starting with the query come first,
+// then codes whose description contains every word of the query.
+func (c *Catalog) Search(query string, limit int) []Code {
+	out := []Code{}
+	query = strings.TrimSpace(query)
+	if query == "" || limit <= 0 {
+		return out
+	}
+
+	seen := map[string]bool{}
+	if prefix, ok := NormalizePrefix(query); ok {
+		start, _ := slices.BinarySearchFunc(c.codes, prefix, func(e Code, p string) int { return strings.Compare(e.Code, p) })
+		for _, code := range c.codes[start:] {
+			if len(out) == limit || !strings.HasPrefix(code.Code, prefix) {
+				break
+			}
+			out = append(out, code)
+			seen[code.Code] = true
+		}
+	}
+
+	words := strings.Fields(strings.ToLower(query))
+	for _, code := range c.codes {
+		if len(out) == limit {
+			break
+		}
+		if seen[code.Code] {
+			continue
+		}
+		description := strings.ToLower(code.Description)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(description, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, code)
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+// Package interactions finds drug-drug interactions between medications given
+// by their WHO ATC codes in a tab-separated interaction table. A table of
+// common interactions is bundled; a full table in the same format can be
+// loaded with Load.
+package interactions
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//go:embed table.tsv
+var bundled string
+
+// Severities of a rule
+const (
+	Contraindicated = "contraindicated"
+	Major           = "major"
+	Moderate        = "moderate"
+	Minor           = "minor"
+)
+
+// Severities lists the accepted severities from the most to the least serious
+var Severities = []string{Contraindicated, Major, Moderate, Minor}
+
+// Rule is one entry of the table. A and B are ATC codes or groups; a rule
+// applies to any two drugs whose codes start with A and B in either order.
+type Rule struct {
+	A           string `json:"a"`
+	B           string `json:"b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// Table is an interaction table
+type Table struct {
+	rules []Rule
+}
+
+var codePattern = regexp.MustCompile(`^[A-Z]([0-9]{2}([A-Z]([A-Z]([0-9]{2})?)?)?)?$`)
+
+// ValidCode reports whether code is an ATC code or the code of an ATC group such as J01C
+func ValidCode(code string) bool {
+	return codePattern.MatchString(code)
+}
+
+// Bundled returns the table shipped with the binary
+func Bundled() *Table {
+	t, err := Load(strings.NewReader(bundled))
+	if err != nil {
+		panic("interactions: bundled table: " + err.Error())
+	}
+	return t
+}
+
+// Load reads "atc<TAB>atc<TAB>severity<TAB>description" lines. Empty lines and
+// lines starting with # are skipped; codes are upper-cased.
+func Load(r io.Reader) (*Table, error) {
+	t := &Table{}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected two codes, a severity and a description separated by tabs", line)
+		}
+		rule := Rule{
+			A:           strings.ToUpper(strings.TrimSpace(fields[0])),
+			B:           strings.ToUpper(strings.TrimSpace(fields[1])),
+			Severity:    strings.ToLower(strings.TrimSpace(fields[2])),
+			Description: strings.TrimSpace(fields[3]),
+		}
+		for _, code := range []string{rule.A, rule.B} {
+			if !ValidCode(code) {
+				return nil, fmt.Errorf("line %d: invalid ATC code %q", line, code)
+			}
+		}
+		if !slices.Contains(Severities, rule.Severity) {
+			return nil, fmt.Errorf("line %d: severity must be one of %s", line, strings.Join(Severities, ", "))
+		}
+		t.rules = append(t.rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Len returns the number of rules in the table
+func (t *Table) Len() int {
+	return len(t.rules)
+}
+
+// Between returns the rules that apply to two drugs, the most serious first.
+// A drug without an ATC code interacts with nothing.
+func (t *Table) Between(a, b string) []Rule {
+	var out []Rule
+	if a == "" || b == "" {
+		return out
+	}
+	for _, rule := range t.rules {
+		if strings.HasPrefix(a, rule.A) && strings.HasPrefix(b, rule.B) ||
+			strings.HasPrefix(a, rule.B) && strings.HasPrefix(b, rule.A) {
+			out = append(out, rule)
+		}
+	}
+	slices.SortStableFunc(out, func(x, y Rule) int {
+		return slices.Index(Severities, x.Severity) - slices.Index(Severities, y.Severity)
+	})
+	return out
+}
@@ -5,10 +5,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/TeseySTD/GoHospitalApi/handlers"
+	"github.com/TeseySTD/GoHospitalApi/hl7"
+	"github.com/TeseySTD/GoHospitalApi/icd10"
+	"github.com/TeseySTD/GoHospitalApi/interactions"
 	"github.com/TeseySTD/GoHospitalApi/middleware"
+	"github.com/TeseySTD/GoHospitalApi/router"
 	"github.com/TeseySTD/GoHospitalApi/storage"
 )
 
@@ -34,59 +40,171 @@ func main() {
 	}
 	log.Println("Database migrated/ready")
 
-	//Public endpoints
-	http.HandleFunc("/", middleware.LoggingMiddleware(handlers.RootHandler))
-	http.HandleFunc("/login", middleware.LoggingMiddleware(handlers.LoginHandler))
-	http.HandleFunc("/users", middleware.LoggingMiddleware(handlers.UsersListHandler))
-
-	// Protected endpoints
-	http.HandleFunc("/patients", middleware.Chain(
-		handlers.PatientsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
-	http.HandleFunc("/patients/", middleware.Chain(
-		handlers.PatientsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
-
-	http.HandleFunc("/doctors", middleware.Chain(
-		handlers.DoctorsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
-	http.HandleFunc("/doctors/", middleware.Chain(
-		handlers.DoctorsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
-
-	http.HandleFunc("/appointments", middleware.Chain(
-		handlers.AppointmentsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
-	http.HandleFunc("/appointments/", middleware.Chain(
-		handlers.AppointmentsRouter,
-		middleware.LoggingMiddleware,
-		middleware.JWTAuthMiddleware,
-		middleware.RoleBasedAccess,
-	))
+	tz := os.Getenv("HOSPITAL_TIMEZONE")
+	if tz == "" {
+		tz = "Europe/Kyiv"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Fatalf("invalid HOSPITAL_TIMEZONE %q: %v", tz, err)
+	}
+	handlers.CalendarLocation = loc
+	storage.ScheduleLocation = loc
+
+	if window := os.Getenv("IDEMPOTENCY_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid IDEMPOTENCY_WINDOW %q", window)
+		}
+		middleware.IdempotencyWindow = d
+	}
+
+	if currency := os.Getenv("BILLING_CURRENCY"); currency != "" {
+		if len(currency) != 3 || strings.ToUpper(currency) != currency {
+			log.Fatalf("invalid BILLING_CURRENCY %q, want an ISO 4217 code such as UAH", currency)
+		}
+		storage.BillingCurrency = currency
+	}
+
+	if sender := os.Getenv("CLAIM_SENDER"); sender != "" {
+		if strings.ContainsAny(sender, "|\r\n") {
+			log.Fatalf("invalid CLAIM_SENDER %q, it cannot contain | or line breaks", sender)
+		}
+		handlers.ClaimSender = sender
+	}
+
+	if path := os.Getenv("ICD10_CODES"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open ICD10_CODES: %v", err)
+		}
+		codes, err := icd10.Load(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("invalid ICD10_CODES %q: %v", path, err)
+		}
+		handlers.ICD10 = codes
+		log.Printf("Loaded %d ICD-10 codes from %s", codes.Len(), path)
+	}
+
+	if path := os.Getenv("INTERACTIONS_TABLE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open INTERACTIONS_TABLE: %v", err)
+		}
+		table, err := interactions.Load(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("invalid INTERACTIONS_TABLE %q: %v", path, err)
+		}
+		handlers.Interactions = table
+		log.Printf("Loaded %d drug interactions from %s", table.Len(), path)
+	}
+
+	// soft-deleted rows are removed for good once they are older than the
+	// retention period. Purging destroys medical records, so it is opt-in.
+	var retention time.Duration
+	if s := os.Getenv("DELETED_RETENTION"); s != "" {
+		if s != "off" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid DELETED_RETENTION %q", s)
+			}
+			retention = d
+		}
+	}
+	purgeInterval := time.Hour
+	if s := os.Getenv("PURGE_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid PURGE_INTERVAL %q", s)
+		}
+		purgeInterval = d
+	}
+	if retention > 0 {
+		go purgeDeleted(ctx, st, retention, purgeInterval)
+	}
+	go expireIdempotencyKeys(ctx, st, purgeInterval)
+
+	mux := router.New()
+	registerRoutes(mux)
+
+	// HL7 v2 feed from the legacy registration system. MLLP has no
+	// authentication, so the listener only starts when an address is set;
+	// bind it to an interface only the registration system can reach.
+	if mllpAddr := os.Getenv("HL7_MLLP_ADDR"); mllpAddr != "" && mllpAddr != "off" {
+		mllp := &hl7.Server{Addr: mllpAddr, Handler: hl7.NewIngestor(st).Handle}
+		go func() {
+			if err := mllp.ListenAndServe(ctx); err != nil {
+				log.Fatalf("hl7 listener failed: %v", err)
+			}
+		}()
+	}
 
 	port := ":8080"
 	log.Printf("Server starting on port %s", port)
 
 	srv := &http.Server{
 		Addr:         port,
+		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
 	log.Fatal(srv.ListenAndServe())
 }
+
+// purgeDeleted removes soft-deleted rows older than retention, now and then every interval
+func purgeDeleted(ctx context.Context, st *storage.Storage, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := st.PurgeDeleted(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("purge of deleted records failed: %v", err)
+		}
+		for table, n := range purged {
+			if n > 0 {
+				log.Printf("Purged %d deleted rows from %s", n, table)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireIdempotencyKeys removes expired Idempotency-Keys every interval
+func expireIdempotencyKeys(ctx context.Context, st *storage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := st.ExpireIdempotencyKeys(ctx, middleware.IdempotencyWindow, middleware.IdempotencyPending); err != nil {
+			log.Printf("expiry of idempotency keys failed: %v", err)
+		}
+	}
+}
+
+// routeMux is satisfied by *router.Router; tests pass a recorder to list registered routes
+type routeMux interface {
+	Handle(method, path string, h http.HandlerFunc)
+}
+
+// registerRoutes wires the central route table with the middleware chain each route's access level requires
+func registerRoutes(mux routeMux) {
+	for _, rt := range handlers.Routes() {
+		chain := middleware.ForAccess(rt.Access, rt.Feed)
+		if rt.Idempotent {
+			chain = append(chain, middleware.Idempotency(storage.Store))
+		}
+		mux.Handle(rt.Method, rt.Path, middleware.Chain(rt.Handler, chain...))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TeseySTD/GoHospitalApi/handlers"
+	"github.com/TeseySTD/GoHospitalApi/openapi"
+	"github.com/TeseySTD/GoHospitalApi/router"
+)
+
+type routeRecorder struct {
+	routes [][2]string
+}
+
+func (rr *routeRecorder) Handle(method, path string, _ http.HandlerFunc) {
+	rr.routes = append(rr.routes, [2]string{method, path})
+}
+
+func TestRegisteredRoutesAreDocumented(t *testing.T) {
+	rec := &routeRecorder{}
+	registerRoutes(rec)
+	spec := handlers.OpenAPISpec()
+
+	for _, rt := range rec.routes {
+		if !openapi.HasOperation(spec, rt[0], rt[1]) {
+			t.Errorf("route %s %s is not in the OpenAPI spec", rt[0], rt[1])
+		}
+	}
+}
+
+func TestDocumentedRoutesAreRegistered(t *testing.T) {
+	rec := &routeRecorder{}
+	registerRoutes(rec)
+
+	mux := router.New()
+	for _, rt := range rec.routes {
+		mux.Handle(rt[0], rt[1], func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+
+	for _, op := range handlers.Operations() {
+		path := strings.ReplaceAll(op.Path, "{id}", "1")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(op.Method, path, nil))
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("documented operation %s %s has no registered route (got %d)", op.Method, op.Path, rr.Code)
+		}
+	}
+}
+
+func TestWrongMethodReturnsAllow(t *testing.T) {
+	mux := router.New()
+	registerRoutes(mux)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/patients", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT /patients: got %d, want 405", rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); !strings.Contains(allow, "GET") || !strings.Contains(allow, "POST") {
+		t.Errorf("PUT /patients: Allow = %q", allow)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Header().Get("Content-Type"), "json") {
+		t.Errorf("GET /nope: got %d %q, want JSON 404", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
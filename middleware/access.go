@@ -0,0 +1,36 @@
+package middleware
+
+import "net/http"
+
+// Access is the authorization level a route requires. Routes declare it in
+// the route table and the middleware chain is derived from it.
+type Access int
+
+const (
+	// AccessPublic needs no token
+	AccessPublic Access = iota
+	// AccessRead allows any authenticated user; readers are limited to GET
+	AccessRead
+	// AccessAdmin allows admins only
+	AccessAdmin
+	// AccessFeed allows a Bearer token or a feed token for the {id} in the path
+	AccessFeed
+	// AccessClinical allows clinical roles only, for records such as clinical notes
+	AccessClinical
+)
+
+// ForAccess returns the middlewares for a route. feed is the feed kind for AccessFeed routes.
+func ForAccess(access Access, feed string) []func(http.HandlerFunc) http.HandlerFunc {
+	switch access {
+	case AccessPublic:
+		return []func(http.HandlerFunc) http.HandlerFunc{LoggingMiddleware}
+	case AccessAdmin:
+		return []func(http.HandlerFunc) http.HandlerFunc{LoggingMiddleware, JWTAuthMiddleware, RequireAdmin}
+	case AccessClinical:
+		return []func(http.HandlerFunc) http.HandlerFunc{LoggingMiddleware, JWTAuthMiddleware, RequireClinical}
+	case AccessFeed:
+		return []func(http.HandlerFunc) http.HandlerFunc{LoggingMiddleware, FeedAuthMiddleware(feed)}
+	default:
+		return []func(http.HandlerFunc) http.HandlerFunc{LoggingMiddleware, JWTAuthMiddleware, RoleBasedAccess}
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/storage"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+	maxIdempotentBody = 64 << 20 // the largest body a route takes, a batch
+)
+
+// IdempotencyWindow is how long a key and its saved response are kept
+var IdempotencyWindow = 24 * time.Hour
+
+// IdempotencyPending is how long a key may wait for its first response; a
+// request still unanswered after it is taken as abandoned by a server that
+// stopped, and the key may be used again
+var IdempotencyPending = 5 * time.Minute
+
+// IdempotencyStore keeps keys and saved responses (implemented by *storage.Storage)
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, scope, key, hash string, window, pending time.Duration) (*storage.IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, scope, key string, resp *storage.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+}
+
+// Idempotency replays the saved response when a request is retried with the
+// same Idempotency-Key. Keys are scoped to the authenticated user, so it must
+// run after JWTAuthMiddleware. Requests without the header pass through.
+func Idempotency(store IdempotencyStore) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyHeader)
+			if key == "" {
+				next(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				respondError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			if len(body) > maxIdempotentBody {
+				respondError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			user, _ := r.Context().Value(UserContextKey).(string)
+			hash := sha256.New()
+			io.WriteString(hash, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+			hash.Write(body)
+			requestHash := hex.EncodeToString(hash.Sum(nil))
+
+			saved, err := store.ClaimIdempotencyKey(r.Context(), user, key, requestHash, IdempotencyWindow, IdempotencyPending)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "idempotency check failed: "+err.Error())
+				return
+			}
+			if saved != nil {
+				replay(w, saved, requestHash)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			completed := false
+			defer func() {
+				// handler failed or panicked: free the key so the client can retry
+				if !completed {
+					if err := store.ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), user, key); err != nil {
+						log.Printf("release idempotency key %q: %v", key, err)
+					}
+				}
+			}()
+
+			next(rec, r)
+
+			if rec.status() >= http.StatusInternalServerError {
+				return
+			}
+			resp := &storage.IdempotentResponse{
+				RequestHash: requestHash,
+				Status:      rec.status(),
+				Header:      w.Header().Clone(),
+				Body:        rec.body.Bytes(),
+			}
+			if err := store.SaveIdempotentResponse(context.WithoutCancel(r.Context()), user, key, resp); err != nil {
+				log.Printf("save idempotent response %q: %v", key, err)
+				return
+			}
+			completed = true
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, saved *storage.IdempotentResponse, requestHash string) {
+	switch {
+	case saved.RequestHash != requestHash:
+		respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+	case saved.Status == 0:
+		respondError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+	default:
+		for name, values := range saved.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(saved.Status)
+		w.Write(saved.Body)
+	}
+}
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) status() int {
+	if rec.code == 0 {
+		return http.StatusOK
+	}
+	return rec.code
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/TeseySTD/GoHospitalApi/auth"
@@ -46,6 +47,38 @@ func JWTAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// FeedAuthMiddleware accepts either a regular Bearer token or a feed token in
+// the "token" query parameter issued for the {id} in the path
+func FeedAuthMiddleware(kind string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				JWTAuthMiddleware(next)(w, r)
+				return
+			}
+
+			token := r.URL.Query().Get("token")
+			if token == "" {
+				respondError(w, http.StatusUnauthorized, "Authorization header or feed token missing")
+				return
+			}
+
+			id, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "Invalid ID")
+				return
+			}
+
+			if err := auth.ValidateFeedToken(token, kind, id); err != nil {
+				respondError(w, http.StatusUnauthorized, "Invalid or expired feed token")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
 func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		role, ok := r.Context().Value(RoleContextKey).(string)
@@ -63,6 +96,24 @@ func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequireClinical lets only clinical roles through
+func RequireClinical(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, ok := r.Context().Value(RoleContextKey).(string)
+		if !ok {
+			respondError(w, http.StatusUnauthorized, "Role not found in context")
+			return
+		}
+
+		if !auth.IsClinical(role) {
+			respondError(w, http.StatusForbidden, "Clinical role required")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 func RoleBasedAccess(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		role, ok := r.Context().Value(RoleContextKey).(string)
@@ -71,6 +122,11 @@ func RoleBasedAccess(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 		
+		if !auth.IsKnownRole(role) {
+			respondError(w, http.StatusForbidden, "Unknown role")
+			return
+		}
+
 		if auth.IsReader(role) && r.Method != http.MethodGet {
 			respondError(w, http.StatusForbidden, "Read-only access. Only GET requests allowed")
 			return
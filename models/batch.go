@@ -0,0 +1,23 @@
+package models
+
+// BatchRef names one row of a batch delete
+type BatchRef struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+}
+
+// BatchItemResult is the outcome of one item, in request order
+type BatchItemResult struct {
+	Index   int    `json:"index"`
+	Status  int    `json:"status"`
+	ID      int    `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
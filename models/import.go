@@ -0,0 +1,32 @@
+package models
+
+// ImportRow is the outcome of one spreadsheet row
+type ImportRow struct {
+	Row         int      `json:"row"` // 1-based row number in the file, the header is row 1
+	Status      string   `json:"status"`
+	ID          int      `json:"id,omitempty"`
+	DuplicateOf string   `json:"duplicate_of,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// ImportReport is returned by dry runs and by committed imports
+type ImportReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Columns    map[string]string `json:"columns"` // field -> column header used
+	Ignored    []string          `json:"ignored_columns,omitempty"`
+	Total      int               `json:"total"`
+	Valid      int               `json:"valid"`
+	Invalid    int               `json:"invalid"`
+	Duplicates int               `json:"duplicates"`
+	Created    int               `json:"created"`
+	Rows       []ImportRow       `json:"rows"`
+}
+
+// Import row statuses
+const (
+	ImportValid     = "valid"
+	ImportInvalid   = "invalid"
+	ImportDuplicate = "duplicate"
+	ImportCreated   = "created"
+	ImportSkipped   = "skipped"
+)
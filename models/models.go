@@ -1,26 +1,812 @@
 package models
 
+import (
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/news2"
+)
+
 type Patient struct {
-    ID        int    `json:"id"`
-    FirstName string `json:"first_name"`
-    LastName  string `json:"last_name"`
-    Age       int    `json:"age"`
-    Diagnosis string `json:"diagnosis"`
+	ID          int    `json:"id"`
+	MRN         string `json:"mrn"` // medical record number, generated on insert
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	DateOfBirth string `json:"date_of_birth"` // YYYY-MM-DD
+	Age         int    `json:"age"`           // computed from date_of_birth when it is set
+	Sex         string `json:"sex"`           // administrative sex: male, female, other, unknown
+	Gender      string `json:"gender"`        // gender identity as stated by the patient
+	Phone       string `json:"phone"`
+	Email       string `json:"email"`
+	AddressLine string `json:"address_line"`
+	City        string `json:"city"`
+	PostalCode  string `json:"postal_code"`
+	Country     string `json:"country"`
+	NationalID  string `json:"national_id"`
+
+	EmergencyContactName     string `json:"emergency_contact_name"`
+	EmergencyContactPhone    string `json:"emergency_contact_phone"`
+	EmergencyContactRelation string `json:"emergency_contact_relation"`
+
+	Diagnosis string `json:"diagnosis"`
+	Version   int    `json:"version"`
+
+	// set while the row is soft-deleted; such rows are hidden until restored or purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// FillMissing copies the fields p leaves empty from other
+func (p *Patient) FillMissing(other *Patient) {
+	keep := func(field *string, stored string) {
+		if *field == "" {
+			*field = stored
+		}
+	}
+	keep(&p.Diagnosis, other.Diagnosis)
+	keep(&p.DateOfBirth, other.DateOfBirth)
+	keep(&p.Sex, other.Sex)
+	keep(&p.Gender, other.Gender)
+	keep(&p.Phone, other.Phone)
+	keep(&p.Email, other.Email)
+	keep(&p.AddressLine, other.AddressLine)
+	keep(&p.City, other.City)
+	keep(&p.PostalCode, other.PostalCode)
+	keep(&p.Country, other.Country)
+	keep(&p.NationalID, other.NationalID)
+	keep(&p.EmergencyContactName, other.EmergencyContactName)
+	keep(&p.EmergencyContactPhone, other.EmergencyContactPhone)
+	keep(&p.EmergencyContactRelation, other.EmergencyContactRelation)
+	if p.Age == 0 {
+		p.Age = other.Age
+	}
 }
 
 type Doctor struct {
-    ID             int    `json:"id"`
-    FirstName      string `json:"first_name"`
-    LastName       string `json:"last_name"`
-    Specialization string `json:"specialization"`
-    Experience     int    `json:"experience"`
+	ID             int    `json:"id"`
+	FirstName      string `json:"first_name"`
+	LastName       string `json:"last_name"`
+	Specialization string `json:"specialization"`
+	SpecialtyCode  string `json:"specialty_code"` // the specialization normalized to the vocabulary; read-only
+	Experience     int    `json:"experience"`
+	Version        int    `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
 }
 
 type Appointment struct {
-    ID        int    `json:"id"`
-    PatientID int    `json:"patient_id"`
-    DoctorID  int    `json:"doctor_id"`
-    Date      string `json:"date"` 
-    Time      string `json:"time"` 
-    Status    string `json:"status"`
+	ID        int    `json:"id"`
+	PatientID int    `json:"patient_id"`
+	DoctorID  int    `json:"doctor_id"`
+	Date      string `json:"date"`
+	Time      string `json:"time"`
+	Status    string `json:"status"`
+	PolicyID  int    `json:"policy_id"` // insurance covering the date, checked when booked; 0 is self-pay
+	Version   int    `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// AppointmentDetails is an appointment joined with the names of its patient and doctor
+type AppointmentDetails struct {
+	Appointment
+	PatientName    string    `json:"patient_name"`
+	DoctorName     string    `json:"doctor_name"`
+	Specialization string    `json:"specialization"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TimelineEvent is one entry of a patient's chronological history
+type TimelineEvent struct {
+	OccurredAt    time.Time      `json:"occurred_at"`
+	Type          string         `json:"type"`
+	AppointmentID int            `json:"appointment_id,omitempty"`
+	Summary       string         `json:"summary"`
+	Details       map[string]any `json:"details,omitempty"`
+}
+
+type HL7Message struct {
+	ID          int       `json:"id"`
+	ReceivedAt  time.Time `json:"received_at"`
+	ControlID   string    `json:"control_id"`
+	MessageType string    `json:"message_type"`
+	Raw         string    `json:"raw"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Problem is a coded entry of a patient's problem list
+type Problem struct {
+	ID            int       `json:"id"`
+	PatientID     int       `json:"patient_id"`
+	AppointmentID int       `json:"appointment_id"` // the visit it was diagnosed at, 0 if none
+	Code          string    `json:"code"`           // ICD-10, e.g. J45.9
+	Description   string    `json:"description"`    // taken from the code list when empty
+	Status        string    `json:"status"`         // active, recurrence, relapse, inactive, remission, resolved
+	OnsetDate     string    `json:"onset_date"`     // YYYY-MM-DD
+	ResolvedDate  string    `json:"resolved_date"`
+	Notes         string    `json:"notes"`
+	RecordedAt    time.Time `json:"recorded_at"`
+	Version       int       `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// DuplicateCandidate is a pair of patients that probably describe the same person
+type DuplicateCandidate struct {
+	Patient   Patient  `json:"patient"`
+	Duplicate Patient  `json:"duplicate"`
+	Score     float64  `json:"score"` // 0 to 1
+	Reasons   []string `json:"reasons"`
+}
+
+// DuplicatePair names two patients, e.g. to dismiss them from the review queue
+type DuplicatePair struct {
+	PatientID   int `json:"patient_id"`
+	DuplicateID int `json:"duplicate_id"`
+}
+
+// PatientMerge records a patient merged into a surviving record
+type PatientMerge struct {
+	ID         int            `json:"id"`
+	SurvivorID int            `json:"survivor_id"`
+	MergedID   int            `json:"merged_id"`
+	MergedMRN  string         `json:"merged_mrn"`
+	Merged     Patient        `json:"merged"` // the record as it was before the merge
+	Moved      map[string]int `json:"moved"`  // reassigned rows per table
+	MergedBy   string         `json:"merged_by"`
+	MergedAt   time.Time      `json:"merged_at"`
+}
+
+// MergeResult is the surviving record after a merge and the audit entry of the merge
+type MergeResult struct {
+	Patient Patient      `json:"patient"`
+	Merge   PatientMerge `json:"merge"`
+}
+
+// SearchResult is one ranked hit of the global search
+type SearchResult struct {
+	Type       string            `json:"type"` // patient, doctor or appointment
+	ID         int               `json:"id"`
+	Title      string            `json:"title"`
+	Subtitle   string            `json:"subtitle"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"` // matched fields with <mark> around matched words
+	Fields     map[string]string `json:"-"`          // searchable fields, used for highlighting
+}
+
+// Medication is an entry of the hospital's medication catalog
+type Medication struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`     // generic name, e.g. amoxicillin
+	Strength string `json:"strength"` // e.g. 500 mg
+	Form     string `json:"form"`     // e.g. tablet, solution for injection
+	ATCCode  string `json:"atc_code"` // WHO ATC classification, e.g. J01CA04
+	Version  int    `json:"version"`
+}
+
+// Prescription is a medication order written for a patient during an appointment
+type Prescription struct {
+	ID             int    `json:"id"`
+	PatientID      int    `json:"patient_id"`
+	AppointmentID  int    `json:"appointment_id"` // 0 when the appointment was purged
+	DoctorID       int    `json:"doctor_id"`      // prescribing doctor, the appointment's doctor by default
+	MedicationID   int    `json:"medication_id"`
+	MedicationName string `json:"medication_name"` // name and strength from the catalog
+	ATCCode        string `json:"atc_code"`        // ATC code of the medication, used for safety checks
+	Dose           string `json:"dose"`            // e.g. 500 mg, 2 puffs
+	Route          string `json:"route"`           // oral, intravenous, ...
+	Frequency      string `json:"frequency"`       // e.g. every 8 hours, once daily
+	DurationDays   int    `json:"duration_days"`   // 0 until discontinued
+	Instructions   string `json:"instructions"`
+	StartDate      string `json:"start_date"` // YYYY-MM-DD, the day of prescribing by default
+	EndDate        string `json:"end_date"`   // last day of the course, computed from duration_days
+
+	Status             string     `json:"status"` // active or discontinued
+	DiscontinuedAt     *time.Time `json:"discontinued_at,omitempty"`
+	DiscontinuedBy     string     `json:"discontinued_by,omitempty"`
+	DiscontinuedReason string     `json:"discontinued_reason,omitempty"`
+
+	OverrideReason string              `json:"override_reason,omitempty"` // why the warnings were accepted
+	Alerts         []PrescriptionAlert `json:"alerts,omitempty"`          // warnings accepted when prescribing
+
+	PrescribedBy string    `json:"prescribed_by"`
+	PrescribedAt time.Time `json:"prescribed_at"`
+	Version      int       `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// Discontinuation stops an active prescription
+type Discontinuation struct {
+	Reason string `json:"reason"`
+}
+
+// Allergy is a recorded allergy or intolerance of a patient
+type Allergy struct {
+	ID         int       `json:"id"`
+	PatientID  int       `json:"patient_id"`
+	Substance  string    `json:"substance"` // e.g. penicillin, peanuts
+	ATCCode    string    `json:"atc_code"`  // ATC code or group of the drugs it covers, e.g. J01C for penicillins
+	Reaction   string    `json:"reaction"`  // e.g. urticaria, anaphylaxis
+	Severity   string    `json:"severity"`  // mild, moderate or severe
+	Status     string    `json:"status"`    // active or inactive
+	Notes      string    `json:"notes"`
+	RecordedBy string    `json:"recorded_by"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Version    int       `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// PrescriptionAlert is a finding of the safety check of a new prescription.
+// Blocking alerts stop the prescription; the others are warnings that the
+// prescriber accepts by giving an override reason.
+type PrescriptionAlert struct {
+	Kind           string `json:"kind"`     // allergy, interaction or duplicate
+	Severity       string `json:"severity"` // allergy or interaction severity
+	Blocking       bool   `json:"blocking"`
+	Message        string `json:"message"`
+	AllergyID      int    `json:"allergy_id,omitempty"`
+	PrescriptionID int    `json:"prescription_id,omitempty"` // the active prescription it conflicts with
+}
+
+// PrescriptionCheck is the result of the safety check
+type PrescriptionCheck struct {
+	Alerts   []PrescriptionAlert `json:"alerts"`
+	Blocked  bool                `json:"blocked"`           // a blocking alert was found
+	Override bool                `json:"override_required"` // warnings need an override_reason
+}
+
+// LabTest is an analyte of the laboratory catalog with its reference range
+type LabTest struct {
+	ID           int      `json:"id"`
+	Code         string   `json:"code"`  // e.g. HGB, or a LOINC code
+	Name         string   `json:"name"`  // e.g. Hemoglobin
+	Unit         string   `json:"unit"`  // e.g. g/dL
+	Panel        string   `json:"panel"` // panel the test is ordered with, e.g. CBC
+	RefLow       *float64 `json:"ref_low"`
+	RefHigh      *float64 `json:"ref_high"`
+	CriticalLow  *float64 `json:"critical_low"`
+	CriticalHigh *float64 `json:"critical_high"`
+	Version      int      `json:"version"`
+}
+
+// LabOrder is a set of lab tests ordered for a patient during an appointment
+type LabOrder struct {
+	ID            int    `json:"id"`
+	PatientID     int    `json:"patient_id"`
+	AppointmentID int    `json:"appointment_id"` // 0 when the appointment was purged
+	DoctorID      int    `json:"doctor_id"`      // ordering doctor, the appointment's doctor by default
+	Priority      string `json:"priority"`       // routine, urgent or stat
+	Status        string `json:"status"`         // ordered, partial or resulted
+	Notes         string `json:"notes"`
+
+	Tests   []string    `json:"tests,omitempty"`  // test codes to order; only read when ordering
+	Panels  []string    `json:"panels,omitempty"` // panels to order; only read when ordering
+	Results []LabResult `json:"results"`
+
+	OrderedBy      string     `json:"ordered_by"`
+	OrderedAt      time.Time  `json:"ordered_at"`
+	ResultsReadyAt *time.Time `json:"results_ready_at,omitempty"` // when every result was first filled in
+	ReadySeq       int64      `json:"ready_seq,omitempty"`        // position in the results-ready feed, see ready_after
+	Version        int        `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// LabResult is one analyte of an order. Unit and ranges are copied from the
+// catalog when ordering so later catalog changes do not alter old results.
+type LabResult struct {
+	ID           int        `json:"id"`
+	TestID       int        `json:"test_id"`
+	Code         string     `json:"code"`
+	Name         string     `json:"name"`
+	Unit         string     `json:"unit"`
+	RefLow       *float64   `json:"ref_low"`
+	RefHigh      *float64   `json:"ref_high"`
+	CriticalLow  *float64   `json:"critical_low"`
+	CriticalHigh *float64   `json:"critical_high"`
+	Value        string     `json:"value"`         // empty until resulted
+	NumericValue *float64   `json:"numeric_value"` // Value when it is a number
+	Flag         string     `json:"flag"`          // normal, low, high, critical_low, critical_high; empty when not flagged
+	ResultedBy   string     `json:"resulted_by,omitempty"`
+	ResultedAt   *time.Time `json:"resulted_at,omitempty"`
+}
+
+// LabResultEntry fills in the value of one test of an order
+type LabResultEntry struct {
+	Code  string `json:"code"`
+	Value string `json:"value"`
+}
+
+// LabTrend is the history of one analyte of a patient
+type LabTrend struct {
+	Code   string          `json:"code"`
+	Name   string          `json:"name"`
+	Unit   string          `json:"unit"`
+	Points []LabTrendPoint `json:"points"`
+}
+
+// LabTrendPoint is one result of a LabTrend
+type LabTrendPoint struct {
+	OrderID      int       `json:"order_id"`
+	ResultedAt   time.Time `json:"resulted_at"`
+	Value        string    `json:"value"`
+	NumericValue *float64  `json:"numeric_value"`
+	Flag         string    `json:"flag"`
+	RefLow       *float64  `json:"ref_low"`
+	RefHigh      *float64  `json:"ref_high"`
+}
+
+// Vitals is a set of vital signs taken from a patient, optionally during an
+// appointment. Unmeasured signs are null; the NEWS2 score is computed on entry.
+type Vitals struct {
+	ID              int       `json:"id"`
+	PatientID       int       `json:"patient_id"`
+	AppointmentID   int       `json:"appointment_id"`             // 0 when not taken during an appointment
+	TakenAt         time.Time `json:"taken_at"`                   // now by default
+	Systolic        *int      `json:"systolic"`                   // mmHg
+	Diastolic       *int      `json:"diastolic"`                  // mmHg
+	Pulse           *int      `json:"pulse"`                      // beats per minute
+	Temperature     *float64  `json:"temperature"`                // stored in °C
+	TemperatureUnit string    `json:"temperature_unit,omitempty"` // C or F in requests; F is converted to °C
+	SpO2            *int      `json:"spo2"`                       // %
+	SpO2Scale2      bool      `json:"spo2_scale_2"`               // target 88-92% for hypercapnic respiratory failure
+	RespirationRate *int      `json:"respiration_rate"`           // breaths per minute
+	OnOxygen        bool      `json:"on_oxygen"`
+	Consciousness   string    `json:"consciousness"` // alert, confusion, voice, pain or unresponsive (ACVPU)
+	Notes           string    `json:"notes"`
+
+	NEWS2 news2.Result `json:"news2"`
+
+	RecordedBy string    `json:"recorded_by"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Version    int       `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// VitalsAlert is a patient whose latest vital signs score a NEWS2 risk that needs a response
+type VitalsAlert struct {
+	PatientID     int    `json:"patient_id"`
+	PatientName   string `json:"patient_name"`
+	MRN           string `json:"mrn"`
+	Latest        Vitals `json:"latest"`
+	PreviousScore *int   `json:"previous_score"` // NEWS2 of the set before, null if none
+	Deteriorating bool   `json:"deteriorating"`  // the score rose since the set before
+}
+
+// ClinicalNote is a SOAP note written for an appointment. Drafts can be edited
+// by their author; signed notes are immutable and corrected with addenda.
+type ClinicalNote struct {
+	ID            int    `json:"id"`
+	PatientID     int    `json:"patient_id"`
+	AppointmentID int    `json:"appointment_id"`
+	Subjective    string `json:"subjective"` // history and complaints as told by the patient
+	Objective     string `json:"objective"`  // examination findings and measurements
+	Assessment    string `json:"assessment"`
+	Plan          string `json:"plan"`
+	Status        string `json:"status"` // draft or signed
+
+	Author    string     `json:"author"` // username of the clinician who wrote the note
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	SignedAt  *time.Time `json:"signed_at"`
+
+	Addenda []NoteAddendum `json:"addenda"`
+	Version int            `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// NoteAddendum is a later correction or addition to a signed note
+type NoteAddendum struct {
+	ID        int       `json:"id"`
+	NoteID    int       `json:"note_id"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Department is a clinical department of the hospital; departments nest,
+// e.g. interventional cardiology under cardiology
+type Department struct {
+	ID       int          `json:"id"`
+	ParentID int          `json:"parent_id"` // 0 for a top-level department
+	Code     string       `json:"code"`      // short unique code, e.g. CARD
+	Name     string       `json:"name"`
+	Children []Department `json:"children,omitempty"` // filled in the department tree
+	Version  int          `json:"version"`
+}
+
+// DepartmentMember is a doctor on the staff of a department with a role in it
+type DepartmentMember struct {
+	DepartmentID   int       `json:"department_id"`
+	Department     string    `json:"department"`
+	DoctorID       int       `json:"doctor_id"`
+	DoctorName     string    `json:"doctor_name"`
+	Specialization string    `json:"specialization"`
+	Role           string    `json:"role"`
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+// Ward is a nursing unit of a department
+type Ward struct {
+	ID           int    `json:"id"`
+	DepartmentID int    `json:"department_id"`
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	Rooms        []Room `json:"rooms,omitempty"` // filled for a single ward
+	Version      int    `json:"version"`
+}
+
+// Room is a room of a ward
+type Room struct {
+	ID      int    `json:"id"`
+	WardID  int    `json:"ward_id"`
+	Number  string `json:"number"`
+	Beds    []Bed  `json:"beds,omitempty"`
+	Version int    `json:"version"`
+}
+
+// Bed is a bed of a room; beds out of service cannot be assigned
+type Bed struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	Label     string `json:"label"`
+	InService bool   `json:"in_service"`
+	Version   int    `json:"version"`
+}
+
+// BedLocation names a bed with the room, ward and department it is in
+type BedLocation struct {
+	Bed          string `json:"bed"`
+	Room         string `json:"room"`
+	WardID       int    `json:"ward_id"`
+	Ward         string `json:"ward"`
+	DepartmentID int    `json:"department_id"`
+	Department   string `json:"department"`
+}
+
+// BedStatus is a bed on the live occupancy board
+type BedStatus struct {
+	BedID    int          `json:"bed_id"`
+	Location BedLocation  `json:"location"`
+	Status   string       `json:"status"`   // free, occupied or out_of_service
+	Occupant *BedOccupant `json:"occupant"` // null unless occupied
+}
+
+// BedOccupant is the admitted patient lying in a bed
+type BedOccupant struct {
+	AdmissionID       int       `json:"admission_id"`
+	PatientID         int       `json:"patient_id"`
+	PatientName       string    `json:"patient_name"`
+	AttendingDoctorID int       `json:"attending_doctor_id"`
+	Since             time.Time `json:"since"` // when the patient moved into the bed
+}
+
+// WardOccupancy counts the beds of a ward
+type WardOccupancy struct {
+	WardID       int     `json:"ward_id"`
+	Ward         string  `json:"ward"`
+	DepartmentID int     `json:"department_id"`
+	Department   string  `json:"department"`
+	Beds         int     `json:"beds"`
+	Occupied     int     `json:"occupied"`
+	Free         int     `json:"free"`
+	OutOfService int     `json:"out_of_service"`
+	Rate         float64 `json:"rate"` // occupied share of the beds in service, 0 to 1
+}
+
+// Admission is an inpatient stay. While admitted the patient occupies
+// exactly one bed; transfers move the patient and are kept as movements.
+type Admission struct {
+	ID                  int         `json:"id"`
+	PatientID           int         `json:"patient_id"`
+	PatientName         string      `json:"patient_name"`
+	AttendingDoctorID   int         `json:"attending_doctor_id"`
+	AttendingDoctorName string      `json:"attending_doctor_name"`
+	BedID               int         `json:"bed_id"` // current bed, or the last one after discharge
+	Location            BedLocation `json:"location"`
+	Reason              string      `json:"reason"`
+	Status              string      `json:"status"` // admitted or discharged
+
+	AdmittedAt       time.Time  `json:"admitted_at"` // now by default
+	AdmittedBy       string     `json:"admitted_by"`
+	DischargedAt     *time.Time `json:"discharged_at"`
+	DischargedBy     string     `json:"discharged_by"`
+	Disposition      string     `json:"disposition"` // home, transferred, deceased or other
+	DischargeSummary string     `json:"discharge_summary"`
+
+	Movements []BedMovement `json:"movements"`
+	Version   int           `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// BedMovement is a period the patient of an admission spent in one bed
+type BedMovement struct {
+	BedID     int         `json:"bed_id"`
+	Location  BedLocation `json:"location"`
+	StartedAt time.Time   `json:"started_at"`
+	EndedAt   *time.Time  `json:"ended_at"` // null for the current bed
+	Reason    string      `json:"reason"`
+	MovedBy   string      `json:"moved_by"`
+}
+
+// Transfer moves an admitted patient to another bed and optionally to another attending doctor
+type Transfer struct {
+	BedID             int    `json:"bed_id"`
+	AttendingDoctorID int    `json:"attending_doctor_id"` // 0 keeps the current one
+	Reason            string `json:"reason"`
+}
+
+// Discharge ends an admission
+type Discharge struct {
+	Disposition string `json:"disposition"`
+	Summary     string `json:"summary"`
+}
+
+// Shift is a period a doctor works: a duty shift, or an on-call period in
+// which the doctor can be called in
+type Shift struct {
+	ID            int       `json:"id"`
+	DoctorID      int       `json:"doctor_id"`
+	DoctorName    string    `json:"doctor_name"`
+	SpecialtyCode string    `json:"specialty_code"` // of the doctor
+	DepartmentID  int       `json:"department_id"`  // 0 when not tied to a department
+	Kind          string    `json:"kind"`           // duty or on_call
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	RotationID    int       `json:"rotation_id"` // the rotation it was generated from, or 0
+	Note          string    `json:"note"`
+	CreatedBy     string    `json:"created_by"`
+	Version       int       `json:"version"`
+}
+
+// OnCallRotation hands on-call duty from one doctor to the next every
+// ShiftHours, beginning with the first doctor at StartsAt
+type OnCallRotation struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	DepartmentID int       `json:"department_id"` // 0 when not tied to a department
+	DoctorIDs    []int     `json:"doctor_ids"`
+	StartsAt     time.Time `json:"starts_at"`
+	ShiftHours   int       `json:"shift_hours"`
+	Version      int       `json:"version"`
+}
+
+// RotationRun reports the on-call shifts generated from a rotation
+type RotationRun struct {
+	Created []Shift       `json:"created"`
+	Skipped []RotationGap `json:"skipped"` // turns left uncovered
+}
+
+// RotationGap is a turn of a rotation that could not be rostered
+type RotationGap struct {
+	DoctorID int       `json:"doctor_id"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason"`
+}
+
+// Leave is a period a doctor is away; approved leave blocks shifts and appointments
+type Leave struct {
+	ID          int        `json:"id"`
+	DoctorID    int        `json:"doctor_id"`
+	DoctorName  string     `json:"doctor_name"`
+	Kind        string     `json:"kind"`
+	StartDate   string     `json:"start_date"` // YYYY-MM-DD
+	EndDate     string     `json:"end_date"`   // YYYY-MM-DD, inclusive
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedBy   string     `json:"decided_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	Version     int        `json:"version"`
+}
+
+// Availability is the bookable time of a doctor on one day
+type Availability struct {
+	DoctorID int     `json:"doctor_id"`
+	Date     string  `json:"date"`
+	OnLeave  bool    `json:"on_leave"`
+	Rostered bool    `json:"rostered"` // false: the doctor has no shifts and any time can be booked
+	Shifts   []Shift `json:"shifts"`
+	Slots    []Slot  `json:"slots"` // free appointment slots within the shifts
+}
+
+// Slot is a free appointment slot
+type Slot struct {
+	Date string `json:"date"`
+	Time string `json:"time"` // HH:MM
+}
+
+// Service is a priced item of the billing catalog. Amounts throughout billing
+// are integers in minor units of the currency, e.g. kopiykas.
+type Service struct {
+	ID        int    `json:"id"`
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	UnitPrice int64  `json:"unit_price"`
+	Specialty string `json:"specialty"` // specialty code; a visit fee applies to its doctors, empty for any
+	VisitFee  bool   `json:"visit_fee"` // charged on the draft invoice of a completed appointment
+	Active    bool   `json:"active"`
+	Version   int    `json:"version"`
+}
+
+// Invoice bills a patient, usually for one appointment. Lines can change only
+// while it is a draft; issuing numbers it and opens it for payment.
+type Invoice struct {
+	ID            int           `json:"id"`
+	Number        string        `json:"number"` // assigned when issued
+	PatientID     int           `json:"patient_id"`
+	PatientName   string        `json:"patient_name"`
+	AppointmentID int           `json:"appointment_id"`
+	Status        string        `json:"status"`
+	Currency      string        `json:"currency"`
+	Lines         []InvoiceLine `json:"lines,omitempty"` // not in lists
+	Subtotal      int64         `json:"subtotal"`
+	Discount      int64         `json:"discount"` // off the subtotal
+	Total         int64         `json:"total"`
+	Paid          int64         `json:"paid"` // payments less refunds
+	Balance       int64         `json:"balance"`
+	DueDate       string        `json:"due_date"` // YYYY-MM-DD
+	Notes         string        `json:"notes"`
+	Payments      []Payment     `json:"payments,omitempty"` // not in lists
+	CreatedBy     string        `json:"created_by"`
+	CreatedAt     time.Time     `json:"created_at"`
+	IssuedAt      *time.Time    `json:"issued_at"`
+	VoidedAt      *time.Time    `json:"voided_at"`
+	Version       int           `json:"version"`
+}
+
+// InvoiceLine is a charge on an invoice; a line from the catalog copies the
+// service's code, name and price
+type InvoiceLine struct {
+	ID              int    `json:"id"`
+	ServiceID       int    `json:"service_id"`
+	Code            string `json:"code"`
+	Description     string `json:"description"`
+	Quantity        int    `json:"quantity"`
+	UnitPrice       int64  `json:"unit_price"`
+	DiscountPercent int    `json:"discount_percent"`
+	Amount          int64  `json:"amount"`
+}
+
+// Payment is money received for an invoice, or a refund of it
+type Payment struct {
+	ID         int       `json:"id"`
+	InvoiceID  int       `json:"invoice_id"`
+	Kind       string    `json:"kind"`
+	Amount     int64     `json:"amount"`
+	Method     string    `json:"method"`
+	Reference  string    `json:"reference"`
+	ReceivedAt time.Time `json:"received_at"`
+	ReceivedBy string    `json:"received_by"`
+}
+
+// PatientBalance is what a patient owes on issued invoices
+type PatientBalance struct {
+	PatientID   int       `json:"patient_id"`
+	PatientName string    `json:"patient_name"`
+	Currency    string    `json:"currency"`
+	Invoiced    int64     `json:"invoiced"`
+	Paid        int64     `json:"paid"`
+	Balance     int64     `json:"balance"`
+	Invoices    []Invoice `json:"invoices,omitempty"` // the unpaid ones, without lines and payments
+}
+
+// Payer is an insurance company or fund claims are submitted to
+type Payer struct {
+	ID      int    `json:"id"`
+	Code    string `json:"code"` // identifies the payer in claim files
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Email   string `json:"email"`
+	Active  bool   `json:"active"`
+	Version int    `json:"version"`
+}
+
+// Policy insures a patient with a payer for a period
+type Policy struct {
+	ID              int    `json:"id"`
+	PatientID       int    `json:"patient_id"`
+	PayerID         int    `json:"payer_id"`
+	PayerName       string `json:"payer_name"`
+	MemberID        string `json:"member_id"`
+	GroupNumber     string `json:"group_number"`
+	CoveragePercent int    `json:"coverage_percent"` // share of the charges after the copay
+	Copay           int64  `json:"copay"`            // paid by the patient per visit, in minor units
+	StartDate       string `json:"start_date"`       // YYYY-MM-DD
+	EndDate         string `json:"end_date"`         // YYYY-MM-DD, inclusive; empty while open-ended
+	CreatedBy       string `json:"created_by"`
+	Version         int    `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// Eligibility tells whether a patient is insured on a date and by which policy
+type Eligibility struct {
+	PatientID int     `json:"patient_id"`
+	Date      string  `json:"date"`
+	Covered   bool    `json:"covered"`
+	Policy    *Policy `json:"policy"`
+}
+
+// Claim asks a payer to cover its share of an invoice
+type Claim struct {
+	ID            int                 `json:"id"`
+	Number        string              `json:"number"`
+	PatientID     int                 `json:"patient_id"`
+	PatientName   string              `json:"patient_name"`
+	PolicyID      int                 `json:"policy_id"`
+	PayerID       int                 `json:"payer_id"`
+	PayerName     string              `json:"payer_name"`
+	MemberID      string              `json:"member_id"`
+	GroupNumber   string              `json:"group_number"`
+	InvoiceID     int                 `json:"invoice_id"`
+	AppointmentID int                 `json:"appointment_id"`
+	ServiceDate   string              `json:"service_date"`
+	DoctorName    string              `json:"doctor_name"`
+	Diagnoses     []string            `json:"diagnoses"` // ICD-10 codes of the problems diagnosed at the visit
+	Lines         []ClaimLine         `json:"lines,omitempty"`
+	TotalCharge   int64               `json:"total_charge"`
+	Copay         int64               `json:"copay"`
+	ClaimedAmount int64               `json:"claimed_amount"`
+	PaidAmount    int64               `json:"paid_amount"`
+	Status        string              `json:"status"`
+	StatusNote    string              `json:"status_note"` // e.g. the payer's reason for a rejection
+	History       []ClaimStatusChange `json:"history,omitempty"`
+	CreatedBy     string              `json:"created_by"`
+	CreatedAt     time.Time           `json:"created_at"`
+	SubmittedAt   *time.Time          `json:"submitted_at"`
+	Version       int                 `json:"version"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+// ClaimLine is an invoice line as claimed
+type ClaimLine struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	Amount      int64  `json:"amount"`
+}
+
+// ClaimStatusChange moves a claim along; PaidAmount is what the payer remitted
+// when the status is paid
+type ClaimStatusChange struct {
+	From       string    `json:"from"`
+	Status     string    `json:"status"`
+	Note       string    `json:"note"`
+	PaidAmount int64     `json:"paid_amount,omitempty"`
+	ChangedBy  string    `json:"changed_by"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// ClaimRun reports the claims generated for a period
+type ClaimRun struct {
+	Created []Claim     `json:"created"`
+	Skipped []ClaimSkip `json:"skipped"`
+}
+
+// ClaimSkip is an invoice of a completed visit that could not be claimed
+type ClaimSkip struct {
+	InvoiceID     int    `json:"invoice_id"`
+	AppointmentID int    `json:"appointment_id"`
+	Reason        string `json:"reason"`
 }
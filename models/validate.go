@@ -0,0 +1,808 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/icd10"
+	"github.com/TeseySTD/GoHospitalApi/interactions"
+	"github.com/TeseySTD/GoHospitalApi/news2"
+	"github.com/TeseySTD/GoHospitalApi/specialties"
+)
+
+var (
+	phonePattern      = regexp.MustCompile(`^\+?[0-9 ()\-]{5,20}$`)
+	nationalIDPattern = regexp.MustCompile(`^[0-9A-Za-z\-]{4,32}$`)
+	unitCodePattern   = regexp.MustCompile(`^[A-Z0-9\-]{2,16}$`)
+)
+
+// Administrative sex values
+var Sexes = []string{"male", "female", "other", "unknown"}
+
+func (p *Patient) Validate() error {
+	if strings.TrimSpace(p.FirstName) == "" || strings.TrimSpace(p.LastName) == "" {
+		return errors.New("first_name and last_name are required")
+	}
+	if p.DateOfBirth != "" {
+		born, err := time.Parse("2006-01-02", p.DateOfBirth)
+		if err != nil {
+			return errors.New("date_of_birth must be in YYYY-MM-DD format")
+		}
+		if born.After(time.Now()) {
+			return errors.New("date_of_birth cannot be in the future")
+		}
+		if AgeOn(born, time.Now()) > 150 {
+			return errors.New("date_of_birth is more than 150 years ago")
+		}
+	} else if p.Age < 0 || p.Age > 150 {
+		return errors.New("age must be between 0 and 150")
+	}
+	if p.Sex != "" && !slices.Contains(Sexes, p.Sex) {
+		return errors.New("sex must be one of " + strings.Join(Sexes, ", "))
+	}
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		return errors.New("phone must contain 5 to 20 digits, spaces, dashes or parentheses")
+	}
+	if p.EmergencyContactPhone != "" && !phonePattern.MatchString(p.EmergencyContactPhone) {
+		return errors.New("emergency_contact_phone must contain 5 to 20 digits, spaces, dashes or parentheses")
+	}
+	if p.Email != "" {
+		if addr, err := mail.ParseAddress(p.Email); err != nil || addr.Address != p.Email {
+			return errors.New("email is not a valid address")
+		}
+	}
+	if p.NationalID != "" && !nationalIDPattern.MatchString(p.NationalID) {
+		return errors.New("national_id must be 4 to 32 letters, digits or dashes")
+	}
+	return nil
+}
+
+// AgeOn returns the age in full years on the given day
+func AgeOn(born, now time.Time) int {
+	age := now.Year() - born.Year()
+	if now.Month() < born.Month() || (now.Month() == born.Month() && now.Day() < born.Day()) {
+		age--
+	}
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+func (d *Doctor) Validate() error {
+	if strings.TrimSpace(d.FirstName) == "" || strings.TrimSpace(d.LastName) == "" {
+		return errors.New("first_name and last_name are required")
+	}
+	if strings.TrimSpace(d.Specialization) == "" {
+		return errors.New("specialization is required")
+	}
+	if _, ok := specialties.Normalize(d.Specialization); !ok {
+		return errors.New("specialization must be a specialty, its code or a synonym such as Cardiologist; see GET /specialties")
+	}
+	if d.Experience < 0 {
+		return errors.New("experience cannot be negative")
+	}
+	return nil
+}
+
+func (a *Appointment) Validate() error {
+	if a.PatientID <= 0 || a.DoctorID <= 0 {
+		return errors.New("patient_id and doctor_id are required")
+	}
+	if _, err := time.Parse("2006-01-02", a.Date); err != nil {
+		return errors.New("date must be in YYYY-MM-DD format")
+	}
+	if a.Time != "" {
+		if _, err := time.Parse("15:04:05", a.Time); err != nil {
+			if _, err := time.Parse("15:04", a.Time); err != nil {
+				return errors.New("time must be in HH:MM or HH:MM:SS format")
+			}
+		}
+	}
+	return nil
+}
+
+// Problem statuses; the last three close the problem
+var ProblemStatuses = []string{"active", "recurrence", "relapse", "inactive", "remission", "resolved"}
+
+func (p *Problem) Validate() error {
+	if p.Code == "" {
+		return errors.New("code is required")
+	}
+	if _, ok := icd10.Normalize(p.Code); !ok {
+		return errors.New("code must be an ICD-10 code such as J45 or J45.9")
+	}
+	if !slices.Contains(ProblemStatuses, p.Status) {
+		return errors.New("status must be one of " + strings.Join(ProblemStatuses, ", "))
+	}
+
+	var onset, resolved time.Time
+	var err error
+	if p.OnsetDate != "" {
+		if onset, err = time.Parse("2006-01-02", p.OnsetDate); err != nil {
+			return errors.New("onset_date must be in YYYY-MM-DD format")
+		}
+		if onset.After(time.Now()) {
+			return errors.New("onset_date cannot be in the future")
+		}
+	}
+	if p.ResolvedDate != "" {
+		if resolved, err = time.Parse("2006-01-02", p.ResolvedDate); err != nil {
+			return errors.New("resolved_date must be in YYYY-MM-DD format")
+		}
+		if resolved.After(time.Now()) {
+			return errors.New("resolved_date cannot be in the future")
+		}
+		if p.OnsetDate != "" && resolved.Before(onset) {
+			return errors.New("resolved_date cannot be before onset_date")
+		}
+		if !p.Closed() {
+			return errors.New("resolved_date requires status inactive, remission or resolved")
+		}
+	}
+	return nil
+}
+
+// Closed reports whether the problem no longer affects the patient
+func (p *Problem) Closed() bool {
+	return p.Status == "inactive" || p.Status == "remission" || p.Status == "resolved"
+}
+
+func (m *Medication) Validate() error {
+	if strings.TrimSpace(m.Name) == "" {
+		return errors.New("name is required")
+	}
+	if m.ATCCode != "" && !interactions.ValidCode(m.ATCCode) {
+		return errors.New("atc_code must be an ATC code such as J01CA04")
+	}
+	return nil
+}
+
+// Prescription statuses
+const (
+	PrescriptionActive       = "active"
+	PrescriptionDiscontinued = "discontinued"
+)
+
+// Routes of administration
+var MedicationRoutes = []string{"oral", "sublingual", "buccal", "intravenous", "intramuscular", "subcutaneous",
+	"intradermal", "topical", "transdermal", "inhaled", "nasal", "ophthalmic", "otic", "rectal", "vaginal"}
+
+// Validate checks the fields a prescriber enters; status and dates of
+// discontinuation are managed by the server
+func (p *Prescription) Validate() error {
+	if p.MedicationID <= 0 {
+		return errors.New("medication_id is required")
+	}
+	if strings.TrimSpace(p.Dose) == "" {
+		return errors.New("dose is required")
+	}
+	if !slices.Contains(MedicationRoutes, p.Route) {
+		return errors.New("route must be one of " + strings.Join(MedicationRoutes, ", "))
+	}
+	if strings.TrimSpace(p.Frequency) == "" {
+		return errors.New("frequency is required")
+	}
+	if p.DurationDays < 0 || p.DurationDays > 3650 {
+		return errors.New("duration_days must be between 0 (until discontinued) and 3650")
+	}
+	if p.StartDate != "" {
+		if _, err := time.Parse("2006-01-02", p.StartDate); err != nil {
+			return errors.New("start_date must be in YYYY-MM-DD format")
+		}
+	}
+	return nil
+}
+
+// Allergy severities; a severe allergy blocks prescribing the drug
+var AllergySeverities = []string{"mild", "moderate", "severe"}
+
+// Allergy statuses; inactive allergies were refuted or outgrown and are not checked
+var AllergyStatuses = []string{"active", "inactive"}
+
+func (a *Allergy) Validate() error {
+	if strings.TrimSpace(a.Substance) == "" {
+		return errors.New("substance is required")
+	}
+	if a.ATCCode != "" && !interactions.ValidCode(a.ATCCode) {
+		return errors.New("atc_code must be an ATC code or group such as J01C")
+	}
+	if !slices.Contains(AllergySeverities, a.Severity) {
+		return errors.New("severity must be one of " + strings.Join(AllergySeverities, ", "))
+	}
+	if !slices.Contains(AllergyStatuses, a.Status) {
+		return errors.New("status must be one of " + strings.Join(AllergyStatuses, ", "))
+	}
+	return nil
+}
+
+func (t *LabTest) Validate() error {
+	if t.Code == "" {
+		return errors.New("code is required")
+	}
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if t.RefLow != nil && t.RefHigh != nil && *t.RefLow > *t.RefHigh {
+		return errors.New("ref_low cannot be above ref_high")
+	}
+	if t.CriticalLow != nil && t.RefLow != nil && *t.CriticalLow > *t.RefLow {
+		return errors.New("critical_low cannot be above ref_low")
+	}
+	if t.CriticalHigh != nil && t.RefHigh != nil && *t.CriticalHigh < *t.RefHigh {
+		return errors.New("critical_high cannot be below ref_high")
+	}
+	return nil
+}
+
+// Lab order statuses; an order is resulted once every test has a value
+const (
+	LabOrdered  = "ordered"
+	LabPartial  = "partial"
+	LabResulted = "resulted"
+)
+
+var LabOrderStatuses = []string{LabOrdered, LabPartial, LabResulted}
+
+var LabPriorities = []string{"routine", "urgent", "stat"}
+
+// Validate checks an order as it is placed; the tests are checked against the catalog by the caller
+func (o *LabOrder) Validate() error {
+	if len(o.Tests) == 0 && len(o.Panels) == 0 {
+		return errors.New("tests or panels are required")
+	}
+	if !slices.Contains(LabPriorities, o.Priority) {
+		return errors.New("priority must be one of " + strings.Join(LabPriorities, ", "))
+	}
+	return nil
+}
+
+// SetValue stores a result and flags it against the reference and critical
+// ranges. Values that are not numbers, such as "positive" or "<0.5", are not flagged.
+func (r *LabResult) SetValue(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("value of " + r.Code + " is required")
+	}
+	r.Value, r.NumericValue, r.Flag = value, nil, ""
+
+	n, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil
+	}
+	r.NumericValue = &n
+	switch {
+	case r.CriticalLow != nil && n < *r.CriticalLow:
+		r.Flag = "critical_low"
+	case r.CriticalHigh != nil && n > *r.CriticalHigh:
+		r.Flag = "critical_high"
+	case r.RefLow != nil && n < *r.RefLow:
+		r.Flag = "low"
+	case r.RefHigh != nil && n > *r.RefHigh:
+		r.Flag = "high"
+	case r.RefLow != nil || r.RefHigh != nil:
+		r.Flag = "normal"
+	}
+	return nil
+}
+
+// vitalRange is the plausible range of a vital sign; values outside it are
+// usually entered in the wrong unit
+type vitalRange struct {
+	name     string
+	unit     string
+	min, max float64
+}
+
+var (
+	systolicRange    = vitalRange{"systolic", "mmHg", 40, 300}
+	diastolicRange   = vitalRange{"diastolic", "mmHg", 20, 200}
+	pulseRange       = vitalRange{"pulse", "beats/min", 20, 300}
+	temperatureRange = vitalRange{"temperature", "°C", 25, 45}
+	spo2Range        = vitalRange{"spo2", "%", 50, 100}
+	respirationRange = vitalRange{"respiration_rate", "breaths/min", 2, 80}
+)
+
+func (vr vitalRange) check(value float64) error {
+	if value < vr.min || value > vr.max {
+		return fmt.Errorf("%s must be between %g and %g %s", vr.name, vr.min, vr.max, vr.unit)
+	}
+	return nil
+}
+
+// Validate checks the signs in their stored units; a temperature in °F must be converted first
+func (v *Vitals) Validate() error {
+	if v.Systolic == nil && v.Diastolic == nil && v.Pulse == nil && v.Temperature == nil &&
+		v.SpO2 == nil && v.RespirationRate == nil && v.Consciousness == "" {
+		return errors.New("at least one vital sign is required")
+	}
+	if (v.Systolic == nil) != (v.Diastolic == nil) {
+		return errors.New("systolic and diastolic are recorded together")
+	}
+	if v.Systolic != nil {
+		if err := systolicRange.check(float64(*v.Systolic)); err != nil {
+			return err
+		}
+		if err := diastolicRange.check(float64(*v.Diastolic)); err != nil {
+			return err
+		}
+		if *v.Diastolic >= *v.Systolic {
+			return errors.New("diastolic must be below systolic")
+		}
+	}
+	if v.Pulse != nil {
+		if err := pulseRange.check(float64(*v.Pulse)); err != nil {
+			return err
+		}
+	}
+	if v.Temperature != nil {
+		if err := temperatureRange.check(*v.Temperature); err != nil {
+			return errors.New(err.Error() + "; send temperature_unit F for Fahrenheit")
+		}
+	}
+	if v.SpO2 != nil {
+		if err := spo2Range.check(float64(*v.SpO2)); err != nil {
+			return err
+		}
+	}
+	if v.RespirationRate != nil {
+		if err := respirationRange.check(float64(*v.RespirationRate)); err != nil {
+			return err
+		}
+	}
+	if v.Consciousness != "" && !slices.Contains(news2.ACVPU, v.Consciousness) {
+		return errors.New("consciousness must be one of " + strings.Join(news2.ACVPU, ", "))
+	}
+	if v.TakenAt.After(time.Now().Add(5 * time.Minute)) {
+		return errors.New("taken_at cannot be in the future")
+	}
+	return nil
+}
+
+// Observation returns the signs NEWS2 is computed from
+func (v *Vitals) Observation() news2.Observation {
+	return news2.Observation{
+		RespirationRate: v.RespirationRate,
+		SpO2:            v.SpO2,
+		SpO2Scale2:      v.SpO2Scale2,
+		OnOxygen:        v.OnOxygen,
+		Systolic:        v.Systolic,
+		Pulse:           v.Pulse,
+		Consciousness:   v.Consciousness,
+		Temperature:     v.Temperature,
+	}
+}
+
+// Clinical note statuses
+const (
+	NoteDraft  = "draft"
+	NoteSigned = "signed"
+)
+
+var NoteStatuses = []string{NoteDraft, NoteSigned}
+
+// maxNoteSection bounds each SOAP section and addendum
+const maxNoteSection = 20000
+
+// Validate checks a draft; Signable checks what signing additionally requires
+func (n *ClinicalNote) Validate() error {
+	sections := []struct{ name, text string }{
+		{"subjective", n.Subjective}, {"objective", n.Objective}, {"assessment", n.Assessment}, {"plan", n.Plan},
+	}
+	empty := true
+	for _, s := range sections {
+		if len(s.text) > maxNoteSection {
+			return fmt.Errorf("%s must be at most %d characters", s.name, maxNoteSection)
+		}
+		if s.text != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return errors.New("at least one of subjective, objective, assessment and plan is required")
+	}
+	return nil
+}
+
+// Signable reports why a note cannot be signed yet
+func (n *ClinicalNote) Signable() error {
+	if n.Assessment == "" || n.Plan == "" {
+		return errors.New("a note needs an assessment and a plan to be signed")
+	}
+	return nil
+}
+
+func (a *NoteAddendum) Validate() error {
+	if a.Text == "" {
+		return errors.New("text is required")
+	}
+	if len(a.Text) > maxNoteSection {
+		return fmt.Errorf("text must be at most %d characters", maxNoteSection)
+	}
+	return nil
+}
+
+func (d *Department) Validate() error {
+	if !unitCodePattern.MatchString(d.Code) {
+		return errors.New("code must be 2-16 upper-case letters, digits or dashes")
+	}
+	if d.Name == "" {
+		return errors.New("name is required")
+	}
+	if d.ParentID < 0 {
+		return errors.New("parent_id must be positive")
+	}
+	return nil
+}
+
+// Roles of a doctor within a department, the most senior first
+const (
+	RoleHead       = "head"
+	RoleDeputyHead = "deputy_head"
+	RoleConsultant = "consultant"
+	RoleSpecialist = "specialist"
+	RoleResident   = "resident"
+)
+
+var DepartmentRoles = []string{RoleHead, RoleDeputyHead, RoleConsultant, RoleSpecialist, RoleResident}
+
+func (m *DepartmentMember) Validate() error {
+	if !slices.Contains(DepartmentRoles, m.Role) {
+		return errors.New("role must be one of " + strings.Join(DepartmentRoles, ", "))
+	}
+	if m.JoinedAt.After(time.Now()) {
+		return errors.New("joined_at cannot be in the future")
+	}
+	return nil
+}
+
+func (w *Ward) Validate() error {
+	if w.DepartmentID <= 0 {
+		return errors.New("department_id is required")
+	}
+	if !unitCodePattern.MatchString(w.Code) {
+		return errors.New("code must be 2-16 upper-case letters, digits or dashes")
+	}
+	if w.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (r *Room) Validate() error {
+	if r.Number == "" {
+		return errors.New("number is required")
+	}
+	return nil
+}
+
+func (b *Bed) Validate() error {
+	if b.Label == "" {
+		return errors.New("label is required")
+	}
+	return nil
+}
+
+// Bed statuses on the occupancy board
+const (
+	BedFree         = "free"
+	BedOccupied     = "occupied"
+	BedOutOfService = "out_of_service"
+)
+
+var BedStatuses = []string{BedFree, BedOccupied, BedOutOfService}
+
+// Admission statuses
+const (
+	AdmissionAdmitted   = "admitted"
+	AdmissionDischarged = "discharged"
+)
+
+var AdmissionStatuses = []string{AdmissionAdmitted, AdmissionDischarged}
+
+// Discharge dispositions: where the patient went
+var Dispositions = []string{"home", "transferred", "deceased", "other"}
+
+func (a *Admission) Validate() error {
+	if a.BedID <= 0 {
+		return errors.New("bed_id is required")
+	}
+	if a.AttendingDoctorID <= 0 {
+		return errors.New("attending_doctor_id is required")
+	}
+	if a.AdmittedAt.After(time.Now().Add(5 * time.Minute)) {
+		return errors.New("admitted_at cannot be in the future")
+	}
+	return nil
+}
+
+func (t *Transfer) Validate() error {
+	if t.BedID <= 0 {
+		return errors.New("bed_id is required")
+	}
+	if t.AttendingDoctorID < 0 {
+		return errors.New("attending_doctor_id must be positive")
+	}
+	return nil
+}
+
+func (d *Discharge) Validate() error {
+	if !slices.Contains(Dispositions, d.Disposition) {
+		return errors.New("disposition must be one of " + strings.Join(Dispositions, ", "))
+	}
+	return nil
+}
+
+// Shift kinds
+const (
+	ShiftDuty   = "duty"
+	ShiftOnCall = "on_call"
+)
+
+var ShiftKinds = []string{ShiftDuty, ShiftOnCall}
+
+// maxShift is the longest shift that can be rostered
+const maxShift = 48 * time.Hour
+
+func (s *Shift) Validate() error {
+	if s.DoctorID <= 0 {
+		return errors.New("doctor_id is required")
+	}
+	if s.DepartmentID < 0 {
+		return errors.New("department_id must be positive")
+	}
+	if !slices.Contains(ShiftKinds, s.Kind) {
+		return errors.New("kind must be one of " + strings.Join(ShiftKinds, ", "))
+	}
+	if s.StartsAt.IsZero() || s.EndsAt.IsZero() {
+		return errors.New("starts_at and ends_at are required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if s.EndsAt.Sub(s.StartsAt) > maxShift {
+		return fmt.Errorf("a shift can last at most %d hours", int(maxShift.Hours()))
+	}
+	return nil
+}
+
+func (r *OnCallRotation) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.DepartmentID < 0 {
+		return errors.New("department_id must be positive")
+	}
+	if len(r.DoctorIDs) == 0 {
+		return errors.New("doctor_ids must list at least one doctor")
+	}
+	for _, id := range r.DoctorIDs {
+		if id <= 0 {
+			return errors.New("doctor_ids must be positive")
+		}
+	}
+	if r.StartsAt.IsZero() {
+		return errors.New("starts_at is required")
+	}
+	if r.ShiftHours <= 0 || time.Duration(r.ShiftHours)*time.Hour > maxShift {
+		return fmt.Errorf("shift_hours must be between 1 and %d", int(maxShift.Hours()))
+	}
+	return nil
+}
+
+// Leave kinds and statuses
+var LeaveKinds = []string{"vacation", "sick", "training", "other"}
+
+const (
+	LeaveRequested = "requested"
+	LeaveApproved  = "approved"
+	LeaveRejected  = "rejected"
+	LeaveCancelled = "cancelled"
+)
+
+var LeaveStatuses = []string{LeaveRequested, LeaveApproved, LeaveRejected, LeaveCancelled}
+
+func (l *Leave) Validate() error {
+	if l.DoctorID <= 0 {
+		return errors.New("doctor_id is required")
+	}
+	if !slices.Contains(LeaveKinds, l.Kind) {
+		return errors.New("kind must be one of " + strings.Join(LeaveKinds, ", "))
+	}
+	start, err := time.Parse("2006-01-02", l.StartDate)
+	if err != nil {
+		return errors.New("start_date must be in YYYY-MM-DD format")
+	}
+	end, err := time.Parse("2006-01-02", l.EndDate)
+	if err != nil {
+		return errors.New("end_date must be in YYYY-MM-DD format")
+	}
+	if end.Before(start) {
+		return errors.New("end_date cannot be before start_date")
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		return errors.New("a leave can last at most a year")
+	}
+	return nil
+}
+
+func (s *Service) Validate() error {
+	if s.Code == "" || s.Name == "" {
+		return errors.New("code and name are required")
+	}
+	if s.UnitPrice < 0 || s.UnitPrice > maxUnitPrice {
+		return fmt.Errorf("unit_price must be between 0 and %d", maxUnitPrice)
+	}
+	if s.Specialty != "" {
+		if _, ok := specialties.Lookup(s.Specialty); !ok {
+			return errors.New("specialty must be a code from /specialties")
+		}
+	}
+	return nil
+}
+
+// Invoice statuses
+const (
+	InvoiceDraft  = "draft"
+	InvoiceIssued = "issued"
+	InvoicePaid   = "paid"
+	InvoiceVoid   = "void"
+)
+
+var InvoiceStatuses = []string{InvoiceDraft, InvoiceIssued, InvoicePaid, InvoiceVoid}
+
+// Validate checks the terms of an invoice: the discount and due date
+func (inv *Invoice) Validate() error {
+	if inv.Discount < 0 {
+		return errors.New("discount cannot be negative")
+	}
+	if inv.DueDate != "" {
+		if _, err := time.Parse("2006-01-02", inv.DueDate); err != nil {
+			return errors.New("due_date must be in YYYY-MM-DD format")
+		}
+	}
+	for i := range inv.Lines {
+		if err := inv.Lines[i].Validate(); err != nil {
+			return fmt.Errorf("lines[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// maxQuantity and maxUnitPrice (in minor units) keep line amounts, and the
+// quantity × price × 100 Compute works with, far from overflowing
+const (
+	maxQuantity  = 10000
+	maxUnitPrice = 10_000_000_000
+)
+
+// Validate checks a line as submitted: one from the catalog needs only the
+// service and a quantity, a free-text one a description and a price
+func (l *InvoiceLine) Validate() error {
+	if l.ServiceID < 0 {
+		return errors.New("service_id must be positive")
+	}
+	if l.ServiceID == 0 && l.Description == "" {
+		return errors.New("service_id or description is required")
+	}
+	if l.Quantity < 1 || l.Quantity > maxQuantity {
+		return fmt.Errorf("quantity must be between 1 and %d", maxQuantity)
+	}
+	if l.UnitPrice < 0 || l.UnitPrice > maxUnitPrice {
+		return fmt.Errorf("unit_price must be between 0 and %d", maxUnitPrice)
+	}
+	if l.DiscountPercent < 0 || l.DiscountPercent > 100 {
+		return errors.New("discount_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// Compute sets Amount: quantity times price less the discount, rounded half up
+func (l *InvoiceLine) Compute() {
+	l.Amount = (int64(l.Quantity)*l.UnitPrice*int64(100-l.DiscountPercent) + 50) / 100
+}
+
+// Payment kinds and methods
+const (
+	PaymentReceived = "payment"
+	PaymentRefund   = "refund"
+)
+
+var PaymentMethods = []string{"cash", "card", "transfer", "insurance"}
+
+func (p *Payment) Validate() error {
+	if p.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	if !slices.Contains(PaymentMethods, p.Method) {
+		return errors.New("method must be one of " + strings.Join(PaymentMethods, ", "))
+	}
+	return nil
+}
+
+func (p *Payer) Validate() error {
+	if p.Code == "" || p.Name == "" {
+		return errors.New("code and name are required")
+	}
+	if strings.ContainsAny(p.Code, "|\r\n") {
+		return errors.New("code cannot contain | or line breaks")
+	}
+	if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			return errors.New("email is not a valid address")
+		}
+	}
+	return nil
+}
+
+func (p *Policy) Validate() error {
+	if p.PayerID <= 0 {
+		return errors.New("payer_id is required")
+	}
+	if p.MemberID == "" {
+		return errors.New("member_id is required")
+	}
+	if p.CoveragePercent < 0 || p.CoveragePercent > 100 {
+		return errors.New("coverage_percent must be between 0 and 100")
+	}
+	if p.Copay < 0 {
+		return errors.New("copay cannot be negative")
+	}
+	start, err := time.Parse("2006-01-02", p.StartDate)
+	if err != nil {
+		return errors.New("start_date must be in YYYY-MM-DD format")
+	}
+	if p.EndDate != "" {
+		end, err := time.Parse("2006-01-02", p.EndDate)
+		if err != nil {
+			return errors.New("end_date must be in YYYY-MM-DD format")
+		}
+		if end.Before(start) {
+			return errors.New("end_date cannot be before start_date")
+		}
+	}
+	return nil
+}
+
+// Claim statuses
+const (
+	ClaimReady     = "ready"
+	ClaimSubmitted = "submitted"
+	ClaimAccepted  = "accepted"
+	ClaimRejected  = "rejected"
+	ClaimPaid      = "paid"
+)
+
+var ClaimStatuses = []string{ClaimReady, ClaimSubmitted, ClaimAccepted, ClaimRejected, ClaimPaid}
+
+// ClaimTransitions lists the statuses a claim can move to from each status; a
+// rejected claim is corrected and made ready again
+var ClaimTransitions = map[string][]string{
+	ClaimReady:     {ClaimSubmitted},
+	ClaimSubmitted: {ClaimAccepted, ClaimRejected},
+	ClaimAccepted:  {ClaimPaid, ClaimRejected},
+	ClaimRejected:  {ClaimReady},
+}
+
+func (c *ClaimStatusChange) Validate() error {
+	if !slices.Contains(ClaimStatuses, c.Status) {
+		return errors.New("status must be one of " + strings.Join(ClaimStatuses, ", "))
+	}
+	if c.Status == ClaimPaid && c.PaidAmount <= 0 {
+		return errors.New("paid_amount is required when the claim is paid")
+	}
+	if c.Status != ClaimPaid && c.PaidAmount != 0 {
+		return errors.New("paid_amount is only accepted when the claim is paid")
+	}
+	if c.Status == ClaimRejected && c.Note == "" {
+		return errors.New("note is required to reject a claim")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Package news2 computes the National Early Warning Score 2 (Royal College of
+// Physicians, 2017) from a set of vital signs.
+package news2
+
+// Levels of consciousness on the ACVPU scale; anything but alert scores 3
+const (
+	Alert        = "alert"
+	Confusion    = "confusion" // new confusion
+	Voice        = "voice"
+	Pain         = "pain"
+	Unresponsive = "unresponsive"
+)
+
+// ACVPU lists the levels of consciousness
+var ACVPU = []string{Alert, Confusion, Voice, Pain, Unresponsive}
+
+// Clinical risk from the lowest to the highest
+const (
+	RiskLow       = "low"
+	RiskLowMedium = "low-medium" // a single parameter scored 3
+	RiskMedium    = "medium"
+	RiskHigh      = "high"
+)
+
+// Risks lists the clinical risks from the lowest to the highest
+var Risks = []string{RiskLow, RiskLowMedium, RiskMedium, RiskHigh}
+
+// Observation is a set of vital signs; nil fields were not measured
+type Observation struct {
+	RespirationRate *int     // breaths per minute
+	SpO2            *int     // %
+	SpO2Scale2      bool     // use SpO2 scale 2, for hypercapnic respiratory failure
+	OnOxygen        bool     // supplemental oxygen
+	Systolic        *int     // mmHg
+	Pulse           *int     // beats per minute
+	Consciousness   string   // one of ACVPU; empty when not assessed
+	Temperature     *float64 // °C
+}
+
+// Result is the aggregate score and the clinical risk it indicates
+type Result struct {
+	Score    int    `json:"score"`
+	Risk     string `json:"risk"`
+	RedScore bool   `json:"red_score"` // a single parameter scored 3
+	Complete bool   `json:"complete"`  // every parameter was measured; a partial score may underestimate the risk
+}
+
+// Score computes NEWS2. Parameters that were not measured score 0 and make the result incomplete.
+func Score(o Observation) Result {
+	var r Result
+	complete := true
+	score := func(points int) {
+		r.Score += points
+		if points == 3 {
+			r.RedScore = true
+		}
+	}
+
+	if o.RespirationRate != nil {
+		score(respirationPoints(*o.RespirationRate))
+	} else {
+		complete = false
+	}
+	switch {
+	case o.SpO2 == nil:
+		complete = false
+	case o.SpO2Scale2:
+		score(spo2Scale2Points(*o.SpO2, o.OnOxygen))
+	default:
+		score(spo2Scale1Points(*o.SpO2))
+	}
+	if o.OnOxygen {
+		score(2)
+	}
+	if o.Systolic != nil {
+		score(systolicPoints(*o.Systolic))
+	} else {
+		complete = false
+	}
+	if o.Pulse != nil {
+		score(pulsePoints(*o.Pulse))
+	} else {
+		complete = false
+	}
+	switch o.Consciousness {
+	case "":
+		complete = false
+	case Alert:
+	default:
+		score(3)
+	}
+	if o.Temperature != nil {
+		score(temperaturePoints(*o.Temperature))
+	} else {
+		complete = false
+	}
+
+	r.Complete = complete
+	switch {
+	case r.Score >= 7:
+		r.Risk = RiskHigh
+	case r.Score >= 5:
+		r.Risk = RiskMedium
+	case r.RedScore:
+		r.Risk = RiskLowMedium
+	default:
+		r.Risk = RiskLow
+	}
+	return r
+}
+
+func respirationPoints(rate int) int {
+	switch {
+	case rate <= 8:
+		return 3
+	case rate <= 11:
+		return 1
+	case rate <= 20:
+		return 0
+	case rate <= 24:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func spo2Scale1Points(spo2 int) int {
+	switch {
+	case spo2 <= 91:
+		return 3
+	case spo2 <= 93:
+		return 2
+	case spo2 <= 95:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// spo2Scale2Points targets 88-92%; above that only oxygen-driven saturation scores
+func spo2Scale2Points(spo2 int, onOxygen bool) int {
+	switch {
+	case spo2 <= 83:
+		return 3
+	case spo2 <= 85:
+		return 2
+	case spo2 <= 87:
+		return 1
+	case spo2 <= 92 || !onOxygen:
+		return 0
+	case spo2 <= 94:
+		return 1
+	case spo2 <= 96:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func systolicPoints(systolic int) int {
+	switch {
+	case systolic <= 90:
+		return 3
+	case systolic <= 100:
+		return 2
+	case systolic <= 110:
+		return 1
+	case systolic <= 219:
+		return 0
+	default:
+		return 3
+	}
+}
+
+func pulsePoints(pulse int) int {
+	switch {
+	case pulse <= 40:
+		return 3
+	case pulse <= 50:
+		return 1
+	case pulse <= 90:
+		return 0
+	case pulse <= 110:
+		return 1
+	case pulse <= 130:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// temperaturePoints uses the bands of the chart, which are read to one decimal
+func temperaturePoints(celsius float64) int {
+	t := float64(int(celsius*10+0.5)) / 10
+	switch {
+	case t <= 35.0:
+		return 3
+	case t <= 36.0:
+		return 1
+	case t <= 38.0:
+		return 0
+	case t <= 39.0:
+		return 1
+	default:
+		return 2
+	}
+}
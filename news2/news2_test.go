@@ -0,0 +1,112 @@
+package news2
+
+import "testing"
+
+func TestRespirationBands(t *testing.T) {
+	for rate, want := range map[int]int{8: 3, 9: 1, 11: 1, 12: 0, 20: 0, 21: 2, 24: 2, 25: 3} {
+		if got := respirationPoints(rate); got != want {
+			t.Errorf("respiration rate %d: got %d, want %d", rate, got, want)
+		}
+	}
+}
+
+func TestSpO2Scale1Bands(t *testing.T) {
+	for spo2, want := range map[int]int{91: 3, 92: 2, 93: 2, 94: 1, 95: 1, 96: 0} {
+		if got := spo2Scale1Points(spo2); got != want {
+			t.Errorf("SpO2 %d%%: got %d, want %d", spo2, got, want)
+		}
+	}
+}
+
+func TestSpO2Scale2Bands(t *testing.T) {
+	tests := []struct {
+		spo2     int
+		onOxygen bool
+		want     int
+	}{
+		{83, false, 3},
+		{84, false, 2},
+		{85, false, 2},
+		{86, false, 1},
+		{87, false, 1},
+		{88, false, 0},
+		{92, true, 0},
+		{93, false, 0},
+		{100, false, 0},
+		{93, true, 1},
+		{94, true, 1},
+		{95, true, 2},
+		{96, true, 2},
+		{97, true, 3},
+	}
+	for _, tt := range tests {
+		if got := spo2Scale2Points(tt.spo2, tt.onOxygen); got != tt.want {
+			t.Errorf("SpO2 %d%%, oxygen %v: got %d, want %d", tt.spo2, tt.onOxygen, got, tt.want)
+		}
+	}
+}
+
+func TestTemperatureBands(t *testing.T) {
+	for celsius, want := range map[float64]int{35.0: 3, 35.1: 1, 36.0: 1, 36.1: 0, 38.0: 0, 38.1: 1, 39.0: 1, 39.1: 2, 36.04: 1, 38.96: 1} {
+		if got := temperaturePoints(celsius); got != want {
+			t.Errorf("temperature %.2f: got %d, want %d", celsius, got, want)
+		}
+	}
+}
+
+func TestSystolicBands(t *testing.T) {
+	for systolic, want := range map[int]int{90: 3, 91: 2, 100: 2, 101: 1, 110: 1, 111: 0, 219: 0, 220: 3} {
+		if got := systolicPoints(systolic); got != want {
+			t.Errorf("systolic %d: got %d, want %d", systolic, got, want)
+		}
+	}
+}
+
+func TestPulseBands(t *testing.T) {
+	for pulse, want := range map[int]int{40: 3, 41: 1, 50: 1, 51: 0, 90: 0, 91: 1, 110: 1, 111: 2, 130: 2, 131: 3} {
+		if got := pulsePoints(pulse); got != want {
+			t.Errorf("pulse %d: got %d, want %d", pulse, got, want)
+		}
+	}
+}
+
+// normal is an observation of a patient in full health, scoring 0
+func normal() Observation {
+	rate, spo2, systolic, pulse, temperature := 16, 97, 120, 70, 37.0
+	return Observation{
+		RespirationRate: &rate, SpO2: &spo2, Systolic: &systolic, Pulse: &pulse,
+		Consciousness: Alert, Temperature: &temperature,
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(o *Observation)
+		want   Result
+	}{
+		{"normal", func(o *Observation) {}, Result{Score: 0, Risk: RiskLow, Complete: true}},
+		{"one parameter scoring 3", func(o *Observation) { o.Consciousness = Confusion },
+			Result{Score: 3, Risk: RiskLowMedium, RedScore: true, Complete: true}},
+		{"three points without a red score", func(o *Observation) { *o.Pulse, *o.Temperature = 95, 38.5; *o.RespirationRate = 10 },
+			Result{Score: 3, Risk: RiskLow, Complete: true}},
+		{"oxygen", func(o *Observation) { o.OnOxygen = true }, Result{Score: 2, Risk: RiskLow, Complete: true}},
+		{"four points", func(o *Observation) { *o.RespirationRate, *o.Pulse = 22, 115 },
+			Result{Score: 4, Risk: RiskLow, Complete: true}},
+		{"five points", func(o *Observation) { *o.RespirationRate, *o.Pulse, *o.Systolic = 22, 115, 105 },
+			Result{Score: 5, Risk: RiskMedium, Complete: true}},
+		{"seven points", func(o *Observation) { *o.RespirationRate, *o.Pulse, *o.Systolic = 25, 115, 100 },
+			Result{Score: 7, Risk: RiskHigh, RedScore: true, Complete: true}},
+		{"red score with five points is medium", func(o *Observation) { *o.Systolic, *o.RespirationRate = 90, 22 },
+			Result{Score: 5, Risk: RiskMedium, RedScore: true, Complete: true}},
+		{"not measured", func(o *Observation) { o.Pulse, o.Consciousness = nil, "" },
+			Result{Score: 0, Risk: RiskLow, Complete: false}},
+	}
+	for _, tt := range tests {
+		o := normal()
+		tt.change(&o)
+		if got := Score(o); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Package openapi builds an OpenAPI 3 document from a list of operations.
+// Schemas are generated from Go types via their json tags, so the document
+// follows the models without being edited by hand.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Param is a path, query or header parameter; path parameters not listed are integers
+type Param struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Type        string // "string", "integer", "boolean"
+	Description string
+	Required    bool
+}
+
+// Operation describes one method + path
+type Operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tag         string
+	Params      []Param
+
+	// Request and Response are zero values of the body types (e.g. models.Patient{} or []models.Patient{})
+	Request     any
+	Response    any
+	Status      int      // success status, defaults to 200
+	ContentType string   // success content type, defaults to application/json
+	Patch       bool     // Request is patched with merge-patch+json or json-patch+json
+	Upload      []string // media types of a file sent as the raw body or as the "file" form field
+	ETag        bool     // success response carries an ETag header
+	AlsoStatus  []int    // further statuses answered with the Response body (e.g. 207)
+
+	Public    bool // no security requirement
+	FeedToken bool // also accepts ?token= feed token
+	Errors    []int
+}
+
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// Build creates the document. Operations sharing a path are merged.
+func Build(info Info, ops []Operation) map[string]any {
+	g := &generator{schemas: map[string]any{}}
+	g.schemas["Error"] = map[string]any{
+		"type":       "object",
+		"required":   []string{"error"},
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}
+
+	g.schemas["JSONPatch"] = map[string]any{
+		"type": "array",
+		"items": map[string]any{
+			"type":     "object",
+			"required": []string{"op", "path"},
+			"properties": map[string]any{
+				"op":    map[string]any{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  map[string]any{"type": "string"},
+				"from":  map[string]any{"type": "string"},
+				"value": map[string]any{},
+			},
+		},
+	}
+
+	paths := map[string]any{}
+	for _, op := range ops {
+		item, ok := paths[op.Path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"feedToken": map[string]any{
+					"type": "apiKey",
+					"in":   "query",
+					"name": "token",
+				},
+			},
+		},
+	}
+}
+
+type generator struct {
+	schemas map[string]any
+}
+
+func (g *generator) operation(op Operation) map[string]any {
+	out := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if op.Tag != "" {
+		out["tags"] = []string{op.Tag}
+	}
+
+	var params []any
+	for _, name := range pathParams(op.Path) {
+		if slices.ContainsFunc(op.Params, func(p Param) bool { return p.In == "path" && p.Name == name }) {
+			continue // declared with its own type
+		}
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "integer"},
+		})
+	}
+	for _, p := range op.Params {
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		in := p.In
+		if in == "" {
+			in = "query"
+		}
+		param := map[string]any{
+			"name":     p.Name,
+			"in":       in,
+			"required": p.Required || in == "path",
+			"schema":   map[string]any{"type": typ},
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if params != nil {
+		out["parameters"] = params
+	}
+
+	if op.Request != nil {
+		schema := g.schemaFor(reflect.TypeOf(op.Request))
+		content := map[string]any{"application/json": map[string]any{"schema": schema}}
+		if op.Patch {
+			content = map[string]any{
+				"application/merge-patch+json": map[string]any{"schema": schema},
+				"application/json-patch+json":  map[string]any{"schema": ref("JSONPatch")},
+			}
+		}
+		out["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+	if len(op.Upload) > 0 {
+		binary := map[string]any{"type": "string", "format": "binary"}
+		content := map[string]any{
+			"multipart/form-data": map[string]any{"schema": map[string]any{
+				"type":       "object",
+				"required":   []string{"file"},
+				"properties": map[string]any{"file": binary},
+			}},
+		}
+		for _, mt := range op.Upload {
+			content[mt] = map[string]any{"schema": binary}
+		}
+		out["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if op.Response != nil {
+		ct := op.ContentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		schema := map[string]any{"type": "string"}
+		if ct == "application/json" {
+			schema = g.schemaFor(reflect.TypeOf(op.Response))
+		}
+		success["content"] = map[string]any{ct: map[string]any{"schema": schema}}
+	}
+	if op.ETag {
+		success["headers"] = map[string]any{
+			"ETag": map[string]any{
+				"description": "Current version of the resource, send it back in If-Match",
+				"schema":      map[string]any{"type": "string"},
+			},
+		}
+	}
+	responses := map[string]any{strconv.Itoa(status): success}
+	for _, code := range op.AlsoStatus {
+		alt := map[string]any{"description": http.StatusText(code)}
+		if content, ok := success["content"]; ok {
+			alt["content"] = content
+		}
+		responses[strconv.Itoa(code)] = alt
+	}
+
+	errs := op.Errors
+	if !op.Public {
+		errs = append(errs, http.StatusUnauthorized)
+	}
+	errs = append(errs, http.StatusInternalServerError)
+	for _, code := range errs {
+		if code == http.StatusNotModified {
+			responses[strconv.Itoa(code)] = map[string]any{"description": http.StatusText(code)}
+			continue
+		}
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": ref("Error")},
+			},
+		}
+	}
+	out["responses"] = responses
+
+	if op.Public {
+		out["security"] = []any{}
+	} else {
+		security := []any{map[string]any{"bearerAuth": []string{}}}
+		if op.FeedToken {
+			security = append(security, map[string]any{"feedToken": []string{}})
+		}
+		out["security"] = security
+	}
+	return out
+}
+
+// schemaFor returns an inline schema for primitives and a $ref for named structs
+func (g *generator) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = map[string]any{} // placeholder for recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return ref(t.Name())
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	g.addFields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func (g *generator) addFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, props)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schemaFor(f.Type)
+	}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func pathParams(path string) []string {
+	var out []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			out = append(out, strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}"))
+		}
+	}
+	return out
+}
+
+func operationID(op Operation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '.' || r == '-' || r == '_' }) {
+		if strings.HasPrefix(part, "{") {
+			part = "By" + strings.Trim(part, "{}")
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// HasOperation reports whether the document contains method + path
+func HasOperation(doc map[string]any, method, path string) bool {
+	paths, _ := doc["paths"].(map[string]any)
+	item, ok := paths[path].(map[string]any)
+	if !ok {
+		return false
+	}
+	_, ok = item[strings.ToLower(method)]
+	return ok
+}
+
+// Paths returns the documented paths in sorted order
+func Paths(doc map[string]any) []string {
+	paths, _ := doc["paths"].(map[string]any)
+	out := make([]string, 0, len(paths))
+	for p := range paths {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,160 @@
+// Package pdf writes plain text documents as PDF 1.4. Text is set in the
+// built-in Courier fonts, so columns line up without font metrics and no font
+// has to be embedded. The built-in fonts only cover WinAnsi (Latin-1):
+// Cyrillic is transliterated and other characters are replaced by "?".
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Page geometry of A4 in points
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 50
+	fontSize   = 10
+	leading    = 14
+)
+
+// Columns is how many characters fit on a line
+const Columns = (pageWidth - 2*margin) * 10 / (fontSize * 6) // Courier glyphs are 0.6 em wide
+
+// Line is one line of text
+type Line struct {
+	Text string
+	Bold bool
+}
+
+// Document is a sequence of lines broken into pages as needed
+type Document struct {
+	Title string
+	Lines []Line
+}
+
+// Add appends a regular line
+func (d *Document) Add(format string, args ...any) {
+	d.Lines = append(d.Lines, Line{Text: fmt.Sprintf(format, args...)})
+}
+
+// AddBold appends a bold line
+func (d *Document) AddBold(format string, args ...any) {
+	d.Lines = append(d.Lines, Line{Text: fmt.Sprintf(format, args...), Bold: true})
+}
+
+// WriteTo serializes the document
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	perPage := (pageHeight - 2*margin) / leading
+	var pages [][]Line
+	for start := 0; start < len(d.Lines) || start == 0; start += perPage {
+		end := min(start+perPage, len(d.Lines))
+		pages = append(pages, d.Lines[start:end])
+	}
+
+	// objects: 1 catalog, 2 page tree, 3 and 4 fonts, 5 info, then a page and its content per page
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title %s /Producer (GoHospitalApi) >>", literal(d.Title)),
+	)
+	for i, lines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n%d TL\n%d %d Td\n", leading, margin, pageHeight-margin-fontSize)
+		bold := -1
+		for _, l := range lines {
+			if b := boolInt(l.Bold); b != bold {
+				fmt.Fprintf(&content, "/F%d %d Tf\n", 1+b, fontSize)
+				bold = b
+			}
+			fmt.Fprintf(&content, "%s Tj T*\n", literal(l.Text))
+		}
+		content.WriteString("ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pageWidth, pageHeight, 7+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// literal encodes text as a WinAnsi string literal
+func literal(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range Transliterate(s) {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// cyrillic is the Ukrainian national transliteration, with the Russian letters
+// it lacks; only lower case, upper case is derived
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "h", 'ґ': "g", 'д': "d", 'е': "e", 'є': "ie", 'ж': "zh", 'з': "z",
+	'и': "y", 'і': "i", 'ї': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ь': "", 'ю': "iu", 'я': "ia", 'ё': "e", 'ы': "y", 'э': "e", 'ъ': "", '’': "", 'ʼ': "",
+}
+
+// Transliterate spells Cyrillic in Latin letters the way the document will show it
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		latin, ok := cyrillic[unicode.ToLower(r)]
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case unicode.IsUpper(r) && latin != "":
+			b.WriteString(strings.ToUpper(latin[:1]) + latin[1:])
+		default:
+			b.WriteString(latin)
+		}
+	}
+	return b.String()
+}
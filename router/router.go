@@ -0,0 +1,85 @@
+// Package router wraps http.ServeMux patterns with JSON 404/405 responses,
+// Allow headers and OPTIONS handling.
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/utils"
+)
+
+type Router struct {
+	mux    *http.ServeMux
+	routes map[string]map[string]http.HandlerFunc // path -> method -> handler
+}
+
+func New() *Router {
+	rt := &Router{
+		mux:    http.NewServeMux(),
+		routes: map[string]map[string]http.HandlerFunc{},
+	}
+	rt.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondError(w, http.StatusNotFound, "Not found")
+	})
+	return rt
+}
+
+// Handle registers a handler for method and a ServeMux path pattern such as /patients/{id}.
+// "/" matches only the root, not every path.
+func (rt *Router) Handle(method, path string, h http.HandlerFunc) {
+	methods, ok := rt.routes[path]
+	if !ok {
+		methods = map[string]http.HandlerFunc{}
+		rt.routes[path] = methods
+
+		pattern := path
+		if path == "/" {
+			pattern = "/{$}"
+		}
+		rt.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			dispatch(methods, w, r)
+		})
+	}
+	if _, dup := methods[method]; dup {
+		panic("router: duplicate route " + method + " " + path)
+	}
+	methods[method] = h
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+func dispatch(methods map[string]http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	h, ok := methods[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = methods[http.MethodGet]
+	}
+	if ok {
+		h(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", allow(methods))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	utils.RespondError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" not allowed")
+}
+
+func allow(methods map[string]http.HandlerFunc) string {
+	list := []string{http.MethodOptions}
+	for m := range methods {
+		list = append(list, m)
+	}
+	if _, ok := methods[http.MethodGet]; ok {
+		if _, ok := methods[http.MethodHead]; !ok {
+			list = append(list, http.MethodHead)
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
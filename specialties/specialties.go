@@ -0,0 +1,106 @@
+// Package specialties is the controlled vocabulary of medical specialties
+// that doctors are filed under. Free-text specializations such as
+// "Cardiologist" are normalized to a specialty by its code, name or a synonym.
+package specialties
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+//go:embed specialties.tsv
+var bundled string
+
+// Specialty is one entry of the vocabulary
+type Specialty struct {
+	Code     string   `json:"code"`
+	Name     string   `json:"name"`
+	Synonyms []string `json:"synonyms,omitempty"`
+}
+
+var (
+	list  []Specialty
+	index map[string]int // keys of codes, names and synonyms
+)
+
+func init() {
+	if err := load(bundled); err != nil {
+		panic("specialties: bundled vocabulary: " + err.Error())
+	}
+}
+
+// load reads "code<TAB>name<TAB>synonyms" lines; empty lines and lines
+// starting with # are skipped
+func load(data string) error {
+	list, index = nil, map[string]int{}
+	sc := bufio.NewScanner(strings.NewReader(data))
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: expected code, name and synonyms separated by tabs", line)
+		}
+		s := Specialty{Code: fields[0], Name: fields[1]}
+		if len(fields) > 2 {
+			for _, synonym := range strings.Split(fields[2], ",") {
+				if synonym = strings.TrimSpace(synonym); synonym != "" {
+					s.Synonyms = append(s.Synonyms, synonym)
+				}
+			}
+		}
+		list = append(list, s)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	slices.SortFunc(list, func(a, b Specialty) int { return strings.Compare(a.Code, b.Code) })
+	for i, s := range list {
+		for _, name := range append([]string{s.Code, s.Name}, s.Synonyms...) {
+			k := key(name)
+			if j, dup := index[k]; dup && j != i {
+				return fmt.Errorf("%q names both %s and %s", name, list[j].Code, s.Code)
+			}
+			index[k] = i
+		}
+	}
+	return nil
+}
+
+// key folds case, separators and repeated spaces so "Internal-medicine" matches "internal_medicine"
+func key(s string) string {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer("_", " ", "-", " ").Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// All returns the vocabulary sorted by code
+func All() []Specialty {
+	return append([]Specialty(nil), list...)
+}
+
+// Lookup returns the specialty with a code
+func Lookup(code string) (Specialty, bool) {
+	i, ok := index[key(code)]
+	if !ok || key(list[i].Code) != key(code) {
+		return Specialty{}, false
+	}
+	return list[i], true
+}
+
+// Normalize finds the specialty that a code, name or synonym stands for
+func Normalize(text string) (Specialty, bool) {
+	i, ok := index[key(text)]
+	if !ok {
+		return Specialty{}, false
+	}
+	return list[i], true
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrNotAdmitted is returned when transferring or discharging a patient that was already discharged
+var ErrNotAdmitted = errors.New("the patient is already discharged")
+
+const admissionColumns = `a.id, a.patient_id, p.first_name || ' ' || p.last_name, COALESCE(a.attending_doctor_id, 0),
+COALESCE(doc.first_name || ' ' || doc.last_name, ''), a.bed_id, ` + bedLocationColumns + `, a.reason, a.status,
+a.admitted_at, a.admitted_by, a.discharged_at, a.discharged_by, a.disposition, a.discharge_summary,
+a.version, a.deleted_at, a.deleted_by`
+
+// admissionSelect reads admissions with the names of the patient and the doctor and the location of the bed
+const admissionSelect = `SELECT ` + admissionColumns + `
+FROM admissions a
+JOIN patients p ON p.id = a.patient_id
+LEFT JOIN doctors doc ON doc.id = a.attending_doctor_id
+JOIN beds b ON b.id = a.bed_id` + bedLocationJoins
+
+func scanAdmission(row pgx.Row, a *models.Admission) error {
+	dest := []any{&a.ID, &a.PatientID, &a.PatientName, &a.AttendingDoctorID, &a.AttendingDoctorName, &a.BedID}
+	dest = append(dest, bedLocationDest(&a.Location)...)
+	return row.Scan(append(dest, &a.Reason, &a.Status,
+		&a.AdmittedAt, &a.AdmittedBy, &a.DischargedAt, &a.DischargedBy, &a.Disposition, &a.DischargeSummary,
+		&a.Version, &a.DeletedAt, &a.DeletedBy)...)
+}
+
+// admissionConflict turns violations of the one-patient-per-bed and
+// one-open-admission-per-patient indexes into ErrBedOccupied and ErrDuplicate
+func admissionConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "admissions_bed_occupied":
+			return ErrBedOccupied
+		case "admissions_patient_admitted":
+			return fmt.Errorf("%w: the patient is already admitted", ErrDuplicate)
+		}
+	}
+	return err
+}
+
+func queryAdmissions(ctx context.Context, q querier, where string, args ...any) ([]models.Admission, error) {
+	rows, err := q.Query(ctx, admissionSelect+`
+WHERE `+where+`
+ORDER BY a.status <> 'admitted', a.admitted_at DESC, a.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Admission
+	for rows.Next() {
+		var a models.Admission
+		if err := scanAdmission(rows, &a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, loadMovements(ctx, q, out)
+}
+
+// loadMovements fills the bed movements of admissions in time order
+func loadMovements(ctx context.Context, q querier, admissions []models.Admission) error {
+	if len(admissions) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(admissions))
+	ids := make([]int, len(admissions))
+	for i := range admissions {
+		index[admissions[i].ID] = i
+		ids[i] = admissions[i].ID
+		admissions[i].Movements = []models.BedMovement{}
+	}
+
+	rows, err := q.Query(ctx, `
+SELECT m.admission_id, m.bed_id, `+bedLocationColumns+`, m.started_at, m.ended_at, m.reason, m.moved_by
+FROM bed_movements m
+JOIN beds b ON b.id = m.bed_id`+bedLocationJoins+`
+WHERE m.admission_id = ANY($1)
+ORDER BY m.admission_id, m.started_at, m.id
+`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var admissionID int
+		var m models.BedMovement
+		dest := append([]any{&admissionID, &m.BedID}, bedLocationDest(&m.Location)...)
+		if err := rows.Scan(append(dest, &m.StartedAt, &m.EndedAt, &m.Reason, &m.MovedBy)...); err != nil {
+			return err
+		}
+		a := &admissions[index[admissionID]]
+		a.Movements = append(a.Movements, m)
+	}
+	return rows.Err()
+}
+
+// getAdmission reads one admission, deleted or not, with its movements
+func getAdmission(ctx context.Context, q querier, id int) (*models.Admission, error) {
+	admissions, err := queryAdmissions(ctx, q, `a.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(admissions) == 0 {
+		return nil, fmt.Errorf("admission not found")
+	}
+	return &admissions[0], nil
+}
+
+func (s *Storage) GetAdmission(ctx context.Context, id int) (*models.Admission, error) {
+	admissions, err := queryAdmissions(ctx, s.pool, `a.id = $1 AND a.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(admissions) == 0 {
+		return nil, fmt.Errorf("admission not found")
+	}
+	return &admissions[0], nil
+}
+
+// GetPatientAdmissions returns the stays of a patient, the current one first;
+// soft-deleted admissions are listed with includeDeleted
+func (s *Storage) GetPatientAdmissions(ctx context.Context, patientID int, includeDeleted bool) ([]models.Admission, error) {
+	return queryAdmissions(ctx, s.pool, `a.patient_id = $1 AND ($2 OR a.deleted_at IS NULL)`, patientID, includeDeleted)
+}
+
+// GetAdmissions lists admissions by status (all when empty), narrowed to the
+// ward or department of the bed when wardID or departmentID is not 0
+func (s *Storage) GetAdmissions(ctx context.Context, status string, wardID, departmentID int) ([]models.Admission, error) {
+	return queryAdmissions(ctx, s.pool, `a.deleted_at IS NULL AND ($1 = '' OR a.status = $1)
+  AND ($2 = 0 OR w.id = $2) AND ($3 = 0 OR d.id = $3)`, status, wardID, departmentID)
+}
+
+// assignableBed locks a bed for a patient to move into
+func assignableBed(ctx context.Context, tx pgx.Tx, bedID int) error {
+	inService, occupied, err := lockBed(ctx, tx, bedID)
+	switch {
+	case err != nil:
+		return err
+	case occupied:
+		return ErrBedOccupied
+	case !inService:
+		return ErrBedOutOfService
+	}
+	return nil
+}
+
+// CreateAdmission admits a patient into a free bed and opens the first bed movement
+func (s *Storage) CreateAdmission(ctx context.Context, a *models.Admission) (*models.Admission, error) {
+	var out *models.Admission
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if err := assignableBed(ctx, tx, a.BedID); err != nil {
+			return err
+		}
+		var id int
+		err := tx.QueryRow(ctx, `
+INSERT INTO admissions (patient_id, attending_doctor_id, bed_id, reason, status, admitted_at, admitted_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`, a.PatientID, a.AttendingDoctorID, a.BedID, a.Reason, models.AdmissionAdmitted, a.AdmittedAt, a.AdmittedBy).Scan(&id)
+		if err != nil {
+			return admissionConflict(err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO bed_movements (admission_id, bed_id, started_at, reason, moved_by) VALUES ($1, $2, $3, 'admission', $4)`,
+			id, a.BedID, a.AdmittedAt, a.AdmittedBy); err != nil {
+			return err
+		}
+		out, err = getAdmission(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// lockAdmitted locks an open admission; a non-zero version must match the stored one
+func lockAdmitted(ctx context.Context, tx pgx.Tx, id, version int) (bedID int, err error) {
+	var status string
+	var current int
+	err = tx.QueryRow(ctx, `SELECT bed_id, status, version FROM admissions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).
+		Scan(&bedID, &status, &current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("admission not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && current != version {
+		return 0, ErrVersionMismatch
+	}
+	if status != models.AdmissionAdmitted {
+		return 0, ErrNotAdmitted
+	}
+	return bedID, nil
+}
+
+// TransferAdmission moves an admitted patient to another free bed; a non-zero
+// t.AttendingDoctorID also hands the patient over to that doctor
+func (s *Storage) TransferAdmission(ctx context.Context, id, version int, t *models.Transfer, by string) (*models.Admission, error) {
+	var out *models.Admission
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		bedID, err := lockAdmitted(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if bedID == t.BedID {
+			return fmt.Errorf("%w: the patient already lies in this bed", ErrBedOccupied)
+		}
+		if err := assignableBed(ctx, tx, t.BedID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bed_movements SET ended_at = now() WHERE admission_id = $1 AND ended_at IS NULL`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO bed_movements (admission_id, bed_id, started_at, reason, moved_by) VALUES ($1, $2, now(), $3, $4)`,
+			id, t.BedID, t.Reason, by); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE admissions SET bed_id = $2, attending_doctor_id = COALESCE(NULLIF($3::integer, 0), attending_doctor_id), version = version + 1
+WHERE id = $1
+`, id, t.BedID, t.AttendingDoctorID); err != nil {
+			return admissionConflict(err)
+		}
+		out, err = getAdmission(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DischargeAdmission ends an admission and frees its bed
+func (s *Storage) DischargeAdmission(ctx context.Context, id, version int, d *models.Discharge, by string) (*models.Admission, error) {
+	var out *models.Admission
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockAdmitted(ctx, tx, id, version); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bed_movements SET ended_at = now() WHERE admission_id = $1 AND ended_at IS NULL`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE admissions
+SET status = $2, discharged_at = now(), discharged_by = $3, disposition = $4, discharge_summary = $5, version = version + 1
+WHERE id = $1
+`, id, models.AdmissionDischarged, by, d.Disposition, d.Summary); err != nil {
+			return err
+		}
+		var err error
+		out, err = getAdmission(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteAdmission soft-deletes an admission entered in error, which frees its bed
+func (s *Storage) DeleteAdmission(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "admissions", "admission", id, version, by) })
+}
+
+// RestoreAdmission undoes DeleteAdmission; the patient must not be deleted and,
+// if still admitted, neither the patient nor the bed may be taken meanwhile
+func (s *Storage) RestoreAdmission(ctx context.Context, id int) (*models.Admission, error) {
+	var out *models.Admission
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "admissions", "admission", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "admissions", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE admissions SET deleted_at = NULL, deleted_by = '', version = version + 1 WHERE id = $1`, id); err != nil {
+			return admissionConflict(err)
+		}
+		var err error
+		out, err = getAdmission(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
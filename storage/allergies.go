@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const allergyColumns = `id, patient_id, substance, atc_code, reaction, severity, status, notes,
+recorded_by, recorded_at, version, deleted_at, deleted_by`
+
+func scanAllergy(row pgx.Row, a *models.Allergy) error {
+	return row.Scan(&a.ID, &a.PatientID, &a.Substance, &a.ATCCode, &a.Reaction, &a.Severity, &a.Status, &a.Notes,
+		&a.RecordedBy, &a.RecordedAt, &a.Version, &a.DeletedAt, &a.DeletedBy)
+}
+
+// GetPatientAllergies lists the allergies of a patient, active and severe ones
+// first; an empty status lists all. Soft-deleted allergies are listed with includeDeleted.
+func (s *Storage) GetPatientAllergies(ctx context.Context, patientID int, status string, includeDeleted bool) ([]models.Allergy, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+allergyColumns+` FROM patient_allergies
+WHERE patient_id = $1 AND ($2 = '' OR status = $2) AND ($3 OR deleted_at IS NULL)
+ORDER BY status <> 'active', array_position(ARRAY['severe', 'moderate', 'mild'], severity), lower(substance), id
+`, patientID, status, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Allergy
+	for rows.Next() {
+		var a models.Allergy
+		if err := scanAllergy(rows, &a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) GetAllergy(ctx context.Context, patientID, id int) (*models.Allergy, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+allergyColumns+` FROM patient_allergies WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, id, patientID)
+	var a models.Allergy
+	if err := scanAllergy(row, &a); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("allergy not found")
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Storage) CreateAllergy(ctx context.Context, a *models.Allergy) (*models.Allergy, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO patient_allergies (patient_id, substance, atc_code, reaction, severity, status, notes, recorded_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, recorded_at, version
+`, a.PatientID, a.Substance, a.ATCCode, a.Reaction, a.Severity, a.Status, a.Notes, a.RecordedBy).
+		Scan(&a.ID, &a.RecordedAt, &a.Version)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// UpdateAllergy overwrites the row; who recorded it is kept. A non-zero
+// a.Version must match the stored version; on success a.Version holds the new version.
+func (s *Storage) UpdateAllergy(ctx context.Context, a *models.Allergy) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if err := sharePatient(ctx, tx, a.PatientID); err != nil {
+			return err
+		}
+		err := tx.QueryRow(ctx, `
+UPDATE patient_allergies SET substance=$1, atc_code=$2, reaction=$3, severity=$4, status=$5, notes=$6, version = version + 1
+WHERE id=$7 AND patient_id=$8 AND deleted_at IS NULL AND ($9 = 0 OR version = $9)
+RETURNING recorded_by, recorded_at, version
+`, a.Substance, a.ATCCode, a.Reaction, a.Severity, a.Status, a.Notes, a.ID, a.PatientID, a.Version).
+			Scan(&a.RecordedBy, &a.RecordedAt, &a.Version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return allergyMissingOrStale(ctx, tx, a.PatientID, a.ID)
+		}
+		return err
+	})
+}
+
+// DeleteAllergy soft-deletes the row; a non-zero version must match the stored one
+func (s *Storage) DeleteAllergy(ctx context.Context, patientID, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx, `
+UPDATE patient_allergies SET deleted_at = now(), deleted_by = $4, version = version + 1
+WHERE id=$1 AND patient_id=$2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3)
+`, id, patientID, version, by)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return allergyMissingOrStale(ctx, tx, patientID, id)
+		}
+		return nil
+	})
+}
+
+// allergyMissingOrStale is missingOrStale for an allergy that must belong to the patient
+func allergyMissingOrStale(ctx context.Context, tx pgx.Tx, patientID, id int) error {
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM patient_allergies WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL)`, id, patientID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("allergy not found")
+	}
+	return ErrVersionMismatch
+}
+
+// RestoreAllergy undoes DeleteAllergy; the patient must not be deleted
+func (s *Storage) RestoreAllergy(ctx context.Context, patientID, id int) (*models.Allergy, error) {
+	var a models.Allergy
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var deleted bool
+		err := tx.QueryRow(ctx, `SELECT deleted_at IS NOT NULL FROM patient_allergies WHERE id = $1 AND patient_id = $2 FOR UPDATE`, id, patientID).Scan(&deleted)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("allergy not found")
+		}
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("allergy is %w", ErrNotDeleted)
+		}
+		if err := requireLivePatient(ctx, tx, "patient_allergies", id); err != nil {
+			return err
+		}
+		if err := sharePatient(ctx, tx, patientID); err != nil {
+			return err
+		}
+		return scanAllergy(tx.QueryRow(ctx, `
+UPDATE patient_allergies SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+allergyColumns, id), &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrNotApplied marks items of an atomic batch that were rolled back because another item failed
+var ErrNotApplied = errors.New("not applied: another item of the batch failed")
+
+// IsConstraintViolation reports whether Postgres rejected a row (foreign key, unique, not null, check)
+func IsConstraintViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23")
+}
+
+// runBatch applies item to every index, each in its own savepoint. In atomic
+// mode the first failure rolls back the whole batch; otherwise the failed
+// items are skipped and the rest is committed. The result holds one error per
+// item (nil for applied items).
+func (s *Storage) runBatch(ctx context.Context, n int, atomic bool, bulk func(tx pgx.Tx) error, item func(tx pgx.Tx, i int) error) ([]error, error) {
+	errs := make([]error, n)
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if bulk != nil {
+			// fast path; on failure fall back to single rows to find the bad ones
+			if err := savepoint(ctx, tx, bulk); err == nil {
+				return nil
+			}
+		}
+
+		failed := false
+		for i := 0; i < n; i++ {
+			errs[i] = savepoint(ctx, tx, func(sp pgx.Tx) error { return item(sp, i) })
+			if errs[i] == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed = true
+			if atomic {
+				break
+			}
+		}
+		if failed && atomic {
+			return errBatchFailed
+		}
+		return nil
+	})
+
+	if errors.Is(err, errBatchFailed) {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = ErrNotApplied
+			}
+		}
+		return errs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+var errBatchFailed = errors.New("batch failed")
+
+func savepoint(ctx context.Context, tx pgx.Tx, fn func(sp pgx.Tx) error) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer sp.Rollback(ctx)
+
+	if err := fn(sp); err != nil {
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+// allocateIDs reserves n ids from the identity sequence of table so rows can be sent with COPY
+func allocateIDs(ctx context.Context, tx pgx.Tx, table string, n int) ([]int, error) {
+	rows, err := tx.Query(ctx, `SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`, table, n)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+//
+// --- Patients ---
+//
+
+// CreatePatients inserts patients with COPY and fills in their IDs
+func (s *Storage) CreatePatients(ctx context.Context, ps []*models.Patient, atomic bool) ([]error, error) {
+	bulk := func(tx pgx.Tx) error {
+		ids, err := allocateIDs(ctx, tx, "patients", len(ps))
+		if err != nil {
+			return err
+		}
+		rows := make([][]any, len(ps))
+		for i, p := range ps {
+			values := patientValues(p)
+			if p.DateOfBirth != "" {
+				born, err := time.Parse("2006-01-02", p.DateOfBirth)
+				if err != nil {
+					return err
+				}
+				values[2] = born
+			}
+			rows[i] = append([]any{ids[i]}, values...)
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"patients"},
+			append([]string{"id"}, patientFields...), pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+
+		// read back the generated MRNs and computed ages
+		fetched, err := tx.Query(ctx, `SELECT id, mrn, `+patientAge+` FROM patients WHERE id = ANY($1)`, ids)
+		if err != nil {
+			return err
+		}
+		byID := make(map[int]*models.Patient, len(ps))
+		for i, p := range ps {
+			p.ID, p.Version = ids[i], 1
+			byID[p.ID] = p
+		}
+		var id, age int
+		var mrn string
+		_, err = pgx.ForEachRow(fetched, []any{&id, &mrn, &age}, func() error {
+			byID[id].MRN, byID[id].Age = mrn, age
+			return nil
+		})
+		return err
+	}
+	return s.runBatch(ctx, len(ps), atomic, bulk, func(tx pgx.Tx, i int) error { return insertPatient(ctx, tx, ps[i]) })
+}
+
+// UpdatePatients overwrites patients; every item must carry its current version
+func (s *Storage) UpdatePatients(ctx context.Context, ps []*models.Patient, atomic bool) ([]error, error) {
+	return s.runBatch(ctx, len(ps), atomic, nil, func(tx pgx.Tx, i int) error { return updatePatient(ctx, tx, ps[i]) })
+}
+
+// DeletePatients soft-deletes patients; every item must carry its current version
+func (s *Storage) DeletePatients(ctx context.Context, refs []*models.BatchRef, atomic bool, by string) ([]error, error) {
+	return s.runBatch(ctx, len(refs), atomic, nil, func(tx pgx.Tx, i int) error {
+		return deletePatient(ctx, tx, refs[i].ID, refs[i].Version, by)
+	})
+}
+
+//
+// --- Doctors ---
+//
+
+// CreateDoctors inserts doctors with COPY and fills in their IDs
+func (s *Storage) CreateDoctors(ctx context.Context, ds []*models.Doctor, atomic bool) ([]error, error) {
+	bulk := func(tx pgx.Tx) error {
+		ids, err := allocateIDs(ctx, tx, "doctors", len(ds))
+		if err != nil {
+			return err
+		}
+		rows := make([][]any, len(ds))
+		for i, d := range ds {
+			rows[i] = []any{ids[i], d.FirstName, d.LastName, d.Specialization, d.Experience}
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"doctors"},
+			[]string{"id", "first_name", "last_name", "specialization", "experience"}, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		for i, d := range ds {
+			d.ID, d.Version, d.SpecialtyCode = ids[i], 1, specialtyCode(d.Specialization)
+		}
+		return nil
+	}
+	return s.runBatch(ctx, len(ds), atomic, bulk, func(tx pgx.Tx, i int) error { return insertDoctor(ctx, tx, ds[i]) })
+}
+
+// UpdateDoctors overwrites doctors; every item must carry its current version
+func (s *Storage) UpdateDoctors(ctx context.Context, ds []*models.Doctor, atomic bool) ([]error, error) {
+	return s.runBatch(ctx, len(ds), atomic, nil, func(tx pgx.Tx, i int) error { return updateDoctor(ctx, tx, ds[i]) })
+}
+
+// DeleteDoctors soft-deletes doctors; every item must carry its current version
+func (s *Storage) DeleteDoctors(ctx context.Context, refs []*models.BatchRef, atomic bool, by string) ([]error, error) {
+	return s.runBatch(ctx, len(refs), atomic, nil, func(tx pgx.Tx, i int) error {
+		return deleteRow(ctx, tx, "doctors", "doctor", refs[i].ID, refs[i].Version, by)
+	})
+}
+
+//
+// --- Appointments ---
+//
+
+// CreateAppointments inserts appointments and their initial status history with COPY;
+// completed ones get a draft invoice
+func (s *Storage) CreateAppointments(ctx context.Context, as []*models.Appointment, atomic bool) ([]error, error) {
+	bulk := func(tx pgx.Tx) error {
+		for _, a := range as {
+			if err := checkBookable(ctx, tx, a); err != nil {
+				return err
+			}
+		}
+		ids, err := allocateIDs(ctx, tx, "appointments", len(as))
+		if err != nil {
+			return err
+		}
+		rows := make([][]any, len(as))
+		history := make([][]any, len(as))
+		for i, a := range as {
+			date, err := time.Parse("2006-01-02", a.Date)
+			if err != nil {
+				return err
+			}
+			clock, err := copyTime(a.Time)
+			if err != nil {
+				return err
+			}
+			if a.PolicyID, err = coveringPolicy(ctx, tx, a.PatientID, a.Date); err != nil {
+				return err
+			}
+			var policyID *int
+			if a.PolicyID != 0 {
+				policyID = &a.PolicyID
+			}
+			rows[i] = []any{ids[i], a.PatientID, a.DoctorID, date, clock, a.Status, policyID}
+			history[i] = []any{ids[i], "", a.Status}
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"appointments"},
+			[]string{"id", "patient_id", "doctor_id", "date", "time", "status", "policy_id"}, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"appointment_status_history"},
+			[]string{"appointment_id", "old_status", "new_status"}, pgx.CopyFromRows(history)); err != nil {
+			return err
+		}
+		for i, a := range as {
+			a.ID, a.Version = ids[i], 1
+			if err := draftVisitInvoice(ctx, tx, a.ID, "", a.Status); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.runBatch(ctx, len(as), atomic, bulk, func(tx pgx.Tx, i int) error { return insertAppointment(ctx, tx, as[i]) })
+}
+
+// copyTime converts HH:MM[:SS] for the binary COPY protocol
+func copyTime(clock string) (pgtype.Time, error) {
+	if clock == "" {
+		return pgtype.Time{}, nil
+	}
+	layout := "15:04:05"
+	if len(clock) == len("15:04") {
+		layout = "15:04"
+	}
+	t, err := time.Parse(layout, clock)
+	if err != nil {
+		return pgtype.Time{}, err
+	}
+	since := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()))
+	return pgtype.Time{Microseconds: since.Microseconds(), Valid: true}, nil
+}
+
+// UpdateAppointments overwrites appointments; every item must carry its current version
+func (s *Storage) UpdateAppointments(ctx context.Context, as []*models.Appointment, atomic bool) ([]error, error) {
+	return s.runBatch(ctx, len(as), atomic, nil, func(tx pgx.Tx, i int) error { return updateAppointment(ctx, tx, as[i]) })
+}
+
+// DeleteAppointments soft-deletes appointments; every item must carry its current version
+func (s *Storage) DeleteAppointments(ctx context.Context, refs []*models.BatchRef, atomic bool, by string) ([]error, error) {
+	return s.runBatch(ctx, len(refs), atomic, nil, func(tx pgx.Tx, i int) error {
+		return deleteRow(ctx, tx, "appointments", "appointment", refs[i].ID, refs[i].Version, by)
+	})
+}
@@ -0,0 +1,622 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// BillingCurrency is the ISO 4217 code new invoices are made out in
+var BillingCurrency = "UAH"
+
+// PaymentTermDays is how long after issuing an invoice is due when no due date was set
+var PaymentTermDays = 14
+
+// ErrInvoiceNotDraft is returned when changing the lines or terms of an issued or void invoice
+var ErrInvoiceNotDraft = errors.New("the invoice is no longer a draft")
+
+// ErrInvoiceNotOpen is returned when paying an invoice that is not issued, or refunding one never paid
+var ErrInvoiceNotOpen = errors.New("the invoice is not open for payment")
+
+// ErrAmountExceeded is returned for payments over the balance and refunds over what was paid
+var ErrAmountExceeded = errors.New("the amount exceeds what the invoice allows")
+
+// ErrInvoicePaid is returned when voiding an invoice with money still paid on it
+var ErrInvoicePaid = errors.New("the invoice has payments; refund them first")
+
+//
+// --- Services catalog ---
+//
+
+const serviceColumns = `id, code, name, unit_price, specialty, visit_fee, active, version`
+
+func scanService(row pgx.Row, sv *models.Service) error {
+	return row.Scan(&sv.ID, &sv.Code, &sv.Name, &sv.UnitPrice, &sv.Specialty, &sv.VisitFee, &sv.Active, &sv.Version)
+}
+
+// serviceConflict turns a unique violation on the code into ErrDuplicate
+func serviceConflict(err error, sv *models.Service) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "services_code_key" {
+		return fmt.Errorf("%w: service %s already exists", ErrDuplicate, sv.Code)
+	}
+	return err
+}
+
+func queryServices(ctx context.Context, q querier, where string, args ...any) ([]models.Service, error) {
+	rows, err := q.Query(ctx, `SELECT `+serviceColumns+` FROM services WHERE `+where+` ORDER BY code`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Service
+	for rows.Next() {
+		var sv models.Service
+		if err := scanService(rows, &sv); err != nil {
+			return nil, err
+		}
+		out = append(out, sv)
+	}
+	return out, rows.Err()
+}
+
+// GetServices lists the catalog by code; inactive services only with includeInactive
+func (s *Storage) GetServices(ctx context.Context, includeInactive bool) ([]models.Service, error) {
+	return queryServices(ctx, s.pool, `$1 OR active`, includeInactive)
+}
+
+func getService(ctx context.Context, q querier, id int) (*models.Service, error) {
+	services, err := queryServices(ctx, q, `id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("service not found")
+	}
+	return &services[0], nil
+}
+
+func (s *Storage) GetService(ctx context.Context, id int) (*models.Service, error) {
+	return getService(ctx, s.pool, id)
+}
+
+func (s *Storage) CreateService(ctx context.Context, sv *models.Service) (*models.Service, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO services (code, name, unit_price, specialty, visit_fee, active)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, version
+`, sv.Code, sv.Name, sv.UnitPrice, sv.Specialty, sv.VisitFee, sv.Active).Scan(&sv.ID, &sv.Version)
+	if err != nil {
+		return nil, serviceConflict(err, sv)
+	}
+	return sv, nil
+}
+
+// UpdateService overwrites the row; invoice lines already billed keep their price.
+// A non-zero sv.Version must match the stored version; on success sv.Version holds the new version.
+func (s *Storage) UpdateService(ctx context.Context, sv *models.Service) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE services SET code=$1, name=$2, unit_price=$3, specialty=$4, visit_fee=$5, active=$6, version = version + 1
+WHERE id=$7 AND ($8 = 0 OR version = $8)
+RETURNING version
+`, sv.Code, sv.Name, sv.UnitPrice, sv.Specialty, sv.VisitFee, sv.Active, sv.ID, sv.Version).Scan(&sv.Version)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return serviceConflict(err, sv)
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM services WHERE id = $1)`, sv.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("service not found")
+		}
+		return ErrVersionMismatch
+	})
+}
+
+//
+// --- Invoices ---
+//
+
+const invoiceSelect = `SELECT i.id, COALESCE(i.number, ''), i.patient_id, p.first_name || ' ' || p.last_name,
+COALESCE(i.appointment_id, 0), i.status, i.currency, i.subtotal, i.discount, i.total, i.paid,
+COALESCE(TO_CHAR(i.due_date, 'YYYY-MM-DD'), ''), i.notes, i.created_by, i.created_at, i.issued_at, i.voided_at, i.version
+FROM invoices i
+JOIN patients p ON p.id = i.patient_id
+`
+
+// queryInvoices loads invoices without their lines and payments, the latest first
+func queryInvoices(ctx context.Context, q querier, sql string, args ...any) ([]models.Invoice, error) {
+	rows, err := q.Query(ctx, sql+`
+ORDER BY i.created_at DESC, i.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Invoice
+	for rows.Next() {
+		var inv models.Invoice
+		if err := rows.Scan(&inv.ID, &inv.Number, &inv.PatientID, &inv.PatientName, &inv.AppointmentID, &inv.Status, &inv.Currency,
+			&inv.Subtotal, &inv.Discount, &inv.Total, &inv.Paid, &inv.DueDate, &inv.Notes, &inv.CreatedBy, &inv.CreatedAt,
+			&inv.IssuedAt, &inv.VoidedAt, &inv.Version); err != nil {
+			return nil, err
+		}
+		inv.Balance = inv.Total - inv.Paid
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+// getInvoice loads a live invoice with its lines and payments
+func getInvoice(ctx context.Context, q querier, id int) (*models.Invoice, error) {
+	invoices, err := queryInvoices(ctx, q, invoiceSelect+`WHERE i.id = $1 AND i.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, fmt.Errorf("invoice not found")
+	}
+	inv := &invoices[0]
+
+	rows, err := q.Query(ctx, `
+SELECT id, COALESCE(service_id, 0), code, description, quantity, unit_price, discount_percent, amount
+FROM invoice_lines WHERE invoice_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inv.Lines = []models.InvoiceLine{}
+	for rows.Next() {
+		var l models.InvoiceLine
+		if err := rows.Scan(&l.ID, &l.ServiceID, &l.Code, &l.Description, &l.Quantity, &l.UnitPrice, &l.DiscountPercent, &l.Amount); err != nil {
+			return nil, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(ctx, `
+SELECT id, invoice_id, kind, amount, method, reference, received_at, received_by
+FROM invoice_payments WHERE invoice_id = $1 ORDER BY received_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inv.Payments = []models.Payment{}
+	for rows.Next() {
+		var p models.Payment
+		if err := rows.Scan(&p.ID, &p.InvoiceID, &p.Kind, &p.Amount, &p.Method, &p.Reference, &p.ReceivedAt, &p.ReceivedBy); err != nil {
+			return nil, err
+		}
+		inv.Payments = append(inv.Payments, p)
+	}
+	return inv, rows.Err()
+}
+
+func (s *Storage) GetInvoice(ctx context.Context, id int) (*models.Invoice, error) {
+	return getInvoice(ctx, s.pool, id)
+}
+
+// GetInvoices lists invoices without lines, the latest first, narrowed to a
+// patient, an appointment and a status when those are not zero
+func (s *Storage) GetInvoices(ctx context.Context, patientID, appointmentID int, status string) ([]models.Invoice, error) {
+	return queryInvoices(ctx, s.pool, invoiceSelect+`WHERE i.deleted_at IS NULL AND ($1 = 0 OR i.patient_id = $1)
+AND ($2 = 0 OR i.appointment_id = $2) AND ($3 = '' OR i.status = $3)`, patientID, appointmentID, status)
+}
+
+// invoiceConflict turns a second live invoice for an appointment into ErrDuplicate
+func invoiceConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "invoices_appointment_key" {
+		return fmt.Errorf("%w: the appointment is already invoiced", ErrDuplicate)
+	}
+	return err
+}
+
+// insertInvoice adds a draft with its lines
+func insertInvoice(ctx context.Context, tx pgx.Tx, inv *models.Invoice) (int, error) {
+	var appointmentID *int
+	if inv.AppointmentID != 0 {
+		appointmentID = &inv.AppointmentID
+	}
+	var dueDate *string
+	if inv.DueDate != "" {
+		dueDate = &inv.DueDate
+	}
+	var id int
+	err := tx.QueryRow(ctx, `
+INSERT INTO invoices (patient_id, appointment_id, currency, discount, due_date, notes, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`, inv.PatientID, appointmentID, BillingCurrency, inv.Discount, dueDate, inv.Notes, inv.CreatedBy).Scan(&id)
+	if err != nil {
+		return 0, invoiceConflict(err)
+	}
+	for i := range inv.Lines {
+		if err := insertInvoiceLine(ctx, tx, id, &inv.Lines[i]); err != nil {
+			return 0, err
+		}
+	}
+	return id, refreshInvoiceTotals(ctx, tx, id)
+}
+
+// CreateInvoice adds a draft invoice
+func (s *Storage) CreateInvoice(ctx context.Context, inv *models.Invoice) (*models.Invoice, error) {
+	var out *models.Invoice
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		id, err := insertInvoice(ctx, tx, inv)
+		if err != nil {
+			return err
+		}
+		out, err = getInvoice(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// insertInvoiceLine prices a line from the catalog when it names a service and adds it
+func insertInvoiceLine(ctx context.Context, tx pgx.Tx, invoiceID int, l *models.InvoiceLine) error {
+	var serviceID *int
+	if l.ServiceID != 0 {
+		sv, err := getService(ctx, tx, l.ServiceID)
+		if err != nil {
+			return err
+		}
+		if !sv.Active {
+			return fmt.Errorf("service %s is not active", sv.Code)
+		}
+		serviceID = &l.ServiceID
+		l.Code, l.UnitPrice = sv.Code, sv.UnitPrice
+		if l.Description == "" {
+			l.Description = sv.Name
+		}
+	}
+	l.Compute()
+	return tx.QueryRow(ctx, `
+INSERT INTO invoice_lines (invoice_id, service_id, code, description, quantity, unit_price, discount_percent, amount)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id
+`, invoiceID, serviceID, l.Code, l.Description, l.Quantity, l.UnitPrice, l.DiscountPercent, l.Amount).Scan(&l.ID)
+}
+
+// refreshInvoiceTotals sums the lines; the discount cannot take the total below zero
+func refreshInvoiceTotals(ctx context.Context, tx pgx.Tx, id int) error {
+	_, err := tx.Exec(ctx, `
+UPDATE invoices SET subtotal = t.subtotal, total = GREATEST(t.subtotal - discount, 0)
+FROM (SELECT COALESCE(SUM(amount), 0) AS subtotal FROM invoice_lines WHERE invoice_id = $1) t
+WHERE id = $1`, id)
+	return err
+}
+
+// lockInvoice locks a live invoice and returns its status and net paid amount;
+// a non-zero version must match the stored one
+func lockInvoice(ctx context.Context, tx pgx.Tx, id, version int) (status string, total, paid int64, err error) {
+	var current int
+	err = tx.QueryRow(ctx, `SELECT status, total, paid, version FROM invoices WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).
+		Scan(&status, &total, &paid, &current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, 0, fmt.Errorf("invoice not found")
+	}
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if version != 0 && current != version {
+		return "", 0, 0, ErrVersionMismatch
+	}
+	return status, total, paid, nil
+}
+
+// changeDraft runs change on a locked draft, refreshes the totals and bumps the version
+func (s *Storage) changeDraft(ctx context.Context, id, version int, change func(tx pgx.Tx) error) (*models.Invoice, error) {
+	var out *models.Invoice
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		status, _, _, err := lockInvoice(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if status != models.InvoiceDraft {
+			return fmt.Errorf("%w: it is %s", ErrInvoiceNotDraft, status)
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		if err := refreshInvoiceTotals(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE invoices SET version = version + 1 WHERE id = $1`, id); err != nil {
+			return err
+		}
+		out, err = getInvoice(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateInvoice sets the discount, due date and notes of a draft
+func (s *Storage) UpdateInvoice(ctx context.Context, id, version int, discount int64, dueDate, notes string) (*models.Invoice, error) {
+	return s.changeDraft(ctx, id, version, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `UPDATE invoices SET discount = $2, due_date = NULLIF($3, '')::date, notes = $4 WHERE id = $1`,
+			id, discount, dueDate, notes)
+		return err
+	})
+}
+
+// AddInvoiceLine adds a line to a draft
+func (s *Storage) AddInvoiceLine(ctx context.Context, id, version int, l *models.InvoiceLine) (*models.Invoice, error) {
+	return s.changeDraft(ctx, id, version, func(tx pgx.Tx) error { return insertInvoiceLine(ctx, tx, id, l) })
+}
+
+// DeleteInvoiceLine removes a line from a draft
+func (s *Storage) DeleteInvoiceLine(ctx context.Context, id, lineID, version int) (*models.Invoice, error) {
+	return s.changeDraft(ctx, id, version, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx, `DELETE FROM invoice_lines WHERE id = $1 AND invoice_id = $2`, lineID, id)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return fmt.Errorf("invoice line not found")
+		}
+		return nil
+	})
+}
+
+// IssueInvoice numbers a draft with lines and opens it for payment; the due
+// date defaults to PaymentTermDays later and an invoice of nothing is paid at once
+func (s *Storage) IssueInvoice(ctx context.Context, id, version int) (*models.Invoice, error) {
+	var out *models.Invoice
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		status, total, _, err := lockInvoice(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if status != models.InvoiceDraft {
+			return fmt.Errorf("%w: it is %s", ErrInvoiceNotDraft, status)
+		}
+		var lines int
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM invoice_lines WHERE invoice_id = $1`, id).Scan(&lines); err != nil {
+			return err
+		}
+		if lines == 0 {
+			return fmt.Errorf("%w: add a line before issuing", ErrInvoiceNotDraft)
+		}
+		issued := models.InvoiceIssued
+		if total == 0 {
+			issued = models.InvoicePaid
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE invoices SET status = $2, issued_at = now(), due_date = COALESCE(due_date, CURRENT_DATE + $3::integer),
+    number = 'INV-' || TO_CHAR(now(), 'YYYY') || '-' || LPAD(nextval('invoice_number_seq')::text, 6, '0'),
+    version = version + 1
+WHERE id = $1`, id, issued, PaymentTermDays); err != nil {
+			return err
+		}
+		out, err = getInvoice(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VoidInvoice cancels an invoice nothing is paid on; its appointment can be
+// invoiced again. Its claims not sent yet are rejected; one the payer has
+// refuses the void with ErrInvoiceClaimed.
+func (s *Storage) VoidInvoice(ctx context.Context, id, version int) (*models.Invoice, error) {
+	var out *models.Invoice
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		status, _, paid, err := lockInvoice(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if status == models.InvoiceVoid {
+			return fmt.Errorf("%w: it is already void", ErrInvoiceNotOpen)
+		}
+		if paid != 0 {
+			return ErrInvoicePaid
+		}
+		if err := rejectVoidedClaims(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE invoices SET status = $2, voided_at = now(), version = version + 1 WHERE id = $1`,
+			id, models.InvoiceVoid); err != nil {
+			return err
+		}
+		out, err = getInvoice(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RecordPayment records a payment against the balance of an issued invoice,
+// which is paid once nothing is left, or a refund of money paid, which opens
+// it again
+func (s *Storage) RecordPayment(ctx context.Context, id, version int, p *models.Payment) (*models.Invoice, error) {
+	var out *models.Invoice
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if err := recordPayment(ctx, tx, id, version, p); err != nil {
+			return err
+		}
+		var err error
+		out, err = getInvoice(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func recordPayment(ctx context.Context, tx pgx.Tx, id, version int, p *models.Payment) error {
+	status, total, paid, err := lockInvoice(ctx, tx, id, version)
+	if err != nil {
+		return err
+	}
+	if status, paid, err = applyPayment(status, total, paid, p); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+INSERT INTO invoice_payments (invoice_id, kind, amount, method, reference, received_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, id, p.Kind, p.Amount, p.Method, p.Reference, p.ReceivedBy); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE invoices SET paid = $2, status = $3, version = version + 1 WHERE id = $1`, id, paid, status)
+	return err
+}
+
+// applyPayment returns the status and paid amount of an invoice after p
+func applyPayment(status string, total, paid int64, p *models.Payment) (string, int64, error) {
+	switch p.Kind {
+	case models.PaymentReceived:
+		if status != models.InvoiceIssued {
+			return "", 0, fmt.Errorf("%w: it is %s", ErrInvoiceNotOpen, status)
+		}
+		if p.Amount > total-paid {
+			return "", 0, fmt.Errorf("%w: the balance is %d", ErrAmountExceeded, total-paid)
+		}
+		paid += p.Amount
+	case models.PaymentRefund:
+		if status != models.InvoiceIssued && status != models.InvoicePaid {
+			return "", 0, fmt.Errorf("%w: it is %s", ErrInvoiceNotOpen, status)
+		}
+		if p.Amount > paid {
+			return "", 0, fmt.Errorf("%w: %d is paid", ErrAmountExceeded, paid)
+		}
+		paid -= p.Amount
+	default:
+		return "", 0, fmt.Errorf("unknown payment kind %q", p.Kind)
+	}
+	status = models.InvoiceIssued
+	if paid == total {
+		status = models.InvoicePaid
+	}
+	return status, paid, nil
+}
+
+// completed reports whether an appointment status means the visit took place
+func completed(status string) bool {
+	status = strings.ToLower(status)
+	return status == "completed" || status == "finished"
+}
+
+// draftVisitInvoice opens a draft invoice for an appointment that has just
+// been completed, charging the visit fee for the doctor's specialty or else
+// the general one. An appointment invoiced before is left alone.
+func draftVisitInvoice(ctx context.Context, tx pgx.Tx, appointmentID int, from, to string) error {
+	if !completed(to) || completed(from) {
+		return nil
+	}
+	var invoiced bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM invoices WHERE appointment_id = $1 AND status <> 'void' AND deleted_at IS NULL)`,
+		appointmentID).Scan(&invoiced)
+	if err != nil || invoiced {
+		return err
+	}
+
+	inv := models.Invoice{AppointmentID: appointmentID, CreatedBy: "system"}
+	var specialization string
+	err = tx.QueryRow(ctx, `
+SELECT a.patient_id, COALESCE(d.specialization, '')
+FROM appointments a
+LEFT JOIN doctors d ON d.id = a.doctor_id
+WHERE a.id = $1`, appointmentID).Scan(&inv.PatientID, &specialization)
+	if err != nil {
+		return err
+	}
+	var serviceID int
+	err = tx.QueryRow(ctx, `
+SELECT id FROM services
+WHERE active AND visit_fee AND specialty IN ($1, '')
+ORDER BY specialty = '', id
+LIMIT 1`, specialtyCode(specialization)).Scan(&serviceID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if serviceID != 0 {
+		inv.Lines = []models.InvoiceLine{{ServiceID: serviceID, Quantity: 1}}
+	}
+	_, err = insertInvoice(ctx, tx, &inv)
+	return err
+}
+
+//
+// --- Balances ---
+//
+
+// balanceSelect sums the issued and paid invoices of each patient
+const balanceSelect = `SELECT p.id, p.first_name || ' ' || p.last_name,
+SUM(i.total), SUM(i.paid), SUM(i.total - i.paid)
+FROM invoices i
+JOIN patients p ON p.id = i.patient_id
+WHERE i.status IN ('issued', 'paid') AND i.deleted_at IS NULL
+`
+
+func queryBalances(ctx context.Context, q querier, sql string, args ...any) ([]models.PatientBalance, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.PatientBalance
+	for rows.Next() {
+		b := models.PatientBalance{Currency: BillingCurrency}
+		if err := rows.Scan(&b.PatientID, &b.PatientName, &b.Invoiced, &b.Paid, &b.Balance); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// GetPatientBalance sums what a patient was invoiced and paid and lists the unpaid invoices
+func (s *Storage) GetPatientBalance(ctx context.Context, patientID int) (*models.PatientBalance, error) {
+	balances, err := queryBalances(ctx, s.pool, balanceSelect+`AND i.patient_id = $1
+GROUP BY p.id`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	b := models.PatientBalance{PatientID: patientID, Currency: BillingCurrency}
+	if len(balances) > 0 {
+		b = balances[0]
+	} else if err := s.pool.QueryRow(ctx, `SELECT first_name || ' ' || last_name FROM patients WHERE id = $1 AND deleted_at IS NULL`,
+		patientID).Scan(&b.PatientName); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("patient not found")
+		}
+		return nil, err
+	}
+	b.Invoices, err = queryInvoices(ctx, s.pool, invoiceSelect+`WHERE i.patient_id = $1 AND i.status = 'issued' AND i.deleted_at IS NULL`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetOutstandingBalances lists the patients who owe money, the largest balance first
+func (s *Storage) GetOutstandingBalances(ctx context.Context) ([]models.PatientBalance, error) {
+	return queryBalances(ctx, s.pool, balanceSelect+`GROUP BY p.id
+HAVING SUM(i.total - i.paid) > 0
+ORDER BY SUM(i.total - i.paid) DESC, p.id`)
+}
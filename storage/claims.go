@@ -0,0 +1,480 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrNotClaimable is returned when an invoice cannot be claimed: it is not
+// issued, no policy covers the visit or the policy covers nothing
+var ErrNotClaimable = errors.New("the invoice cannot be claimed")
+
+// ErrClaimTransition is returned for a status change models.ClaimTransitions does not allow
+var ErrClaimTransition = errors.New("the claim cannot move to that status")
+
+// ErrInvoiceClaimed is returned when voiding an invoice a payer has a claim on
+var ErrInvoiceClaimed = errors.New("the invoice is claimed; the payer must reject the claim first")
+
+// maxDiagnoses is how many diagnosis codes a claim carries
+const maxDiagnoses = 12
+
+const claimSelect = `SELECT c.id, c.number, c.patient_id, p.first_name || ' ' || p.last_name, COALESCE(c.policy_id, 0),
+c.payer_id, pay.name, c.member_id, c.group_number, c.invoice_id, COALESCE(c.appointment_id, 0),
+TO_CHAR(c.service_date, 'YYYY-MM-DD'), c.doctor_name, c.diagnoses, c.total_charge, c.copay, c.claimed_amount,
+c.paid_amount, c.status, c.status_note, c.created_by, c.created_at, c.submitted_at, c.version, c.deleted_at, c.deleted_by
+FROM claims c
+JOIN patients p ON p.id = c.patient_id
+JOIN insurance_payers pay ON pay.id = c.payer_id
+`
+
+// queryClaims loads claims without their lines and history, the latest first
+func queryClaims(ctx context.Context, q querier, sql string, args ...any) ([]models.Claim, error) {
+	rows, err := q.Query(ctx, sql+`
+ORDER BY c.created_at DESC, c.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Claim
+	for rows.Next() {
+		var c models.Claim
+		if err := rows.Scan(&c.ID, &c.Number, &c.PatientID, &c.PatientName, &c.PolicyID,
+			&c.PayerID, &c.PayerName, &c.MemberID, &c.GroupNumber, &c.InvoiceID, &c.AppointmentID,
+			&c.ServiceDate, &c.DoctorName, &c.Diagnoses, &c.TotalCharge, &c.Copay, &c.ClaimedAmount,
+			&c.PaidAmount, &c.Status, &c.StatusNote, &c.CreatedBy, &c.CreatedAt, &c.SubmittedAt, &c.Version, &c.DeletedAt, &c.DeletedBy); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// loadClaimLines fills in the lines of claims
+func loadClaimLines(ctx context.Context, q querier, claims []models.Claim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+	index := map[int]int{}
+	ids := make([]int, len(claims))
+	for i := range claims {
+		index[claims[i].ID], ids[i] = i, claims[i].ID
+		claims[i].Lines = []models.ClaimLine{}
+	}
+	rows, err := q.Query(ctx, `
+SELECT claim_id, code, description, quantity, unit_price, amount
+FROM claim_lines WHERE claim_id = ANY($1) ORDER BY id`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var claimID int
+		var l models.ClaimLine
+		if err := rows.Scan(&claimID, &l.Code, &l.Description, &l.Quantity, &l.UnitPrice, &l.Amount); err != nil {
+			return err
+		}
+		c := &claims[index[claimID]]
+		c.Lines = append(c.Lines, l)
+	}
+	return rows.Err()
+}
+
+// getClaim loads a live claim with its lines and status history
+func getClaim(ctx context.Context, q querier, id int) (*models.Claim, error) {
+	claims, err := queryClaims(ctx, q, claimSelect+`WHERE c.id = $1 AND c.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		return nil, fmt.Errorf("claim not found")
+	}
+	if err := loadClaimLines(ctx, q, claims); err != nil {
+		return nil, err
+	}
+	c := &claims[0]
+
+	rows, err := q.Query(ctx, `
+SELECT old_status, new_status, note, paid_amount, changed_by, changed_at
+FROM claim_status_history WHERE claim_id = $1 ORDER BY changed_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c.History = []models.ClaimStatusChange{}
+	for rows.Next() {
+		var h models.ClaimStatusChange
+		if err := rows.Scan(&h.From, &h.Status, &h.Note, &h.PaidAmount, &h.ChangedBy, &h.ChangedAt); err != nil {
+			return nil, err
+		}
+		c.History = append(c.History, h)
+	}
+	return c, rows.Err()
+}
+
+func (s *Storage) GetClaim(ctx context.Context, id int) (*models.Claim, error) {
+	return getClaim(ctx, s.pool, id)
+}
+
+// GetClaims lists claims without lines, the latest first, narrowed to a
+// patient, a payer and a status when those are not zero
+func (s *Storage) GetClaims(ctx context.Context, patientID, payerID int, status string) ([]models.Claim, error) {
+	return queryClaims(ctx, s.pool, claimSelect+`WHERE c.deleted_at IS NULL AND ($1 = 0 OR c.patient_id = $1)
+AND ($2 = 0 OR c.payer_id = $2) AND ($3 = '' OR c.status = $3)`, patientID, payerID, status)
+}
+
+// GetClaimsForExport lists the claims of a payer in a status with their lines, in number order
+func (s *Storage) GetClaimsForExport(ctx context.Context, payerID int, status string) ([]models.Claim, error) {
+	claims, err := queryClaims(ctx, s.pool, claimSelect+`WHERE c.deleted_at IS NULL AND c.payer_id = $1 AND c.status = $2`, payerID, status)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(claims)
+	return claims, loadClaimLines(ctx, s.pool, claims)
+}
+
+// claimConflict turns a second claim for an invoice into ErrDuplicate
+func claimConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "claims_invoice_key" {
+		return fmt.Errorf("%w: the invoice is already claimed", ErrDuplicate)
+	}
+	return err
+}
+
+// recordClaimStatus keeps the status history of a claim
+func recordClaimStatus(ctx context.Context, tx pgx.Tx, claimID int, h *models.ClaimStatusChange) error {
+	_, err := tx.Exec(ctx, `
+INSERT INTO claim_status_history (claim_id, old_status, new_status, note, paid_amount, changed_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, claimID, h.From, h.Status, h.Note, h.PaidAmount, h.ChangedBy)
+	return err
+}
+
+// insertClaim claims the payer's share of an issued invoice under the policy
+// found when the visit was booked, or else the one covering the service date.
+// The payer is asked for coverage_percent of the charges after the copay.
+func insertClaim(ctx context.Context, tx pgx.Tx, invoiceID int, by string) (int, error) {
+	inv, err := getInvoice(ctx, tx, invoiceID)
+	if err != nil {
+		return 0, err
+	}
+	if inv.Status != models.InvoiceIssued && inv.Status != models.InvoicePaid {
+		return 0, fmt.Errorf("%w: the invoice is %s", ErrNotClaimable, inv.Status)
+	}
+
+	var serviceDate, doctorName string
+	var policyID int
+	if inv.AppointmentID != 0 {
+		err := tx.QueryRow(ctx, `
+SELECT TO_CHAR(a.date, 'YYYY-MM-DD'), COALESCE(a.policy_id, 0), COALESCE(d.first_name || ' ' || d.last_name, '')
+FROM appointments a
+LEFT JOIN doctors d ON d.id = a.doctor_id
+WHERE a.id = $1`, inv.AppointmentID).Scan(&serviceDate, &policyID, &doctorName)
+		if err != nil {
+			return 0, err
+		}
+	} else if inv.IssuedAt != nil {
+		serviceDate = inv.IssuedAt.In(ScheduleLocation).Format("2006-01-02")
+	}
+
+	var policy *models.Policy
+	if policyID != 0 {
+		// the policy may have been deleted since the booking
+		if policy, err = getPolicy(ctx, tx, policyID); err != nil && !strings.Contains(err.Error(), "not found") {
+			return 0, err
+		}
+	}
+	if policy == nil {
+		if policyID, err = coveringPolicy(ctx, tx, inv.PatientID, serviceDate); err != nil {
+			return 0, err
+		}
+		if policyID == 0 {
+			return 0, fmt.Errorf("%w: no insurance policy covers %s", ErrNotClaimable, serviceDate)
+		}
+		if policy, err = getPolicy(ctx, tx, policyID); err != nil {
+			return 0, err
+		}
+	}
+
+	copay := min(policy.Copay, inv.Total)
+	claimed := ((inv.Total-copay)*int64(policy.CoveragePercent) + 50) / 100
+	if claimed == 0 {
+		return 0, fmt.Errorf("%w: policy %s covers nothing of it", ErrNotClaimable, policy.MemberID)
+	}
+
+	// the ICD-10 codes of the problems diagnosed at the visit by the service
+	// date, in the order they were recorded; the first is the principal one
+	diagnoses := []string{}
+	if inv.AppointmentID != 0 {
+		rows, err := tx.Query(ctx, `
+SELECT code FROM patient_problems
+WHERE patient_id = $1 AND appointment_id = $2 AND deleted_at IS NULL
+  AND (recorded_at AT TIME ZONE $4)::date <= $3::date
+GROUP BY code
+ORDER BY min(recorded_at), code
+LIMIT $5`, inv.PatientID, inv.AppointmentID, serviceDate, ScheduleLocation.String(), maxDiagnoses)
+		if err != nil {
+			return 0, err
+		}
+		if diagnoses, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+			return 0, err
+		}
+		if diagnoses == nil {
+			diagnoses = []string{}
+		}
+	}
+
+	var appointmentID *int
+	if inv.AppointmentID != 0 {
+		appointmentID = &inv.AppointmentID
+	}
+	var id int
+	err = tx.QueryRow(ctx, `
+INSERT INTO claims (number, patient_id, policy_id, payer_id, member_id, group_number, invoice_id, appointment_id,
+    service_date, doctor_name, diagnoses, total_charge, copay, claimed_amount, created_by)
+VALUES ('CLM-' || TO_CHAR(now(), 'YYYY') || '-' || LPAD(nextval('claim_number_seq')::text, 6, '0'),
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+RETURNING id
+`, inv.PatientID, policy.ID, policy.PayerID, policy.MemberID, policy.GroupNumber, inv.ID, appointmentID,
+		serviceDate, doctorName, diagnoses, inv.Total, copay, claimed, by).Scan(&id)
+	if err != nil {
+		return 0, claimConflict(err)
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO claim_lines (claim_id, code, description, quantity, unit_price, amount)
+SELECT $1, code, description, quantity, unit_price, amount FROM invoice_lines WHERE invoice_id = $2 ORDER BY id
+`, id, inv.ID); err != nil {
+		return 0, err
+	}
+	return id, recordClaimStatus(ctx, tx, id, &models.ClaimStatusChange{Status: models.ClaimReady, ChangedBy: by})
+}
+
+// CreateClaim claims an issued invoice
+func (s *Storage) CreateClaim(ctx context.Context, invoiceID int, by string) (*models.Claim, error) {
+	var out *models.Claim
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		id, err := insertClaim(ctx, tx, invoiceID, by)
+		if err != nil {
+			return err
+		}
+		out, err = getClaim(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GenerateClaims claims the invoices of the visits completed between from
+// and to (YYYY-MM-DD, inclusive) that are not claimed yet; invoices that
+// cannot be claimed are reported as skipped
+func (s *Storage) GenerateClaims(ctx context.Context, from, to, by string) (*models.ClaimRun, error) {
+	out := &models.ClaimRun{Created: []models.Claim{}, Skipped: []models.ClaimSkip{}}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT i.id, a.id
+FROM invoices i
+JOIN appointments a ON a.id = i.appointment_id
+WHERE i.deleted_at IS NULL AND i.status <> 'void' AND a.deleted_at IS NULL
+  AND lower(COALESCE(a.status, '')) IN ('completed', 'finished')
+  AND a.date BETWEEN $1::date AND $2::date
+  AND NOT EXISTS (SELECT 1 FROM claims c WHERE c.invoice_id = i.id AND c.deleted_at IS NULL)
+ORDER BY a.date, a.id`, from, to)
+		if err != nil {
+			return err
+		}
+		type visit struct{ invoiceID, appointmentID int }
+		visits, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (visit, error) {
+			var v visit
+			err := row.Scan(&v.invoiceID, &v.appointmentID)
+			return v, err
+		})
+		if err != nil {
+			return err
+		}
+
+		var ids []int
+		for _, v := range visits {
+			var id int
+			err := savepoint(ctx, tx, func(sp pgx.Tx) error {
+				var err error
+				id, err = insertClaim(ctx, sp, v.invoiceID, by)
+				return err
+			})
+			switch {
+			case err == nil:
+				ids = append(ids, id)
+			case errors.Is(err, ErrNotClaimable):
+				out.Skipped = append(out.Skipped, models.ClaimSkip{InvoiceID: v.invoiceID, AppointmentID: v.appointmentID, Reason: err.Error()})
+			default:
+				return err
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		created, err := queryClaims(ctx, tx, claimSelect+`WHERE c.id = ANY($1)`, ids)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(created)
+		out.Created = created
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// remittancePayments are the invoice payments posting remitted money of a
+// claim on an invoice with total and paid: the insurance payment, preceded,
+// when the patient has already paid more than their share, by a refund of
+// the difference to the patient
+func remittancePayments(c *models.Claim, total, paid, remitted int64, by string) []models.Payment {
+	var payments []models.Payment
+	if over := remitted - (total - paid); over > 0 {
+		payments = append(payments, models.Payment{Kind: models.PaymentRefund, Amount: over, Method: "transfer",
+			Reference: "overpaid, covered by " + c.Number, ReceivedBy: by})
+	}
+	return append(payments, models.Payment{Kind: models.PaymentReceived, Amount: remitted, Method: "insurance",
+		Reference: c.Number, ReceivedBy: by})
+}
+
+// changeClaimStatus moves a locked claim to h.Status. A paid claim posts the
+// remittance to its invoice as an insurance payment, refunding the patient
+// what they paid up front of the insured share.
+func changeClaimStatus(ctx context.Context, tx pgx.Tx, c *models.Claim, h *models.ClaimStatusChange) error {
+	if !slices.Contains(models.ClaimTransitions[c.Status], h.Status) {
+		return fmt.Errorf("%w: it is %s", ErrClaimTransition, c.Status)
+	}
+	h.From = c.Status
+	if h.Status == models.ClaimReady {
+		status, _, _, err := lockInvoice(ctx, tx, c.InvoiceID, 0)
+		if err != nil {
+			return err
+		}
+		if status != models.InvoiceIssued && status != models.InvoicePaid {
+			return fmt.Errorf("%w: the invoice is %s", ErrNotClaimable, status)
+		}
+	}
+	if h.Status == models.ClaimPaid {
+		if h.PaidAmount > c.ClaimedAmount {
+			return fmt.Errorf("%w: %d was claimed", ErrAmountExceeded, c.ClaimedAmount)
+		}
+		_, total, paid, err := lockInvoice(ctx, tx, c.InvoiceID, 0)
+		if err != nil {
+			return err
+		}
+		for _, payment := range remittancePayments(c, total, paid, h.PaidAmount, h.ChangedBy) {
+			if err := recordPayment(ctx, tx, c.InvoiceID, 0, &payment); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+UPDATE claims SET status = $2, status_note = $3, paid_amount = paid_amount + $4,
+    submitted_at = CASE WHEN $2 = 'submitted' THEN now() ELSE submitted_at END, version = version + 1
+WHERE id = $1`, c.ID, h.Status, h.Note, h.PaidAmount); err != nil {
+		return err
+	}
+	return recordClaimStatus(ctx, tx, c.ID, h)
+}
+
+// rejectVoidedClaims rejects the claims of an invoice being voided that were
+// not sent yet; claims the payer has are refused with ErrInvoiceClaimed
+func rejectVoidedClaims(ctx context.Context, tx pgx.Tx, invoiceID int) error {
+	claims, err := queryClaims(ctx, tx, claimSelect+`WHERE c.invoice_id = $1 AND c.deleted_at IS NULL AND c.status <> $2 FOR UPDATE OF c`,
+		invoiceID, models.ClaimRejected)
+	if err != nil {
+		return err
+	}
+	for _, c := range claims {
+		if c.Status != models.ClaimReady {
+			return fmt.Errorf("%w: claim %s is %s", ErrInvoiceClaimed, c.Number, c.Status)
+		}
+	}
+	for _, c := range claims {
+		h := models.ClaimStatusChange{From: c.Status, Status: models.ClaimRejected, Note: "the invoice was voided", ChangedBy: "system"}
+		if _, err := tx.Exec(ctx, `UPDATE claims SET status = $2, status_note = $3, version = version + 1 WHERE id = $1`,
+			c.ID, h.Status, h.Note); err != nil {
+			return err
+		}
+		if err := recordClaimStatus(ctx, tx, c.ID, &h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockClaim locks a live claim; a non-zero version must match the stored one
+func lockClaim(ctx context.Context, tx pgx.Tx, id, version int) (*models.Claim, error) {
+	claims, err := queryClaims(ctx, tx, claimSelect+`WHERE c.id = $1 AND c.deleted_at IS NULL FOR UPDATE OF c`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		return nil, fmt.Errorf("claim not found")
+	}
+	if version != 0 && claims[0].Version != version {
+		return nil, ErrVersionMismatch
+	}
+	return &claims[0], nil
+}
+
+// ChangeClaimStatus records the progress of a claim with the payer
+func (s *Storage) ChangeClaimStatus(ctx context.Context, id, version int, h *models.ClaimStatusChange) (*models.Claim, error) {
+	var out *models.Claim
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		c, err := lockClaim(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if err := changeClaimStatus(ctx, tx, c, h); err != nil {
+			return err
+		}
+		out, err = getClaim(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SubmitClaims marks the ready claims of a payer submitted and returns them
+// with their lines, in number order, for the submission file
+func (s *Storage) SubmitClaims(ctx context.Context, payerID int, by string) ([]models.Claim, error) {
+	var out []models.Claim
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		claims, err := queryClaims(ctx, tx, claimSelect+`WHERE c.deleted_at IS NULL AND c.payer_id = $1 AND c.status = $2 FOR UPDATE OF c`,
+			payerID, models.ClaimReady)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(claims)
+		for i := range claims {
+			h := models.ClaimStatusChange{Status: models.ClaimSubmitted, ChangedBy: by}
+			if err := changeClaimStatus(ctx, tx, &claims[i], &h); err != nil {
+				return err
+			}
+			claims[i].Status = models.ClaimSubmitted
+			claims[i].Version++
+		}
+		out = claims
+		return loadClaimLines(ctx, tx, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
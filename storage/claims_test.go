@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+)
+
+func TestRemittanceAfterPatientPaid(t *testing.T) {
+	claim := &models.Claim{Number: "CLM-2025-000001", ClaimedAmount: 80000}
+	tests := []struct {
+		name       string
+		status     string
+		paid       int64
+		remitted   int64
+		refund     int64
+		wantStatus string
+		wantPaid   int64
+	}{
+		{"nothing paid yet", models.InvoiceIssued, 0, 80000, 0, models.InvoiceIssued, 80000},
+		{"copay paid", models.InvoiceIssued, 20000, 80000, 0, models.InvoicePaid, 100000},
+		{"patient paid in full, then the insurer pays", models.InvoicePaid, 100000, 80000, 80000, models.InvoicePaid, 100000},
+		{"patient paid part of the insured share", models.InvoiceIssued, 50000, 80000, 30000, models.InvoicePaid, 100000},
+		{"insurer pays less than claimed", models.InvoicePaid, 100000, 60000, 60000, models.InvoicePaid, 100000},
+	}
+	for _, tt := range tests {
+		const total = 100000
+		status, paid := tt.status, tt.paid
+		var refund int64
+		for _, p := range remittancePayments(claim, total, paid, tt.remitted, "billing") {
+			if p.Kind == models.PaymentRefund {
+				refund += p.Amount
+			}
+			var err error
+			if status, paid, err = applyPayment(status, total, paid, &p); err != nil {
+				t.Fatalf("%s: %s of %d: %v", tt.name, p.Kind, p.Amount, err)
+			}
+		}
+		if refund != tt.refund || status != tt.wantStatus || paid != tt.wantPaid {
+			t.Errorf("%s: refund %d, invoice %s with %d paid; want refund %d, %s with %d paid",
+				tt.name, refund, status, paid, tt.refund, tt.wantStatus, tt.wantPaid)
+		}
+	}
+}
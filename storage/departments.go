@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrDepartmentCycle is returned when a department would be nested under itself or one of its sub-departments
+var ErrDepartmentCycle = errors.New("a department cannot be nested under itself or its sub-departments")
+
+const departmentColumns = `id, COALESCE(parent_id, 0), code, name, version`
+
+// departmentSubtree is a CTE of the ids of department $1 and all departments nested under it
+const departmentSubtree = `WITH RECURSIVE subtree AS (
+    SELECT id FROM departments WHERE id = $1
+    UNION
+    SELECT c.id FROM departments c JOIN subtree s ON c.parent_id = s.id
+)
+`
+
+func scanDepartment(row pgx.Row, d *models.Department) error {
+	return row.Scan(&d.ID, &d.ParentID, &d.Code, &d.Name, &d.Version)
+}
+
+func (s *Storage) GetDepartments(ctx context.Context) ([]models.Department, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+departmentColumns+` FROM departments ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Department
+	for rows.Next() {
+		var d models.Department
+		if err := scanDepartment(rows, &d); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// GetDepartmentTree returns the top-level departments with their sub-departments nested in children
+func (s *Storage) GetDepartmentTree(ctx context.Context) ([]models.Department, error) {
+	departments, err := s.GetDepartments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children := map[int][]models.Department{}
+	for _, d := range departments {
+		children[d.ParentID] = append(children[d.ParentID], d)
+	}
+	var nest func(parentID int) []models.Department
+	nest = func(parentID int) []models.Department {
+		out := children[parentID]
+		for i := range out {
+			out[i].Children = nest(out[i].ID)
+		}
+		return out
+	}
+	return nest(0), nil
+}
+
+func (s *Storage) GetDepartment(ctx context.Context, id int) (*models.Department, error) {
+	var d models.Department
+	err := scanDepartment(s.pool.QueryRow(ctx, `SELECT `+departmentColumns+` FROM departments WHERE id = $1`, id), &d)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("department not found")
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *Storage) CreateDepartment(ctx context.Context, d *models.Department) (*models.Department, error) {
+	err := s.pool.QueryRow(ctx, `INSERT INTO departments (parent_id, code, name) VALUES (NULLIF($1, 0), $2, $3) RETURNING id, version`,
+		d.ParentID, d.Code, d.Name).Scan(&d.ID, &d.Version)
+	if err != nil {
+		return nil, facilityConflict(err)
+	}
+	return d, nil
+}
+
+// UpdateDepartment overwrites the row; a non-zero d.Version must match the stored one.
+// Moving a department under itself or one of its sub-departments is ErrDepartmentCycle.
+func (s *Storage) UpdateDepartment(ctx context.Context, d *models.Department) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if d.ParentID != 0 {
+			// serializes re-parenting so two concurrent moves cannot close a cycle
+			if _, err := tx.Exec(ctx, `LOCK TABLE departments IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+			var cycle bool
+			if err := tx.QueryRow(ctx, departmentSubtree+`SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`, d.ID, d.ParentID).Scan(&cycle); err != nil {
+				return err
+			}
+			if cycle {
+				return ErrDepartmentCycle
+			}
+		}
+		err := tx.QueryRow(ctx, `
+UPDATE departments SET parent_id = NULLIF($1, 0), code = $2, name = $3, version = version + 1
+WHERE id = $4 AND ($5 = 0 OR version = $5)
+RETURNING version
+`, d.ParentID, d.Code, d.Name, d.ID, d.Version).Scan(&d.Version)
+		return facilityUpdated(ctx, tx, err, "departments", "department", d.ID)
+	})
+}
+
+// GetDepartmentDoctorIDs returns the doctors on the staff of a department and
+// its sub-departments; an unknown department has none
+func (s *Storage) GetDepartmentDoctorIDs(ctx context.Context, departmentID int) ([]int, error) {
+	rows, err := s.pool.Query(ctx, departmentSubtree+`
+SELECT DISTINCT doctor_id FROM department_members WHERE department_id IN (SELECT id FROM subtree)
+`, departmentID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+// memberSelect reads memberships with the department and doctor names;
+// members who are soft-deleted doctors are left out
+const memberSelect = `SELECT m.department_id, dep.name, m.doctor_id, doc.first_name || ' ' || doc.last_name,
+doc.specialization, m.role, m.joined_at
+FROM department_members m
+JOIN departments dep ON dep.id = m.department_id
+JOIN doctors doc ON doc.id = m.doctor_id AND doc.deleted_at IS NULL
+`
+
+// memberOrder lists the most senior members first
+const memberOrder = `
+ORDER BY array_position(ARRAY['head', 'deputy_head', 'consultant', 'specialist', 'resident'], m.role), doc.last_name, doc.first_name, m.doctor_id`
+
+func queryMembers(ctx context.Context, q querier, sql string, args ...any) ([]models.DepartmentMember, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.DepartmentMember
+	for rows.Next() {
+		var m models.DepartmentMember
+		if err := rows.Scan(&m.DepartmentID, &m.Department, &m.DoctorID, &m.DoctorName, &m.Specialization, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// GetDepartmentMembers lists the staff of a department, with the staff of its
+// sub-departments when subdepartments is set; role narrows to one role when not empty
+func (s *Storage) GetDepartmentMembers(ctx context.Context, departmentID int, role string, subdepartments bool) ([]models.DepartmentMember, error) {
+	return queryMembers(ctx, s.pool, departmentSubtree+memberSelect+`
+WHERE (m.department_id = $1 OR ($2 AND m.department_id IN (SELECT id FROM subtree)))
+  AND ($3 = '' OR m.role = $3)`+memberOrder, departmentID, subdepartments, role)
+}
+
+// GetDepartmentHead returns the head of a department
+func (s *Storage) GetDepartmentHead(ctx context.Context, departmentID int) (*models.DepartmentMember, error) {
+	members, err := queryMembers(ctx, s.pool, memberSelect+`WHERE m.department_id = $1 AND m.role = $2`, departmentID, models.RoleHead)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("department head not found")
+	}
+	return &members[0], nil
+}
+
+// GetDoctorDepartments lists the departments a doctor works in with the role in each
+func (s *Storage) GetDoctorDepartments(ctx context.Context, doctorID int) ([]models.DepartmentMember, error) {
+	return queryMembers(ctx, s.pool, memberSelect+`WHERE m.doctor_id = $1`+memberOrder, doctorID)
+}
+
+// SetDepartmentMember adds a doctor to a department or changes the role; created
+// reports whether the doctor was not on the staff before. A second head is ErrDuplicate.
+func (s *Storage) SetDepartmentMember(ctx context.Context, m *models.DepartmentMember) (out *models.DepartmentMember, created bool, err error) {
+	err = s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+INSERT INTO department_members (department_id, doctor_id, role, joined_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (department_id, doctor_id) DO UPDATE SET role = EXCLUDED.role, joined_at = EXCLUDED.joined_at
+RETURNING xmax = 0
+`, m.DepartmentID, m.DoctorID, m.Role, m.JoinedAt).Scan(&created)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "department_members_head" {
+				return fmt.Errorf("%w: the department already has a head", ErrDuplicate)
+			}
+			return err
+		}
+		members, err := queryMembers(ctx, tx, memberSelect+`WHERE m.department_id = $1 AND m.doctor_id = $2`, m.DepartmentID, m.DoctorID)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("doctor not found")
+		}
+		out = &members[0]
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return out, created, nil
+}
+
+// RemoveDepartmentMember takes a doctor off the staff of a department
+func (s *Storage) RemoveDepartmentMember(ctx context.Context, departmentID, doctorID int) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM department_members WHERE department_id = $1 AND doctor_id = $2`, departmentID, doctorID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("department member not found")
+	}
+	return nil
+}
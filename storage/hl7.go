@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Link kinds map identifiers from the legacy registration system to local rows
+const (
+	LinkPatient     = "patient"
+	LinkDoctor      = "doctor"
+	LinkAppointment = "appointment"
+)
+
+//
+// --- HL7 message log ---
+//
+
+// LogHL7Message stores the raw message before it is processed so it can be replayed later
+func (s *Storage) LogHL7Message(ctx context.Context, m *models.HL7Message) (*models.HL7Message, error) {
+	row := s.pool.QueryRow(ctx, `
+INSERT INTO hl7_messages (control_id, message_type, raw, status, error)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, received_at
+`, m.ControlID, m.MessageType, m.Raw, m.Status, m.Error)
+	if err := row.Scan(&m.ID, &m.ReceivedAt); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *Storage) SetHL7MessageStatus(ctx context.Context, id int, status, errText string) error {
+	ct, err := s.pool.Exec(ctx, `UPDATE hl7_messages SET status=$1, error=$2 WHERE id=$3`, status, errText, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return fmt.Errorf("hl7 message not found")
+	}
+	return nil
+}
+
+func (s *Storage) GetHL7Messages(ctx context.Context, status string, limit int) ([]models.HL7Message, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT id, received_at, control_id, message_type, raw, status, error
+FROM hl7_messages
+WHERE $1 = '' OR status = $1
+ORDER BY id DESC
+LIMIT $2
+`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.HL7Message
+	for rows.Next() {
+		var m models.HL7Message
+		if err := rows.Scan(&m.ID, &m.ReceivedAt, &m.ControlID, &m.MessageType, &m.Raw, &m.Status, &m.Error); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) GetHL7MessageByID(ctx context.Context, id int) (*models.HL7Message, error) {
+	row := s.pool.QueryRow(ctx, `
+SELECT id, received_at, control_id, message_type, raw, status, error
+FROM hl7_messages WHERE id = $1
+`, id)
+	var m models.HL7Message
+	if err := row.Scan(&m.ID, &m.ReceivedAt, &m.ControlID, &m.MessageType, &m.Raw, &m.Status, &m.Error); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+//
+// --- External identifier links ---
+//
+
+// FindLink returns the local ID linked to an external identifier, or 0 when there is none
+func (s *Storage) FindLink(ctx context.Context, kind, externalID string) (int, error) {
+	var id int
+	err := s.pool.QueryRow(ctx, `SELECT entity_id FROM hl7_links WHERE kind=$1 AND external_id=$2`, kind, externalID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (s *Storage) SaveLink(ctx context.Context, kind, externalID string, entityID int) error {
+	_, err := s.pool.Exec(ctx, `
+INSERT INTO hl7_links (kind, external_id, entity_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (kind, external_id) DO UPDATE SET entity_id = EXCLUDED.entity_id
+`, kind, externalID, entityID)
+	return err
+}
+
+// FindDoctorByName is used when an HL7 message names a doctor that has no link yet
+func (s *Storage) FindDoctorByName(ctx context.Context, firstName, lastName string) (*models.Doctor, error) {
+	row := s.pool.QueryRow(ctx, `
+SELECT `+doctorColumns+` FROM doctors
+WHERE deleted_at IS NULL AND lower(last_name) = lower($1) AND ($2 = '' OR lower(first_name) = lower($2))
+ORDER BY id LIMIT 1
+`, lastName, firstName)
+	var d models.Doctor
+	if err := scanDoctor(row, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
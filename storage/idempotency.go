@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IdempotentResponse is the saved outcome of a request sent with an Idempotency-Key.
+// Status is 0 while the first request is still being processed.
+type IdempotentResponse struct {
+	RequestHash string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// expiredKey is the condition of keys older than window, or still being
+// processed after pending, so their server went away
+const expiredKey = `(created_at < $1 OR status = 0 AND created_at < $2)`
+
+// ClaimIdempotencyKey reserves key for a new request. It returns nil when the
+// key was free or its previous use expired and the saved response otherwise.
+// Only this key is expired here; ExpireIdempotencyKeys clears the others.
+func (s *Storage) ClaimIdempotencyKey(ctx context.Context, scope, key, hash string, window, pending time.Duration) (*IdempotentResponse, error) {
+	var saved *IdempotentResponse
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		now := time.Now()
+		if _, err := tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE `+expiredKey+` AND scope = $3 AND key = $4`,
+			now.Add(-window), now.Add(-pending), scope, key); err != nil {
+			return err
+		}
+
+		ct, err := tx.Exec(ctx, `
+INSERT INTO idempotency_keys (scope, key, request_hash)
+VALUES ($1, $2, $3)
+ON CONFLICT (scope, key) DO NOTHING
+`, scope, key, hash)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 1 {
+			return nil
+		}
+
+		saved = &IdempotentResponse{}
+		return tx.QueryRow(ctx, `
+SELECT request_hash, status, header, body FROM idempotency_keys WHERE scope=$1 AND key=$2
+`, scope, key).Scan(&saved.RequestHash, &saved.Status, &saved.Header, &saved.Body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// SaveIdempotentResponse stores the response of a claimed key for replays
+func (s *Storage) SaveIdempotentResponse(ctx context.Context, scope, key string, resp *IdempotentResponse) error {
+	ct, err := s.pool.Exec(ctx, `
+UPDATE idempotency_keys SET status=$1, header=$2, body=$3
+WHERE scope=$4 AND key=$5
+`, resp.Status, resp.Header, resp.Body, scope, key)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return errors.New("idempotency key not found")
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a claimed key so the request can be retried
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2`, scope, key)
+	return err
+}
+
+// ExpireIdempotencyKeys removes the keys older than window and those still
+// being processed after pending
+func (s *Storage) ExpireIdempotencyKeys(ctx context.Context, window, pending time.Duration) (int64, error) {
+	now := time.Now()
+	ct, err := s.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE `+expiredKey, now.Add(-window), now.Add(-pending))
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
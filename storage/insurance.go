@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+//
+// --- Payers ---
+//
+
+const payerColumns = `id, code, name, phone, email, active, version`
+
+func scanPayer(row pgx.Row, p *models.Payer) error {
+	return row.Scan(&p.ID, &p.Code, &p.Name, &p.Phone, &p.Email, &p.Active, &p.Version)
+}
+
+// payerConflict turns a unique violation on the code into ErrDuplicate
+func payerConflict(err error, p *models.Payer) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "insurance_payers_code_key" {
+		return fmt.Errorf("%w: payer %s already exists", ErrDuplicate, p.Code)
+	}
+	return err
+}
+
+func queryPayers(ctx context.Context, q querier, where string, args ...any) ([]models.Payer, error) {
+	rows, err := q.Query(ctx, `SELECT `+payerColumns+` FROM insurance_payers WHERE `+where+` ORDER BY name, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Payer
+	for rows.Next() {
+		var p models.Payer
+		if err := scanPayer(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// GetPayers lists the payers by name; inactive ones only with includeInactive
+func (s *Storage) GetPayers(ctx context.Context, includeInactive bool) ([]models.Payer, error) {
+	return queryPayers(ctx, s.pool, `$1 OR active`, includeInactive)
+}
+
+func (s *Storage) GetPayer(ctx context.Context, id int) (*models.Payer, error) {
+	payers, err := queryPayers(ctx, s.pool, `id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(payers) == 0 {
+		return nil, fmt.Errorf("payer not found")
+	}
+	return &payers[0], nil
+}
+
+func (s *Storage) CreatePayer(ctx context.Context, p *models.Payer) (*models.Payer, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO insurance_payers (code, name, phone, email, active)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, version
+`, p.Code, p.Name, p.Phone, p.Email, p.Active).Scan(&p.ID, &p.Version)
+	if err != nil {
+		return nil, payerConflict(err, p)
+	}
+	return p, nil
+}
+
+// UpdatePayer overwrites the row. A non-zero p.Version must match the stored
+// version; on success p.Version holds the new version.
+func (s *Storage) UpdatePayer(ctx context.Context, p *models.Payer) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE insurance_payers SET code=$1, name=$2, phone=$3, email=$4, active=$5, version = version + 1
+WHERE id=$6 AND ($7 = 0 OR version = $7)
+RETURNING version
+`, p.Code, p.Name, p.Phone, p.Email, p.Active, p.ID, p.Version).Scan(&p.Version)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return payerConflict(err, p)
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM insurance_payers WHERE id = $1)`, p.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("payer not found")
+		}
+		return ErrVersionMismatch
+	})
+}
+
+//
+// --- Policies ---
+//
+
+const policySelect = `SELECT pol.id, pol.patient_id, pol.payer_id, pay.name, pol.member_id, pol.group_number,
+pol.coverage_percent, pol.copay, TO_CHAR(pol.start_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(pol.end_date, 'YYYY-MM-DD'), ''),
+pol.created_by, pol.version, pol.deleted_at, pol.deleted_by
+FROM insurance_policies pol
+JOIN insurance_payers pay ON pay.id = pol.payer_id
+`
+
+func queryPolicies(ctx context.Context, q querier, sql string, args ...any) ([]models.Policy, error) {
+	rows, err := q.Query(ctx, sql+`
+ORDER BY pol.start_date DESC, pol.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Policy
+	for rows.Next() {
+		var p models.Policy
+		if err := rows.Scan(&p.ID, &p.PatientID, &p.PayerID, &p.PayerName, &p.MemberID, &p.GroupNumber,
+			&p.CoveragePercent, &p.Copay, &p.StartDate, &p.EndDate, &p.CreatedBy, &p.Version, &p.DeletedAt, &p.DeletedBy); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func getPolicy(ctx context.Context, q querier, id int) (*models.Policy, error) {
+	policies, err := queryPolicies(ctx, q, policySelect+`WHERE pol.id = $1 AND pol.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("policy not found")
+	}
+	return &policies[0], nil
+}
+
+func (s *Storage) GetPolicy(ctx context.Context, id int) (*models.Policy, error) {
+	return getPolicy(ctx, s.pool, id)
+}
+
+// GetPatientPolicies lists the policies of a patient, the latest first; soft-deleted ones only with includeDeleted
+func (s *Storage) GetPatientPolicies(ctx context.Context, patientID int, includeDeleted bool) ([]models.Policy, error) {
+	return queryPolicies(ctx, s.pool, policySelect+`WHERE pol.patient_id = $1 AND ($2 OR pol.deleted_at IS NULL)`, patientID, includeDeleted)
+}
+
+func (s *Storage) CreatePolicy(ctx context.Context, p *models.Policy) (*models.Policy, error) {
+	var out *models.Policy
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var id int
+		err := tx.QueryRow(ctx, `
+INSERT INTO insurance_policies (patient_id, payer_id, member_id, group_number, coverage_percent, copay, start_date, end_date, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, '')::date, $9)
+RETURNING id
+`, p.PatientID, p.PayerID, p.MemberID, p.GroupNumber, p.CoveragePercent, p.Copay, p.StartDate, p.EndDate, p.CreatedBy).Scan(&id)
+		if err != nil {
+			return err
+		}
+		out, err = getPolicy(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdatePolicy changes the payer, member details, terms and period of a
+// policy; appointments keep the policy found when they were booked and claims
+// already made keep their amounts. A non-zero p.Version must match the stored version.
+func (s *Storage) UpdatePolicy(ctx context.Context, p *models.Policy) (*models.Policy, error) {
+	var out *models.Policy
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx, `
+UPDATE insurance_policies SET payer_id=$1, member_id=$2, group_number=$3, coverage_percent=$4, copay=$5,
+    start_date=$6, end_date=NULLIF($7, '')::date, version = version + 1
+WHERE id=$8 AND deleted_at IS NULL AND ($9 = 0 OR version = $9)
+`, p.PayerID, p.MemberID, p.GroupNumber, p.CoveragePercent, p.Copay, p.StartDate, p.EndDate, p.ID, p.Version)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return missingOrStale(ctx, tx, "insurance_policies", "policy", p.ID)
+		}
+		out, err = getPolicy(ctx, tx, p.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeletePolicy soft-deletes a policy entered in error
+func (s *Storage) DeletePolicy(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return deleteRow(ctx, tx, "insurance_policies", "policy", id, version, by)
+	})
+}
+
+// RestorePolicy undoes DeletePolicy; the patient must not be deleted
+func (s *Storage) RestorePolicy(ctx context.Context, id int) (*models.Policy, error) {
+	var out *models.Policy
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "insurance_policies", "policy", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "insurance_policies", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE insurance_policies SET deleted_at = NULL, deleted_by = '', version = version + 1 WHERE id = $1`, id); err != nil {
+			return err
+		}
+		var err error
+		out, err = getPolicy(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// coveringPolicy returns the policy of an active payer that covers a patient
+// on a date, the one that started last when several do, or 0. An empty date
+// means today.
+func coveringPolicy(ctx context.Context, q querier, patientID int, date string) (int, error) {
+	policies, err := queryPolicies(ctx, q, policySelect+`
+WHERE pol.patient_id = $1 AND pol.deleted_at IS NULL AND pay.active
+  AND pol.start_date <= COALESCE(NULLIF($2, '')::date, CURRENT_DATE)
+  AND (pol.end_date IS NULL OR pol.end_date >= COALESCE(NULLIF($2, '')::date, CURRENT_DATE))`, patientID, date)
+	if err != nil || len(policies) == 0 {
+		return 0, err
+	}
+	return policies[0].ID, nil
+}
+
+// GetEligibility tells whether a patient is insured on a date
+func (s *Storage) GetEligibility(ctx context.Context, patientID int, date string) (*models.Eligibility, error) {
+	out := &models.Eligibility{PatientID: patientID, Date: date}
+	id, err := coveringPolicy(ctx, s.pool, patientID, date)
+	if err != nil || id == 0 {
+		return out, err
+	}
+	if out.Policy, err = getPolicy(ctx, s.pool, id); err != nil {
+		return nil, err
+	}
+	out.Covered = true
+	return out, nil
+}
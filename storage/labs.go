@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const labTestColumns = `id, code, name, unit, panel, ref_low, ref_high, critical_low, critical_high, version`
+
+func scanLabTest(row pgx.Row, t *models.LabTest) error {
+	return row.Scan(&t.ID, &t.Code, &t.Name, &t.Unit, &t.Panel, &t.RefLow, &t.RefHigh, &t.CriticalLow, &t.CriticalHigh, &t.Version)
+}
+
+// labTestConflict turns a unique violation on the code into ErrDuplicate
+func labTestConflict(err error, t *models.LabTest) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "lab_tests_code_key" {
+		return fmt.Errorf("%w: lab test %s already exists", ErrDuplicate, t.Code)
+	}
+	return err
+}
+
+func (s *Storage) queryLabTests(ctx context.Context, where string, args ...any) ([]models.LabTest, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+labTestColumns+` FROM lab_tests WHERE `+where+` ORDER BY panel, code`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.LabTest
+	for rows.Next() {
+		var t models.LabTest
+		if err := scanLabTest(rows, &t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetLabTests lists the catalog by panel and code; a non-empty panel lists only its tests
+func (s *Storage) GetLabTests(ctx context.Context, panel string) ([]models.LabTest, error) {
+	return s.queryLabTests(ctx, `$1 = '' OR upper(panel) = upper($1)`, panel)
+}
+
+// GetLabTestsFor lists the tests with one of codes or in one of panels
+func (s *Storage) GetLabTestsFor(ctx context.Context, codes, panels []string) ([]models.LabTest, error) {
+	return s.queryLabTests(ctx, `upper(code) = ANY($1) OR upper(panel) = ANY($2)`, codes, panels)
+}
+
+func (s *Storage) GetLabTest(ctx context.Context, id int) (*models.LabTest, error) {
+	var t models.LabTest
+	if err := scanLabTest(s.pool.QueryRow(ctx, `SELECT `+labTestColumns+` FROM lab_tests WHERE id = $1`, id), &t); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("lab test not found")
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Storage) CreateLabTest(ctx context.Context, t *models.LabTest) (*models.LabTest, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO lab_tests (code, name, unit, panel, ref_low, ref_high, critical_low, critical_high)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, version
+`, t.Code, t.Name, t.Unit, t.Panel, t.RefLow, t.RefHigh, t.CriticalLow, t.CriticalHigh).Scan(&t.ID, &t.Version)
+	if err != nil {
+		return nil, labTestConflict(err, t)
+	}
+	return t, nil
+}
+
+// UpdateLabTest overwrites the row; results already ordered keep their ranges.
+// A non-zero t.Version must match the stored version; on success t.Version holds the new version.
+func (s *Storage) UpdateLabTest(ctx context.Context, t *models.LabTest) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE lab_tests SET code=$1, name=$2, unit=$3, panel=$4, ref_low=$5, ref_high=$6, critical_low=$7, critical_high=$8, version = version + 1
+WHERE id=$9 AND ($10 = 0 OR version = $10)
+RETURNING version
+`, t.Code, t.Name, t.Unit, t.Panel, t.RefLow, t.RefHigh, t.CriticalLow, t.CriticalHigh, t.ID, t.Version).Scan(&t.Version)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return labTestConflict(err, t)
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM lab_tests WHERE id = $1)`, t.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("lab test not found")
+		}
+		return ErrVersionMismatch
+	})
+}
+
+// labReadyLock is the advisory lock serializing ready_seq assignments
+const labReadyLock = 0x6c6162 // "lab"
+
+const labOrderColumns = `id, patient_id, COALESCE(appointment_id, 0), COALESCE(doctor_id, 0), priority, status, notes,
+ordered_by, ordered_at, results_ready_at, COALESCE(ready_seq, 0), version, deleted_at, deleted_by`
+
+func scanLabOrder(row pgx.Row, o *models.LabOrder) error {
+	return row.Scan(&o.ID, &o.PatientID, &o.AppointmentID, &o.DoctorID, &o.Priority, &o.Status, &o.Notes,
+		&o.OrderedBy, &o.OrderedAt, &o.ResultsReadyAt, &o.ReadySeq, &o.Version, &o.DeletedAt, &o.DeletedBy)
+}
+
+const labResultColumns = `id, order_id, test_id, code, name, unit, ref_low, ref_high, critical_low, critical_high,
+value, numeric_value, flag, resulted_by, resulted_at`
+
+func scanLabResult(row pgx.Row, orderID *int, r *models.LabResult) error {
+	return row.Scan(&r.ID, orderID, &r.TestID, &r.Code, &r.Name, &r.Unit, &r.RefLow, &r.RefHigh, &r.CriticalLow, &r.CriticalHigh,
+		&r.Value, &r.NumericValue, &r.Flag, &r.ResultedBy, &r.ResultedAt)
+}
+
+// querier is the pool or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadLabResults fills in the results of orders
+func loadLabResults(ctx context.Context, q querier, orders []models.LabOrder) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(orders))
+	ids := make([]int, len(orders))
+	for i := range orders {
+		index[orders[i].ID] = i
+		ids[i] = orders[i].ID
+		orders[i].Results = []models.LabResult{}
+	}
+
+	rows, err := q.Query(ctx, `SELECT `+labResultColumns+` FROM lab_results WHERE order_id = ANY($1) ORDER BY order_id, id`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID int
+		var r models.LabResult
+		if err := scanLabResult(rows, &orderID, &r); err != nil {
+			return err
+		}
+		o := &orders[index[orderID]]
+		o.Results = append(o.Results, r)
+	}
+	return rows.Err()
+}
+
+func (s *Storage) queryLabOrders(ctx context.Context, where, order string, args ...any) ([]models.LabOrder, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+labOrderColumns+` FROM lab_orders WHERE `+where+` ORDER BY `+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []models.LabOrder
+	for rows.Next() {
+		var o models.LabOrder
+		if err := scanLabOrder(rows, &o); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadLabResults(ctx, s.pool, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetPatientLabOrders lists the lab orders of a patient, newest first; an
+// empty status lists all. Soft-deleted orders are listed with includeDeleted.
+func (s *Storage) GetPatientLabOrders(ctx context.Context, patientID int, status string, includeDeleted bool) ([]models.LabOrder, error) {
+	return s.queryLabOrders(ctx, `patient_id = $1 AND ($2 = '' OR status = $2) AND ($3 OR deleted_at IS NULL)`,
+		`ordered_at DESC, id DESC`, patientID, status, includeDeleted)
+}
+
+// GetAppointmentLabOrders lists the lab orders placed during an appointment
+func (s *Storage) GetAppointmentLabOrders(ctx context.Context, appointmentID int) ([]models.LabOrder, error) {
+	return s.queryLabOrders(ctx, `appointment_id = $1 AND deleted_at IS NULL`, `ordered_at, id`, appointmentID)
+}
+
+// GetLabOrders is the laboratory worklist: orders in a status, most urgent
+// then oldest first
+func (s *Storage) GetLabOrders(ctx context.Context, status string, limit int) ([]models.LabOrder, error) {
+	return s.queryLabOrders(ctx, `($1 = '' OR status = $1) AND deleted_at IS NULL`,
+		`array_position(ARRAY['stat', 'urgent', 'routine'], priority), ordered_at, id LIMIT $2`, status, limit)
+}
+
+// GetReadyLabOrders lists the orders whose results became ready after the
+// one with ReadySeq after, in the order they did. Ready sequence numbers are
+// committed in order, so a client polling with the last one it saw misses none.
+func (s *Storage) GetReadyLabOrders(ctx context.Context, after int64, limit int) ([]models.LabOrder, error) {
+	return s.queryLabOrders(ctx, `ready_seq > $1 AND deleted_at IS NULL`, `ready_seq LIMIT $2`, after, limit)
+}
+
+func (s *Storage) GetLabOrder(ctx context.Context, id int) (*models.LabOrder, error) {
+	orders, err := s.queryLabOrders(ctx, `id = $1 AND deleted_at IS NULL`, `id`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("lab order not found")
+	}
+	return &orders[0], nil
+}
+
+// CreateLabOrder inserts o with an empty result for each of tests, copying their units and ranges
+func (s *Storage) CreateLabOrder(ctx context.Context, o *models.LabOrder, tests []models.LabTest) (*models.LabOrder, error) {
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+INSERT INTO lab_orders (patient_id, appointment_id, doctor_id, priority, status, notes, ordered_by)
+VALUES ($1, NULLIF($2::integer, 0), NULLIF($3::integer, 0), $4, $5, $6, $7)
+RETURNING id, ordered_at, version
+`, o.PatientID, o.AppointmentID, o.DoctorID, o.Priority, models.LabOrdered, o.Notes, o.OrderedBy).Scan(&o.ID, &o.OrderedAt, &o.Version)
+		if err != nil {
+			return err
+		}
+		o.Status = models.LabOrdered
+
+		ids := make([]int, len(tests))
+		for i, t := range tests {
+			ids[i] = t.ID
+		}
+		if _, err := tx.Exec(ctx, `
+INSERT INTO lab_results (order_id, test_id, code, name, unit, ref_low, ref_high, critical_low, critical_high)
+SELECT $1, id, code, name, unit, ref_low, ref_high, critical_low, critical_high FROM lab_tests WHERE id = ANY($2)
+ORDER BY panel, code
+`, o.ID, ids); err != nil {
+			return err
+		}
+		orders := []models.LabOrder{*o}
+		if err := loadLabResults(ctx, tx, orders); err != nil {
+			return err
+		}
+		o.Results = orders[0].Results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.Tests, o.Panels = nil, nil
+	return o, nil
+}
+
+// RecordLabResults saves the values of results (by result id) and moves the
+// order to partial or, once every test has a value, to resulted. The first
+// time an order becomes resulted its results_ready_at and ready_seq are set;
+// they are kept when a value is later cleared and filled in again. A non-zero
+// version must match the stored one.
+func (s *Storage) RecordLabResults(ctx context.Context, id, version int, results []models.LabResult, by string) (*models.LabOrder, error) {
+	var o models.LabOrder
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `SELECT `+labOrderColumns+` FROM lab_orders WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id)
+		if err := scanLabOrder(row, &o); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("lab order not found")
+			}
+			return err
+		}
+		if version != 0 && o.Version != version {
+			return ErrVersionMismatch
+		}
+
+		for _, r := range results {
+			if _, err := tx.Exec(ctx, `
+UPDATE lab_results SET value = $3, numeric_value = $4, flag = $5, resulted_by = $6, resulted_at = now()
+WHERE id = $1 AND order_id = $2
+`, r.ID, id, r.Value, r.NumericValue, r.Flag, by); err != nil {
+				return err
+			}
+		}
+
+		if o.ReadySeq == 0 {
+			var pending int
+			if err := tx.QueryRow(ctx, `SELECT count(*) FROM lab_results WHERE order_id = $1 AND value = ''`, id).Scan(&pending); err != nil {
+				return err
+			}
+			// ready sequence numbers are taken one transaction at a time, so
+			// they commit in order and pollers never skip a smaller one
+			if pending == 0 {
+				if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, labReadyLock); err != nil {
+					return err
+				}
+			}
+		}
+
+		err := tx.QueryRow(ctx, `
+UPDATE lab_orders o
+SET status = CASE WHEN pending.n = 0 THEN 'resulted' ELSE 'partial' END,
+    results_ready_at = CASE WHEN pending.n = 0 THEN COALESCE(o.results_ready_at, clock_timestamp()) ELSE o.results_ready_at END,
+    ready_seq = CASE WHEN pending.n = 0 THEN COALESCE(o.ready_seq, nextval('lab_orders_ready_seq')) ELSE o.ready_seq END,
+    version = o.version + 1
+FROM (SELECT count(*) AS n FROM lab_results WHERE order_id = $1 AND value = '') pending
+WHERE o.id = $1
+RETURNING o.status, o.results_ready_at, COALESCE(o.ready_seq, 0), o.version
+`, id).Scan(&o.Status, &o.ResultsReadyAt, &o.ReadySeq, &o.Version)
+		if err != nil {
+			return err
+		}
+		orders := []models.LabOrder{o}
+		if err := loadLabResults(ctx, tx, orders); err != nil {
+			return err
+		}
+		o.Results = orders[0].Results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// DeleteLabOrder soft-deletes an order placed in error; a non-zero version must match the stored one
+func (s *Storage) DeleteLabOrder(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "lab_orders", "lab order", id, version, by) })
+}
+
+// RestoreLabOrder undoes DeleteLabOrder; the patient must not be deleted
+func (s *Storage) RestoreLabOrder(ctx context.Context, id int) (*models.LabOrder, error) {
+	var o models.LabOrder
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "lab_orders", "lab order", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "lab_orders", id); err != nil {
+			return err
+		}
+		if err := scanLabOrder(tx.QueryRow(ctx, `
+UPDATE lab_orders SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+labOrderColumns, id), &o); err != nil {
+			return err
+		}
+		orders := []models.LabOrder{o}
+		if err := loadLabResults(ctx, tx, orders); err != nil {
+			return err
+		}
+		o.Results = orders[0].Results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetLabTrends returns the resulted values of a patient per analyte in time
+// order; empty codes returns every analyte
+func (s *Storage) GetLabTrends(ctx context.Context, patientID int, codes []string) ([]models.LabTrend, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT r.code, r.name, r.unit, r.order_id, r.resulted_at, r.value, r.numeric_value, r.flag, r.ref_low, r.ref_high
+FROM lab_results r
+JOIN lab_orders o ON o.id = r.order_id
+WHERE o.patient_id = $1 AND o.deleted_at IS NULL AND r.resulted_at IS NOT NULL
+  AND (cardinality($2::text[]) = 0 OR upper(r.code) = ANY($2))
+ORDER BY upper(r.code), r.resulted_at, r.id
+`, patientID, codes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.LabTrend
+	for rows.Next() {
+		var code, name, unit string
+		var p models.LabTrendPoint
+		if err := rows.Scan(&code, &name, &unit, &p.OrderID, &p.ResultedAt, &p.Value, &p.NumericValue, &p.Flag, &p.RefLow, &p.RefHigh); err != nil {
+			return nil, err
+		}
+		// the latest name and unit describe the trend
+		if n := len(out); n > 0 && strings.EqualFold(out[n-1].Code, code) {
+			out[n-1].Name, out[n-1].Unit = name, unit
+			out[n-1].Points = append(out[n-1].Points, p)
+			continue
+		}
+		out = append(out, models.LabTrend{Code: code, Name: name, Unit: unit, Points: []models.LabTrendPoint{p}})
+	}
+	return out, rows.Err()
+}
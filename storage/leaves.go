@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrLeaveDecided is returned when approving, rejecting or cancelling a leave that is no longer open to it
+var ErrLeaveDecided = errors.New("the leave request is already decided")
+
+// ErrLeaveConflict is returned when approving leave over shifts or booked appointments of the doctor
+var ErrLeaveConflict = errors.New("the leave overlaps scheduled work")
+
+const leaveSelect = `SELECT l.id, l.doctor_id, doc.first_name || ' ' || doc.last_name, l.kind,
+TO_CHAR(l.start_date, 'YYYY-MM-DD'), TO_CHAR(l.end_date, 'YYYY-MM-DD'), l.reason, l.status,
+l.requested_by, l.requested_at, l.decided_by, l.decided_at, l.version
+FROM leaves l
+JOIN doctors doc ON doc.id = l.doctor_id
+`
+
+func queryLeaves(ctx context.Context, q querier, sql string, args ...any) ([]models.Leave, error) {
+	rows, err := q.Query(ctx, sql+`
+ORDER BY l.start_date DESC, l.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Leave
+	for rows.Next() {
+		var l models.Leave
+		if err := rows.Scan(&l.ID, &l.DoctorID, &l.DoctorName, &l.Kind, &l.StartDate, &l.EndDate, &l.Reason, &l.Status,
+			&l.RequestedBy, &l.RequestedAt, &l.DecidedBy, &l.DecidedAt, &l.Version); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func getLeave(ctx context.Context, q querier, id int) (*models.Leave, error) {
+	leaves, err := queryLeaves(ctx, q, leaveSelect+`WHERE l.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("leave not found")
+	}
+	return &leaves[0], nil
+}
+
+func (s *Storage) GetLeave(ctx context.Context, id int) (*models.Leave, error) {
+	return getLeave(ctx, s.pool, id)
+}
+
+// GetLeaves lists leave, the latest first, narrowed to a doctor and a status when those are not zero
+func (s *Storage) GetLeaves(ctx context.Context, doctorID int, status string) ([]models.Leave, error) {
+	return queryLeaves(ctx, s.pool, leaveSelect+`WHERE ($1 = 0 OR l.doctor_id = $1) AND ($2 = '' OR l.status = $2)`, doctorID, status)
+}
+
+// CreateLeave files a leave request; it blocks nothing until approved
+func (s *Storage) CreateLeave(ctx context.Context, l *models.Leave) (*models.Leave, error) {
+	var out *models.Leave
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var id int
+		err := tx.QueryRow(ctx, `
+INSERT INTO leaves (doctor_id, kind, start_date, end_date, reason, status, requested_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`, l.DoctorID, l.Kind, l.StartDate, l.EndDate, l.Reason, models.LeaveRequested, l.RequestedBy).Scan(&id)
+		if err != nil {
+			return err
+		}
+		out, err = getLeave(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// leaveConflicts counts the shifts and the booked appointments of a doctor that fall in a leave
+func leaveConflicts(ctx context.Context, tx pgx.Tx, l *models.Leave) (shifts, appointments int, err error) {
+	err = tx.QueryRow(ctx, `
+SELECT (SELECT count(*) FROM shifts WHERE doctor_id = $1
+        AND (starts_at AT TIME ZONE $4)::date <= $3::date
+        AND ((ends_at - interval '1 microsecond') AT TIME ZONE $4)::date >= $2::date),
+       (SELECT count(*) FROM appointments WHERE doctor_id = $1 AND deleted_at IS NULL
+        AND date BETWEEN $2::date AND $3::date AND lower(COALESCE(status, '')) NOT IN ('cancelled', 'canceled'))
+`, l.DoctorID, l.StartDate, l.EndDate, ScheduleLocation.String()).Scan(&shifts, &appointments)
+	return shifts, appointments, err
+}
+
+// decideLeave moves a leave from one of the statuses in from to status; a
+// non-zero version must match the stored one. Approval is refused with
+// ErrLeaveConflict while shifts or booked appointments fall in the leave.
+func (s *Storage) decideLeave(ctx context.Context, id, version int, from []string, status, by string) (*models.Leave, error) {
+	var out *models.Leave
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var current, doctorID int
+		var currentStatus string
+		err := tx.QueryRow(ctx, `SELECT doctor_id, status, version FROM leaves WHERE id = $1 FOR UPDATE`, id).Scan(&doctorID, &currentStatus, &current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("leave not found")
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && current != version {
+			return ErrVersionMismatch
+		}
+		if !slices.Contains(from, currentStatus) {
+			return fmt.Errorf("%w: it is %s", ErrLeaveDecided, currentStatus)
+		}
+
+		if status == models.LeaveApproved {
+			if err := lockRoster(ctx, tx, doctorID); err != nil {
+				return err
+			}
+			l, err := getLeave(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			shifts, appointments, err := leaveConflicts(ctx, tx, l)
+			if err != nil {
+				return err
+			}
+			if shifts > 0 || appointments > 0 {
+				return fmt.Errorf("%w: %d shifts and %d booked appointments fall in it; move them first", ErrLeaveConflict, shifts, appointments)
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE leaves SET status = $2, decided_by = $3, decided_at = now(), version = version + 1 WHERE id = $1`,
+			id, status, by); err != nil {
+			return err
+		}
+		out, err = getLeave(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ApproveLeave approves a requested leave
+func (s *Storage) ApproveLeave(ctx context.Context, id, version int, by string) (*models.Leave, error) {
+	return s.decideLeave(ctx, id, version, []string{models.LeaveRequested}, models.LeaveApproved, by)
+}
+
+// RejectLeave turns down a requested leave
+func (s *Storage) RejectLeave(ctx context.Context, id, version int, by string) (*models.Leave, error) {
+	return s.decideLeave(ctx, id, version, []string{models.LeaveRequested}, models.LeaveRejected, by)
+}
+
+// CancelLeave withdraws a requested or approved leave, which frees the days again
+func (s *Storage) CancelLeave(ctx context.Context, id, version int, by string) (*models.Leave, error) {
+	return s.decideLeave(ctx, id, version, []string{models.LeaveRequested, models.LeaveApproved}, models.LeaveCancelled, by)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const medicationColumns = `medications.id, medications.name, medications.strength, medications.form, medications.atc_code, medications.version`
+
+func scanMedication(row pgx.Row, m *models.Medication) error {
+	return row.Scan(&m.ID, &m.Name, &m.Strength, &m.Form, &m.ATCCode, &m.Version)
+}
+
+// medicationConflict turns a unique violation on name, strength and form into ErrDuplicate
+func medicationConflict(err error, m *models.Medication) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "medications_name_key" {
+		return fmt.Errorf("%w: %s %s %s is already in the catalog", ErrDuplicate, m.Name, m.Strength, m.Form)
+	}
+	return err
+}
+
+// GetMedications lists the catalog by name; a non-empty q matches a name
+// substring or an ATC code prefix
+func (s *Storage) GetMedications(ctx context.Context, q string) ([]models.Medication, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+medicationColumns+` FROM medications
+WHERE $1 = '' OR name ILIKE '%' || $1 || '%' OR atc_code LIKE upper($1) || '%'
+ORDER BY lower(name), strength, form, id
+`, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Medication
+	for rows.Next() {
+		var m models.Medication
+		if err := scanMedication(rows, &m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) GetMedication(ctx context.Context, id int) (*models.Medication, error) {
+	var m models.Medication
+	if err := scanMedication(s.pool.QueryRow(ctx, `SELECT `+medicationColumns+` FROM medications WHERE id = $1`, id), &m); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("medication not found")
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *Storage) CreateMedication(ctx context.Context, m *models.Medication) (*models.Medication, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO medications (name, strength, form, atc_code)
+VALUES ($1, $2, $3, $4)
+RETURNING id, version
+`, m.Name, m.Strength, m.Form, m.ATCCode).Scan(&m.ID, &m.Version)
+	if err != nil {
+		return nil, medicationConflict(err, m)
+	}
+	return m, nil
+}
+
+// UpdateMedication overwrites the row. A non-zero m.Version must match the
+// stored version; on success m.Version holds the new version.
+func (s *Storage) UpdateMedication(ctx context.Context, m *models.Medication) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE medications SET name=$1, strength=$2, form=$3, atc_code=$4, version = version + 1
+WHERE id=$5 AND ($6 = 0 OR version = $6)
+RETURNING version
+`, m.Name, m.Strength, m.Form, m.ATCCode, m.ID, m.Version).Scan(&m.Version)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return medicationConflict(err, m)
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM medications WHERE id = $1)`, m.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("medication not found")
+		}
+		return ErrVersionMismatch
+	})
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// patientReference is a table whose rows belong to a patient through patient_id
+type patientReference struct {
+	table     string
+	versioned bool // the table has a version column to bump
+}
+
+// patientReferences are reassigned to the surviving record when patients are
+// merged and soft-deleted with the patient; they need deleted_at and deleted_by columns
+var patientReferences = []patientReference{
+	{table: "appointments", versioned: true},
+	{table: "patient_problems", versioned: true},
+	{table: "prescriptions", versioned: true},
+	{table: "patient_allergies", versioned: true},
+	{table: "lab_orders", versioned: true},
+	{table: "vital_signs", versioned: true},
+	{table: "clinical_notes", versioned: true},
+	{table: "admissions", versioned: true},
+	{table: "invoices", versioned: true},
+	{table: "insurance_policies", versioned: true},
+	{table: "claims", versioned: true},
+}
+
+// MergePatients moves everything recorded for mergedID to survivorID, fills
+// the survivor's empty fields from the merged record, deletes the merged
+// record and keeps a snapshot of it in patient_merges
+func (s *Storage) MergePatients(ctx context.Context, survivorID, mergedID int, by string) (*models.PatientMerge, *models.Patient, error) {
+	m := &models.PatientMerge{SurvivorID: survivorID, MergedID: mergedID, MergedBy: by, Moved: map[string]int{}}
+	var survivor models.Patient
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		// lock both rows in id order so concurrent merges cannot deadlock
+		rows, err := tx.Query(ctx, `SELECT `+patientColumns+` FROM patients WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE`, []int{survivorID, mergedID})
+		if err != nil {
+			return err
+		}
+		found := map[int]models.Patient{}
+		for rows.Next() {
+			var p models.Patient
+			if err := scanPatient(rows, &p); err != nil {
+				rows.Close()
+				return err
+			}
+			found[p.ID] = p
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		var ok bool
+		if survivor, ok = found[survivorID]; !ok {
+			return fmt.Errorf("patient %d not found", survivorID)
+		}
+		if m.Merged, ok = found[mergedID]; !ok {
+			return fmt.Errorf("patient %d not found", mergedID)
+		}
+		m.MergedMRN = m.Merged.MRN
+
+		for _, ref := range patientReferences {
+			bump := ""
+			if ref.versioned {
+				bump = ", version = version + 1"
+			}
+			ct, err := tx.Exec(ctx, `UPDATE `+ref.table+` SET patient_id = $1`+bump+` WHERE patient_id = $2`, survivorID, mergedID)
+			if err != nil {
+				// both records cannot be admitted at once
+				return admissionConflict(err)
+			}
+			m.Moved[ref.table] = int(ct.RowsAffected())
+		}
+		ct, err := tx.Exec(ctx, `UPDATE hl7_links SET entity_id = $1 WHERE kind = $3 AND entity_id = $2`, survivorID, mergedID, LinkPatient)
+		if err != nil {
+			return err
+		}
+		m.Moved["hl7_links"] = int(ct.RowsAffected())
+		// records merged into the merged patient earlier now point to the survivor
+		if _, err := tx.Exec(ctx, `UPDATE patient_merges SET survivor_id = $1 WHERE survivor_id = $2`, survivorID, mergedID); err != nil {
+			return err
+		}
+
+		// delete first so identifiers such as national_id can move to the survivor;
+		// the row is removed for good, the snapshot below keeps its data
+		if _, err := tx.Exec(ctx, `DELETE FROM patients WHERE id = $1`, mergedID); err != nil {
+			return err
+		}
+		survivor.FillMissing(&m.Merged)
+		survivor.Version = 0
+		if err := updatePatient(ctx, tx, &survivor); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx, `
+INSERT INTO patient_merges (survivor_id, merged_id, merged_mrn, merged, moved, merged_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, merged_at
+`, m.SurvivorID, m.MergedID, m.MergedMRN, m.Merged, m.Moved, m.MergedBy).Scan(&m.ID, &m.MergedAt)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, &survivor, nil
+}
+
+// GetPatientMerges lists merges into the patient and the merge that removed it, newest first
+func (s *Storage) GetPatientMerges(ctx context.Context, patientID int) ([]models.PatientMerge, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT id, survivor_id, merged_id, merged_mrn, merged, moved, merged_by, merged_at
+FROM patient_merges
+WHERE survivor_id = $1 OR merged_id = $1
+ORDER BY merged_at DESC, id DESC
+`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.PatientMerge
+	for rows.Next() {
+		var m models.PatientMerge
+		if err := rows.Scan(&m.ID, &m.SurvivorID, &m.MergedID, &m.MergedMRN, &m.Merged, &m.Moved, &m.MergedBy, &m.MergedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// DismissDuplicate records that two patients are different people so the pair leaves the review queue
+func (s *Storage) DismissDuplicate(ctx context.Context, a, b int, by string) error {
+	a, b = min(a, b), max(a, b)
+	_, err := s.pool.Exec(ctx, `
+INSERT INTO patient_duplicate_dismissals (patient_id, duplicate_id, dismissed_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (patient_id, duplicate_id) DO NOTHING
+`, a, b, by)
+	return err
+}
+
+// GetDuplicateDismissals returns the dismissed pairs with the lower ID as PatientID
+func (s *Storage) GetDuplicateDismissals(ctx context.Context) ([]models.DuplicatePair, error) {
+	rows, err := s.pool.Query(ctx, `SELECT patient_id, duplicate_id FROM patient_duplicate_dismissals`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.DuplicatePair
+	for rows.Next() {
+		var d models.DuplicatePair
+		if err := rows.Scan(&d.PatientID, &d.DuplicateID); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
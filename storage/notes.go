@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoteSigned is returned when changing or deleting a signed note
+var ErrNoteSigned = errors.New("note is signed; add an addendum instead")
+
+// ErrNoteDraft is returned when adding an addendum to a note that is not signed yet
+var ErrNoteDraft = errors.New("note is a draft; edit it instead")
+
+// ErrNotAuthor is returned when someone other than the author edits, signs or deletes a draft
+var ErrNotAuthor = errors.New("only the author can change a draft note")
+
+const noteColumns = `id, patient_id, COALESCE(appointment_id, 0) AS appointment_id, subjective, objective, assessment, plan,
+status, author, created_at, updated_at, signed_at, version, deleted_at, deleted_by`
+
+// noteDest returns the scan destinations for noteColumns
+func noteDest(n *models.ClinicalNote) []any {
+	return []any{&n.ID, &n.PatientID, &n.AppointmentID, &n.Subjective, &n.Objective, &n.Assessment, &n.Plan,
+		&n.Status, &n.Author, &n.CreatedAt, &n.UpdatedAt, &n.SignedAt, &n.Version, &n.DeletedAt, &n.DeletedBy}
+}
+
+func (s *Storage) queryNotes(ctx context.Context, where string, args ...any) ([]models.ClinicalNote, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+noteColumns+` FROM clinical_notes WHERE `+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ClinicalNote
+	for rows.Next() {
+		var n models.ClinicalNote
+		if err := rows.Scan(noteDest(&n)...); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, loadAddenda(ctx, s.pool, out)
+}
+
+// loadAddenda fills the addenda of notes, oldest first
+func loadAddenda(ctx context.Context, q querier, notes []models.ClinicalNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(notes))
+	ids := make([]int, len(notes))
+	for i := range notes {
+		index[notes[i].ID] = i
+		ids[i] = notes[i].ID
+		notes[i].Addenda = []models.NoteAddendum{}
+	}
+
+	rows, err := q.Query(ctx, `SELECT id, note_id, text, author, created_at FROM clinical_note_addenda WHERE note_id = ANY($1) ORDER BY note_id, id`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.NoteAddendum
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.Text, &a.Author, &a.CreatedAt); err != nil {
+			return err
+		}
+		n := &notes[index[a.NoteID]]
+		n.Addenda = append(n.Addenda, a)
+	}
+	return rows.Err()
+}
+
+// GetPatientNotes returns the notes of a patient, newest first. An empty
+// status lists all; soft-deleted notes are listed with includeDeleted.
+func (s *Storage) GetPatientNotes(ctx context.Context, patientID int, status string, includeDeleted bool) ([]models.ClinicalNote, error) {
+	return s.queryNotes(ctx, `patient_id = $1 AND ($2 = '' OR status = $2) AND ($3 OR deleted_at IS NULL)`, patientID, status, includeDeleted)
+}
+
+// GetAppointmentNotes returns the notes written for an appointment
+func (s *Storage) GetAppointmentNotes(ctx context.Context, appointmentID int) ([]models.ClinicalNote, error) {
+	return s.queryNotes(ctx, `appointment_id = $1 AND deleted_at IS NULL`, appointmentID)
+}
+
+func (s *Storage) GetNote(ctx context.Context, id int) (*models.ClinicalNote, error) {
+	notes, err := s.queryNotes(ctx, `id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, fmt.Errorf("note not found")
+	}
+	return &notes[0], nil
+}
+
+// CreateNote stores a draft note
+func (s *Storage) CreateNote(ctx context.Context, n *models.ClinicalNote) (*models.ClinicalNote, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO clinical_notes (patient_id, appointment_id, subjective, objective, assessment, plan, status, author)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING `+noteColumns, n.PatientID, n.AppointmentID, n.Subjective, n.Objective, n.Assessment, n.Plan,
+		models.NoteDraft, n.Author).Scan(noteDest(n)...)
+	if err != nil {
+		return nil, err
+	}
+	n.Addenda = []models.NoteAddendum{}
+	return n, nil
+}
+
+// lockDraft locks a live note that by may still change; a non-zero version must match the stored one
+func lockDraft(ctx context.Context, tx pgx.Tx, id, version int, by string) (*models.ClinicalNote, error) {
+	var n models.ClinicalNote
+	err := tx.QueryRow(ctx, `SELECT `+noteColumns+` FROM clinical_notes WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(noteDest(&n)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("note not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && n.Version != version {
+		return nil, ErrVersionMismatch
+	}
+	if n.Status != models.NoteDraft {
+		return nil, ErrNoteSigned
+	}
+	if n.Author != by {
+		return nil, ErrNotAuthor
+	}
+	return &n, nil
+}
+
+// UpdateNote replaces the sections of a draft; only its author may do so
+func (s *Storage) UpdateNote(ctx context.Context, n *models.ClinicalNote, by string) (*models.ClinicalNote, error) {
+	var out models.ClinicalNote
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDraft(ctx, tx, n.ID, n.Version, by); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+UPDATE clinical_notes SET subjective=$2, objective=$3, assessment=$4, plan=$5, updated_at = now(), version = version + 1
+WHERE id = $1
+RETURNING `+noteColumns, n.ID, n.Subjective, n.Objective, n.Assessment, n.Plan).Scan(noteDest(&out)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Addenda = []models.NoteAddendum{}
+	return &out, nil
+}
+
+// SignNote signs a draft of by; from then on the note is immutable. check
+// validates the locked note so what is signed is what was checked.
+func (s *Storage) SignNote(ctx context.Context, id, version int, by string, check func(*models.ClinicalNote) error) (*models.ClinicalNote, error) {
+	var out models.ClinicalNote
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		n, err := lockDraft(ctx, tx, id, version, by)
+		if err != nil {
+			return err
+		}
+		if err := check(n); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+UPDATE clinical_notes SET status = $2, signed_at = now(), updated_at = now(), version = version + 1
+WHERE id = $1
+RETURNING `+noteColumns, id, models.NoteSigned).Scan(noteDest(&out)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Addenda = []models.NoteAddendum{}
+	return &out, nil
+}
+
+// AddAddendum appends an addendum to a signed note and returns the note with all its addenda
+func (s *Storage) AddAddendum(ctx context.Context, a *models.NoteAddendum) (*models.ClinicalNote, error) {
+	var n models.ClinicalNote
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE clinical_notes SET version = version + 1
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING `+noteColumns, a.NoteID).Scan(noteDest(&n)...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("note not found")
+		}
+		if err != nil {
+			return err
+		}
+		if n.Status != models.NoteSigned {
+			return ErrNoteDraft
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO clinical_note_addenda (note_id, text, author) VALUES ($1, $2, $3)`,
+			a.NoteID, a.Text, a.Author); err != nil {
+			return err
+		}
+		notes := []models.ClinicalNote{n}
+		if err := loadAddenda(ctx, tx, notes); err != nil {
+			return err
+		}
+		n = notes[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// DeleteNote soft-deletes a draft of by; signed notes are kept
+func (s *Storage) DeleteNote(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDraft(ctx, tx, id, version, by); err != nil {
+			return err
+		}
+		return deleteRow(ctx, tx, "clinical_notes", "note", id, 0, by)
+	})
+}
+
+// RestoreNote undoes DeleteNote; the patient must not be deleted
+func (s *Storage) RestoreNote(ctx context.Context, id int) (*models.ClinicalNote, error) {
+	var n models.ClinicalNote
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "clinical_notes", "note", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "clinical_notes", id); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+UPDATE clinical_notes SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+noteColumns, id).Scan(noteDest(&n)...); err != nil {
+			return err
+		}
+		notes := []models.ClinicalNote{n}
+		if err := loadAddenda(ctx, tx, notes); err != nil {
+			return err
+		}
+		n = notes[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNotActive is returned when discontinuing a prescription that is already discontinued
+var ErrNotActive = errors.New("prescription is not active")
+
+const prescriptionColumns = `prescriptions.id, prescriptions.patient_id, COALESCE(prescriptions.appointment_id, 0),
+COALESCE(prescriptions.doctor_id, 0), prescriptions.medication_id,
+medications.name || COALESCE(' ' || NULLIF(medications.strength, ''), ''), medications.atc_code,
+prescriptions.dose, prescriptions.route, prescriptions.frequency, prescriptions.duration_days, prescriptions.instructions,
+TO_CHAR(prescriptions.start_date, 'YYYY-MM-DD'), COALESCE(TO_CHAR(prescriptions.end_date, 'YYYY-MM-DD'), ''),
+prescriptions.status, prescriptions.discontinued_at, prescriptions.discontinued_by, prescriptions.discontinued_reason,
+prescriptions.override_reason, prescriptions.alerts, prescriptions.prescribed_by, prescriptions.prescribed_at, prescriptions.version,
+prescriptions.deleted_at, prescriptions.deleted_by`
+
+// prescriptionSelect reads prescriptions with the name of the medication
+const prescriptionSelect = `SELECT ` + prescriptionColumns + `
+FROM prescriptions JOIN medications ON medications.id = prescriptions.medication_id`
+
+func scanPrescription(row pgx.Row, p *models.Prescription) error {
+	return row.Scan(&p.ID, &p.PatientID, &p.AppointmentID, &p.DoctorID, &p.MedicationID, &p.MedicationName, &p.ATCCode,
+		&p.Dose, &p.Route, &p.Frequency, &p.DurationDays, &p.Instructions, &p.StartDate, &p.EndDate,
+		&p.Status, &p.DiscontinuedAt, &p.DiscontinuedBy, &p.DiscontinuedReason,
+		&p.OverrideReason, &p.Alerts, &p.PrescribedBy, &p.PrescribedAt, &p.Version, &p.DeletedAt, &p.DeletedBy)
+}
+
+func (s *Storage) queryPrescriptions(ctx context.Context, where string, args ...any) ([]models.Prescription, error) {
+	rows, err := s.pool.Query(ctx, prescriptionSelect+`
+WHERE `+where+`
+ORDER BY prescriptions.status <> 'active', prescriptions.start_date DESC, prescriptions.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Prescription
+	for rows.Next() {
+		var p models.Prescription
+		if err := scanPrescription(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// GetPatientPrescriptions lists the prescriptions of a patient, active ones
+// first; an empty status lists all. Soft-deleted ones are listed with includeDeleted.
+func (s *Storage) GetPatientPrescriptions(ctx context.Context, patientID int, status string, includeDeleted bool) ([]models.Prescription, error) {
+	return s.queryPrescriptions(ctx, `prescriptions.patient_id = $1 AND ($2 = '' OR prescriptions.status = $2)
+  AND ($3 OR prescriptions.deleted_at IS NULL)`, patientID, status, includeDeleted)
+}
+
+// GetCurrentMedications lists the active prescriptions of a patient whose course has not ended before today (YYYY-MM-DD)
+func (s *Storage) GetCurrentMedications(ctx context.Context, patientID int, today string) ([]models.Prescription, error) {
+	return s.queryPrescriptions(ctx, `prescriptions.patient_id = $1 AND prescriptions.status = 'active'
+  AND (prescriptions.end_date IS NULL OR prescriptions.end_date >= $2::date) AND prescriptions.deleted_at IS NULL`, patientID, today)
+}
+
+// GetAppointmentPrescriptions lists what was prescribed during an appointment
+func (s *Storage) GetAppointmentPrescriptions(ctx context.Context, appointmentID int) ([]models.Prescription, error) {
+	return s.queryPrescriptions(ctx, `prescriptions.appointment_id = $1 AND prescriptions.deleted_at IS NULL`, appointmentID)
+}
+
+func (s *Storage) GetPrescription(ctx context.Context, id int) (*models.Prescription, error) {
+	var p models.Prescription
+	row := s.pool.QueryRow(ctx, prescriptionSelect+` WHERE prescriptions.id = $1 AND prescriptions.deleted_at IS NULL`, id)
+	if err := scanPrescription(row, &p); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("prescription not found")
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LockPatient locks a live patient until the transaction of a Storage made by
+// InTx ends, so a prescription safety check made under the lock sees every
+// allergy and prescription of the patient. New allergies and prescriptions
+// wait for it through their foreign key; allergy updates through sharePatient.
+func (s *Storage) LockPatient(ctx context.Context, id int) error {
+	err := s.pool.QueryRow(ctx, `SELECT id FROM patients WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("patient not found")
+	}
+	return err
+}
+
+// LockPrescriptionPatient is LockPatient for the patient of a prescription,
+// deleted or not, so a restore takes the patient lock before the prescription's.
+// A deleted patient is locked too; RestorePrescription then refuses the restore.
+func (s *Storage) LockPrescriptionPatient(ctx context.Context, id int) error {
+	err := s.pool.QueryRow(ctx, `
+SELECT p.id FROM patients p JOIN prescriptions r ON r.patient_id = p.id WHERE r.id = $1 FOR UPDATE OF p`, id).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("prescription not found")
+	}
+	return err
+}
+
+// SetPrescriptionAlerts replaces the safety check findings of a prescription
+// and the reason they were accepted, after the prescription was checked again
+func (s *Storage) SetPrescriptionAlerts(ctx context.Context, p *models.Prescription) error {
+	if p.Alerts == nil {
+		p.Alerts = []models.PrescriptionAlert{}
+	}
+	_, err := s.pool.Exec(ctx, `UPDATE prescriptions SET alerts = $2, override_reason = $3 WHERE id = $1`, p.ID, p.Alerts, p.OverrideReason)
+	return err
+}
+
+// sharePatient waits for a LockPatient on the patient and holds it off until tx ends
+func sharePatient(ctx context.Context, tx pgx.Tx, id int) error {
+	_, err := tx.Exec(ctx, `SELECT 1 FROM patients WHERE id = $1 FOR KEY SHARE`, id)
+	return err
+}
+
+// CreatePrescription inserts p and fills in the computed fields
+func (s *Storage) CreatePrescription(ctx context.Context, p *models.Prescription) (*models.Prescription, error) {
+	alerts := p.Alerts
+	if alerts == nil {
+		alerts = []models.PrescriptionAlert{}
+	}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var id int
+		err := tx.QueryRow(ctx, `
+INSERT INTO prescriptions (patient_id, appointment_id, doctor_id, medication_id, dose, route, frequency,
+                           duration_days, instructions, start_date, status, override_reason, alerts, prescribed_by)
+VALUES ($1, NULLIF($2::integer, 0), NULLIF($3::integer, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+RETURNING id
+`, p.PatientID, p.AppointmentID, p.DoctorID, p.MedicationID, p.Dose, p.Route, p.Frequency,
+			p.DurationDays, p.Instructions, p.StartDate, models.PrescriptionActive, p.OverrideReason, alerts, p.PrescribedBy).Scan(&id)
+		if err != nil {
+			return err
+		}
+		return scanPrescription(tx.QueryRow(ctx, prescriptionSelect+` WHERE prescriptions.id = $1`, id), p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DiscontinuePrescription stops an active prescription; a non-zero version must match the stored one
+func (s *Storage) DiscontinuePrescription(ctx context.Context, id, version int, reason, by string) (*models.Prescription, error) {
+	var p models.Prescription
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, prescriptionSelect+` WHERE prescriptions.id = $1 AND prescriptions.deleted_at IS NULL FOR UPDATE OF prescriptions`, id)
+		if err := scanPrescription(row, &p); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("prescription not found")
+			}
+			return err
+		}
+		if version != 0 && p.Version != version {
+			return ErrVersionMismatch
+		}
+		if p.Status != models.PrescriptionActive {
+			return ErrNotActive
+		}
+
+		return tx.QueryRow(ctx, `
+UPDATE prescriptions
+SET status = $2, discontinued_at = now(), discontinued_by = $3, discontinued_reason = $4, version = version + 1
+WHERE id = $1
+RETURNING status, discontinued_at, discontinued_by, discontinued_reason, version
+`, id, models.PrescriptionDiscontinued, by, reason).Scan(&p.Status, &p.DiscontinuedAt, &p.DiscontinuedBy, &p.DiscontinuedReason, &p.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeletePrescription soft-deletes a prescription entered in error; a non-zero version must match the stored one
+func (s *Storage) DeletePrescription(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "prescriptions", "prescription", id, version, by) })
+}
+
+// RestorePrescription undoes DeletePrescription; the patient must not be deleted
+func (s *Storage) RestorePrescription(ctx context.Context, id int) (*models.Prescription, error) {
+	var p models.Prescription
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "prescriptions", "prescription", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "prescriptions", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE prescriptions SET deleted_at = NULL, deleted_by = '', version = version + 1 WHERE id = $1`, id); err != nil {
+			return err
+		}
+		return scanPrescription(tx.QueryRow(ctx, prescriptionSelect+` WHERE prescriptions.id = $1`, id), &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const problemColumns = `patient_problems.id, patient_problems.patient_id, COALESCE(patient_problems.appointment_id, 0),
+patient_problems.code, patient_problems.description,
+patient_problems.status, COALESCE(TO_CHAR(patient_problems.onset_date, 'YYYY-MM-DD'), ''),
+COALESCE(TO_CHAR(patient_problems.resolved_date, 'YYYY-MM-DD'), ''), patient_problems.notes,
+patient_problems.recorded_at, patient_problems.version, patient_problems.deleted_at, patient_problems.deleted_by`
+
+func scanProblem(row pgx.Row, p *models.Problem) error {
+	return row.Scan(&p.ID, &p.PatientID, &p.AppointmentID, &p.Code, &p.Description, &p.Status, &p.OnsetDate,
+		&p.ResolvedDate, &p.Notes, &p.RecordedAt, &p.Version, &p.DeletedAt, &p.DeletedBy)
+}
+
+// nullDate stores an empty date as NULL
+func nullDate(date string) any {
+	if date == "" {
+		return nil
+	}
+	return date
+}
+
+// GetPatientProblems lists the problems of a patient, open ones first; an
+// empty status lists all. Soft-deleted problems are listed with includeDeleted.
+func (s *Storage) GetPatientProblems(ctx context.Context, patientID int, status string, includeDeleted bool) ([]models.Problem, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+problemColumns+` FROM patient_problems
+WHERE patient_id = $1 AND ($2 = '' OR status = $2) AND ($3 OR deleted_at IS NULL)
+ORDER BY status IN ('inactive', 'remission', 'resolved'), onset_date DESC NULLS LAST, id
+`, patientID, status, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Problem
+	for rows.Next() {
+		var p models.Problem
+		if err := scanProblem(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) GetProblem(ctx context.Context, patientID, id int) (*models.Problem, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+problemColumns+` FROM patient_problems WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, id, patientID)
+	var p models.Problem
+	if err := scanProblem(row, &p); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("problem not found")
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) CreateProblem(ctx context.Context, p *models.Problem) (*models.Problem, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO patient_problems (patient_id, appointment_id, code, description, status, onset_date, resolved_date, notes)
+VALUES ($1, NULLIF($2::integer, 0), $3, $4, $5, $6, $7, $8)
+RETURNING id, recorded_at, version
+`, p.PatientID, p.AppointmentID, p.Code, p.Description, p.Status, nullDate(p.OnsetDate), nullDate(p.ResolvedDate), p.Notes).
+		Scan(&p.ID, &p.RecordedAt, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// UpdateProblem overwrites the row. A non-zero p.Version must match the stored
+// version; on success p.Version holds the new version.
+func (s *Storage) UpdateProblem(ctx context.Context, p *models.Problem) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return updateProblem(ctx, tx, p) })
+}
+
+func updateProblem(ctx context.Context, tx pgx.Tx, p *models.Problem) error {
+	err := tx.QueryRow(ctx, `
+UPDATE patient_problems SET code=$1, description=$2, status=$3, onset_date=$4, resolved_date=$5, notes=$6,
+    appointment_id = NULLIF($10::integer, 0), version = version + 1
+WHERE id=$7 AND patient_id=$8 AND deleted_at IS NULL AND ($9 = 0 OR version = $9)
+RETURNING recorded_at, version
+`, p.Code, p.Description, p.Status, nullDate(p.OnsetDate), nullDate(p.ResolvedDate), p.Notes, p.ID, p.PatientID, p.Version, p.AppointmentID).
+		Scan(&p.RecordedAt, &p.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return problemMissingOrStale(ctx, tx, p.PatientID, p.ID)
+	}
+	return err
+}
+
+// PatchProblem loads the row for update, lets apply modify it and saves the result in one transaction
+func (s *Storage) PatchProblem(ctx context.Context, patientID, id, version int, apply func(*models.Problem) error) (*models.Problem, error) {
+	var p models.Problem
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `SELECT `+problemColumns+` FROM patient_problems WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL FOR UPDATE`, id, patientID)
+		if err := scanProblem(row, &p); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("problem not found")
+			}
+			return err
+		}
+		if version != 0 && p.Version != version {
+			return ErrVersionMismatch
+		}
+
+		current := p.Version
+		if err := apply(&p); err != nil {
+			return err
+		}
+		p.ID, p.PatientID, p.Version = id, patientID, current
+
+		return updateProblem(ctx, tx, &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeleteProblem soft-deletes the row; a non-zero version must match the stored one
+func (s *Storage) DeleteProblem(ctx context.Context, patientID, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx, `
+UPDATE patient_problems SET deleted_at = now(), deleted_by = $4, version = version + 1
+WHERE id=$1 AND patient_id=$2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3)
+`, id, patientID, version, by)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return problemMissingOrStale(ctx, tx, patientID, id)
+		}
+		return nil
+	})
+}
+
+// problemMissingOrStale is missingOrStale for a problem that must belong to the patient
+func problemMissingOrStale(ctx context.Context, tx pgx.Tx, patientID, id int) error {
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM patient_problems WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL)`, id, patientID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("problem not found")
+	}
+	return ErrVersionMismatch
+}
+
+// RestoreProblem undoes DeleteProblem; the patient must not be deleted
+func (s *Storage) RestoreProblem(ctx context.Context, patientID, id int) (*models.Problem, error) {
+	var p models.Problem
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var deleted bool
+		err := tx.QueryRow(ctx, `SELECT deleted_at IS NOT NULL FROM patient_problems WHERE id = $1 AND patient_id = $2 FOR UPDATE`, id, patientID).Scan(&deleted)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("problem not found")
+		}
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("problem is %w", ErrNotDeleted)
+		}
+		if err := requireLivePatient(ctx, tx, "patient_problems", id); err != nil {
+			return err
+		}
+		return scanProblem(tx.QueryRow(ctx, `
+UPDATE patient_problems SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+problemColumns, id), &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPatientsByProblemCode lists patients with a problem whose ICD-10 code
+// starts with prefix; an empty status matches problems in any status
+func (s *Storage) GetPatientsByProblemCode(ctx context.Context, prefix, status string) ([]models.Patient, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+patientColumns+` FROM patients
+WHERE deleted_at IS NULL AND id IN (
+    SELECT patient_id FROM patient_problems
+    WHERE code LIKE $1 || '%' AND ($2 = '' OR status = $2) AND deleted_at IS NULL)
+ORDER BY id
+`, prefix, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Patient
+	for rows.Next() {
+		var p models.Patient
+		if err := scanPatient(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
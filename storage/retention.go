@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PurgeDeleted permanently removes rows soft-deleted before the cutoff and
+// returns how many rows of each table were removed. Records of a patient go
+// before the patient, together with the snapshots of the records merged into
+// it, which hold patient data too; doctors still named by an appointment,
+// prescription, lab order, admission, shift or leave are kept so the history
+// stays complete. A purged doctor leaves the on-call rotations, and the only
+// doctors of a rotation are kept so it is never left empty.
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) (map[string]int, error) {
+	purged := map[string]int{}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		for _, ref := range patientReferences {
+			ct, err := tx.Exec(ctx, `DELETE FROM `+ref.table+` WHERE deleted_at < $1`, before)
+			if err != nil {
+				return err
+			}
+			purged[ref.table] = int(ct.RowsAffected())
+		}
+		ct, err := tx.Exec(ctx, `
+DELETE FROM patient_merges
+WHERE survivor_id IN (SELECT id FROM patients WHERE deleted_at < $1)`, before)
+		if err != nil {
+			return err
+		}
+		purged["patient_merges"] = int(ct.RowsAffected())
+		ct, err = tx.Exec(ctx, `DELETE FROM patients WHERE deleted_at < $1`, before)
+		if err != nil {
+			return err
+		}
+		purged["patients"] = int(ct.RowsAffected())
+		rows, err := tx.Query(ctx, `
+DELETE FROM doctors
+WHERE deleted_at < $1
+  AND NOT EXISTS (SELECT 1 FROM appointments WHERE appointments.doctor_id = doctors.id)
+  AND NOT EXISTS (SELECT 1 FROM prescriptions WHERE prescriptions.doctor_id = doctors.id)
+  AND NOT EXISTS (SELECT 1 FROM lab_orders WHERE lab_orders.doctor_id = doctors.id)
+  AND NOT EXISTS (SELECT 1 FROM admissions WHERE admissions.attending_doctor_id = doctors.id)
+  AND NOT EXISTS (SELECT 1 FROM shifts WHERE shifts.doctor_id = doctors.id)
+  AND NOT EXISTS (SELECT 1 FROM leaves WHERE leaves.doctor_id = doctors.id)
+  AND NOT EXISTS (
+      SELECT 1 FROM oncall_rotations o
+      WHERE doctors.id = ANY (o.doctor_ids)
+        AND NOT EXISTS (SELECT 1 FROM doctors d WHERE d.id = ANY (o.doctor_ids) AND d.deleted_at IS NULL))
+RETURNING id
+`, before)
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return err
+		}
+		purged["doctors"] = len(ids)
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `
+UPDATE oncall_rotations
+SET doctor_ids = ARRAY(SELECT d FROM unnest(doctor_ids) WITH ORDINALITY AS u(d, n) WHERE d <> ALL ($1) ORDER BY n),
+    version = version + 1
+WHERE doctor_ids && $1
+`, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
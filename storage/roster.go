@@ -0,0 +1,489 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Appointments are booked in slots of AppointmentDuration at a date and time
+// local to ScheduleLocation; main sets the zone of the hospital
+var (
+	ScheduleLocation    = time.UTC
+	AppointmentDuration = 30 * time.Minute
+)
+
+// ErrShiftOverlap is returned when a doctor would work two shifts at once
+var ErrShiftOverlap = errors.New("the doctor already has a shift at this time")
+
+// ErrOnLeave is returned when rostering or booking a doctor on approved leave
+var ErrOnLeave = errors.New("the doctor is on approved leave")
+
+// ErrOffShift is returned when booking a rostered doctor outside the shifts
+var ErrOffShift = errors.New("the doctor has no shift at this time")
+
+const shiftSelect = `SELECT s.id, s.doctor_id, doc.first_name || ' ' || doc.last_name, doc.specialization,
+COALESCE(s.department_id, 0), s.kind, s.starts_at, s.ends_at, COALESCE(s.rotation_id, 0), s.note, s.created_by, s.version
+FROM shifts s
+JOIN doctors doc ON doc.id = s.doctor_id
+`
+
+func queryShifts(ctx context.Context, q querier, sql string, args ...any) ([]models.Shift, error) {
+	rows, err := q.Query(ctx, sql+`
+ORDER BY s.starts_at, s.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Shift
+	for rows.Next() {
+		var sh models.Shift
+		var specialization string
+		if err := rows.Scan(&sh.ID, &sh.DoctorID, &sh.DoctorName, &specialization, &sh.DepartmentID, &sh.Kind,
+			&sh.StartsAt, &sh.EndsAt, &sh.RotationID, &sh.Note, &sh.CreatedBy, &sh.Version); err != nil {
+			return nil, err
+		}
+		sh.SpecialtyCode = specialtyCode(specialization)
+		out = append(out, sh)
+	}
+	return out, rows.Err()
+}
+
+func getShift(ctx context.Context, q querier, id int) (*models.Shift, error) {
+	shifts, err := queryShifts(ctx, q, shiftSelect+`WHERE s.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(shifts) == 0 {
+		return nil, fmt.Errorf("shift not found")
+	}
+	return &shifts[0], nil
+}
+
+func (s *Storage) GetShift(ctx context.Context, id int) (*models.Shift, error) {
+	return getShift(ctx, s.pool, id)
+}
+
+// GetShifts lists the shifts overlapping [from, to), narrowed to a doctor, to a
+// department and its sub-departments and to a kind when those are not zero
+func (s *Storage) GetShifts(ctx context.Context, from, to time.Time, doctorID, departmentID int, kind string) ([]models.Shift, error) {
+	return queryShifts(ctx, s.pool, departmentSubtree+shiftSelect+`
+WHERE s.starts_at < $3 AND s.ends_at > $2
+  AND ($4 = 0 OR s.doctor_id = $4)
+  AND ($1 = 0 OR s.department_id IN (SELECT id FROM subtree))
+  AND ($5 = '' OR s.kind = $5)`, departmentID, from, to, doctorID, kind)
+}
+
+// GetShiftsAt lists the shifts of a kind under way at a moment, of doctors who
+// are not deleted; departmentID narrows to a department and its sub-departments
+func (s *Storage) GetShiftsAt(ctx context.Context, kind string, at time.Time, departmentID int) ([]models.Shift, error) {
+	return queryShifts(ctx, s.pool, departmentSubtree+shiftSelect+`
+WHERE s.kind = $2 AND s.starts_at <= $3 AND s.ends_at > $3 AND doc.deleted_at IS NULL
+  AND ($1 = 0 OR s.department_id IN (SELECT id FROM subtree))`, departmentID, kind, at)
+}
+
+// lockRoster locks a doctor so shifts and leave of the doctor are checked and
+// written one transaction at a time
+func lockRoster(ctx context.Context, tx pgx.Tx, doctorID int) error {
+	var id int
+	err := tx.QueryRow(ctx, `SELECT id FROM doctors WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, doctorID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("doctor not found")
+	}
+	return err
+}
+
+// checkShift refuses a shift that overlaps another shift of the doctor, other
+// than the one with excludeID, or falls on a day of approved leave
+func checkShift(ctx context.Context, tx pgx.Tx, sh *models.Shift, excludeID int) error {
+	var overlap, onLeave bool
+	err := tx.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM shifts WHERE doctor_id = $1 AND id <> $2 AND starts_at < $4 AND ends_at > $3),
+       EXISTS (SELECT 1 FROM leaves WHERE doctor_id = $1 AND status = 'approved'
+               AND start_date <= (($4::timestamptz - interval '1 microsecond') AT TIME ZONE $5)::date
+               AND end_date >= ($3::timestamptz AT TIME ZONE $5)::date)
+`, sh.DoctorID, excludeID, sh.StartsAt, sh.EndsAt, ScheduleLocation.String()).Scan(&overlap, &onLeave)
+	switch {
+	case err != nil:
+		return err
+	case onLeave:
+		return ErrOnLeave
+	case overlap:
+		return ErrShiftOverlap
+	}
+	return nil
+}
+
+func insertShift(ctx context.Context, tx pgx.Tx, sh *models.Shift) error {
+	if err := lockRoster(ctx, tx, sh.DoctorID); err != nil {
+		return err
+	}
+	if err := checkShift(ctx, tx, sh, 0); err != nil {
+		return err
+	}
+	return tx.QueryRow(ctx, `
+INSERT INTO shifts (doctor_id, department_id, kind, starts_at, ends_at, rotation_id, note, created_by)
+VALUES ($1, NULLIF($2, 0), $3, $4, $5, NULLIF($6, 0), $7, $8)
+RETURNING id, version
+`, sh.DoctorID, sh.DepartmentID, sh.Kind, sh.StartsAt, sh.EndsAt, sh.RotationID, sh.Note, sh.CreatedBy).Scan(&sh.ID, &sh.Version)
+}
+
+// CreateShift rosters a doctor; ErrShiftOverlap or ErrOnLeave when the doctor is not free
+func (s *Storage) CreateShift(ctx context.Context, sh *models.Shift) (*models.Shift, error) {
+	var out *models.Shift
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if err := insertShift(ctx, tx, sh); err != nil {
+			return err
+		}
+		var err error
+		out, err = getShift(ctx, tx, sh.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateShift moves or reassigns a shift; a non-zero sh.Version must match the stored one
+func (s *Storage) UpdateShift(ctx context.Context, sh *models.Shift) (*models.Shift, error) {
+	var out *models.Shift
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var version int
+		err := tx.QueryRow(ctx, `SELECT version FROM shifts WHERE id = $1 FOR UPDATE`, sh.ID).Scan(&version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("shift not found")
+		}
+		if err != nil {
+			return err
+		}
+		if sh.Version != 0 && sh.Version != version {
+			return ErrVersionMismatch
+		}
+		if err := lockRoster(ctx, tx, sh.DoctorID); err != nil {
+			return err
+		}
+		if err := checkShift(ctx, tx, sh, sh.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE shifts SET doctor_id = $2, department_id = NULLIF($3, 0), kind = $4, starts_at = $5, ends_at = $6, note = $7, version = version + 1
+WHERE id = $1
+`, sh.ID, sh.DoctorID, sh.DepartmentID, sh.Kind, sh.StartsAt, sh.EndsAt, sh.Note); err != nil {
+			return err
+		}
+		out, err = getShift(ctx, tx, sh.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteShift takes a shift off the roster; a non-zero version must match the stored one
+func (s *Storage) DeleteShift(ctx context.Context, id, version int) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `DELETE FROM shifts WHERE id = $1 AND ($2 = 0 OR version = $2)`, id, version)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 1 {
+			return nil
+		}
+		return facilityUpdated(ctx, tx, pgx.ErrNoRows, "shifts", "shift", id)
+	})
+}
+
+// cancelled reports whether an appointment status frees its slot
+func cancelled(status string) bool {
+	status = strings.ToLower(status)
+	return status == "cancelled" || status == "canceled"
+}
+
+// appointmentPeriod is the time an appointment takes; without a time it is the whole day
+func appointmentPeriod(a *models.Appointment) (start, end time.Time, exact bool, err error) {
+	day, err := time.ParseInLocation("2006-01-02", a.Date, ScheduleLocation)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	if a.Time == "" {
+		return day, day.AddDate(0, 0, 1), false, nil
+	}
+	clock, err := copyTime(a.Time)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	// the wall clock of the day, as adding it to midnight is off on a DST change
+	offset := time.Duration(clock.Microseconds) * time.Microsecond
+	y, m, d := day.Date()
+	start = time.Date(y, m, d, int(offset/time.Hour), int(offset%time.Hour/time.Minute),
+		int(offset%time.Minute/time.Second), int(offset%time.Second), ScheduleLocation)
+	return start, start.Add(AppointmentDuration), true, nil
+}
+
+// checkBookable refuses an appointment on a day of approved leave and, for a
+// doctor with a roster, outside the shifts; cancelled appointments are not checked
+func checkBookable(ctx context.Context, tx pgx.Tx, a *models.Appointment) error {
+	if a.DoctorID == 0 || cancelled(a.Status) {
+		return nil
+	}
+	start, end, exact, err := appointmentPeriod(a)
+	if err != nil {
+		return err
+	}
+	// waits for a concurrent roster change of the doctor
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM doctors WHERE id = $1 FOR SHARE`, a.DoctorID); err != nil {
+		return err
+	}
+
+	var onLeave, rostered, covered bool
+	err = tx.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM leaves WHERE doctor_id = $1 AND status = 'approved' AND $2::date BETWEEN start_date AND end_date),
+       EXISTS (SELECT 1 FROM shifts WHERE doctor_id = $1),
+       EXISTS (SELECT 1 FROM shifts WHERE doctor_id = $1
+               AND CASE WHEN $5 THEN starts_at <= $3 AND ends_at >= $4 ELSE starts_at < $4 AND ends_at > $3 END)
+`, a.DoctorID, a.Date, start, end, exact).Scan(&onLeave, &rostered, &covered)
+	switch {
+	case err != nil:
+		return err
+	case onLeave:
+		return ErrOnLeave
+	case rostered && !covered:
+		return ErrOffShift
+	}
+	return nil
+}
+
+// rebooked reports whether saving a over the stored row takes a new slot
+func rebooked(ctx context.Context, tx pgx.Tx, a *models.Appointment) (bool, error) {
+	var old models.Appointment
+	if err := scanAppointment(tx.QueryRow(ctx, `SELECT `+appointmentColumns+` FROM appointments WHERE id = $1`, a.ID), &old); err != nil {
+		return false, err
+	}
+	return old.DoctorID != a.DoctorID || old.Date != a.Date || clockSeconds(old.Time) != clockSeconds(a.Time) ||
+		cancelled(old.Status) && !cancelled(a.Status), nil
+}
+
+// clockSeconds makes 10:00 and 10:00:00 compare equal
+func clockSeconds(clock string) string {
+	if len(clock) == len("15:04") {
+		return clock + ":00"
+	}
+	return clock
+}
+
+// GetAvailability returns the shifts of a doctor on a day and the free
+// appointment slots in them; a doctor without a roster has no slots to list
+func (s *Storage) GetAvailability(ctx context.Context, doctorID int, date string) (*models.Availability, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, ScheduleLocation)
+	if err != nil {
+		return nil, err
+	}
+	next := day.AddDate(0, 0, 1)
+	out := &models.Availability{DoctorID: doctorID, Date: date, Shifts: []models.Shift{}, Slots: []models.Slot{}}
+
+	err = s.pool.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM leaves WHERE doctor_id = $1 AND status = 'approved' AND $2::date BETWEEN start_date AND end_date),
+       EXISTS (SELECT 1 FROM shifts WHERE doctor_id = $1)
+`, doctorID, date).Scan(&out.OnLeave, &out.Rostered)
+	if err != nil {
+		return nil, err
+	}
+	shifts, err := queryShifts(ctx, s.pool, shiftSelect+`WHERE s.doctor_id = $1 AND s.starts_at < $3 AND s.ends_at > $2`, doctorID, day, next)
+	if err != nil {
+		return nil, err
+	}
+	if shifts != nil {
+		out.Shifts = shifts
+	}
+	if out.OnLeave {
+		return out, nil
+	}
+
+	rows, err := s.pool.Query(ctx, `
+SELECT TO_CHAR(time, 'HH24:MI:SS') FROM appointments
+WHERE doctor_id = $1 AND date = $2::date AND time IS NOT NULL AND deleted_at IS NULL
+  AND lower(COALESCE(status, '')) NOT IN ('cancelled', 'canceled')
+`, doctorID, date)
+	if err != nil {
+		return nil, err
+	}
+	clocks, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	var booked []time.Time
+	for _, clock := range clocks {
+		start, _, _, err := appointmentPeriod(&models.Appointment{Date: date, Time: clock})
+		if err != nil {
+			return nil, err
+		}
+		booked = append(booked, start)
+	}
+
+	for _, sh := range shifts {
+		// slots start on the shift start, clipped to the day
+		start := sh.StartsAt
+		for start.Before(day) {
+			start = start.Add(AppointmentDuration)
+		}
+		for ; !start.Add(AppointmentDuration).After(sh.EndsAt) && start.Before(next); start = start.Add(AppointmentDuration) {
+			end := start.Add(AppointmentDuration)
+			free := true
+			for _, b := range booked {
+				if b.Before(end) && b.Add(AppointmentDuration).After(start) {
+					free = false
+					break
+				}
+			}
+			if free {
+				local := start.In(ScheduleLocation)
+				out.Slots = append(out.Slots, models.Slot{Date: local.Format("2006-01-02"), Time: local.Format("15:04")})
+			}
+		}
+	}
+	return out, nil
+}
+
+//
+// --- On-call rotations ---
+//
+
+const rotationColumns = `id, name, COALESCE(department_id, 0), doctor_ids, starts_at, shift_hours, version`
+
+func scanRotation(row pgx.Row, r *models.OnCallRotation) error {
+	return row.Scan(&r.ID, &r.Name, &r.DepartmentID, &r.DoctorIDs, &r.StartsAt, &r.ShiftHours, &r.Version)
+}
+
+// rotationConflict turns a taken rotation name into ErrDuplicate
+func rotationConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "oncall_rotations_name_key" {
+		return fmt.Errorf("%w: rotation name is already taken", ErrDuplicate)
+	}
+	return err
+}
+
+func (s *Storage) GetRotations(ctx context.Context) ([]models.OnCallRotation, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+rotationColumns+` FROM oncall_rotations ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.OnCallRotation
+	for rows.Next() {
+		var r models.OnCallRotation
+		if err := scanRotation(rows, &r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) GetRotation(ctx context.Context, id int) (*models.OnCallRotation, error) {
+	var r models.OnCallRotation
+	if err := scanRotation(s.pool.QueryRow(ctx, `SELECT `+rotationColumns+` FROM oncall_rotations WHERE id = $1`, id), &r); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("rotation not found")
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Storage) CreateRotation(ctx context.Context, r *models.OnCallRotation) (*models.OnCallRotation, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO oncall_rotations (name, department_id, doctor_ids, starts_at, shift_hours)
+VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+RETURNING id, version
+`, r.Name, r.DepartmentID, r.DoctorIDs, r.StartsAt, r.ShiftHours).Scan(&r.ID, &r.Version)
+	if err != nil {
+		return nil, rotationConflict(err)
+	}
+	return r, nil
+}
+
+// UpdateRotation overwrites the row; a non-zero r.Version must match the stored one.
+// Shifts generated before keep their doctors.
+func (s *Storage) UpdateRotation(ctx context.Context, r *models.OnCallRotation) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE oncall_rotations SET name = $1, department_id = NULLIF($2, 0), doctor_ids = $3, starts_at = $4, shift_hours = $5, version = version + 1
+WHERE id = $6 AND ($7 = 0 OR version = $7)
+RETURNING version
+`, r.Name, r.DepartmentID, r.DoctorIDs, r.StartsAt, r.ShiftHours, r.ID, r.Version).Scan(&r.Version)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return rotationConflict(err)
+		}
+		return facilityUpdated(ctx, tx, err, "oncall_rotations", "rotation", r.ID)
+	})
+}
+
+// GenerateRotation rosters the on-call turns of a rotation that start in [from, to).
+// Turns already generated are left alone; turns whose doctor is on leave, busy
+// or deleted are reported as skipped and stay uncovered.
+func (s *Storage) GenerateRotation(ctx context.Context, id int, from, to time.Time, by string) (*models.RotationRun, error) {
+	out := &models.RotationRun{Created: []models.Shift{}, Skipped: []models.RotationGap{}}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var r models.OnCallRotation
+		err := scanRotation(tx.QueryRow(ctx, `SELECT `+rotationColumns+` FROM oncall_rotations WHERE id = $1 FOR UPDATE`, id), &r)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("rotation not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		turn := time.Duration(r.ShiftHours) * time.Hour
+		k := 0
+		if from.After(r.StartsAt) {
+			k = int((from.Sub(r.StartsAt) + turn - 1) / turn)
+		}
+		var ids []int
+		for start := r.StartsAt.Add(time.Duration(k) * turn); start.Before(to); k, start = k+1, start.Add(turn) {
+			sh := models.Shift{
+				DoctorID: r.DoctorIDs[k%len(r.DoctorIDs)], DepartmentID: r.DepartmentID, Kind: models.ShiftOnCall,
+				StartsAt: start, EndsAt: start.Add(turn), RotationID: r.ID, Note: r.Name, CreatedBy: by,
+			}
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM shifts WHERE rotation_id = $1 AND starts_at = $2)`, r.ID, start).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			err := savepoint(ctx, tx, func(sp pgx.Tx) error { return insertShift(ctx, sp, &sh) })
+			switch {
+			case err == nil:
+				ids = append(ids, sh.ID)
+			case errors.Is(err, ErrOnLeave), errors.Is(err, ErrShiftOverlap), strings.Contains(err.Error(), "not found"):
+				out.Skipped = append(out.Skipped, models.RotationGap{DoctorID: sh.DoctorID, StartsAt: sh.StartsAt, EndsAt: sh.EndsAt, Reason: err.Error()})
+			default:
+				return err
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		created, err := queryShifts(ctx, tx, shiftSelect+`WHERE s.id = ANY($1)`, ids)
+		if err != nil {
+			return err
+		}
+		out.Created = created
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
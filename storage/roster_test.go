@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+)
+
+func TestAppointmentPeriodOnDSTChange(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Kyiv")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	saved := ScheduleLocation
+	ScheduleLocation = loc
+	defer func() { ScheduleLocation = saved }()
+
+	// clocks go forward at 03:00 on 2025-03-30 and back at 04:00 on 2025-10-26
+	for _, date := range []string{"2025-03-30", "2025-10-26"} {
+		start, _, exact, err := appointmentPeriod(&models.Appointment{Date: date, Time: "10:30"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := start.In(loc).Format("2006-01-02 15:04"); !exact || got != date+" 10:30" {
+			t.Errorf("%s 10:30 starts at %s", date, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+)
+
+// Search types
+const (
+	SearchPatient     = "patient"
+	SearchDoctor      = "doctor"
+	SearchAppointment = "appointment"
+)
+
+// searchMatch matches a search_document against the folded query $1 (pg_trgm
+// word similarity, tolerates typos) or the prefix tsquery $2
+const searchMatch = `(to_tsvector('simple', %[1]s.search_document) @@ to_tsquery('simple', $2) OR $1 <%% %[1]s.search_document)`
+
+// searchScore ranks full-text hits and close spellings together
+const searchScore = `(ts_rank(to_tsvector('simple', %[1]s.search_document), to_tsquery('simple', $2)) + word_similarity($1, %[1]s.search_document))`
+
+// searchSources select (type, id, title, subtitle, score, fields) for the
+// types in $3. Appointments are found through their patient and doctor and
+// rank slightly below direct hits.
+var searchSources = []string{
+	`
+SELECT 'patient' AS type, p.id AS id, p.first_name || ' ' || p.last_name AS title,
+       p.mrn || COALESCE(', born ' || TO_CHAR(p.date_of_birth, 'YYYY-MM-DD'), '') AS subtitle,
+       ` + fmt.Sprintf(searchScore, "p") + ` AS score,
+       jsonb_build_object('first_name', p.first_name, 'last_name', p.last_name, 'mrn', p.mrn,
+                          'national_id', p.national_id, 'phone', p.phone, 'email', p.email,
+                          'city', p.city, 'diagnosis', COALESCE(p.diagnosis, '')) AS fields
+FROM patients p
+WHERE 'patient' = ANY($3) AND p.deleted_at IS NULL AND ` + fmt.Sprintf(searchMatch, "p"),
+	`
+SELECT 'doctor', d.id, 'Dr. ' || d.first_name || ' ' || d.last_name, d.specialization,
+       ` + fmt.Sprintf(searchScore, "d") + `,
+       jsonb_build_object('first_name', d.first_name, 'last_name', d.last_name, 'specialization', d.specialization)
+FROM doctors d
+WHERE 'doctor' = ANY($3) AND d.deleted_at IS NULL AND ` + fmt.Sprintf(searchMatch, "d"),
+	`
+SELECT 'appointment', a.id,
+       p.first_name || ' ' || p.last_name || ' with Dr. ' || COALESCE(d.first_name || ' ' || d.last_name, 'unknown'),
+       concat_ws(' ', TO_CHAR(a.date, 'YYYY-MM-DD'), TO_CHAR(a.time, 'HH24:MI'), a.status),
+       0.9 * GREATEST(CASE WHEN ` + fmt.Sprintf(searchMatch, "p") + ` THEN ` + fmt.Sprintf(searchScore, "p") + ` ELSE 0 END,
+                      CASE WHEN ` + fmt.Sprintf(searchMatch, "d") + ` THEN ` + fmt.Sprintf(searchScore, "d") + ` ELSE 0 END),
+       jsonb_build_object('patient_name', p.first_name || ' ' || p.last_name,
+                          'doctor_name', COALESCE(d.first_name || ' ' || d.last_name, ''),
+                          'specialization', COALESCE(d.specialization, ''), 'status', COALESCE(a.status, ''))
+FROM appointments a
+JOIN patients p ON p.id = a.patient_id
+LEFT JOIN doctors d ON d.id = a.doctor_id
+WHERE 'appointment' = ANY($3) AND a.deleted_at IS NULL AND (` + fmt.Sprintf(searchMatch, "p") + ` OR COALESCE(` + fmt.Sprintf(searchMatch, "d") + `, false))`,
+}
+
+// Search ranks patients, doctors and appointments. folded is the query folded
+// with translit.Fold, tsquery a prefix query such as "petr:* & ivan:*".
+func (s *Storage) Search(ctx context.Context, folded, tsquery string, types []string, limit int) ([]models.SearchResult, error) {
+	query := `SELECT * FROM (` + strings.Join(searchSources, "\nUNION ALL\n") + `
+) hits
+ORDER BY score DESC, type, id
+LIMIT $4`
+	rows, err := s.pool.Query(ctx, query, folded, tsquery, types, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		if err := rows.Scan(&r.Type, &r.ID, &r.Title, &r.Subtitle, &r.Score, &r.Fields); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
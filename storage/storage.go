@@ -2,19 +2,46 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/TeseySTD/GoHospitalApi/specialties"
+	"github.com/TeseySTD/GoHospitalApi/translit"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Storage struct {
-	pool *pgxpool.Pool
+	pool conn
+}
+
+// conn is the pool, or a transaction for a Storage made by InTx
+type conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 var Store *Storage
 
+// ErrVersionMismatch is returned when the row was changed since the client read it
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrDuplicate is returned when a unique identifier is already taken by another row
+var ErrDuplicate = errors.New("duplicate identifier")
+
+// ErrNotDeleted is returned when restoring a row that is not deleted
+var ErrNotDeleted = errors.New("not deleted")
+
+// ErrParentDeleted is returned when restoring a row whose patient is still deleted
+var ErrParentDeleted = errors.New("the patient of the record is deleted")
+
 // New creates storage wrapper
 func New(pool *pgxpool.Pool) *Storage {
 	return &Storage{pool: pool}
@@ -38,15 +65,9 @@ func ConnectPool(ctx context.Context, connString string) (*pgxpool.Pool, error)
 	return pool, nil
 }
 
-// Migrate creates tables if they do not exist
-func (s *Storage) Migrate(ctx context.Context) error {
-	tx, err := s.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	_, err = tx.Exec(ctx, `
+// migrations are applied in order on every start, so each statement must be idempotent
+var migrations = []string{
+	`
 CREATE TABLE IF NOT EXISTS doctors (
     id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     first_name text NOT NULL,
@@ -54,12 +75,8 @@ CREATE TABLE IF NOT EXISTS doctors (
     specialization text NOT NULL,
     experience integer NOT NULL
 );
-`)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(ctx, `
+`,
+	`
 CREATE TABLE IF NOT EXISTS patients (
     id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     first_name text NOT NULL,
@@ -67,12 +84,8 @@ CREATE TABLE IF NOT EXISTS patients (
     age        integer NOT NULL,
     diagnosis  text
 );
-`)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(ctx, `
+`,
+	`
 CREATE TABLE IF NOT EXISTS appointments (
     id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     patient_id integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
@@ -81,11 +94,724 @@ CREATE TABLE IF NOT EXISTS appointments (
     time       time,
     status     text
 );
-`)
+`,
+	`
+CREATE TABLE IF NOT EXISTS hl7_messages (
+    id           integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    received_at  timestamptz NOT NULL DEFAULT now(),
+    control_id   text NOT NULL DEFAULT '',
+    message_type text NOT NULL DEFAULT '',
+    raw          text NOT NULL,
+    status       text NOT NULL DEFAULT 'received',
+    error        text NOT NULL DEFAULT ''
+);
+`,
+	`
+CREATE TABLE IF NOT EXISTS hl7_links (
+    kind        text NOT NULL,
+    external_id text NOT NULL,
+    entity_id   integer NOT NULL,
+    PRIMARY KEY (kind, external_id)
+);
+`,
+	`
+CREATE TABLE IF NOT EXISTS appointment_status_history (
+    id             integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    appointment_id integer NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    old_status     text NOT NULL DEFAULT '',
+    new_status     text NOT NULL DEFAULT '',
+    changed_at     timestamptz NOT NULL DEFAULT now()
+);
+`,
+	`ALTER TABLE patients ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1`,
+	`ALTER TABLE doctors ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1`,
+	`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1`,
+	`
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope        text NOT NULL,
+    key          text NOT NULL,
+    request_hash text NOT NULL,
+    status       integer NOT NULL DEFAULT 0,
+    header       jsonb NOT NULL DEFAULT '{}',
+    body         bytea NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (scope, key)
+);
+`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_created_at ON idempotency_keys (created_at)`,
+	`CREATE SEQUENCE IF NOT EXISTS patient_mrn_seq`,
+	`
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS mrn text NOT NULL DEFAULT ('MRN' || lpad(nextval('patient_mrn_seq')::text, 8, '0')),
+    ADD COLUMN IF NOT EXISTS date_of_birth date,
+    ADD COLUMN IF NOT EXISTS sex text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS gender text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS phone text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS email text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS address_line text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS city text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS postal_code text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS country text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS national_id text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS emergency_contact_name text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS emergency_contact_phone text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS emergency_contact_relation text NOT NULL DEFAULT ''
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS patients_mrn_key ON patients (mrn)`,
+	`
+CREATE TABLE IF NOT EXISTS patient_problems (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id    integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    code          text NOT NULL,
+    description   text NOT NULL DEFAULT '',
+    status        text NOT NULL DEFAULT 'active',
+    onset_date    date,
+    resolved_date date,
+    notes         text NOT NULL DEFAULT '',
+    recorded_at   timestamptz NOT NULL DEFAULT now(),
+    version       integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE INDEX IF NOT EXISTS patient_problems_patient_id ON patient_problems (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS patient_problems_code ON patient_problems (code text_pattern_ops)`,
+	`
+CREATE TABLE IF NOT EXISTS patient_duplicate_dismissals (
+    patient_id   integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    duplicate_id integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    dismissed_by text NOT NULL DEFAULT '',
+    dismissed_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (patient_id, duplicate_id),
+    CHECK (patient_id < duplicate_id)
+);
+`,
+	`
+CREATE TABLE IF NOT EXISTS patient_merges (
+    id          integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    survivor_id integer NOT NULL,
+    merged_id   integer NOT NULL,
+    merged_mrn  text NOT NULL DEFAULT '',
+    merged      jsonb NOT NULL,
+    moved       jsonb NOT NULL DEFAULT '{}',
+    merged_by   text NOT NULL DEFAULT '',
+    merged_at   timestamptz NOT NULL DEFAULT now()
+);
+`,
+	`CREATE INDEX IF NOT EXISTS patient_merges_survivor_id ON patient_merges (survivor_id)`,
+	`CREATE INDEX IF NOT EXISTS patient_merges_merged_id ON patient_merges (merged_id)`,
+	// search: documents folded to Latin, ranked with full-text search and pg_trgm
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	translit.FunctionSQL("search_fold"),
+	`
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS search_document text GENERATED ALWAYS AS (search_fold(
+    first_name || ' ' || last_name || ' ' || mrn || ' ' || national_id || ' ' || phone || ' ' ||
+    email || ' ' || city || ' ' || COALESCE(diagnosis, ''))) STORED
+`,
+	`
+ALTER TABLE doctors ADD COLUMN IF NOT EXISTS search_document text GENERATED ALWAYS AS (search_fold(
+    first_name || ' ' || last_name || ' ' || specialization)) STORED
+`,
+	`CREATE INDEX IF NOT EXISTS patients_search_trgm ON patients USING gin (search_document gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS patients_search_fts ON patients USING gin (to_tsvector('simple', search_document))`,
+	`CREATE INDEX IF NOT EXISTS doctors_search_trgm ON doctors USING gin (search_document gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS doctors_search_fts ON doctors USING gin (to_tsvector('simple', search_document))`,
+	// soft deletion: deleted rows are hidden until restored or purged after the retention period, if one is set
+	`ALTER TABLE patients ADD COLUMN IF NOT EXISTS deleted_at timestamptz, ADD COLUMN IF NOT EXISTS deleted_by text NOT NULL DEFAULT ''`,
+	`ALTER TABLE doctors ADD COLUMN IF NOT EXISTS deleted_at timestamptz, ADD COLUMN IF NOT EXISTS deleted_by text NOT NULL DEFAULT ''`,
+	`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS deleted_at timestamptz, ADD COLUMN IF NOT EXISTS deleted_by text NOT NULL DEFAULT ''`,
+	`ALTER TABLE patient_problems ADD COLUMN IF NOT EXISTS deleted_at timestamptz, ADD COLUMN IF NOT EXISTS deleted_by text NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS patients_deleted_at ON patients (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS doctors_deleted_at ON doctors (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS appointments_deleted_at ON appointments (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS patient_problems_deleted_at ON patient_problems (deleted_at) WHERE deleted_at IS NOT NULL`,
+	// a deleted patient no longer holds its national ID; restoring it fails while another patient does
+	`DROP INDEX IF EXISTS patients_national_id_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS patients_national_id_live_key ON patients (national_id) WHERE national_id <> '' AND deleted_at IS NULL`,
+	`
+CREATE TABLE IF NOT EXISTS medications (
+    id       integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name     text NOT NULL,
+    strength text NOT NULL DEFAULT '',
+    form     text NOT NULL DEFAULT '',
+    atc_code text NOT NULL DEFAULT '',
+    version  integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS medications_name_key ON medications (lower(name), lower(strength), lower(form))`,
+	`
+CREATE TABLE IF NOT EXISTS prescriptions (
+    id                  integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id          integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    appointment_id      integer REFERENCES appointments(id) ON DELETE SET NULL,
+    doctor_id           integer REFERENCES doctors(id) ON DELETE SET NULL,
+    medication_id       integer NOT NULL REFERENCES medications(id),
+    dose                text NOT NULL,
+    route               text NOT NULL,
+    frequency           text NOT NULL,
+    duration_days       integer NOT NULL DEFAULT 0,
+    instructions        text NOT NULL DEFAULT '',
+    start_date          date NOT NULL DEFAULT CURRENT_DATE,
+    end_date            date GENERATED ALWAYS AS (CASE WHEN duration_days > 0 THEN start_date + duration_days - 1 END) STORED,
+    status              text NOT NULL DEFAULT 'active',
+    discontinued_at     timestamptz,
+    discontinued_by     text NOT NULL DEFAULT '',
+    discontinued_reason text NOT NULL DEFAULT '',
+    prescribed_by       text NOT NULL DEFAULT '',
+    prescribed_at       timestamptz NOT NULL DEFAULT now(),
+    version             integer NOT NULL DEFAULT 1,
+    deleted_at          timestamptz,
+    deleted_by          text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS prescriptions_patient_id ON prescriptions (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS prescriptions_appointment_id ON prescriptions (appointment_id)`,
+	`CREATE INDEX IF NOT EXISTS prescriptions_deleted_at ON prescriptions (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS patient_allergies (
+    id          integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id  integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    substance   text NOT NULL,
+    atc_code    text NOT NULL DEFAULT '',
+    reaction    text NOT NULL DEFAULT '',
+    severity    text NOT NULL,
+    status      text NOT NULL DEFAULT 'active',
+    notes       text NOT NULL DEFAULT '',
+    recorded_by text NOT NULL DEFAULT '',
+    recorded_at timestamptz NOT NULL DEFAULT now(),
+    version     integer NOT NULL DEFAULT 1,
+    deleted_at  timestamptz,
+    deleted_by  text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS patient_allergies_patient_id ON patient_allergies (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS patient_allergies_deleted_at ON patient_allergies (deleted_at) WHERE deleted_at IS NOT NULL`,
+	// warnings of the safety check that the prescriber accepted
+	`
+ALTER TABLE prescriptions
+    ADD COLUMN IF NOT EXISTS override_reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS alerts jsonb NOT NULL DEFAULT '[]'
+`,
+	`
+CREATE TABLE IF NOT EXISTS lab_tests (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    code          text NOT NULL,
+    name          text NOT NULL,
+    unit          text NOT NULL DEFAULT '',
+    panel         text NOT NULL DEFAULT '',
+    ref_low       double precision,
+    ref_high      double precision,
+    critical_low  double precision,
+    critical_high double precision,
+    version       integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS lab_tests_code_key ON lab_tests (upper(code))`,
+	`
+CREATE TABLE IF NOT EXISTS lab_orders (
+    id               integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id       integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    appointment_id   integer REFERENCES appointments(id) ON DELETE SET NULL,
+    doctor_id        integer REFERENCES doctors(id) ON DELETE SET NULL,
+    priority         text NOT NULL DEFAULT 'routine',
+    status           text NOT NULL DEFAULT 'ordered',
+    notes            text NOT NULL DEFAULT '',
+    ordered_by       text NOT NULL DEFAULT '',
+    ordered_at       timestamptz NOT NULL DEFAULT now(),
+    results_ready_at timestamptz,
+    version          integer NOT NULL DEFAULT 1,
+    deleted_at       timestamptz,
+    deleted_by       text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS lab_orders_patient_id ON lab_orders (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS lab_orders_appointment_id ON lab_orders (appointment_id)`,
+	`CREATE INDEX IF NOT EXISTS lab_orders_results_ready_at ON lab_orders (results_ready_at) WHERE results_ready_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS lab_orders_deleted_at ON lab_orders (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS lab_results (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    order_id      integer NOT NULL REFERENCES lab_orders(id) ON DELETE CASCADE,
+    test_id       integer NOT NULL REFERENCES lab_tests(id),
+    code          text NOT NULL,
+    name          text NOT NULL,
+    unit          text NOT NULL DEFAULT '',
+    ref_low       double precision,
+    ref_high      double precision,
+    critical_low  double precision,
+    critical_high double precision,
+    value         text NOT NULL DEFAULT '',
+    numeric_value double precision,
+    flag          text NOT NULL DEFAULT '',
+    resulted_by   text NOT NULL DEFAULT '',
+    resulted_at   timestamptz,
+    UNIQUE (order_id, test_id)
+);
+`,
+	`CREATE INDEX IF NOT EXISTS lab_results_code ON lab_results (code)`,
+	`
+CREATE TABLE IF NOT EXISTS vital_signs (
+    id               integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id       integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    appointment_id   integer REFERENCES appointments(id) ON DELETE SET NULL,
+    taken_at         timestamptz NOT NULL DEFAULT now(),
+    systolic         integer,
+    diastolic        integer,
+    pulse            integer,
+    temperature      double precision,
+    spo2             integer,
+    spo2_scale_2     boolean NOT NULL DEFAULT false,
+    respiration_rate integer,
+    on_oxygen        boolean NOT NULL DEFAULT false,
+    consciousness    text NOT NULL DEFAULT '',
+    notes            text NOT NULL DEFAULT '',
+    news2_score      integer NOT NULL DEFAULT 0,
+    news2_risk       text NOT NULL DEFAULT 'low',
+    news2_red_score  boolean NOT NULL DEFAULT false,
+    news2_complete   boolean NOT NULL DEFAULT false,
+    recorded_by      text NOT NULL DEFAULT '',
+    recorded_at      timestamptz NOT NULL DEFAULT now(),
+    version          integer NOT NULL DEFAULT 1,
+    deleted_at       timestamptz,
+    deleted_by       text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS vital_signs_patient_taken_at ON vital_signs (patient_id, taken_at)`,
+	`CREATE INDEX IF NOT EXISTS vital_signs_appointment_id ON vital_signs (appointment_id)`,
+	`CREATE INDEX IF NOT EXISTS vital_signs_deleted_at ON vital_signs (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS clinical_notes (
+    id             integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id     integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    appointment_id integer REFERENCES appointments(id) ON DELETE SET NULL,
+    subjective     text NOT NULL DEFAULT '',
+    objective      text NOT NULL DEFAULT '',
+    assessment     text NOT NULL DEFAULT '',
+    plan           text NOT NULL DEFAULT '',
+    status         text NOT NULL DEFAULT 'draft',
+    author         text NOT NULL,
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    updated_at     timestamptz NOT NULL DEFAULT now(),
+    signed_at      timestamptz,
+    version        integer NOT NULL DEFAULT 1,
+    deleted_at     timestamptz,
+    deleted_by     text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS clinical_notes_patient_id ON clinical_notes (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS clinical_notes_appointment_id ON clinical_notes (appointment_id)`,
+	`CREATE INDEX IF NOT EXISTS clinical_notes_deleted_at ON clinical_notes (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS clinical_note_addenda (
+    id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    note_id    integer NOT NULL REFERENCES clinical_notes(id) ON DELETE CASCADE,
+    text       text NOT NULL,
+    author     text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+`,
+	`CREATE INDEX IF NOT EXISTS clinical_note_addenda_note_id ON clinical_note_addenda (note_id)`,
+	`
+CREATE TABLE IF NOT EXISTS departments (
+    id      integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    code    text NOT NULL,
+    name    text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS departments_code_key ON departments (code)`,
+	`
+CREATE TABLE IF NOT EXISTS wards (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    department_id integer NOT NULL REFERENCES departments(id),
+    code          text NOT NULL,
+    name          text NOT NULL,
+    version       integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS wards_code_key ON wards (code)`,
+	`
+CREATE TABLE IF NOT EXISTS rooms (
+    id      integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    ward_id integer NOT NULL REFERENCES wards(id),
+    number  text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS rooms_number_key ON rooms (ward_id, number)`,
+	`
+CREATE TABLE IF NOT EXISTS beds (
+    id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    room_id    integer NOT NULL REFERENCES rooms(id),
+    label      text NOT NULL,
+    in_service boolean NOT NULL DEFAULT true,
+    version    integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS beds_label_key ON beds (room_id, label)`,
+	`
+CREATE TABLE IF NOT EXISTS admissions (
+    id                  integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id          integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    attending_doctor_id integer REFERENCES doctors(id) ON DELETE SET NULL,
+    bed_id              integer NOT NULL REFERENCES beds(id),
+    reason              text NOT NULL DEFAULT '',
+    status              text NOT NULL DEFAULT 'admitted',
+    admitted_at         timestamptz NOT NULL DEFAULT now(),
+    admitted_by         text NOT NULL DEFAULT '',
+    discharged_at       timestamptz,
+    discharged_by       text NOT NULL DEFAULT '',
+    disposition         text NOT NULL DEFAULT '',
+    discharge_summary   text NOT NULL DEFAULT '',
+    version             integer NOT NULL DEFAULT 1,
+    deleted_at          timestamptz,
+    deleted_by          text NOT NULL DEFAULT ''
+);
+`,
+	// a bed holds one admitted patient and a patient has one open admission
+	`CREATE UNIQUE INDEX IF NOT EXISTS admissions_bed_occupied ON admissions (bed_id) WHERE status = 'admitted' AND deleted_at IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS admissions_patient_admitted ON admissions (patient_id) WHERE status = 'admitted' AND deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS admissions_patient_id ON admissions (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS admissions_deleted_at ON admissions (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS bed_movements (
+    id           integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    admission_id integer NOT NULL REFERENCES admissions(id) ON DELETE CASCADE,
+    bed_id       integer NOT NULL REFERENCES beds(id),
+    started_at   timestamptz NOT NULL,
+    ended_at     timestamptz,
+    reason       text NOT NULL DEFAULT '',
+    moved_by     text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS bed_movements_admission_id ON bed_movements (admission_id)`,
+	`ALTER TABLE departments ADD COLUMN IF NOT EXISTS parent_id integer REFERENCES departments(id)`,
+	`
+CREATE TABLE IF NOT EXISTS department_members (
+    department_id integer NOT NULL REFERENCES departments(id),
+    doctor_id     integer NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+    role          text NOT NULL,
+    joined_at     date NOT NULL DEFAULT CURRENT_DATE,
+    PRIMARY KEY (department_id, doctor_id)
+);
+`,
+	// a department has at most one head
+	`CREATE UNIQUE INDEX IF NOT EXISTS department_members_head ON department_members (department_id) WHERE role = 'head'`,
+	`CREATE INDEX IF NOT EXISTS department_members_doctor_id ON department_members (doctor_id)`,
+	`
+CREATE TABLE IF NOT EXISTS oncall_rotations (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name          text NOT NULL,
+    department_id integer REFERENCES departments(id),
+    doctor_ids    integer[] NOT NULL,
+    starts_at     timestamptz NOT NULL,
+    shift_hours   integer NOT NULL,
+    version       integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS oncall_rotations_name_key ON oncall_rotations (name)`,
+	`
+CREATE TABLE IF NOT EXISTS shifts (
+    id            integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    doctor_id     integer NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+    department_id integer REFERENCES departments(id),
+    kind          text NOT NULL,
+    starts_at     timestamptz NOT NULL,
+    ends_at       timestamptz NOT NULL CHECK (ends_at > starts_at),
+    rotation_id   integer REFERENCES oncall_rotations(id) ON DELETE SET NULL,
+    note          text NOT NULL DEFAULT '',
+    created_by    text NOT NULL DEFAULT '',
+    version       integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE INDEX IF NOT EXISTS shifts_doctor_id ON shifts (doctor_id, starts_at)`,
+	`CREATE INDEX IF NOT EXISTS shifts_period ON shifts (starts_at, ends_at)`,
+	`
+CREATE TABLE IF NOT EXISTS leaves (
+    id           integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    doctor_id    integer NOT NULL REFERENCES doctors(id) ON DELETE CASCADE,
+    kind         text NOT NULL,
+    start_date   date NOT NULL,
+    end_date     date NOT NULL CHECK (end_date >= start_date),
+    reason       text NOT NULL DEFAULT '',
+    status       text NOT NULL DEFAULT 'requested',
+    requested_by text NOT NULL DEFAULT '',
+    requested_at timestamptz NOT NULL DEFAULT now(),
+    decided_by   text NOT NULL DEFAULT '',
+    decided_at   timestamptz,
+    version      integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE INDEX IF NOT EXISTS leaves_doctor_id ON leaves (doctor_id, start_date)`,
+	`
+CREATE TABLE IF NOT EXISTS services (
+    id         integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    code       text NOT NULL,
+    name       text NOT NULL,
+    unit_price bigint NOT NULL CHECK (unit_price >= 0),
+    specialty  text NOT NULL DEFAULT '',
+    visit_fee  boolean NOT NULL DEFAULT false,
+    active     boolean NOT NULL DEFAULT true,
+    version    integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS services_code_key ON services (upper(code))`,
+	`CREATE SEQUENCE IF NOT EXISTS invoice_number_seq`,
+	`
+CREATE TABLE IF NOT EXISTS invoices (
+    id             integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    number         text UNIQUE,
+    patient_id     integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    appointment_id integer REFERENCES appointments(id) ON DELETE SET NULL,
+    status         text NOT NULL DEFAULT 'draft',
+    currency       text NOT NULL,
+    subtotal       bigint NOT NULL DEFAULT 0,
+    discount       bigint NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    total          bigint NOT NULL DEFAULT 0,
+    paid           bigint NOT NULL DEFAULT 0,
+    due_date       date,
+    notes          text NOT NULL DEFAULT '',
+    created_by     text NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    issued_at      timestamptz,
+    voided_at      timestamptz,
+    version        integer NOT NULL DEFAULT 1,
+    deleted_at     timestamptz,
+    deleted_by     text NOT NULL DEFAULT ''
+);
+`,
+	// an appointment is billed once; a void invoice can be replaced
+	`CREATE UNIQUE INDEX IF NOT EXISTS invoices_appointment_key ON invoices (appointment_id) WHERE status <> 'void' AND deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS invoices_patient_id ON invoices (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS invoices_deleted_at ON invoices (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id               integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    invoice_id       integer NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    service_id       integer REFERENCES services(id),
+    code             text NOT NULL DEFAULT '',
+    description      text NOT NULL,
+    quantity         integer NOT NULL CHECK (quantity > 0),
+    unit_price       bigint NOT NULL,
+    discount_percent integer NOT NULL DEFAULT 0,
+    amount           bigint NOT NULL
+);
+`,
+	`CREATE INDEX IF NOT EXISTS invoice_lines_invoice_id ON invoice_lines (invoice_id)`,
+	`
+CREATE TABLE IF NOT EXISTS invoice_payments (
+    id          integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    invoice_id  integer NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    kind        text NOT NULL,
+    amount      bigint NOT NULL CHECK (amount > 0),
+    method      text NOT NULL,
+    reference   text NOT NULL DEFAULT '',
+    received_at timestamptz NOT NULL DEFAULT now(),
+    received_by text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS invoice_payments_invoice_id ON invoice_payments (invoice_id)`,
+	`
+CREATE TABLE IF NOT EXISTS insurance_payers (
+    id      integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    code    text NOT NULL,
+    name    text NOT NULL,
+    phone   text NOT NULL DEFAULT '',
+    email   text NOT NULL DEFAULT '',
+    active  boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS insurance_payers_code_key ON insurance_payers (upper(code))`,
+	`
+CREATE TABLE IF NOT EXISTS insurance_policies (
+    id               integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    patient_id       integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    payer_id         integer NOT NULL REFERENCES insurance_payers(id),
+    member_id        text NOT NULL,
+    group_number     text NOT NULL DEFAULT '',
+    coverage_percent integer NOT NULL CHECK (coverage_percent BETWEEN 0 AND 100),
+    copay            bigint NOT NULL DEFAULT 0,
+    start_date       date NOT NULL,
+    end_date         date CHECK (end_date >= start_date),
+    created_by       text NOT NULL DEFAULT '',
+    version          integer NOT NULL DEFAULT 1,
+    deleted_at       timestamptz,
+    deleted_by       text NOT NULL DEFAULT ''
+);
+`,
+	`CREATE INDEX IF NOT EXISTS insurance_policies_patient_id ON insurance_policies (patient_id, start_date)`,
+	`CREATE INDEX IF NOT EXISTS insurance_policies_deleted_at ON insurance_policies (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS policy_id integer REFERENCES insurance_policies(id) ON DELETE SET NULL`,
+	`CREATE SEQUENCE IF NOT EXISTS claim_number_seq`,
+	`
+CREATE TABLE IF NOT EXISTS claims (
+    id             integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    number         text NOT NULL UNIQUE,
+    patient_id     integer NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    policy_id      integer REFERENCES insurance_policies(id) ON DELETE SET NULL,
+    payer_id       integer NOT NULL REFERENCES insurance_payers(id),
+    member_id      text NOT NULL,
+    group_number   text NOT NULL DEFAULT '',
+    invoice_id     integer NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    appointment_id integer REFERENCES appointments(id) ON DELETE SET NULL,
+    service_date   date NOT NULL,
+    doctor_name    text NOT NULL DEFAULT '',
+    diagnoses      text[] NOT NULL DEFAULT '{}',
+    total_charge   bigint NOT NULL,
+    copay          bigint NOT NULL,
+    claimed_amount bigint NOT NULL,
+    paid_amount    bigint NOT NULL DEFAULT 0,
+    status         text NOT NULL DEFAULT 'ready',
+    status_note    text NOT NULL DEFAULT '',
+    created_by     text NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    submitted_at   timestamptz,
+    version        integer NOT NULL DEFAULT 1,
+    deleted_at     timestamptz,
+    deleted_by     text NOT NULL DEFAULT ''
+);
+`,
+	// an invoice is claimed once; a rejected claim is corrected and resubmitted
+	`CREATE UNIQUE INDEX IF NOT EXISTS claims_invoice_key ON claims (invoice_id) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS claims_patient_id ON claims (patient_id)`,
+	`CREATE INDEX IF NOT EXISTS claims_payer_status ON claims (payer_id, status)`,
+	`CREATE INDEX IF NOT EXISTS claims_deleted_at ON claims (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`
+CREATE TABLE IF NOT EXISTS claim_lines (
+    id          integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    claim_id    integer NOT NULL REFERENCES claims(id) ON DELETE CASCADE,
+    code        text NOT NULL DEFAULT '',
+    description text NOT NULL,
+    quantity    integer NOT NULL,
+    unit_price  bigint NOT NULL,
+    amount      bigint NOT NULL
+);
+`,
+	`CREATE INDEX IF NOT EXISTS claim_lines_claim_id ON claim_lines (claim_id)`,
+	`
+CREATE TABLE IF NOT EXISTS claim_status_history (
+    id          integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    claim_id    integer NOT NULL REFERENCES claims(id) ON DELETE CASCADE,
+    old_status  text NOT NULL,
+    new_status  text NOT NULL,
+    note        text NOT NULL DEFAULT '',
+    paid_amount bigint NOT NULL DEFAULT 0,
+    changed_by  text NOT NULL DEFAULT '',
+    changed_at  timestamptz NOT NULL DEFAULT now()
+);
+`,
+	`CREATE INDEX IF NOT EXISTS claim_status_history_claim_id ON claim_status_history (claim_id)`,
+	`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now()`,
+	`CREATE SEQUENCE IF NOT EXISTS lab_orders_ready_seq`,
+	`ALTER TABLE lab_orders ADD COLUMN IF NOT EXISTS ready_seq bigint`,
+	`
+UPDATE lab_orders o SET ready_seq = b.seq
+FROM (SELECT id, nextval('lab_orders_ready_seq') AS seq
+      FROM (SELECT id FROM lab_orders WHERE ready_seq IS NULL AND results_ready_at IS NOT NULL ORDER BY results_ready_at, id) ready) b
+WHERE o.id = b.id
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS lab_orders_ready_seq_key ON lab_orders (ready_seq) WHERE ready_seq IS NOT NULL`,
+	`ALTER TABLE patient_problems ADD COLUMN IF NOT EXISTS appointment_id integer REFERENCES appointments(id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS patient_problems_appointment_id ON patient_problems (appointment_id)`,
+}
+
+// Migrate creates tables if they do not exist
+func (s *Storage) Migrate(ctx context.Context) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, stmt := range migrations {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// missingOrStale explains why a versioned write matched no rows
+func missingOrStale(ctx context.Context, q pgx.Tx, table, entity string, id int) error {
+	var exists bool
+	if err := q.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s not found", entity)
+	}
+	return ErrVersionMismatch
+}
+
+// deleteRow soft-deletes one row of table; a non-zero version must match the stored one
+func deleteRow(ctx context.Context, tx pgx.Tx, table, entity string, id, version int, by string) error {
+	ct, err := tx.Exec(ctx, `
+UPDATE `+table+` SET deleted_at = now(), deleted_by = $3, version = version + 1
+WHERE id=$1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+`, id, version, by)
 	if err != nil {
 		return err
 	}
+	if ct.RowsAffected() == 0 {
+		return missingOrStale(ctx, tx, table, entity, id)
+	}
+	return nil
+}
 
+// lockDeleted locks a soft-deleted row for restoring and returns when it was deleted
+func lockDeleted(ctx context.Context, tx pgx.Tx, table, entity string, id int) (time.Time, error) {
+	var deletedAt *time.Time
+	err := tx.QueryRow(ctx, `SELECT deleted_at FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&deletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("%s not found", entity)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if deletedAt == nil {
+		return time.Time{}, fmt.Errorf("%s is %w", entity, ErrNotDeleted)
+	}
+	return *deletedAt, nil
+}
+
+// placeholders returns "$from, ..., $(from+n-1)"
+func placeholders(from, n int) string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = "$" + strconv.Itoa(from+i)
+	}
+	return strings.Join(out, ", ")
+}
+
+// assignments returns "a=$1, b=$2, ..." for an UPDATE
+func assignments(columns []string) string {
+	out := make([]string, len(columns))
+	for i, c := range columns {
+		out[i] = c + "=$" + strconv.Itoa(i+1)
+	}
+	return strings.Join(out, ", ")
+}
+
+// InTx calls fn with a Storage whose reads and writes all run in one
+// transaction, committed when fn succeeds; methods that open their own
+// transaction use a savepoint in it
+func (s *Storage) InTx(ctx context.Context, fn func(st *Storage) error) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return fn(&Storage{pool: tx})
+	})
+}
+
+// withTx runs fn in a transaction that is committed when fn succeeds
+func (s *Storage) withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
 	return tx.Commit(ctx)
 }
 
@@ -93,24 +819,72 @@ CREATE TABLE IF NOT EXISTS appointments (
 // --- Patients CRUD ---
 //
 
+// patientAge is the stored age for legacy rows and the age computed from date_of_birth otherwise
+const patientAge = `CASE WHEN patients.date_of_birth IS NULL THEN patients.age ELSE date_part('year', age(patients.date_of_birth))::integer END`
+
+const patientColumns = `patients.id, patients.mrn, patients.first_name, patients.last_name,
+COALESCE(TO_CHAR(patients.date_of_birth, 'YYYY-MM-DD'), ''), ` + patientAge + `,
+patients.sex, patients.gender, patients.phone, patients.email,
+patients.address_line, patients.city, patients.postal_code, patients.country, patients.national_id,
+patients.emergency_contact_name, patients.emergency_contact_phone, patients.emergency_contact_relation,
+COALESCE(patients.diagnosis, ''), patients.version, patients.deleted_at, patients.deleted_by`
+
+func scanPatient(row pgx.Row, p *models.Patient) error {
+	return row.Scan(&p.ID, &p.MRN, &p.FirstName, &p.LastName, &p.DateOfBirth, &p.Age,
+		&p.Sex, &p.Gender, &p.Phone, &p.Email,
+		&p.AddressLine, &p.City, &p.PostalCode, &p.Country, &p.NationalID,
+		&p.EmergencyContactName, &p.EmergencyContactPhone, &p.EmergencyContactRelation,
+		&p.Diagnosis, &p.Version, &p.DeletedAt, &p.DeletedBy)
+}
+
+// patientFields are the writable columns, in the order of patientValues
+var patientFields = []string{"first_name", "last_name", "date_of_birth", "age", "sex", "gender", "phone", "email",
+	"address_line", "city", "postal_code", "country", "national_id",
+	"emergency_contact_name", "emergency_contact_phone", "emergency_contact_relation", "diagnosis"}
+
+func patientValues(p *models.Patient) []any {
+	var dob any
+	age := p.Age
+	if p.DateOfBirth != "" {
+		dob = p.DateOfBirth
+		if born, err := time.Parse("2006-01-02", p.DateOfBirth); err == nil {
+			age = models.AgeOn(born, time.Now())
+		}
+	}
+	return []any{p.FirstName, p.LastName, dob, age, p.Sex, p.Gender, p.Phone, p.Email,
+		p.AddressLine, p.City, p.PostalCode, p.Country, p.NationalID,
+		p.EmergencyContactName, p.EmergencyContactPhone, p.EmergencyContactRelation, p.Diagnosis}
+}
+
+// patientConflict turns a unique violation on an identifier into ErrDuplicate
+func patientConflict(err error, p *models.Patient) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "patients_national_id_live_key" {
+		return fmt.Errorf("%w: national_id %s is already registered", ErrDuplicate, p.NationalID)
+	}
+	return err
+}
+
 // CreatePatient inserts patient and returns created model (with ID)
 func (s *Storage) CreatePatient(ctx context.Context, p *models.Patient) (*models.Patient, error) {
-	row := s.pool.QueryRow(ctx, `
-INSERT INTO patients (first_name, last_name, age, diagnosis)
-VALUES ($1, $2, $3, $4)
-RETURNING id
-`, p.FirstName, p.LastName, p.Age, p.Diagnosis)
-
-	var id int
-	if err := row.Scan(&id); err != nil {
+	if err := s.withTx(ctx, func(tx pgx.Tx) error { return insertPatient(ctx, tx, p) }); err != nil {
 		return nil, err
 	}
-	p.ID = id
 	return p, nil
 }
 
-func (s *Storage) GetAllPatients(ctx context.Context) ([]models.Patient, error) {
-	rows, err := s.pool.Query(ctx, `SELECT id, first_name, last_name, age, diagnosis FROM patients ORDER BY id`)
+func insertPatient(ctx context.Context, tx pgx.Tx, p *models.Patient) error {
+	err := tx.QueryRow(ctx, `
+INSERT INTO patients (`+strings.Join(patientFields, ", ")+`)
+VALUES (`+placeholders(1, len(patientFields))+`)
+RETURNING id, version, mrn, `+patientAge,
+		patientValues(p)...).Scan(&p.ID, &p.Version, &p.MRN, &p.Age)
+	return patientConflict(err, p)
+}
+
+// GetAllPatients lists patients; soft-deleted ones only with includeDeleted
+func (s *Storage) GetAllPatients(ctx context.Context, includeDeleted bool) ([]models.Patient, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+patientColumns+` FROM patients WHERE $1 OR deleted_at IS NULL ORDER BY id`, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -119,8 +893,7 @@ func (s *Storage) GetAllPatients(ctx context.Context) ([]models.Patient, error)
 	var out []models.Patient
 	for rows.Next() {
 		var p models.Patient
-		err := rows.Scan(&p.ID, &p.FirstName, &p.LastName, &p.Age, &p.Diagnosis)
-		if err != nil {
+		if err := scanPatient(rows, &p); err != nil {
 			return nil, err
 		}
 		out = append(out, p)
@@ -129,58 +902,173 @@ func (s *Storage) GetAllPatients(ctx context.Context) ([]models.Patient, error)
 }
 
 func (s *Storage) GetPatientByID(ctx context.Context, id int) (*models.Patient, error) {
-	row := s.pool.QueryRow(ctx, `SELECT id, first_name, last_name, age, diagnosis FROM patients WHERE id = $1`, id)
+	row := s.pool.QueryRow(ctx, `SELECT `+patientColumns+` FROM patients WHERE id = $1 AND deleted_at IS NULL`, id)
 	var p models.Patient
-	if err := row.Scan(&p.ID, &p.FirstName, &p.LastName, &p.Age, &p.Diagnosis); err != nil {
+	if err := scanPatient(row, &p); err != nil {
 		return nil, err
 	}
 	return &p, nil
 }
 
+// PatientDeleted reports whether patient id is soft-deleted; a purged or unknown id is not
+func (s *Storage) PatientDeleted(ctx context.Context, id int) (bool, error) {
+	var deleted bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM patients WHERE id = $1 AND deleted_at IS NOT NULL)`, id).Scan(&deleted)
+	return deleted, err
+}
+
+// UpdatePatient overwrites the row. A non-zero p.Version must match the stored
+// version; on success p.Version holds the new version.
 func (s *Storage) UpdatePatient(ctx context.Context, p *models.Patient) error {
-	ct, err := s.pool.Exec(ctx, `
-UPDATE patients SET first_name=$1, last_name=$2, age=$3, diagnosis=$4 WHERE id=$5
-`, p.FirstName, p.LastName, p.Age, p.Diagnosis, p.ID)
+	return s.withTx(ctx, func(tx pgx.Tx) error { return updatePatient(ctx, tx, p) })
+}
+
+func updatePatient(ctx context.Context, tx pgx.Tx, p *models.Patient) error {
+	n := len(patientFields)
+	args := append(patientValues(p), p.ID, p.Version)
+	err := tx.QueryRow(ctx, `
+UPDATE patients SET `+assignments(patientFields)+`, version = version + 1
+WHERE id=$`+strconv.Itoa(n+1)+` AND deleted_at IS NULL AND ($`+strconv.Itoa(n+2)+` = 0 OR version = $`+strconv.Itoa(n+2)+`)
+RETURNING version, mrn, `+patientAge,
+		args...).Scan(&p.Version, &p.MRN, &p.Age)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return missingOrStale(ctx, tx, "patients", "patient", p.ID)
+	}
+	return patientConflict(err, p)
+}
+
+// PatchPatient loads the row for update, lets apply modify it and saves the result in one transaction
+func (s *Storage) PatchPatient(ctx context.Context, id, version int, apply func(*models.Patient) error) (*models.Patient, error) {
+	var p models.Patient
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `SELECT `+patientColumns+` FROM patients WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id)
+		if err := scanPatient(row, &p); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("patient not found")
+			}
+			return err
+		}
+		if version != 0 && p.Version != version {
+			return ErrVersionMismatch
+		}
+
+		current := p.Version
+		if err := apply(&p); err != nil {
+			return err
+		}
+		p.ID, p.Version = id, current
+
+		return updatePatient(ctx, tx, &p)
+	})
 	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeletePatient soft-deletes the row together with the records that belong
+// to the patient; a non-zero version must match the stored one
+func (s *Storage) DeletePatient(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deletePatient(ctx, tx, id, version, by) })
+}
+
+func deletePatient(ctx context.Context, tx pgx.Tx, id, version int, by string) error {
+	if err := deleteRow(ctx, tx, "patients", "patient", id, version, by); err != nil {
 		return err
 	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("patient not found")
+	// now() is the transaction start, so the records share the patient's deleted_at and are restored with it
+	for _, ref := range patientReferences {
+		bump := ""
+		if ref.versioned {
+			bump = ", version = version + 1"
+		}
+		if _, err := tx.Exec(ctx, `UPDATE `+ref.table+` SET deleted_at = now(), deleted_by = $2`+bump+`
+WHERE patient_id = $1 AND deleted_at IS NULL`, id, by); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (s *Storage) DeletePatient(ctx context.Context, id int) error {
-	ct, err := s.pool.Exec(ctx, `DELETE FROM patients WHERE id=$1`, id)
+// RestorePatient undoes DeletePatient, including the records deleted with the patient
+func (s *Storage) RestorePatient(ctx context.Context, id int) (*models.Patient, error) {
+	var p models.Patient
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		deletedAt, err := lockDeleted(ctx, tx, "patients", "patient", id)
+		if err != nil {
+			return err
+		}
+		// the national ID may have been registered to another patient meanwhile
+		var nationalID string
+		if err := tx.QueryRow(ctx, `SELECT national_id FROM patients WHERE id = $1`, id).Scan(&nationalID); err != nil {
+			return err
+		}
+		row := tx.QueryRow(ctx, `
+UPDATE patients SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+patientColumns, id)
+		if err := scanPatient(row, &p); err != nil {
+			return patientConflict(err, &models.Patient{NationalID: nationalID})
+		}
+		for _, ref := range patientReferences {
+			bump := ""
+			if ref.versioned {
+				bump = ", version = version + 1"
+			}
+			if _, err := tx.Exec(ctx, `UPDATE `+ref.table+` SET deleted_at = NULL, deleted_by = ''`+bump+`
+WHERE patient_id = $1 AND deleted_at = $2`, id, deletedAt); err != nil {
+				return admissionConflict(err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("patient not found")
+		return nil, err
 	}
-	return nil
+	return &p, nil
 }
 
 //
 // --- Doctors CRUD ---
 //
 
+const doctorColumns = `doctors.id, doctors.first_name, doctors.last_name, doctors.specialization, doctors.experience, doctors.version,
+doctors.deleted_at, doctors.deleted_by`
+
+func scanDoctor(row pgx.Row, d *models.Doctor) error {
+	if err := row.Scan(&d.ID, &d.FirstName, &d.LastName, &d.Specialization, &d.Experience, &d.Version, &d.DeletedAt, &d.DeletedBy); err != nil {
+		return err
+	}
+	d.SpecialtyCode = specialtyCode(d.Specialization)
+	return nil
+}
+
+// specialtyCode normalizes a specialization to the vocabulary; empty for
+// free text recorded before the vocabulary was enforced
+func specialtyCode(specialization string) string {
+	s, _ := specialties.Normalize(specialization)
+	return s.Code
+}
+
 func (s *Storage) CreateDoctor(ctx context.Context, d *models.Doctor) (*models.Doctor, error) {
-	row := s.pool.QueryRow(ctx, `
-INSERT INTO doctors (first_name, last_name, specialization, experience)
-VALUES ($1, $2, $3, $4)
-RETURNING id
-`, d.FirstName, d.LastName, d.Specialization, d.Experience)
-	var id int
-	if err := row.Scan(&id); err != nil {
+	if err := s.withTx(ctx, func(tx pgx.Tx) error { return insertDoctor(ctx, tx, d) }); err != nil {
 		return nil, err
 	}
-	d.ID = id
 	return d, nil
 }
 
-func (s *Storage) GetAllDoctors(ctx context.Context) ([]models.Doctor, error) {
-	rows, err := s.pool.Query(ctx, `SELECT id, first_name, last_name, specialization, experience FROM doctors ORDER BY id`)
+func insertDoctor(ctx context.Context, tx pgx.Tx, d *models.Doctor) error {
+	d.SpecialtyCode = specialtyCode(d.Specialization)
+	return tx.QueryRow(ctx, `
+INSERT INTO doctors (first_name, last_name, specialization, experience)
+VALUES ($1, $2, $3, $4)
+RETURNING id, version
+`, d.FirstName, d.LastName, d.Specialization, d.Experience).Scan(&d.ID, &d.Version)
+}
+
+// GetAllDoctors lists doctors; soft-deleted ones only with includeDeleted
+func (s *Storage) GetAllDoctors(ctx context.Context, includeDeleted bool) ([]models.Doctor, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+doctorColumns+` FROM doctors WHERE $1 OR deleted_at IS NULL ORDER BY id`, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -188,7 +1076,7 @@ func (s *Storage) GetAllDoctors(ctx context.Context) ([]models.Doctor, error) {
 	var out []models.Doctor
 	for rows.Next() {
 		var d models.Doctor
-		if err := rows.Scan(&d.ID, &d.FirstName, &d.LastName, &d.Specialization, &d.Experience); err != nil {
+		if err := scanDoctor(rows, &d); err != nil {
 			return nil, err
 		}
 		out = append(out, d)
@@ -197,58 +1085,133 @@ func (s *Storage) GetAllDoctors(ctx context.Context) ([]models.Doctor, error) {
 }
 
 func (s *Storage) GetDoctorByID(ctx context.Context, id int) (*models.Doctor, error) {
-	row := s.pool.QueryRow(ctx, `SELECT id, first_name, last_name, specialization, experience FROM doctors WHERE id = $1`, id)
+	row := s.pool.QueryRow(ctx, `SELECT `+doctorColumns+` FROM doctors WHERE id = $1 AND deleted_at IS NULL`, id)
 	var d models.Doctor
-	if err := row.Scan(&d.ID, &d.FirstName, &d.LastName, &d.Specialization, &d.Experience); err != nil {
+	if err := scanDoctor(row, &d); err != nil {
 		return nil, err
 	}
 	return &d, nil
 }
 
+// UpdateDoctor overwrites the row. A non-zero d.Version must match the stored
+// version; on success d.Version holds the new version.
 func (s *Storage) UpdateDoctor(ctx context.Context, d *models.Doctor) error {
-	ct, err := s.pool.Exec(ctx, `
-UPDATE doctors SET first_name=$1, last_name=$2, specialization=$3, experience=$4 WHERE id=$5
-`, d.FirstName, d.LastName, d.Specialization, d.Experience, d.ID)
-	if err != nil {
-		return err
-	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("doctor not found")
+	return s.withTx(ctx, func(tx pgx.Tx) error { return updateDoctor(ctx, tx, d) })
+}
+
+func updateDoctor(ctx context.Context, tx pgx.Tx, d *models.Doctor) error {
+	d.SpecialtyCode = specialtyCode(d.Specialization)
+	err := tx.QueryRow(ctx, `
+UPDATE doctors SET first_name=$1, last_name=$2, specialization=$3, experience=$4, version = version + 1
+WHERE id=$5 AND deleted_at IS NULL AND ($6 = 0 OR version = $6)
+RETURNING version
+`, d.FirstName, d.LastName, d.Specialization, d.Experience, d.ID, d.Version).Scan(&d.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return missingOrStale(ctx, tx, "doctors", "doctor", d.ID)
 	}
-	return nil
+	return err
 }
 
-func (s *Storage) DeleteDoctor(ctx context.Context, id int) error {
-	ct, err := s.pool.Exec(ctx, `DELETE FROM doctors WHERE id=$1`, id)
+// PatchDoctor loads the row for update, lets apply modify it and saves the result in one transaction
+func (s *Storage) PatchDoctor(ctx context.Context, id, version int, apply func(*models.Doctor) error) (*models.Doctor, error) {
+	var d models.Doctor
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `SELECT `+doctorColumns+` FROM doctors WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id)
+		if err := scanDoctor(row, &d); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("doctor not found")
+			}
+			return err
+		}
+		if version != 0 && d.Version != version {
+			return ErrVersionMismatch
+		}
+
+		if err := apply(&d); err != nil {
+			return err
+		}
+		d.ID = id
+		d.SpecialtyCode = specialtyCode(d.Specialization)
+
+		return tx.QueryRow(ctx, `
+UPDATE doctors SET first_name=$1, last_name=$2, specialization=$3, experience=$4, version = version + 1
+WHERE id=$5
+RETURNING version
+`, d.FirstName, d.LastName, d.Specialization, d.Experience, d.ID).Scan(&d.Version)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("doctor not found")
+	return &d, nil
+}
+
+// DeleteDoctor soft-deletes the row; a non-zero version must match the stored one.
+// The doctor's appointments stay in the patients' history.
+func (s *Storage) DeleteDoctor(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "doctors", "doctor", id, version, by) })
+}
+
+// RestoreDoctor undoes DeleteDoctor
+func (s *Storage) RestoreDoctor(ctx context.Context, id int) (*models.Doctor, error) {
+	var d models.Doctor
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "doctors", "doctor", id); err != nil {
+			return err
+		}
+		return scanDoctor(tx.QueryRow(ctx, `
+UPDATE doctors SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+doctorColumns, id), &d)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &d, nil
 }
 
 //
 // --- Appointments CRUD ---
 //
 
+const appointmentColumns = `appointments.id, appointments.patient_id, COALESCE(appointments.doctor_id, 0), COALESCE(TO_CHAR(appointments.date,'YYYY-MM-DD'),''), COALESCE(TO_CHAR(appointments.time,'HH24:MI:SS'),''), COALESCE(appointments.status, ''), COALESCE(appointments.policy_id, 0), appointments.version, appointments.deleted_at, appointments.deleted_by`
+
+func scanAppointment(row pgx.Row, a *models.Appointment, extra ...any) error {
+	dest := append([]any{&a.ID, &a.PatientID, &a.DoctorID, &a.Date, &a.Time, &a.Status, &a.PolicyID, &a.Version, &a.DeletedAt, &a.DeletedBy}, extra...)
+	return row.Scan(dest...)
+}
+
 func (s *Storage) CreateAppointment(ctx context.Context, a *models.Appointment) (*models.Appointment, error) {
-	row := s.pool.QueryRow(ctx, `
-INSERT INTO appointments (patient_id, doctor_id, date, time, status)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id
-`, a.PatientID, a.DoctorID, a.Date, a.Time, a.Status)
-	var id int
-	if err := row.Scan(&id); err != nil {
+	if err := s.withTx(ctx, func(tx pgx.Tx) error { return insertAppointment(ctx, tx, a) }); err != nil {
 		return nil, err
 	}
-	a.ID = id
 	return a, nil
 }
 
-func (s *Storage) GetAllAppointments(ctx context.Context) ([]models.Appointment, error) {
-	rows, err := s.pool.Query(ctx, `SELECT id, patient_id, doctor_id, COALESCE(TO_CHAR(date,'YYYY-MM-DD'),'') , COALESCE(TO_CHAR(time,'HH24:MI:SS'),'') , status FROM appointments ORDER BY id`)
+func insertAppointment(ctx context.Context, tx pgx.Tx, a *models.Appointment) error {
+	if err := checkBookable(ctx, tx, a); err != nil {
+		return err
+	}
+	var err error
+	if a.PolicyID, err = coveringPolicy(ctx, tx, a.PatientID, a.Date); err != nil {
+		return err
+	}
+	row := tx.QueryRow(ctx, `
+INSERT INTO appointments (patient_id, doctor_id, date, time, status, policy_id)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+RETURNING id, version
+`, a.PatientID, a.DoctorID, a.Date, a.Time, a.Status, a.PolicyID)
+	if err := row.Scan(&a.ID, &a.Version); err != nil {
+		return err
+	}
+	if err := recordStatusChange(ctx, tx, a.ID, "", a.Status); err != nil {
+		return err
+	}
+	return draftVisitInvoice(ctx, tx, a.ID, "", a.Status)
+}
+
+// GetAllAppointments lists appointments; soft-deleted ones only with includeDeleted
+func (s *Storage) GetAllAppointments(ctx context.Context, includeDeleted bool) ([]models.Appointment, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+appointmentColumns+` FROM appointments WHERE $1 OR deleted_at IS NULL ORDER BY id`, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -256,7 +1219,7 @@ func (s *Storage) GetAllAppointments(ctx context.Context) ([]models.Appointment,
 	var out []models.Appointment
 	for rows.Next() {
 		var a models.Appointment
-		if err := rows.Scan(&a.ID, &a.PatientID, &a.DoctorID, &a.Date, &a.Time, &a.Status); err != nil {
+		if err := scanAppointment(rows, &a); err != nil {
 			return nil, err
 		}
 		out = append(out, a)
@@ -265,34 +1228,177 @@ func (s *Storage) GetAllAppointments(ctx context.Context) ([]models.Appointment,
 }
 
 func (s *Storage) GetAppointmentByID(ctx context.Context, id int) (*models.Appointment, error) {
-	row := s.pool.QueryRow(ctx, `SELECT id, patient_id, doctor_id, COALESCE(TO_CHAR(date,'YYYY-MM-DD'),'') , COALESCE(TO_CHAR(time,'HH24:MI:SS'),'') , status FROM appointments WHERE id=$1`, id)
+	row := s.pool.QueryRow(ctx, `SELECT `+appointmentColumns+` FROM appointments WHERE id=$1 AND deleted_at IS NULL`, id)
 	var a models.Appointment
-	if err := row.Scan(&a.ID, &a.PatientID, &a.DoctorID, &a.Date, &a.Time, &a.Status); err != nil {
+	if err := scanAppointment(row, &a); err != nil {
 		return nil, err
 	}
 	return &a, nil
 }
 
+// UpdateAppointment overwrites the row. A non-zero a.Version must match the
+// stored version; on success a.Version holds the new version.
 func (s *Storage) UpdateAppointment(ctx context.Context, a *models.Appointment) error {
-	ct, err := s.pool.Exec(ctx, `
-UPDATE appointments SET patient_id=$1, doctor_id=$2, date=$3, time=$4, status=$5 WHERE id=$6
-`, a.PatientID, a.DoctorID, a.Date, a.Time, a.Status, a.ID)
+	return s.withTx(ctx, func(tx pgx.Tx) error { return updateAppointment(ctx, tx, a) })
+}
+
+func updateAppointment(ctx context.Context, tx pgx.Tx, a *models.Appointment) error {
+	var oldStatus string
+	var version int
+	err := tx.QueryRow(ctx, `SELECT COALESCE(status, ''), version FROM appointments WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, a.ID).Scan(&oldStatus, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("appointment not found")
+	}
 	if err != nil {
 		return err
 	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("appointment not found")
+	if a.Version != 0 && a.Version != version {
+		return ErrVersionMismatch
 	}
-	return nil
+
+	return saveAppointment(ctx, tx, a, oldStatus)
 }
 
-func (s *Storage) DeleteAppointment(ctx context.Context, id int) error {
-	ct, err := s.pool.Exec(ctx, `DELETE FROM appointments WHERE id=$1`, id)
+// saveAppointment writes a locked row and records a status change; a new
+// doctor, date or time must be bookable, the insurance covering the date is
+// looked up again and completing it drafts an invoice
+func saveAppointment(ctx context.Context, tx pgx.Tx, a *models.Appointment, oldStatus string) error {
+	moved, err := rebooked(ctx, tx, a)
 	if err != nil {
 		return err
 	}
-	if ct.RowsAffected() == 0 {
-		return fmt.Errorf("appointment not found")
+	if moved {
+		if err := checkBookable(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+	if a.PolicyID, err = coveringPolicy(ctx, tx, a.PatientID, a.Date); err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+UPDATE appointments SET patient_id=$1, doctor_id=$2, date=$3, time=$4, status=$5, policy_id=NULLIF($6, 0), updated_at = now(), version = version + 1
+WHERE id=$7
+RETURNING version
+`, a.PatientID, a.DoctorID, a.Date, a.Time, a.Status, a.PolicyID, a.ID).Scan(&a.Version)
+	if err != nil {
+		return err
+	}
+	if oldStatus == a.Status {
+		return nil
+	}
+	if err := recordStatusChange(ctx, tx, a.ID, oldStatus, a.Status); err != nil {
+		return err
+	}
+	return draftVisitInvoice(ctx, tx, a.ID, oldStatus, a.Status)
+}
+
+// recordStatusChange keeps the appointment status history used by the patient timeline
+func recordStatusChange(ctx context.Context, tx pgx.Tx, appointmentID int, from, to string) error {
+	_, err := tx.Exec(ctx, `
+INSERT INTO appointment_status_history (appointment_id, old_status, new_status)
+VALUES ($1, $2, $3)
+`, appointmentID, from, to)
+	return err
+}
+
+// PatchAppointment loads the row for update, lets apply modify it and saves the result in one transaction
+func (s *Storage) PatchAppointment(ctx context.Context, id, version int, apply func(*models.Appointment) error) (*models.Appointment, error) {
+	var a models.Appointment
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `SELECT `+appointmentColumns+` FROM appointments WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id)
+		if err := scanAppointment(row, &a); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("appointment not found")
+			}
+			return err
+		}
+		if version != 0 && a.Version != version {
+			return ErrVersionMismatch
+		}
+		oldStatus := a.Status
+
+		if err := apply(&a); err != nil {
+			return err
+		}
+		a.ID = id
+
+		return saveAppointment(ctx, tx, &a, oldStatus)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// DeleteAppointment soft-deletes the row; a non-zero version must match the stored one
+func (s *Storage) DeleteAppointment(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "appointments", "appointment", id, version, by) })
+}
+
+// RestoreAppointment undoes DeleteAppointment; the patient must not be deleted
+func (s *Storage) RestoreAppointment(ctx context.Context, id int) (*models.Appointment, error) {
+	var a models.Appointment
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "appointments", "appointment", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "appointments", id); err != nil {
+			return err
+		}
+		return scanAppointment(tx.QueryRow(ctx, `
+UPDATE appointments SET deleted_at = NULL, deleted_by = '', updated_at = now(), version = version + 1
+WHERE id = $1
+RETURNING `+appointmentColumns, id), &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// requireLivePatient fails with ErrParentDeleted when the patient of a row of table is deleted
+func requireLivePatient(ctx context.Context, tx pgx.Tx, table string, id int) error {
+	var deleted bool
+	err := tx.QueryRow(ctx, `
+SELECT patients.deleted_at IS NOT NULL FROM `+table+` JOIN patients ON patients.id = `+table+`.patient_id
+WHERE `+table+`.id = $1
+`, id).Scan(&deleted)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return fmt.Errorf("%w: restore the patient first", ErrParentDeleted)
 	}
 	return nil
 }
+
+// GetAppointmentDetails lists appointments of one patient or one doctor (pass 0 to skip a filter)
+func (s *Storage) GetAppointmentDetails(ctx context.Context, patientID, doctorID int) ([]models.AppointmentDetails, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+appointmentColumns+`,
+       patients.first_name || ' ' || patients.last_name,
+       COALESCE(doctors.first_name || ' ' || doctors.last_name, ''),
+       COALESCE(doctors.specialization, ''),
+       appointments.updated_at
+FROM appointments
+JOIN patients ON patients.id = appointments.patient_id
+LEFT JOIN doctors ON doctors.id = appointments.doctor_id
+WHERE appointments.deleted_at IS NULL
+  AND ($1 = 0 OR appointments.patient_id = $1) AND ($2 = 0 OR appointments.doctor_id = $2)
+ORDER BY appointments.date, appointments.time, appointments.id
+`, patientID, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.AppointmentDetails
+	for rows.Next() {
+		var a models.AppointmentDetails
+		if err := scanAppointment(rows, &a.Appointment, &a.PatientName, &a.DoctorName, &a.Specialization, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+)
+
+// timelineSources are merged with UNION ALL. Each selects
+// (occurred_at, type, appointment_id, summary, details) for patient $1;
+// $2 is the hospital timezone used for appointment dates stored without a zone.
+var timelineSources = []string{
+	`
+SELECT (a.date + COALESCE(a.time, '00:00'::time)) AT TIME ZONE $2, 'appointment', a.id,
+       'Appointment with Dr. ' || COALESCE(d.first_name || ' ' || d.last_name, 'unknown'),
+       jsonb_build_object('status', a.status, 'doctor_id', a.doctor_id, 'specialization', d.specialization)
+FROM appointments a
+LEFT JOIN doctors d ON d.id = a.doctor_id
+WHERE a.patient_id = $1 AND a.date IS NOT NULL AND a.deleted_at IS NULL`,
+	`
+SELECT h.changed_at, 'status_change', h.appointment_id,
+       CASE WHEN h.old_status = '' THEN 'Appointment created as ' || h.new_status
+            ELSE 'Appointment status changed from ' || h.old_status || ' to ' || h.new_status END,
+       jsonb_build_object('old_status', h.old_status, 'new_status', h.new_status)
+FROM appointment_status_history h
+JOIN appointments a ON a.id = h.appointment_id
+WHERE a.patient_id = $1 AND a.deleted_at IS NULL`,
+	`
+SELECT COALESCE(p.onset_date::timestamp AT TIME ZONE $2, p.recorded_at), 'problem', 0,
+       'Diagnosed with ' || p.code || ' ' || p.description,
+       jsonb_build_object('problem_id', p.id, 'code', p.code, 'status', p.status)
+FROM patient_problems p
+WHERE p.patient_id = $1 AND p.deleted_at IS NULL`,
+	`
+SELECT p.resolved_date::timestamp AT TIME ZONE $2, 'problem_resolved', 0,
+       initcap(p.status) || ': ' || p.code || ' ' || p.description,
+       jsonb_build_object('problem_id', p.id, 'code', p.code, 'status', p.status)
+FROM patient_problems p
+WHERE p.patient_id = $1 AND p.resolved_date IS NOT NULL AND p.deleted_at IS NULL`,
+	`
+SELECT r.prescribed_at, 'prescription', COALESCE(r.appointment_id, 0),
+       'Prescribed ' || m.name || COALESCE(' ' || NULLIF(m.strength, ''), '') || ', ' || r.dose || ' ' || r.route || ' ' || r.frequency,
+       jsonb_build_object('prescription_id', r.id, 'medication_id', r.medication_id, 'doctor_id', r.doctor_id, 'end_date', r.end_date)
+FROM prescriptions r
+JOIN medications m ON m.id = r.medication_id
+WHERE r.patient_id = $1 AND r.deleted_at IS NULL`,
+	`
+SELECT r.discontinued_at, 'prescription_discontinued', COALESCE(r.appointment_id, 0),
+       'Discontinued ' || m.name || COALESCE(' ' || NULLIF(m.strength, ''), '') || COALESCE(': ' || NULLIF(r.discontinued_reason, ''), ''),
+       jsonb_build_object('prescription_id', r.id, 'discontinued_by', r.discontinued_by)
+FROM prescriptions r
+JOIN medications m ON m.id = r.medication_id
+WHERE r.patient_id = $1 AND r.discontinued_at IS NOT NULL AND r.deleted_at IS NULL`,
+	`
+SELECT a.recorded_at, 'allergy', 0,
+       'Allergy to ' || a.substance || COALESCE(' (' || NULLIF(a.reaction, '') || ')', ''),
+       jsonb_build_object('allergy_id', a.id, 'severity', a.severity, 'status', a.status)
+FROM patient_allergies a
+WHERE a.patient_id = $1 AND a.deleted_at IS NULL`,
+	`
+SELECT o.ordered_at, 'lab_order', COALESCE(o.appointment_id, 0),
+       'Ordered lab tests: ' || (SELECT string_agg(r.code, ', ' ORDER BY r.id) FROM lab_results r WHERE r.order_id = o.id),
+       jsonb_build_object('lab_order_id', o.id, 'priority', o.priority, 'doctor_id', o.doctor_id)
+FROM lab_orders o
+WHERE o.patient_id = $1 AND o.deleted_at IS NULL`,
+	`
+SELECT o.results_ready_at, 'lab_results_ready', COALESCE(o.appointment_id, 0),
+       'Lab results ready' || COALESCE(', abnormal: ' || (
+           SELECT string_agg(r.code, ', ' ORDER BY r.id) FROM lab_results r
+           WHERE r.order_id = o.id AND r.flag NOT IN ('', 'normal')), ''),
+       jsonb_build_object('lab_order_id', o.id)
+FROM lab_orders o
+WHERE o.patient_id = $1 AND o.results_ready_at IS NOT NULL AND o.deleted_at IS NULL`,
+	`
+SELECT v.taken_at, 'news2_alert', COALESCE(v.appointment_id, 0),
+       'NEWS2 ' || v.news2_score || ', ' || v.news2_risk || ' clinical risk',
+       jsonb_build_object('vitals_id', v.id, 'score', v.news2_score, 'risk', v.news2_risk)
+FROM vital_signs v
+WHERE v.patient_id = $1 AND v.news2_risk <> 'low' AND v.deleted_at IS NULL`,
+	`
+SELECT a.admitted_at, 'admission', 0,
+       'Admitted to ' || w.name || ', room ' || r.number || ', bed ' || b.label || COALESCE(': ' || NULLIF(a.reason, ''), ''),
+       jsonb_build_object('admission_id', a.id, 'bed_id', a.bed_id, 'attending_doctor_id', a.attending_doctor_id)
+FROM admissions a
+JOIN bed_movements m ON m.admission_id = a.id AND m.id = (SELECT min(id) FROM bed_movements WHERE admission_id = a.id)
+JOIN beds b ON b.id = m.bed_id
+JOIN rooms r ON r.id = b.room_id
+JOIN wards w ON w.id = r.ward_id
+WHERE a.patient_id = $1 AND a.deleted_at IS NULL`,
+	`
+SELECT m.started_at, 'transfer', 0,
+       'Transferred to ' || w.name || ', room ' || r.number || ', bed ' || b.label || COALESCE(': ' || NULLIF(m.reason, ''), ''),
+       jsonb_build_object('admission_id', a.id, 'bed_id', m.bed_id, 'moved_by', m.moved_by)
+FROM bed_movements m
+JOIN admissions a ON a.id = m.admission_id
+JOIN beds b ON b.id = m.bed_id
+JOIN rooms r ON r.id = b.room_id
+JOIN wards w ON w.id = r.ward_id
+WHERE a.patient_id = $1 AND a.deleted_at IS NULL AND m.id <> (SELECT min(id) FROM bed_movements WHERE admission_id = a.id)`,
+	`
+SELECT a.discharged_at, 'discharge', 0,
+       'Discharged (' || a.disposition || ')',
+       jsonb_build_object('admission_id', a.id, 'discharged_by', a.discharged_by)
+FROM admissions a
+WHERE a.patient_id = $1 AND a.discharged_at IS NOT NULL AND a.deleted_at IS NULL`,
+	`
+SELECT m.merged_at, 'merge', 0,
+       'Merged duplicate record ' || m.merged_mrn,
+       jsonb_build_object('merge_id', m.id, 'merged_id', m.merged_id, 'merged_by', m.merged_by)
+FROM patient_merges m
+WHERE m.survivor_id = $1`,
+}
+
+// GetPatientTimeline returns appointments, status changes and clinical records in chronological order
+func (s *Storage) GetPatientTimeline(ctx context.Context, patientID int, timezone string) ([]models.TimelineEvent, error) {
+	query := strings.Join(timelineSources, "\nUNION ALL\n") + "\nORDER BY 1, 2"
+	rows, err := s.pool.Query(ctx, query, patientID, timezone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.TimelineEvent
+	for rows.Next() {
+		var e models.TimelineEvent
+		if err := rows.Scan(&e.OccurredAt, &e.Type, &e.AppointmentID, &e.Summary, &e.Details); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// GetDoctorPatients lists distinct patients that have appointments with the doctor
+func (s *Storage) GetDoctorPatients(ctx context.Context, doctorID int) ([]models.Patient, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT `+patientColumns+` FROM patients
+WHERE deleted_at IS NULL AND id IN (SELECT patient_id FROM appointments WHERE doctor_id = $1 AND deleted_at IS NULL)
+ORDER BY id
+`, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Patient
+	for rows.Next() {
+		var p models.Patient
+		if err := scanPatient(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
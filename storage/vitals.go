@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const vitalsColumns = `id, patient_id, COALESCE(appointment_id, 0) AS appointment_id, taken_at, systolic, diastolic, pulse,
+temperature, spo2, spo2_scale_2, respiration_rate, on_oxygen, consciousness, notes,
+news2_score, news2_risk, news2_red_score, news2_complete, recorded_by, recorded_at, version, deleted_at, deleted_by`
+
+// vitalsDest returns the scan destinations for vitalsColumns
+func vitalsDest(v *models.Vitals) []any {
+	return []any{&v.ID, &v.PatientID, &v.AppointmentID, &v.TakenAt, &v.Systolic, &v.Diastolic, &v.Pulse,
+		&v.Temperature, &v.SpO2, &v.SpO2Scale2, &v.RespirationRate, &v.OnOxygen, &v.Consciousness, &v.Notes,
+		&v.NEWS2.Score, &v.NEWS2.Risk, &v.NEWS2.RedScore, &v.NEWS2.Complete, &v.RecordedBy, &v.RecordedAt, &v.Version, &v.DeletedAt, &v.DeletedBy}
+}
+
+func (s *Storage) queryVitals(ctx context.Context, where string, args ...any) ([]models.Vitals, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+vitalsColumns+` FROM vital_signs WHERE `+where+` ORDER BY taken_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Vitals
+	for rows.Next() {
+		var v models.Vitals
+		if err := rows.Scan(vitalsDest(&v)...); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// GetPatientVitals returns the vital signs of a patient taken in [from, to) in
+// time order; a zero bound is open. Soft-deleted sets are listed with includeDeleted.
+func (s *Storage) GetPatientVitals(ctx context.Context, patientID int, from, to time.Time, includeDeleted bool) ([]models.Vitals, error) {
+	return s.queryVitals(ctx, `patient_id = $1 AND ($2::timestamptz IS NULL OR taken_at >= $2) AND ($3::timestamptz IS NULL OR taken_at < $3)
+  AND ($4 OR deleted_at IS NULL)`, patientID, nullTime(from), nullTime(to), includeDeleted)
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// GetAppointmentVitals returns the vital signs taken during an appointment
+func (s *Storage) GetAppointmentVitals(ctx context.Context, appointmentID int) ([]models.Vitals, error) {
+	return s.queryVitals(ctx, `appointment_id = $1 AND deleted_at IS NULL`, appointmentID)
+}
+
+func (s *Storage) GetVitals(ctx context.Context, id int) (*models.Vitals, error) {
+	var v models.Vitals
+	err := s.pool.QueryRow(ctx, `SELECT `+vitalsColumns+` FROM vital_signs WHERE id = $1 AND deleted_at IS NULL`, id).Scan(vitalsDest(&v)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("vitals not found")
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *Storage) CreateVitals(ctx context.Context, v *models.Vitals) (*models.Vitals, error) {
+	err := s.pool.QueryRow(ctx, `
+INSERT INTO vital_signs (patient_id, appointment_id, taken_at, systolic, diastolic, pulse, temperature, spo2, spo2_scale_2,
+                         respiration_rate, on_oxygen, consciousness, notes, news2_score, news2_risk, news2_red_score,
+                         news2_complete, recorded_by)
+VALUES ($1, NULLIF($2::integer, 0), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+RETURNING id, recorded_at, version
+`, v.PatientID, v.AppointmentID, v.TakenAt, v.Systolic, v.Diastolic, v.Pulse, v.Temperature, v.SpO2, v.SpO2Scale2,
+		v.RespirationRate, v.OnOxygen, v.Consciousness, v.Notes, v.NEWS2.Score, v.NEWS2.Risk, v.NEWS2.RedScore,
+		v.NEWS2.Complete, v.RecordedBy).Scan(&v.ID, &v.RecordedAt, &v.Version)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// DeleteVitals soft-deletes a set entered in error; a non-zero version must match the stored one
+func (s *Storage) DeleteVitals(ctx context.Context, id, version int, by string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error { return deleteRow(ctx, tx, "vital_signs", "vitals", id, version, by) })
+}
+
+// RestoreVitals undoes DeleteVitals; the patient must not be deleted
+func (s *Storage) RestoreVitals(ctx context.Context, id int) (*models.Vitals, error) {
+	var v models.Vitals
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockDeleted(ctx, tx, "vital_signs", "vitals", id); err != nil {
+			return err
+		}
+		if err := requireLivePatient(ctx, tx, "vital_signs", id); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+UPDATE vital_signs SET deleted_at = NULL, deleted_by = '', version = version + 1
+WHERE id = $1
+RETURNING `+vitalsColumns, id).Scan(vitalsDest(&v)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetVitalsAlerts lists patients whose latest vital signs, taken at or after
+// since, score one of risks, highest score first. The score of the set before
+// shows whether the patient is deteriorating.
+func (s *Storage) GetVitalsAlerts(ctx context.Context, since time.Time, risks []string) ([]models.VitalsAlert, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT p.id, p.first_name || ' ' || p.last_name, p.mrn, v.*, prev.news2_score
+FROM (SELECT DISTINCT ON (patient_id) `+vitalsColumns+`
+      FROM vital_signs WHERE deleted_at IS NULL
+      ORDER BY patient_id, taken_at DESC, id DESC) v
+JOIN patients p ON p.id = v.patient_id AND p.deleted_at IS NULL
+LEFT JOIN LATERAL (
+    SELECT pv.news2_score FROM vital_signs pv
+    WHERE pv.patient_id = v.patient_id AND pv.deleted_at IS NULL AND (pv.taken_at, pv.id) < (v.taken_at, v.id)
+    ORDER BY pv.taken_at DESC, pv.id DESC
+    LIMIT 1) prev ON true
+WHERE v.taken_at >= $1 AND v.news2_risk = ANY($2)
+ORDER BY v.news2_score DESC, v.taken_at DESC
+`, since, risks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.VitalsAlert
+	for rows.Next() {
+		var a models.VitalsAlert
+		dest := append([]any{&a.PatientID, &a.PatientName, &a.MRN}, vitalsDest(&a.Latest)...)
+		if err := rows.Scan(append(dest, &a.PreviousScore)...); err != nil {
+			return nil, err
+		}
+		a.Deteriorating = a.PreviousScore != nil && a.Latest.NEWS2.Score > *a.PreviousScore
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TeseySTD/GoHospitalApi/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrBedOccupied is returned when assigning a bed that holds an admitted patient or taking it out of service
+var ErrBedOccupied = errors.New("bed is occupied")
+
+// ErrBedOutOfService is returned when assigning a bed that is out of service
+var ErrBedOutOfService = errors.New("bed is out of service")
+
+// facilityConflict turns unique violations on department, ward, room and bed names into ErrDuplicate
+func facilityConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	switch pgErr.ConstraintName {
+	case "departments_code_key":
+		return fmt.Errorf("%w: department code is already taken", ErrDuplicate)
+	case "wards_code_key":
+		return fmt.Errorf("%w: ward code is already taken", ErrDuplicate)
+	case "rooms_number_key":
+		return fmt.Errorf("%w: the ward already has a room with this number", ErrDuplicate)
+	case "beds_label_key":
+		return fmt.Errorf("%w: the room already has a bed with this label", ErrDuplicate)
+	}
+	return err
+}
+
+// facilityUpdated finishes an UPDATE ... RETURNING version of a facility row: no row
+// means it is missing or its version is stale
+func facilityUpdated(ctx context.Context, tx pgx.Tx, err error, table, entity string, id int) error {
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return facilityConflict(err)
+	}
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s not found", entity)
+	}
+	return ErrVersionMismatch
+}
+
+// GetWards lists the wards, of one department when departmentID is not 0
+func (s *Storage) GetWards(ctx context.Context, departmentID int) ([]models.Ward, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT id, department_id, code, name, version FROM wards
+WHERE $1 = 0 OR department_id = $1
+ORDER BY name, id
+`, departmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Ward
+	for rows.Next() {
+		var w models.Ward
+		if err := rows.Scan(&w.ID, &w.DepartmentID, &w.Code, &w.Name, &w.Version); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// GetWard returns a ward with its rooms and their beds
+func (s *Storage) GetWard(ctx context.Context, id int) (*models.Ward, error) {
+	var w models.Ward
+	err := s.pool.QueryRow(ctx, `SELECT id, department_id, code, name, version FROM wards WHERE id = $1`, id).
+		Scan(&w.ID, &w.DepartmentID, &w.Code, &w.Name, &w.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("ward not found")
+		}
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+SELECT r.id, r.number, r.version, b.id, b.label, b.in_service, b.version
+FROM rooms r LEFT JOIN beds b ON b.room_id = r.id
+WHERE r.ward_id = $1
+ORDER BY r.number, r.id, b.label, b.id
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	w.Rooms = []models.Room{}
+	for rows.Next() {
+		var room models.Room
+		var bedID, bedVersion *int
+		var label *string
+		var inService *bool
+		if err := rows.Scan(&room.ID, &room.Number, &room.Version, &bedID, &label, &inService, &bedVersion); err != nil {
+			return nil, err
+		}
+		if n := len(w.Rooms); n == 0 || w.Rooms[n-1].ID != room.ID {
+			room.WardID = id
+			w.Rooms = append(w.Rooms, room)
+		}
+		if bedID != nil {
+			last := &w.Rooms[len(w.Rooms)-1]
+			last.Beds = append(last.Beds, models.Bed{ID: *bedID, RoomID: last.ID, Label: *label, InService: *inService, Version: *bedVersion})
+		}
+	}
+	return &w, rows.Err()
+}
+
+func (s *Storage) CreateWard(ctx context.Context, w *models.Ward) (*models.Ward, error) {
+	err := s.pool.QueryRow(ctx, `INSERT INTO wards (department_id, code, name) VALUES ($1, $2, $3) RETURNING id, version`,
+		w.DepartmentID, w.Code, w.Name).Scan(&w.ID, &w.Version)
+	if err != nil {
+		return nil, facilityConflict(err)
+	}
+	return w, nil
+}
+
+// UpdateWard overwrites the row; a non-zero w.Version must match the stored one
+func (s *Storage) UpdateWard(ctx context.Context, w *models.Ward) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE wards SET department_id=$1, code=$2, name=$3, version = version + 1
+WHERE id=$4 AND ($5 = 0 OR version = $5)
+RETURNING version
+`, w.DepartmentID, w.Code, w.Name, w.ID, w.Version).Scan(&w.Version)
+		return facilityUpdated(ctx, tx, err, "wards", "ward", w.ID)
+	})
+}
+
+func (s *Storage) CreateRoom(ctx context.Context, r *models.Room) (*models.Room, error) {
+	err := s.pool.QueryRow(ctx, `INSERT INTO rooms (ward_id, number) VALUES ($1, $2) RETURNING id, version`, r.WardID, r.Number).
+		Scan(&r.ID, &r.Version)
+	if err != nil {
+		return nil, facilityConflict(err)
+	}
+	return r, nil
+}
+
+// UpdateRoom renumbers a room; a non-zero r.Version must match the stored one
+func (s *Storage) UpdateRoom(ctx context.Context, r *models.Room) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+UPDATE rooms SET number=$1, version = version + 1
+WHERE id=$2 AND ($3 = 0 OR version = $3)
+RETURNING ward_id, version
+`, r.Number, r.ID, r.Version).Scan(&r.WardID, &r.Version)
+		return facilityUpdated(ctx, tx, err, "rooms", "room", r.ID)
+	})
+}
+
+func (s *Storage) GetRoom(ctx context.Context, id int) (*models.Room, error) {
+	var r models.Room
+	err := s.pool.QueryRow(ctx, `SELECT id, ward_id, number, version FROM rooms WHERE id = $1`, id).Scan(&r.ID, &r.WardID, &r.Number, &r.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("room not found")
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Storage) CreateBed(ctx context.Context, b *models.Bed) (*models.Bed, error) {
+	err := s.pool.QueryRow(ctx, `INSERT INTO beds (room_id, label, in_service) VALUES ($1, $2, $3) RETURNING id, version`,
+		b.RoomID, b.Label, b.InService).Scan(&b.ID, &b.Version)
+	if err != nil {
+		return nil, facilityConflict(err)
+	}
+	return b, nil
+}
+
+func (s *Storage) GetBed(ctx context.Context, id int) (*models.Bed, error) {
+	var b models.Bed
+	err := s.pool.QueryRow(ctx, `SELECT id, room_id, label, in_service, version FROM beds WHERE id = $1`, id).
+		Scan(&b.ID, &b.RoomID, &b.Label, &b.InService, &b.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("bed not found")
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// UpdateBed relabels a bed or takes it in or out of service; an occupied bed
+// stays in service. A non-zero b.Version must match the stored one.
+func (s *Storage) UpdateBed(ctx context.Context, b *models.Bed) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if !b.InService {
+			_, occupied, err := lockBed(ctx, tx, b.ID)
+			if err != nil {
+				return err
+			}
+			if occupied {
+				return fmt.Errorf("%w: transfer or discharge the patient first", ErrBedOccupied)
+			}
+		}
+		err := tx.QueryRow(ctx, `
+UPDATE beds SET label=$1, in_service=$2, version = version + 1
+WHERE id=$3 AND ($4 = 0 OR version = $4)
+RETURNING room_id, version
+`, b.Label, b.InService, b.ID, b.Version).Scan(&b.RoomID, &b.Version)
+		return facilityUpdated(ctx, tx, err, "beds", "bed", b.ID)
+	})
+}
+
+// lockBed locks a bed and reports whether it is in service and whether an admitted patient lies in it
+func lockBed(ctx context.Context, tx pgx.Tx, bedID int) (inService, occupied bool, err error) {
+	err = tx.QueryRow(ctx, `
+SELECT in_service, EXISTS (SELECT 1 FROM admissions WHERE bed_id = beds.id AND status = 'admitted' AND deleted_at IS NULL)
+FROM beds WHERE id = $1 FOR UPDATE
+`, bedID).Scan(&inService, &occupied)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, fmt.Errorf("bed not found")
+	}
+	return inService, occupied, err
+}
+
+// bedLocationJoins joins the room, ward and department of the bed aliased b
+const bedLocationJoins = `
+JOIN rooms r ON r.id = b.room_id
+JOIN wards w ON w.id = r.ward_id
+JOIN departments d ON d.id = w.department_id`
+
+const bedLocationColumns = `b.label, r.number, w.id, w.name, d.id, d.name`
+
+// bedLocationDest returns the scan destinations for bedLocationColumns
+func bedLocationDest(l *models.BedLocation) []any {
+	return []any{&l.Bed, &l.Room, &l.WardID, &l.Ward, &l.DepartmentID, &l.Department}
+}
+
+// GetBedBoard lists every bed with its occupant, narrowed to a ward or a
+// department when wardID or departmentID is not 0
+func (s *Storage) GetBedBoard(ctx context.Context, wardID, departmentID int) ([]models.BedStatus, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT b.id, `+bedLocationColumns+`, b.in_service,
+       a.id, a.patient_id, p.first_name || ' ' || p.last_name, COALESCE(a.attending_doctor_id, 0), m.started_at
+FROM beds b`+bedLocationJoins+`
+LEFT JOIN admissions a ON a.bed_id = b.id AND a.status = 'admitted' AND a.deleted_at IS NULL
+LEFT JOIN patients p ON p.id = a.patient_id
+LEFT JOIN bed_movements m ON m.admission_id = a.id AND m.ended_at IS NULL
+WHERE ($1 = 0 OR w.id = $1) AND ($2 = 0 OR d.id = $2)
+ORDER BY d.name, w.name, r.number, b.label, b.id
+`, wardID, departmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.BedStatus
+	for rows.Next() {
+		var b models.BedStatus
+		var inService bool
+		var admissionID, patientID *int
+		var patientName *string
+		var doctorID int
+		var since *time.Time
+		dest := append([]any{&b.BedID}, bedLocationDest(&b.Location)...)
+		if err := rows.Scan(append(dest, &inService, &admissionID, &patientID, &patientName, &doctorID, &since)...); err != nil {
+			return nil, err
+		}
+		switch {
+		case admissionID != nil:
+			b.Status = models.BedOccupied
+			b.Occupant = &models.BedOccupant{AdmissionID: *admissionID, PatientID: *patientID, PatientName: *patientName, AttendingDoctorID: doctorID}
+			if since != nil {
+				b.Occupant.Since = *since
+			}
+		case !inService:
+			b.Status = models.BedOutOfService
+		default:
+			b.Status = models.BedFree
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// GetWardOccupancy counts free, occupied and closed beds per ward, of one
+// department when departmentID is not 0
+func (s *Storage) GetWardOccupancy(ctx context.Context, departmentID int) ([]models.WardOccupancy, error) {
+	rows, err := s.pool.Query(ctx, `
+SELECT w.id, w.name, d.id, d.name, count(b.id), count(a.id), count(b.id) FILTER (WHERE NOT b.in_service AND a.id IS NULL)
+FROM wards w
+JOIN departments d ON d.id = w.department_id
+LEFT JOIN rooms r ON r.ward_id = w.id
+LEFT JOIN beds b ON b.room_id = r.id
+LEFT JOIN admissions a ON a.bed_id = b.id AND a.status = 'admitted' AND a.deleted_at IS NULL
+WHERE $1 = 0 OR d.id = $1
+GROUP BY w.id, d.id
+ORDER BY d.name, w.name, w.id
+`, departmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.WardOccupancy
+	for rows.Next() {
+		var o models.WardOccupancy
+		if err := rows.Scan(&o.WardID, &o.Ward, &o.DepartmentID, &o.Department, &o.Beds, &o.Occupied, &o.OutOfService); err != nil {
+			return nil, err
+		}
+		o.Free = o.Beds - o.Occupied - o.OutOfService
+		if open := o.Beds - o.OutOfService; open > 0 {
+			o.Rate = float64(o.Occupied) / float64(open)
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
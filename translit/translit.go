@@ -0,0 +1,84 @@
+// Package translit folds Ukrainian and Russian Cyrillic and their Latin
+// transliterations to one lower-case Latin form, so "Петринко", "Petrynko"
+// and "Petrinko" compare equal or close. The folded form is only meant for
+// matching; it is not a transliteration to show to users.
+//
+// The same folding is available in Postgres as the search_fold function
+// created from FunctionSQL, so stored documents and queries agree.
+package translit
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// letters are applied in order: multi-letter results first, then single letters
+var letters = []struct{ from, to string }{
+	{"щ", "shch"}, {"ж", "zh"}, {"ч", "ch"}, {"ш", "sh"}, {"х", "kh"}, {"ц", "ts"},
+	{"є", "ie"}, {"ю", "iu"}, {"я", "ia"},
+	{"а", "a"}, {"б", "b"}, {"в", "v"}, {"г", "h"}, {"ґ", "g"}, {"д", "d"}, {"е", "e"},
+	{"з", "z"}, {"и", "y"}, {"і", "i"}, {"ї", "i"}, {"й", "i"}, {"к", "k"}, {"л", "l"},
+	{"м", "m"}, {"н", "n"}, {"о", "o"}, {"п", "p"}, {"р", "r"}, {"с", "s"}, {"т", "t"},
+	{"у", "u"}, {"ф", "f"}, {"ы", "y"}, {"э", "e"}, {"ё", "e"},
+	{"ь", ""}, {"ъ", ""}, {"'", ""}, {"’", ""}, {"ʼ", ""},
+}
+
+// spellings that differ between transliteration systems (Olga/Olha, Kharkiv/Harkiv, Petrynko/Petrinko)
+var folds = []struct{ from, to string }{
+	{"kh", "h"}, {"g", "h"}, {"y", "i"},
+}
+
+// Fold lower-cases s, transliterates Cyrillic and folds spelling variants
+func Fold(s string) string {
+	s = strings.ToLower(s)
+	for _, l := range letters {
+		s = strings.ReplaceAll(s, l.from, l.to)
+	}
+	for _, f := range folds {
+		s = strings.ReplaceAll(s, f.from, f.to)
+	}
+	return s
+}
+
+// Words returns the folded words of s: runs of letters and digits
+func Words(s string) []string {
+	return strings.FieldsFunc(Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// FunctionSQL creates or replaces the IMMUTABLE SQL function name(text) that
+// computes Fold in Postgres
+func FunctionSQL(name string) string {
+	expr := "lower($1)"
+	var from, to, drop strings.Builder
+	for _, l := range letters {
+		// upper case is mapped too, lower() only folds Cyrillic under a UTF-8 locale
+		variants := []string{l.from}
+		if upper := strings.ToUpper(l.from); upper != l.from {
+			variants = append(variants, upper)
+		}
+		for _, v := range variants {
+			switch {
+			case l.to == "":
+				drop.WriteString(v)
+			case len([]rune(v)) == 1 && len(l.to) == 1:
+				from.WriteString(v)
+				to.WriteString(l.to)
+			default:
+				expr = fmt.Sprintf("replace(%s, %s, %s)", expr, quote(v), quote(l.to))
+			}
+		}
+	}
+	// characters of from without a counterpart in to are deleted
+	expr = fmt.Sprintf("translate(%s, %s, %s)", expr, quote(from.String()+drop.String()), quote(to.String()))
+	for _, f := range folds {
+		expr = fmt.Sprintf("replace(%s, %s, %s)", expr, quote(f.from), quote(f.to))
+	}
+	return fmt.Sprintf("CREATE OR REPLACE FUNCTION %s(text) RETURNS text LANGUAGE sql IMMUTABLE PARALLEL SAFE RETURNS NULL ON NULL INPUT AS $$ SELECT %s $$", name, expr)
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
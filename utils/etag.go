@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag formats a row version as a strong entity tag
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag adds the ETag header for a row version
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+// NotModified answers 304 when If-None-Match already names the current version
+func NotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	current := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			SetETag(w, version)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatch returns the version a write request was based on, or 0 for "*".
+// It answers 428 when the header is missing and 412 when it cannot match.
+func IfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		RespondError(w, http.StatusPreconditionRequired, "If-Match header is required; send the ETag from a previous GET")
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	// weak tags never match and a list cannot be checked against one row version
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		RespondError(w, http.StatusPreconditionFailed, "If-Match does not match the current version")
+		return 0, false
+	}
+	return version, true
+}
@@ -1,9 +1,10 @@
 package utils
+
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
 )
 
 func RespondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -16,8 +17,12 @@ func RespondError(w http.ResponseWriter, status int, message string) {
 	RespondJSON(w, status, map[string]string{"error": message})
 }
 
-func ExtractID(path, prefix string) int {
-	idStr := strings.TrimPrefix(path, prefix)
-	id, _ := strconv.Atoi(idStr)
-	return id
+// PathID parses a positive integer path parameter such as {id}
+func PathID(r *http.Request, name string) (int, error) {
+	value := r.PathValue(name)
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid " + name + " " + strconv.Quote(value) + ": must be a positive integer")
+	}
+	return id, nil
 }